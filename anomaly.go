@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ComputeAnomalies orders the rows of the input by its order field, or its
+// join field if no order field is given, and compares the value of each row
+// with the mean and standard deviation of the values of the preceding window
+// rows. A row is anomalous if its value is more than threshold standard
+// deviations from the mean. Rows with fewer than two preceding values, or
+// whose preceding values are all the same, are never anomalous. Null values
+// are ignored. The computed dataset has the join field and value of each
+// anomalous row, with the baseline mean as expected and the number of
+// standard deviations from it as deviation, so it can be plotted as markers
+// over the original series.
+func ComputeAnomalies(ctx context.Context, in ComputeInput, window int, threshold float64) (DataSet, error) {
+	orderField := in.Def.OrderField
+	if orderField == "" {
+		orderField = in.Def.JoinField
+	}
+
+	type row struct {
+		order any
+		join  any
+		value float64
+	}
+
+	in.DataSet.ResetIterator()
+	var rows []row
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		order := in.DataSet.Field(orderField)
+		if err, ok := order.(error); ok {
+			return nil, fmt.Errorf("did not get order field value %q from dataset %q: %w", orderField, in.Def.DataSet, err)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		if value == nil {
+			continue
+		}
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot detect anomalies in value of type %T", value)
+		}
+		rows = append(rows, row{order: order, join: join, value: f})
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareValues(rows[i].order, rows[j].order) < 0
+	})
+
+	data := make(map[string][]any)
+	for i, r := range rows {
+		baseline := rows[max(0, i-window):i]
+		if len(baseline) < 2 {
+			continue
+		}
+
+		var mean float64
+		for _, b := range baseline {
+			mean += b.value
+		}
+		mean /= float64(len(baseline))
+		var variance float64
+		for _, b := range baseline {
+			variance += (b.value - mean) * (b.value - mean)
+		}
+		sigma := math.Sqrt(variance / float64(len(baseline)-1))
+		if sigma == 0 {
+			continue
+		}
+
+		deviation := (r.value - mean) / sigma
+		if math.Abs(deviation) <= threshold {
+			continue
+		}
+		data["field"] = append(data["field"], r.join)
+		data["value"] = append(data["value"], r.value)
+		data["expected"] = append(data["expected"], mean)
+		data["deviation"] = append(data["deviation"], deviation)
+	}
+
+	return NewStaticDataSet(data), nil
+}
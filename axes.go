@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+// genericFieldNames are field names used by static and computed datasets that
+// say nothing about the data and so make poor axis titles
+var genericFieldNames = map[string]bool{
+	"x":     true,
+	"y":     true,
+	"field": true,
+	"value": true,
+	"group": true,
+}
+
+// upperCaseWords are words that are written in upper case in titles
+var upperCaseWords = map[string]bool{
+	"api": true,
+	"cpu": true,
+	"id":  true,
+	"ip":  true,
+	"rtt": true,
+	"url": true,
+}
+
+// inferAxisTitles sets titles for the primary axes from the label and value
+// field names of the series plotted against them, unless the layout already
+// has titles. Titles are only set when all the series on an axis use the same
+// field. Value axis titles include the unit suffix of the series.
+func inferAxisTitles(layout *grob.Layout, series []*LabeledSeries) {
+	var xfields, yfields []string
+	for _, ls := range series {
+		s := ls.SeriesDef
+		if s.Type == SeriesTypeChoropleth || (s.Yaxis != "" && s.Yaxis != "y") {
+			continue
+		}
+
+		valueTitle := prettyFieldName(s.Values)
+		if valueTitle != "" {
+			if suffix := s.EffectiveUnit().Suffix(); suffix != "" {
+				valueTitle += " (" + suffix + ")"
+			} else if s.EffectiveUnit() == UnitTypePercent {
+				valueTitle += " (%)"
+			}
+		}
+		labelTitle := prettyFieldName(s.Labels)
+
+		if s.ValueAxis() == "x" {
+			xfields = append(xfields, valueTitle)
+			yfields = append(yfields, labelTitle)
+		} else {
+			xfields = append(xfields, labelTitle)
+			yfields = append(yfields, valueTitle)
+		}
+	}
+
+	if title := commonTitle(xfields); title != "" {
+		if layout.Xaxis == nil {
+			layout.Xaxis = &grob.LayoutXaxis{}
+		}
+		if layout.Xaxis.Title == nil {
+			layout.Xaxis.Title = &grob.LayoutXaxisTitle{Text: title}
+		}
+	}
+	if title := commonTitle(yfields); title != "" {
+		if layout.Yaxis == nil {
+			layout.Yaxis = &grob.LayoutYaxis{}
+		}
+		if layout.Yaxis.Title == nil {
+			layout.Yaxis.Title = &grob.LayoutYaxisTitle{Text: title}
+		}
+	}
+}
+
+// commonTitle returns the title shared by all the entries of titles, or an
+// empty string if they differ
+func commonTitle(titles []string) string {
+	if len(titles) == 0 {
+		return ""
+	}
+	for _, t := range titles[1:] {
+		if t != titles[0] {
+			return ""
+		}
+	}
+	return titles[0]
+}
+
+// prettyFieldName converts a field name such as bytes_sent or bytesSent into
+// a title such as Bytes Sent. Generic field names result in an empty string.
+func prettyFieldName(name string) string {
+	if genericFieldNames[strings.ToLower(name)] {
+		return ""
+	}
+
+	var words []string
+	var word []rune
+	prev := rune(0)
+	for _, r := range name {
+		switch {
+		case r == '_' || r == '-' || r == '.' || unicode.IsSpace(r):
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = word[:0]
+		case unicode.IsUpper(r) && unicode.IsLower(prev) && len(word) > 0:
+			words = append(words, string(word))
+			word = append(word[:0], r)
+		default:
+			word = append(word, r)
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	for i, w := range words {
+		lw := strings.ToLower(w)
+		if upperCaseWords[lw] {
+			words[i] = strings.ToUpper(lw)
+			continue
+		}
+		words[i] = strings.ToUpper(lw[:1]) + lw[1:]
+	}
+	return strings.Join(words, " ")
+}
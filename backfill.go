@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var backfillCommand = &cli.Command{
+	Name:   "backfill",
+	Usage:  "Generate the dated outputs of plots for a range of past basis times",
+	Action: Backfill,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "from",
+			Required:    true,
+			Usage:       "Start of the range of basis times, as a date in RFC3339 or Unix timestamp format or an offset from the current date in hours (e.g. -48h), days (e.g. -90d) or weeks (e.g. -12w).",
+			Destination: &backfillOpts.from,
+		},
+		&cli.StringFlag{
+			Name:        "to",
+			Required:    false,
+			Value:       "now",
+			Usage:       "End of the range of basis times, in the same formats as --from.",
+			Destination: &backfillOpts.to,
+		},
+		&cli.StringSliceFlag{
+			Name:        "frequency",
+			Required:    false,
+			Usage:       "Only backfill plots of this frequency. May be repeated. Defaults to all frequencies.",
+			Destination: &backfillOpts.frequencies,
+		},
+	}, batchFlagsExcept("basis", "version", "validate", "results", "summary", "summary-template", "image-url")...),
+}
+
+var backfillOpts struct {
+	from        string
+	to          string
+	frequencies cli.StringSlice
+}
+
+// batchFlagsExcept returns the flags of the batch command other than those
+// named
+func batchFlagsExcept(names ...string) []cli.Flag {
+	var flags []cli.Flag
+outer:
+	for _, f := range batchCommand.Flags {
+		for _, name := range names {
+			if f.Names()[0] == name {
+				continue outer
+			}
+		}
+		flags = append(flags, f)
+	}
+	return flags
+}
+
+// Backfill runs a batch for each period of each frequency between two basis
+// times, oldest first, generating only the plots of that frequency. Outputs
+// that already exist are skipped as they are by batch, so an interrupted
+// backfill resumes where it stopped when it is run again.
+func Backfill(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	frequencies := []PlotFrequency{
+		PlotFrequencyYearly,
+		PlotFrequencyQuarterly,
+		PlotFrequencyMonthly,
+		PlotFrequencyWeekly,
+		PlotFrequencyDaily,
+		PlotFrequencyHourly,
+	}
+	if opts := backfillOpts.frequencies.Value(); len(opts) > 0 {
+		frequencies = frequencies[:0]
+		for _, opt := range opts {
+			if err := PlotFrequency(opt).Validate(); err != nil {
+				return err
+			}
+			frequencies = append(frequencies, PlotFrequency(opt))
+		}
+	}
+
+	cfg, tz, err := newBatchConfig(ctx)
+	if err != nil {
+		return err
+	}
+	from, err := parseBasisTime(backfillOpts.from)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	to, err := parseBasisTime(backfillOpts.to)
+	if err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	from, to = from.In(tz), to.In(tz)
+	if from.After(to) {
+		return fmt.Errorf("start of range %s is after its end %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	store, outBase, err := newBatchOutputStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	counts := map[BatchStatus]int{}
+	for _, freq := range frequencies {
+		// each period that overlaps the range is generated with the basis
+		// time at its start
+		for t := freq.Truncate(from, cfg.WeekStart); !t.After(to); t = freq.Next(t, cfg.WeekStart) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			slog.Info("backfilling plots", "frequency", freq, "basis_time", t.Format(time.RFC3339))
+			cfg.BasisTime = t
+			cfg.MatchFrequency = freq
+			results, err := runBatch(ctx, cfg, store, outBase)
+			if err != nil {
+				return fmt.Errorf("backfill %s plots for %s: %w", freq, t.Format(time.RFC3339), err)
+			}
+			for _, res := range results.Plots {
+				counts[res.Status]++
+			}
+		}
+	}
+
+	slog.Info("backfill complete", "generated", counts[BatchStatusGenerated], "skipped", counts[BatchStatusSkipped], "failed", counts[BatchStatusFailed])
+	if n := counts[BatchStatusFailed]; n > 0 {
+		return fmt.Errorf("%d plots failed to generate, run backfill again to retry them", n)
+	}
+	return nil
+}
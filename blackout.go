@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A BlackoutWindow is a period during which a source must not be queried,
+// such as while an upstream ETL job or database maintenance is running. A
+// window either recurs daily, given by start and end times of day, or is a
+// single period given by from and to.
+type BlackoutWindow struct {
+	Source string         `yaml:"source"` // the name of the source
+	Reason string         `yaml:"reason"` // optional description of why the source is unavailable
+	Action BlackoutAction `yaml:"action"` // what to do with plots that query the source: skip or defer. Defaults to skip.
+	Start  string         `yaml:"start"`  // start of a daily window as a UTC time of day, such as 02:00
+	End    string         `yaml:"end"`    // end of a daily window as a UTC time of day. Windows ending before they start span midnight.
+	Days   []string       `yaml:"days"`   // optional days of the week on which a daily window starts, such as sat
+	From   time.Time      `yaml:"from"`   // start of a one-off window
+	To     time.Time      `yaml:"to"`     // end of a one-off window
+
+	start, end time.Duration // parsed start and end offsets from midnight
+	days       map[time.Weekday]bool
+}
+
+type BlackoutAction string
+
+const (
+	BlackoutActionSkip  BlackoutAction = "skip"  // the plot is not generated in this run
+	BlackoutActionDefer BlackoutAction = "defer" // generation of the plot waits until the window ends
+)
+
+func (a BlackoutAction) String() string { return string(a) }
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// readBlackoutsConf reads the optional blackouts.yaml in the conf dir
+func readBlackoutsConf(conffs fs.FS) ([]BlackoutWindow, error) {
+	content, err := fs.ReadFile(conffs, "blackouts.yaml")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read blackouts: %w", err)
+	}
+
+	var windows []BlackoutWindow
+	if err := yaml.Unmarshal(content, &windows); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal blackouts.yaml: %w", err)
+	}
+	for i := range windows {
+		if err := windows[i].parse(); err != nil {
+			return nil, fmt.Errorf("blackout window %d for source %q: %w", i+1, windows[i].Source, err)
+		}
+	}
+	return windows, nil
+}
+
+func (w *BlackoutWindow) parse() error {
+	if w.Source == "" {
+		return fmt.Errorf("no source specified")
+	}
+
+	switch w.Action {
+	case "", BlackoutActionSkip, BlackoutActionDefer:
+	default:
+		return fmt.Errorf("unknown action: %q", w.Action)
+	}
+
+	daily := w.Start != "" || w.End != ""
+	oneOff := !w.From.IsZero() || !w.To.IsZero()
+	if daily == oneOff {
+		return fmt.Errorf("window must have either start and end or from and to")
+	}
+
+	if oneOff {
+		if w.From.IsZero() || w.To.IsZero() {
+			return fmt.Errorf("window must have both from and to")
+		}
+		if !w.To.After(w.From) {
+			return fmt.Errorf("window must end after it starts")
+		}
+		if len(w.Days) > 0 {
+			return fmt.Errorf("days may only be used with daily windows")
+		}
+		return nil
+	}
+
+	var err error
+	if w.start, err = parseTimeOfDay(w.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if w.end, err = parseTimeOfDay(w.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if w.start == w.end {
+		return fmt.Errorf("window must end after it starts")
+	}
+	if len(w.Days) > 0 {
+		w.days = make(map[time.Weekday]bool, len(w.Days))
+		for _, d := range w.Days {
+			wd, ok := weekdayNames[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("unknown day of the week: %q", d)
+			}
+			w.days[wd] = true
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a time of day in the form hh:mm as an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, use format hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ActiveUntil reports whether the window is active at the given time and, if
+// so, when it ends.
+func (w *BlackoutWindow) ActiveUntil(now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	if !w.From.IsZero() {
+		if !now.Before(w.From) && now.Before(w.To) {
+			return w.To, true
+		}
+		return time.Time{}, false
+	}
+
+	// check the window starting today and the one that started yesterday,
+	// which may span midnight
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		if w.days != nil && !w.days[day.Weekday()] {
+			continue
+		}
+		start := day.Add(w.start)
+		end := day.Add(w.end)
+		if w.end < w.start {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// A Blackout is an active blackout window affecting a plot
+type Blackout struct {
+	Window *BlackoutWindow
+	Until  time.Time
+}
+
+func (b *Blackout) String() string {
+	msg := fmt.Sprintf("source %q is in a blackout window until %s", b.Window.Source, b.Until.Format(time.RFC3339))
+	if b.Window.Reason != "" {
+		msg += ": " + b.Window.Reason
+	}
+	return msg
+}
+
+// ActiveBlackout returns the active blackout window affecting any source
+// queried by the plot. Windows that skip the plot take precedence, followed
+// by the window that ends last. It returns nil if no window is active.
+func (c *PlotConfig) ActiveBlackout(pd *PlotDef, now time.Time) *Blackout {
+	sources := make(map[string]bool, len(pd.Datasets))
+	for _, ds := range pd.Datasets {
+		sources[ds.Source] = true
+	}
+	for _, dep := range pd.DependsOn {
+		sources[dep.Source] = true
+	}
+
+	var active *Blackout
+	for i := range c.Blackouts {
+		w := &c.Blackouts[i]
+		if !sources[w.Source] {
+			continue
+		}
+		until, ok := w.ActiveUntil(now)
+		if !ok {
+			continue
+		}
+		if active == nil {
+			active = &Blackout{Window: w, Until: until}
+			continue
+		}
+		skip, activeSkip := w.Action != BlackoutActionDefer, active.Window.Action != BlackoutActionDefer
+		if skip && !activeSkip || skip == activeSkip && until.After(active.Until) {
+			active = &Blackout{Window: w, Until: until}
+		}
+	}
+	return active
+}
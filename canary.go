@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var canaryCommand = &cli.Command{
+	Name:      "canary",
+	Usage:     "Generate plots against alternate sources, such as a staging database, and compare them to production outputs",
+	ArgsUsage: "<plot definition>...",
+	Action:    Canary,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    true,
+			Usage:       "Specify the url of an alternate data source, in the format name=url, which replaces the source of the same name in the conf dir. May be repeated to specify multiple sources.",
+			Destination: &canaryOpts.sources,
+		},
+		&cli.StringFlag{
+			Name:        "baseline",
+			Required:    true,
+			Usage:       "Path of the output directory of the production batch run to compare against.",
+			Destination: &canaryOpts.baseline,
+		},
+		&cli.StringFlag{
+			Name:        "output-template",
+			Required:    false,
+			Usage:       "Template used by the production batch profile to name plot outputs.",
+			Value:       "{{ .PlotDefFilename }}.json",
+			Destination: &canaryOpts.outputTemplate,
+		},
+		&cli.Float64Flag{
+			Name:        "tolerance",
+			Required:    false,
+			Usage:       "Maximum relative difference allowed between numeric values.",
+			Value:       0.01,
+			Destination: &canaryOpts.tolerance,
+		},
+		&cli.IntFlag{
+			Name:        "max-diffs",
+			Required:    false,
+			Usage:       "Maximum number of differences to report for each plot.",
+			Value:       10,
+			Destination: &canaryOpts.maxDiffs,
+		},
+		&cli.StringSliceFlag{
+			Name:        "params",
+			Aliases:     []string{"p"},
+			Required:    false,
+			Usage:       "Specify templating parameters, in the format key=value. May be repeated to specify multiple parameters.",
+			Destination: &canaryOpts.params,
+		},
+		&cli.StringFlag{
+			Name:        "basis",
+			Required:    false,
+			Usage:       "Basis time to generate the plots for, in any of the formats accepted by batch. Plots are compared with the production outputs for the period of the basis time, or their latest versions when it is now.",
+			Value:       "now",
+			Destination: &canaryOpts.basis,
+		},
+		&cli.StringFlag{
+			Name:        "week-start",
+			Required:    false,
+			Usage:       "Day of the week that weekly periods and the week template variables start on, such as monday or sunday.",
+			Value:       string(WeekStartMonday),
+			Destination: &canaryOpts.weekStart,
+		},
+		&cli.StringFlag{
+			Name:        "tz",
+			Required:    false,
+			Usage:       "IANA timezone, such as Europe/Berlin, that periods and the template variables are computed in, unless the plot sets its own timezone.",
+			Value:       "UTC",
+			Destination: &canaryOpts.tz,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration. Sources in the conf dir that are not replaced by a --source option are used as they are.",
+			Destination: &canaryOpts.confDir,
+		},
+	}, loggingFlags...),
+}
+
+var canaryOpts struct {
+	sources        cli.StringSlice
+	baseline       string
+	outputTemplate string
+	tolerance      float64
+	maxDiffs       int
+	params         cli.StringSlice
+	basis          string
+	weekStart      string
+	tz             string
+	confDir        string
+}
+
+func Canary(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if cc.NArg() == 0 {
+		return fmt.Errorf("at least one plot definition must be supplied as an argument")
+	}
+
+	tz, err := loadTimezone(canaryOpts.tz)
+	if err != nil {
+		return err
+	}
+	basisTime, err := parseBasisTime(canaryOpts.basis)
+	if err != nil {
+		return fmt.Errorf("basis: %w", err)
+	}
+
+	cfg, err := newPlotConfig(ctx, canaryOpts.confDir, nil, false, tz, canaryOpts.weekStart)
+	if err != nil {
+		return err
+	}
+	cfg.BasisTime = basisTime.In(tz)
+	cfg.TemplateParams, err = parseTemplateParams(canaryOpts.params.Value())
+	if err != nil {
+		return err
+	}
+
+	// the alternate sources replace the production sources of the same name
+	sourceSpecs, err := parseSourceOptions(canaryOpts.sources.Value())
+	if err != nil {
+		return err
+	}
+	for _, spec := range sourceSpecs {
+		if _, ok := cfg.Sources[spec.Name].(*PgDataSource); ok {
+			delete(cfg.Sources, spec.Name)
+		}
+	}
+	if err := addSources(ctx, cfg, sourceSpecs, false); err != nil {
+		return err
+	}
+
+	absBaseline, err := filepath.Abs(canaryOpts.baseline)
+	if err != nil {
+		return fmt.Errorf("failed to find baseline directory: %w", err)
+	}
+	org := Organizer{
+		Base:      absBaseline,
+		Template:  canaryOpts.outputTemplate,
+		Params:    cfg.TemplateParams,
+		WeekStart: cfg.WeekStart,
+	}
+
+	fnames := cc.Args().Slice()
+	failed := 0
+	for _, fname := range fnames {
+		content, err := os.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("failed to read plot definition: %w", err)
+		}
+		// the plot's config has the basis time in the plot's own timezone,
+		// which dates its outputs
+		pd, plotCfg, err := loadPlotDef(ctx, fname, content, os.DirFS(filepath.Dir(fname)), ".", cfg)
+		if err != nil {
+			return fmt.Errorf("plot definition %q: %w", fname, err)
+		}
+		logger := slog.With("name", pd.Name)
+
+		var baselineFilename string
+		if canaryOpts.basis == "now" {
+			baselineFilename, err = org.LatestFilepath(pd)
+		} else {
+			baselineFilename, err = org.Filepath(pd, plotCfg.BasisTime)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to format baseline filename: %w", err)
+		}
+		baseline, err := os.ReadFile(baselineFilename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("%s: no production output to compare with at %s\n", fname, baselineFilename)
+				failed++
+				continue
+			}
+			return fmt.Errorf("failed to read baseline: %w", err)
+		}
+
+		logger.Info("generating canary plot")
+		fig, err := generateFig(ctx, pd, plotCfg, nil)
+		if err != nil {
+			fmt.Printf("%s: failed to generate: %v\n", fname, err)
+			failed++
+			continue
+		}
+		candidate, err := json.Marshal(FigureData{Figure: fig})
+		if err != nil {
+			return fmt.Errorf("failed to marshal to json: %w", err)
+		}
+
+		// compared as the diff command compares two figures, from the
+		// production output to the canary
+		changes, err := DiffFigureData(baseline, candidate, canaryOpts.tolerance)
+		if err != nil {
+			return fmt.Errorf("failed to compare plot %q: %w", pd.Name, err)
+		}
+		if len(changes) == 0 {
+			fmt.Printf("%s: ok\n", fname)
+			continue
+		}
+
+		failed++
+		fmt.Printf("%s: %d differences from production\n", fname, len(changes))
+		for j, c := range changes {
+			if j == canaryOpts.maxDiffs {
+				fmt.Printf("  ... %d more\n", len(changes)-j)
+				break
+			}
+			fmt.Printf("  %s\n", formatFigureChange(c))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d plots differ from production or could not be compared", failed, len(fnames))
+	}
+	return nil
+}
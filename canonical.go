@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// canonicalJSON rewrites a json document in a canonical form so that
+// documents with the same content have the same bytes and can be diffed or
+// compared by hash. The keys of every object are sorted, including those
+// marshalled from struct fields, and numbers are written in the shortest form
+// that parses to the same float64, with negative zero written as zero.
+// Integers are kept as they are written since they may be too large for a
+// float64. The document is indented with two spaces unless compact is set.
+func canonicalJSON(data []byte, compact bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeCanonicalJSON(buf, v); err != nil {
+		return nil, err
+	}
+	if compact {
+		return buf.Bytes(), nil
+	}
+	out := new(bytes.Buffer)
+	if err := json.Indent(out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeCanonicalJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case json.Number:
+		s, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	default:
+		// strings, booleans and null are written as encoding/json writes them
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+func canonicalNumber(n json.Number) (string, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if strings.TrimLeft(s, "-0") == "" {
+			return "0", nil
+		}
+		return s, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid number %q: %w", s, err)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	// encoding/json writes the shortest representation, switching to
+	// exponents for very large and very small magnitudes
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// displayValue returns the value shown for a label or group value of the
+// series. Values listed in the series' value labels are replaced by their
+// display string and other booleans are shown as text, since plotly does not
+// treat booleans as categories.
+func (s *SeriesDef) displayValue(v any) any {
+	if d, ok := s.ValueLabels[stringify(v)]; ok {
+		return d
+	}
+	if b, ok := v.(bool); ok {
+		return strconv.FormatBool(b)
+	}
+	return v
+}
+
+// categoryOrder returns the order in which categorical labels and group
+// values of the series should appear: the series' categories if given,
+// otherwise false before true when the values are booleans. A nil order
+// keeps the order of the data.
+func (s *SeriesDef) categoryOrder(boolValues bool) []string {
+	if len(s.Categories) > 0 {
+		return s.Categories
+	}
+	if boolValues {
+		return []string{stringify(s.displayValue(false)), stringify(s.displayValue(true))}
+	}
+	return nil
+}
+
+// categoryRank returns the position of a displayed value in the category
+// order. Values that are not listed are ranked after all those that are.
+func categoryRank(order []string, v any) int {
+	sv := stringify(v)
+	for i, c := range order {
+		if c == sv {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// sortByCategory orders the points of the series by the rank of their
+// labels in the category order. Points with the same rank keep their
+// original order.
+func (ls *LabeledSeries) sortByCategory(order []string) {
+	if len(order) == 0 || len(ls.Labels) == 0 {
+		return
+	}
+
+	idx := make([]int, len(ls.Labels))
+	ranks := make([]int, len(ls.Labels))
+	for i, l := range ls.Labels {
+		idx[i] = i
+		ranks[i] = categoryRank(order, l)
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return ranks[idx[a]] < ranks[idx[b]]
+	})
+
+	permute := func(vs []any) []any {
+		if len(vs) != len(idx) {
+			return vs
+		}
+		sorted := make([]any, len(vs))
+		for i, j := range idx {
+			sorted[i] = vs[j]
+		}
+		return sorted
+	}
+	ls.Labels = permute(ls.Labels)
+	ls.Values = permute(ls.Values)
+	ls.Text = permute(ls.Text)
+	ls.IDs = permute(ls.IDs)
+	if len(ls.CustomData) == len(idx) {
+		sorted := make([][]any, len(ls.CustomData))
+		for i, j := range idx {
+			sorted[i] = ls.CustomData[j]
+		}
+		ls.CustomData = sorted
+	}
+}
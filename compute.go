@@ -1,75 +1,741 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/exp/slog"
 )
 
 type BinaryPredicate func(x, y any) (any, error)
 
+// errSkipRow may be returned by a predicate to omit a row from the computed dataset
+var errSkipRow = errors.New("skip row")
+
 type ComputeInput struct {
 	Def     ComputeDataSetDef
 	DataSet DataSet
 }
 
-func ComputeBinaryPredicate(ctx context.Context, pred BinaryPredicate, in1 ComputeInput, in2 ComputeInput) (DataSet, error) {
-	in1.DataSet.ResetIterator()
-	in2.DataSet.ResetIterator()
+func ComputeBinaryPredicate(ctx context.Context, pred BinaryPredicate, joinType JoinType, in1 ComputeInput, in2 ComputeInput) (DataSet, error) {
+	return ComputeReduce(ctx, pred, joinType, in1, in2)
+}
 
-	rows2 := make(map[any]any)
-	for in2.DataSet.Next() {
-		join := in2.DataSet.Field(in2.Def.JoinField)
-		if err, ok := join.(error); ok {
-			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in2.Def.ValueField, in2.Def.DataSet, err)
+// ComputeReduce joins the input datasets on their join fields and combines
+// the value fields of each joined row by applying the predicate from left to
+// right. The join type determines which join field values are included, see
+// joinRows. Rows for which the predicate returns errSkipRow are omitted.
+func ComputeReduce(ctx context.Context, pred BinaryPredicate, joinType JoinType, in1 ComputeInput, others ...ComputeInput) (DataSet, error) {
+	return ComputeAggregate(ctx, foldValues(pred), joinType, append([]ComputeInput{in1}, others...)...)
+}
+
+// An AggregateFunc combines the values of a joined row into a single value
+type AggregateFunc func(values []any) (any, error)
+
+// aggregateFuncs maps computed dataset functions to the aggregate functions
+// that implement them
+var aggregateFuncs = map[ComputeType]AggregateFunc{
+	ComputeTypeSum:  foldValues(sum2),
+	ComputeTypeMean: meanN,
+	ComputeTypeMin:  minN,
+	ComputeTypeMax:  maxN,
+}
+
+// ComputeAggregate joins the input datasets on their join fields and
+// combines the value fields of each joined row using the aggregate function.
+// The join type determines which join field values are included, see
+// joinRows. Rows for which the function returns errSkipRow are omitted.
+func ComputeAggregate(ctx context.Context, fn AggregateFunc, joinType JoinType, inputs ...ComputeInput) (DataSet, error) {
+	rows, err := joinRows(joinType, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]any)
+	for _, row := range rows {
+		var res any
+		if !row.missing {
+			res, err = fn(row.values)
+			if err != nil {
+				if errors.Is(err, errSkipRow) {
+					continue
+				}
+				return nil, err
+			}
 		}
-		value := in2.DataSet.Field(in2.Def.ValueField)
+
+		data["field"] = append(data["field"], row.join)
+		data["value"] = append(data["value"], res)
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// foldValues returns an aggregate function that applies the predicate to
+// the values from left to right
+func foldValues(pred BinaryPredicate) AggregateFunc {
+	return func(values []any) (any, error) {
+		res := values[0]
+		for _, value := range values[1:] {
+			var err error
+			res, err = pred(res, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+}
+
+// numericValues converts values to floats, ignoring nulls
+func numericValues(values []any) ([]float64, error) {
+	fs := make([]float64, 0, len(values))
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		f, ok := toFloat64(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot aggregate value of type %T", v)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// meanN returns the mean of the non-null values, or null if there are none
+func meanN(values []any) (any, error) {
+	fs, err := numericValues(values)
+	if err != nil || len(fs) == 0 {
+		return nil, err
+	}
+	total := 0.0
+	for _, f := range fs {
+		total += f
+	}
+	return total / float64(len(fs)), nil
+}
+
+// minN returns the smallest of the non-null values, or null if there are none
+func minN(values []any) (any, error) {
+	fs, err := numericValues(values)
+	if err != nil || len(fs) == 0 {
+		return nil, err
+	}
+	return slices.Min(fs), nil
+}
+
+// maxN returns the largest of the non-null values, or null if there are none
+func maxN(values []any) (any, error) {
+	fs, err := numericValues(values)
+	if err != nil || len(fs) == 0 {
+		return nil, err
+	}
+	return slices.Max(fs), nil
+}
+
+// ComputeHistogram counts the values of the value field of the input in
+// bins of the given width. Bins are aligned to start, so that each bin covers
+// start+n*width up to but excluding start+(n+1)*width. Empty bins between the
+// smallest and largest values are included with a zero count. Null values are
+// ignored. The computed dataset has the lower bound and count of each bin.
+func ComputeHistogram(ctx context.Context, in ComputeInput, width float64, start float64) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	counts := make(map[int64]int)
+	var first, last int64
+	for in.DataSet.Next() {
+		value := in.DataSet.Field(in.Def.ValueField)
 		if err, ok := value.(error); ok {
-			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in2.Def.ValueField, in2.Def.DataSet, err)
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		if value == nil {
+			continue
+		}
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot bin value of type %T", value)
+		}
+
+		bin := int64(math.Floor((f - start) / width))
+		if len(counts) == 0 || bin < first {
+			first = bin
+		}
+		if len(counts) == 0 || bin > last {
+			last = bin
+		}
+		counts[bin]++
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	data := make(map[string][]any)
+	if len(counts) == 0 {
+		return NewStaticDataSet(data), nil
+	}
+	for bin := first; bin <= last; bin++ {
+		data["field"] = append(data["field"], start+float64(bin)*width)
+		data["value"] = append(data["value"], counts[bin])
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// ComputeCorrelation computes the Pearson correlation coefficient between
+// each pair of the fields of a wide dataset. Each pair is computed over the
+// rows where both fields are non-null, and the coefficient is null if there
+// are fewer than two such rows or either field is constant. The computed
+// dataset has a row for every ordered pair of fields, with the fields as x
+// and y and the coefficient as value, so it can be used as a heatmap table.
+func ComputeCorrelation(ctx context.Context, in ComputeInput, fields []string) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	columns := make([][]*float64, len(fields))
+	for in.DataSet.Next() {
+		for i, field := range fields {
+			value := in.DataSet.Field(field)
+			if err, ok := value.(error); ok {
+				return nil, fmt.Errorf("did not get field value %q from dataset %q: %w", field, in.Def.DataSet, err)
+			}
+			if value == nil {
+				columns[i] = append(columns[i], nil)
+				continue
+			}
+			f, ok := toFloat64(value)
+			if !ok {
+				return nil, fmt.Errorf("cannot correlate value of type %T in field %q", value, field)
+			}
+			columns[i] = append(columns[i], &f)
 		}
-		rows2[stringify(join)] = value
 	}
-	if in2.DataSet.Err() != nil {
-		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in2.DataSet.Err())
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
 	}
 
 	data := make(map[string][]any)
+	for i, fx := range fields {
+		for j, fy := range fields {
+			data["x"] = append(data["x"], fx)
+			data["y"] = append(data["y"], fy)
+			data["value"] = append(data["value"], pearson(columns[i], columns[j]))
+		}
+	}
 
-	for in1.DataSet.Next() {
+	return NewStaticDataSet(data), nil
+}
 
-		join := in1.DataSet.Field(in1.Def.JoinField)
+// pearson returns the correlation coefficient of the pairs of values that
+// are both non-null, or nil if it is undefined
+func pearson(xs, ys []*float64) any {
+	var n, sx, sy, sxx, syy, sxy float64
+	for i := range xs {
+		if xs[i] == nil || ys[i] == nil {
+			continue
+		}
+		x, y := *xs[i], *ys[i]
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+	}
+	if n < 2 {
+		return nil
+	}
+	cov := sxy - sx*sy/n
+	vx := sxx - sx*sx/n
+	vy := syy - sy*sy/n
+	if vx <= 0 || vy <= 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(vx*vy)
+	return math.Max(-1, math.Min(1, r))
+}
+
+// ComputeSelect copies the given fields of the input, or all of its fields
+// if none are given, renaming any that appear in rename. The computed
+// dataset has a field for each field copied, named by rename or by its
+// original name.
+func ComputeSelect(ctx context.Context, in ComputeInput, fields []string, rename map[string]string) (DataSet, error) {
+	if len(fields) == 0 {
+		sds, ok := in.DataSet.(*StaticDataSet)
+		if !ok {
+			return nil, fmt.Errorf("fields must be listed to select from dataset %q", in.Def.DataSet)
+		}
+		for field := range sds.Data {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+
+	names := make(map[string]string, len(fields))
+	for _, field := range fields {
+		name := field
+		if renamed, ok := rename[field]; ok {
+			name = renamed
+		}
+		for other, otherName := range names {
+			if otherName == name {
+				return nil, fmt.Errorf("fields %q and %q would both be named %q", other, field, name)
+			}
+		}
+		names[field] = name
+	}
+
+	in.DataSet.ResetIterator()
+	data := make(map[string][]any, len(fields))
+	for _, field := range fields {
+		data[names[field]] = []any{}
+	}
+	for in.DataSet.Next() {
+		for _, field := range fields {
+			value := in.DataSet.Field(field)
+			if err, ok := value.(error); ok {
+				return nil, fmt.Errorf("did not get field value %q from dataset %q: %w", field, in.Def.DataSet, err)
+			}
+			data[names[field]] = append(data[names[field]], value)
+		}
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// orderComputed orders computed datasets so that each comes after the
+// computed datasets it uses as inputs, otherwise keeping the order they were
+// defined in. It returns an error if computed datasets depend on each other
+// in a cycle.
+func orderComputed(computed []ComputedDef) ([]ComputedDef, error) {
+	index := make(map[string]int, len(computed))
+	for i, cds := range computed {
+		if _, exists := index[cds.Name]; exists {
+			return nil, fmt.Errorf("duplicate computed dataset name: %q", cds.Name)
+		}
+		index[cds.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(computed))
+	ordered := make([]ComputedDef, 0, len(computed))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("computed datasets form a cycle: %s", strings.Join(append(path, computed[i].Name), " -> "))
+		}
+		state[i] = visiting
+		path = append(path, computed[i].Name)
+		for _, ds := range computed[i].DataSets {
+			if j, ok := index[ds.DataSet]; ok {
+				if err := visit(j, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = visited
+		ordered = append(ordered, computed[i])
+		return nil
+	}
+
+	for i := range computed {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// A joinedRow holds the value fields of the inputs for a join field value
+type joinedRow struct {
+	join    any
+	values  []any // the value of each input, in order
+	missing bool  // true if an input had no row and no fill value
+}
+
+// joinRows joins the inputs on their join fields. An inner join only
+// includes join field values present in every input. A left join includes
+// every join field value of the first input and a full join every value of
+// any input, in ascending order if they are all times or all numbers and
+// otherwise in the order they are first seen. Inputs without a row for a
+// join field value use their fill value, or mark the row as missing if they
+// have none.
+func joinRows(joinType JoinType, inputs []ComputeInput) ([]joinedRow, error) {
+	type inputRows struct {
+		values map[string]any
+		order  []any
+	}
+
+	all := make([]inputRows, len(inputs))
+	for i, in := range inputs {
+		values, order, err := readJoinValues(in)
+		if err != nil {
+			return nil, err
+		}
+		all[i] = inputRows{values: values, order: order}
+	}
+
+	// determine the join field values to include
+	keys := all[0].order
+	if joinType == JoinTypeFull {
+		seen := make(map[string]bool)
+		keys = nil
+		for _, ir := range all {
+			for _, join := range ir.order {
+				if !seen[stringify(join)] {
+					seen[stringify(join)] = true
+					keys = append(keys, join)
+				}
+			}
+		}
+		sortJoinKeys(keys)
+	}
+
+	var rows []joinedRow
+keys:
+	for _, join := range keys {
+		row := joinedRow{join: join, values: make([]any, len(inputs))}
+		for i, ir := range all {
+			value, ok := ir.values[stringify(join)]
+			if ok {
+				row.values[i] = value
+				continue
+			}
+			if joinType == JoinTypeInner || joinType == "" {
+				slog.Debug("no matching row for join field", "join", join)
+				continue keys
+			}
+			if inputs[i].Def.Fill == nil {
+				row.missing = true
+				continue
+			}
+			row.values[i] = inputs[i].Def.Fill
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// sortJoinKeys sorts join field values in ascending order if they are all
+// times or all numbers. Other values are left in the order given.
+func sortJoinKeys(keys []any) {
+	times := make([]time.Time, len(keys))
+	nums := make([]float64, len(keys))
+	allTimes, allNums := true, true
+	for i, k := range keys {
+		var ok bool
+		if allTimes {
+			times[i], ok = toTime(k)
+			allTimes = ok
+		}
+		if allNums {
+			nums[i], ok = toFloat64(k)
+			allNums = ok
+		}
+	}
+
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	switch {
+	case allTimes:
+		sort.SliceStable(idx, func(a, b int) bool { return times[idx[a]].Before(times[idx[b]]) })
+	case allNums:
+		sort.SliceStable(idx, func(a, b int) bool { return nums[idx[a]] < nums[idx[b]] })
+	default:
+		return
+	}
+
+	sorted := make([]any, len(keys))
+	for i, j := range idx {
+		sorted[i] = keys[j]
+	}
+	copy(keys, sorted)
+}
+
+// readJoinValues reads the value field of every row of the input, keyed by
+// the stringified join field. It also returns the join field values in the
+// order they were read. It is an error for the input to have more than one
+// row with the same join field value.
+func readJoinValues(in ComputeInput) (map[string]any, []any, error) {
+	in.DataSet.ResetIterator()
+
+	rows := make(map[string]any)
+	var order []any
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
 		if err, ok := join.(error); ok {
-			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in1.Def.ValueField, in1.Def.DataSet, err)
+			return nil, nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		key := stringify(join)
+		if _, exists := rows[key]; exists {
+			return nil, nil, fmt.Errorf("dataset %q has more than one row with join field %q value %s", in.Def.DataSet, in.Def.JoinField, key)
 		}
+		order = append(order, join)
+		rows[key] = value
+	}
+	if in.DataSet.Err() != nil {
+		return nil, nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+	return rows, order, nil
+}
 
-		value2, ok := rows2[stringify(join)]
+// ComputePercentOfTotal converts the value field of each row of the input to
+// a fraction of the total of all values, where 1 is 100%. If the input has a
+// group field then the total of the row's group is used instead. The computed
+// dataset has the join field, value and, if grouped, group fields of each row.
+func ComputePercentOfTotal(ctx context.Context, in ComputeInput) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	totals := make(map[string]float64)
+	for in.DataSet.Next() {
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		f, ok := toFloat64(value)
 		if !ok {
-			slog.Debug("no matching row for join field", "join", join)
 			continue
 		}
-
-		value1 := in1.DataSet.Field(in1.Def.ValueField)
-		if err, ok := value1.(error); ok {
-			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in1.Def.ValueField, in1.Def.DataSet, err)
+		group, err := groupValue(in)
+		if err != nil {
+			return nil, err
 		}
+		totals[stringify(group)] += f
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
 
-		res, err := pred(value1, value2)
+	in.DataSet.ResetIterator()
+	data := make(map[string][]any)
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		group, err := groupValue(in)
 		if err != nil {
 			return nil, err
 		}
 
+		var res any
+		f, ok := toFloat64(in.DataSet.Field(in.Def.ValueField))
+		if total := totals[stringify(group)]; ok && total != 0 {
+			res = f / total
+		}
+
 		data["field"] = append(data["field"], join)
 		data["value"] = append(data["value"], res)
+		if in.Def.GroupField != "" {
+			data["group"] = append(data["group"], group)
+		}
 	}
-	if in1.DataSet.Err() != nil {
-		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in1.DataSet.Err())
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
 	}
 
 	return NewStaticDataSet(data), nil
 }
 
+// ComputeCumulativeSum orders the rows of the input by its order field, or
+// its join field if no order field is given, and computes the running total
+// of the value field. The computed dataset has the join field and running
+// total of each row.
+func ComputeCumulativeSum(ctx context.Context, in ComputeInput) (DataSet, error) {
+	orderField := in.Def.OrderField
+	if orderField == "" {
+		orderField = in.Def.JoinField
+	}
+
+	type row struct {
+		order any
+		join  any
+		value float64
+	}
+
+	in.DataSet.ResetIterator()
+	var rows []row
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		order := in.DataSet.Field(orderField)
+		if err, ok := order.(error); ok {
+			return nil, fmt.Errorf("did not get order field value %q from dataset %q: %w", orderField, in.Def.DataSet, err)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		f, ok := toFloat64(value)
+		if !ok && value != nil {
+			return nil, fmt.Errorf("cannot calculate cumulative sum of %T", value)
+		}
+		rows = append(rows, row{order: order, join: join, value: f})
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareValues(rows[i].order, rows[j].order) < 0
+	})
+
+	data := make(map[string][]any)
+	total := 0.0
+	for _, r := range rows {
+		total += r.value
+		data["field"] = append(data["field"], r.join)
+		data["value"] = append(data["value"], total)
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// ComputeSort orders the rows of the input by its order field, or its value
+// field if no order field is given. Rows with a null order value are placed
+// last in either direction. The computed dataset has the join field and value
+// of each row.
+func ComputeSort(ctx context.Context, in ComputeInput, order SortOrder) (DataSet, error) {
+	orderField := in.Def.OrderField
+	if orderField == "" {
+		orderField = in.Def.ValueField
+	}
+
+	type row struct {
+		order any
+		join  any
+		value any
+	}
+
+	in.DataSet.ResetIterator()
+	var rows []row
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		ord := in.DataSet.Field(orderField)
+		if err, ok := ord.(error); ok {
+			return nil, fmt.Errorf("did not get order field value %q from dataset %q: %w", orderField, in.Def.DataSet, err)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		rows = append(rows, row{order: ord, join: join, value: value})
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].order == nil || rows[j].order == nil {
+			return rows[j].order == nil && rows[i].order != nil
+		}
+		if order == SortOrderDesc {
+			return compareValues(rows[i].order, rows[j].order) > 0
+		}
+		return compareValues(rows[i].order, rows[j].order) < 0
+	})
+
+	data := make(map[string][]any)
+	for _, r := range rows {
+		data["field"] = append(data["field"], r.join)
+		data["value"] = append(data["value"], r.value)
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// ComputeLimit drops the first offset rows of the input and keeps at most
+// limit of the remainder, or all of them if limit is zero. The computed
+// dataset has the join field and value of each row kept.
+func ComputeLimit(ctx context.Context, in ComputeInput, offset int, limit int) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	data := make(map[string][]any)
+	n := 0
+	for in.DataSet.Next() {
+		n++
+		if n <= offset {
+			continue
+		}
+		if limit > 0 && n > offset+limit {
+			break
+		}
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		data["field"] = append(data["field"], join)
+		data["value"] = append(data["value"], value)
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// compareValues orders two field values, comparing numbers numerically,
+// times chronologically and anything else by its string form
+func compareValues(a, b any) int {
+	if fa, ok := toFloat64(a); ok {
+		if fb, ok := toFloat64(b); ok {
+			return cmp.Compare(fa, fb)
+		}
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(stringify(a), stringify(b))
+}
+
+// groupValue returns the value of the group field of the current row of the
+// input, or nil if the input is not grouped
+func groupValue(in ComputeInput) (any, error) {
+	if in.Def.GroupField == "" {
+		return nil, nil
+	}
+	group := in.DataSet.Field(in.Def.GroupField)
+	if err, ok := group.(error); ok {
+		return nil, fmt.Errorf("did not get group field value %q from dataset %q: %w", in.Def.GroupField, in.Def.DataSet, err)
+	}
+	return group, nil
+}
+
 func stringify(v any) string {
 	switch tv := v.(type) {
 	case string:
@@ -91,6 +757,8 @@ func diff2(x, y any) (any, error) {
 			diff = tx - ty
 		case int64:
 			diff = tx - float64(ty)
+		case int:
+			diff = tx - float64(ty)
 		}
 	case int64:
 		switch ty := y.(type) {
@@ -118,3 +786,122 @@ func diff2(x, y any) (any, error) {
 
 	return diff, nil
 }
+
+// sum2 adds two numbers. Sums of integers are integers, other numeric types
+// such as the numerics read from postgres are summed as floats. Nulls are
+// ignored, as by the other aggregates, so the sum is only null if both
+// values are.
+func sum2(x, y any) (any, error) {
+	switch {
+	case isNullValue(x) && isNullValue(y):
+		return nil, nil
+	case isNullValue(x):
+		return y, nil
+	case isNullValue(y):
+		return x, nil
+	}
+
+	switch tx := x.(type) {
+	case int64:
+		switch ty := y.(type) {
+		case int64:
+			return tx + ty, nil
+		case int:
+			return tx + int64(ty), nil
+		}
+	case int:
+		switch ty := y.(type) {
+		case int:
+			return tx + ty, nil
+		case int64:
+			return int64(tx) + ty, nil
+		}
+	}
+
+	fx, okx := toFloat64(x)
+	fy, oky := toFloat64(y)
+	if !okx || !oky {
+		return nil, fmt.Errorf("cannot calculate sum of %T and %T", x, y)
+	}
+	return fx + fy, nil
+}
+
+// isNullValue reports whether a value is null, including the null numerics
+// read from postgres
+func isNullValue(v any) bool {
+	if n, ok := v.(pgtype.Numeric); ok {
+		return !n.Valid
+	}
+	return v == nil
+}
+
+// ratio2 returns a predicate that divides x by y, handling a zero y
+// according to the divide by zero mode. The ratio is null when either value
+// is null.
+func ratio2(divideByZero DivideByZeroType) BinaryPredicate {
+	return func(x, y any) (any, error) {
+		if isNullValue(x) || isNullValue(y) {
+			return nil, nil
+		}
+		fx, ok := toFloat64(x)
+		if !ok {
+			return nil, fmt.Errorf("cannot calculate ratio of %T and %T", x, y)
+		}
+		fy, ok := toFloat64(y)
+		if !ok {
+			return nil, fmt.Errorf("cannot calculate ratio of %T and %T", x, y)
+		}
+
+		if fy == 0 {
+			switch divideByZero {
+			case DivideByZeroZero:
+				return 0.0, nil
+			case DivideByZeroSkip:
+				return nil, errSkipRow
+			default:
+				return nil, nil
+			}
+		}
+
+		return fx / fy, nil
+	}
+}
+
+// toFloat64 converts a numeric value to a float64, reporting whether the
+// conversion was possible
+func toFloat64(v any) (float64, bool) {
+	switch tv := v.(type) {
+	case float64:
+		return tv, true
+	case float32:
+		return float64(tv), true
+	case int:
+		return float64(tv), true
+	case int64:
+		return float64(tv), true
+	case int32:
+		return float64(tv), true
+	case int16:
+		return float64(tv), true
+	case int8:
+		return float64(tv), true
+	case uint:
+		return float64(tv), true
+	case uint64:
+		return float64(tv), true
+	case uint32:
+		return float64(tv), true
+	case uint16:
+		return float64(tv), true
+	case uint8:
+		return float64(tv), true
+	case pgtype.Numeric:
+		f, err := tv.Float64Value()
+		if err != nil || !f.Valid {
+			return 0, false
+		}
+		return f.Float64, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestRatio2(t *testing.T) {
+	tests := []struct {
+		name         string
+		x, y         any
+		divideByZero DivideByZeroType
+		want         any
+	}{
+		{name: "ratio", x: 3.0, y: 2.0, want: 1.5},
+		{name: "null numerator", x: nil, y: 2.0, want: nil},
+		{name: "null denominator", x: 3.0, y: nil, want: nil},
+		{name: "null numeric", x: pgtype.Numeric{}, y: 2.0, want: nil},
+		{name: "null with zero mode", x: nil, y: 0.0, divideByZero: DivideByZeroZero, want: nil},
+		{name: "zero denominator", x: 3.0, y: 0.0, want: nil},
+		{name: "zero denominator with zero mode", x: 3.0, y: 0.0, divideByZero: DivideByZeroZero, want: 0.0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ratio2(tc.divideByZero)(tc.x, tc.y)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// DashboardDef combines the outputs of several plots of a processing profile
+// into a single file, with hints for laying them out in a grid
+type DashboardDef struct {
+	Name      string              `yaml:"name"`
+	Title     string              `yaml:"title"`
+	Frequency PlotFrequency       `yaml:"frequency"`
+	Columns   int                 `yaml:"columns"` // the number of columns in the grid
+	Panels    []DashboardPanelDef `yaml:"panels"`
+}
+
+type DashboardPanelDef struct {
+	Plot   string `yaml:"plot" json:"plot"`               // the filename of the plot definition, relative to the profile source
+	Title  string `yaml:"title" json:"title,omitempty"`   // optional title of the panel
+	Row    int    `yaml:"row" json:"row"`                 // the grid row of the top of the panel, starting at 0
+	Column int    `yaml:"column" json:"column"`           // the grid column of the left of the panel, starting at 0
+	Width  int    `yaml:"width" json:"width,omitempty"`   // the number of grid columns spanned by the panel, defaults to 1
+	Height int    `yaml:"height" json:"height,omitempty"` // the number of grid rows spanned by the panel, defaults to 1
+}
+
+// DashboardData is the output of a dashboard
+type DashboardData struct {
+	Name    string               `json:"name"`
+	Title   string               `json:"title,omitempty"`
+	Columns int                  `json:"columns,omitempty"`
+	Panels  []DashboardPanelData `json:"panels"`
+}
+
+type DashboardPanelData struct {
+	DashboardPanelDef
+	Figure    json.RawMessage `json:"figure"`              // null when the plot has no output for the period
+	Stale     bool            `json:"stale,omitempty"`     // the figure was written by an earlier run because the plot was not generated in this one
+	Generated *time.Time      `json:"generated,omitempty"` // when a stale figure was written
+	Error     string          `json:"error,omitempty"`     // why the plot was not generated in this run
+}
+
+func (d *DashboardDef) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("dashboard has no name")
+	}
+	if d.Frequency.Validate() != nil {
+		return fmt.Errorf("dashboard %q has unsupported frequency: %q", d.Name, d.Frequency)
+	}
+	if len(d.Panels) == 0 {
+		return fmt.Errorf("dashboard %q has no panels", d.Name)
+	}
+	for i := range d.Panels {
+		p := &d.Panels[i]
+		if p.Plot == "" {
+			return fmt.Errorf("dashboard %q panel %d has no plot", d.Name, i+1)
+		}
+		if p.Row < 0 || p.Column < 0 || p.Width < 0 || p.Height < 0 {
+			return fmt.Errorf("dashboard %q panel %d has a negative position or size", d.Name, i+1)
+		}
+		if p.Width == 0 {
+			p.Width = 1
+		}
+		if p.Height == 0 {
+			p.Height = 1
+		}
+		if d.Columns > 0 && p.Column+p.Width > d.Columns {
+			return fmt.Errorf("dashboard %q panel %d extends beyond column %d", d.Name, i+1, d.Columns)
+		}
+	}
+	return nil
+}
+
+// writeDashboard combines the outputs of the panel plots, as recorded in the
+// results of the batch keyed by plot definition filename, and writes them
+// through the organizer, as json or, for xlsx outputs, as a single workbook.
+// A dashboard is only written if one of its plots was generated or it does
+// not exist yet. Panels whose plot was not generated in this run keep the
+// output of an earlier run marked as stale, or have no figure when there is
+// none, along with the reason. Json output is compact or canonical like the
+// outputs of plots.
+func (d *DashboardDef) writeDashboard(ctx context.Context, org *Organizer, basisTime time.Time, force bool, compact bool, canonical bool, plotResults map[string]*BatchResult) *BatchResult {
+	pd := &PlotDef{Name: d.Name, Frequency: d.Frequency}
+	res := &BatchResult{
+		Name:       d.Name,
+		Definition: "profiles.yaml",
+		Status:     BatchStatusFailed,
+		Frequency:  d.Frequency,
+	}
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start).Seconds()
+	}()
+
+	logger := slog.With("dashboard", d.Name)
+	var err error
+	if res.Plot, err = org.Filename(d.Name); err != nil {
+		res.Plot = d.Name
+	}
+	res.Output, err = org.Filepath(pd, basisTime)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to format output filename: %v", err)
+		return res
+	}
+
+	anyGenerated := false
+	for _, p := range d.Panels {
+		if pr, ok := plotResults[p.Plot]; ok && pr.Status == BatchStatusGenerated {
+			anyGenerated = true
+		}
+	}
+	if !force && !anyGenerated {
+		if _, err := org.store().ModTime(ctx, res.Output); err == nil {
+			logger.Info("skipping dashboard, output already exists")
+			res.Status = BatchStatusSkipped
+			return res
+		} else if !errors.Is(err, os.ErrNotExist) {
+			res.Error = fmt.Sprintf("failed to stat dashboard output: %v", err)
+			return res
+		}
+	}
+
+	panels := make([]DashboardPanelData, 0, len(d.Panels))
+	outputs := make([][]byte, 0, len(d.Panels))
+	for _, p := range d.Panels {
+		pr, ok := plotResults[p.Plot]
+		if !ok {
+			res.Error = fmt.Sprintf("plot %q of panel was not processed", p.Plot)
+			return res
+		}
+		panel := DashboardPanelData{DashboardPanelDef: p}
+		if pr.Output == "" {
+			// the plot failed before its output path was known, such as
+			// when its definition could not be parsed
+			panel.Error = pr.Error
+			if panel.Error == "" {
+				panel.Error = fmt.Sprintf("plot was not generated: %s", pr.Status)
+			}
+			logger.Warn("plot of panel has no output", "plot", p.Plot, "status", pr.Status, "error", pr.Error)
+			panels = append(panels, panel)
+			outputs = append(outputs, nil)
+			continue
+		}
+		current := pr.Status == BatchStatusGenerated || pr.Status == BatchStatusSkipped
+		if !current {
+			panel.Error = pr.Error
+			if panel.Error == "" {
+				panel.Error = fmt.Sprintf("plot was not generated: %s", pr.Status)
+			}
+			modTime, err := org.store().ModTime(ctx, pr.Output)
+			if errors.Is(err, os.ErrNotExist) {
+				logger.Warn("plot of panel has no output", "plot", p.Plot, "status", pr.Status)
+				panels = append(panels, panel)
+				outputs = append(outputs, nil)
+				continue
+			} else if err != nil {
+				res.Error = fmt.Sprintf("failed to stat output of plot %q: %v", p.Plot, err)
+				return res
+			}
+			logger.Warn("using stale output of plot for panel", "plot", p.Plot, "status", pr.Status, "generated", modTime)
+			modTime = modTime.UTC()
+			panel.Stale = true
+			panel.Generated = &modTime
+		}
+		output, err := org.store().ReadFile(ctx, pr.Output)
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to read output of plot %q: %v", p.Plot, err)
+			return res
+		}
+		panels = append(panels, panel)
+		outputs = append(outputs, output)
+	}
+
+	var data []byte
+	if org.Format == OutputFormatXLSX {
+		data, err = marshalDashboardWorkbook(d, panels, outputs)
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to marshal to xlsx: %v", err)
+			return res
+		}
+	} else {
+		for i := range panels {
+			panels[i].Figure = outputs[i]
+		}
+		dd := DashboardData{
+			Name:    d.Name,
+			Title:   d.Title,
+			Columns: d.Columns,
+			Panels:  panels,
+		}
+		data, err = marshalJSONOutput(dd, compact, canonical)
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to marshal to json: %v", err)
+			return res
+		}
+	}
+
+	logger.Info("writing dashboard output", "filename", res.Output)
+	if err := org.WritePlot(ctx, data, pd, basisTime); err != nil {
+		res.Error = fmt.Sprintf("failed to write: %v", err)
+		return res
+	}
+	if isLatest, err := org.IsLatest(ctx, pd, basisTime); err == nil && isLatest {
+		res.Latest, _ = org.LatestFilepath(pd)
+	}
+	res.Status = BatchStatusGenerated
+	return res
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteDashboardFailedPanel(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	basisTime := time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)
+
+	org := &Organizer{Base: base, Template: "{{.PlotDefFilename}}.json"}
+	visits := &PlotDef{Name: "visits", Frequency: PlotFrequencyDaily}
+	if err := org.WritePlot(ctx, []byte(`{"data":[]}`), visits, basisTime); err != nil {
+		t.Fatalf("write plot: %v", err)
+	}
+	visitsOutput, err := org.Filepath(visits, basisTime)
+	if err != nil {
+		t.Fatalf("filepath: %v", err)
+	}
+
+	d := &DashboardDef{
+		Name:      "overview",
+		Frequency: PlotFrequencyDaily,
+		Panels: []DashboardPanelDef{
+			{Plot: "visits.yaml"},
+			{Plot: "broken.yaml", Column: 1},
+		},
+	}
+	if err := d.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	results := map[string]*BatchResult{
+		"visits.yaml": {Name: "visits", Output: visitsOutput, Status: BatchStatusGenerated},
+		"broken.yaml": {Status: BatchStatusFailed, Error: "failed to parse: bad yaml"},
+	}
+
+	res := d.writeDashboard(ctx, org, basisTime, false, false, false, results)
+	if res.Status != BatchStatusGenerated {
+		t.Fatalf("got status %q, wanted %q: %s", res.Status, BatchStatusGenerated, res.Error)
+	}
+
+	data, err := os.ReadFile(filepath.Join(base, "2024", "05", "08", "overview.json"))
+	if err != nil {
+		t.Fatalf("read dashboard: %v", err)
+	}
+	if !strings.Contains(string(data), "\n  ") {
+		t.Errorf("dashboard is not indented without compact: %s", data)
+	}
+
+	var dd DashboardData
+	if err := json.Unmarshal(data, &dd); err != nil {
+		t.Fatalf("unmarshal dashboard: %v", err)
+	}
+	if len(dd.Panels) != 2 {
+		t.Fatalf("got %d panels, wanted 2", len(dd.Panels))
+	}
+	if string(dd.Panels[0].Figure) == "null" || dd.Panels[0].Error != "" {
+		t.Errorf("panel of generated plot has figure %s and error %q", dd.Panels[0].Figure, dd.Panels[0].Error)
+	}
+	if string(dd.Panels[1].Figure) != "null" || dd.Panels[1].Error != "failed to parse: bad yaml" {
+		t.Errorf("panel of failed plot has figure %s and error %q", dd.Panels[1].Figure, dd.Panels[1].Error)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// A DependencyDef is a condition that must hold before a plot can be
+// generated, such as a materialized view having been refreshed since the
+// start of the day. The condition is a query returning a single row with a
+// boolean field.
+type DependencyDef struct {
+	Name   string `yaml:"name"`   // a description of the dependency, used in logs and batch results
+	Source string `yaml:"source"` // the name of the source to query
+	Query  string `yaml:"query"`  // the query to run, which should return a single row
+	Field  string `yaml:"field"`  // the boolean field of the row that reports whether the dependency is satisfied. Defaults to ready.
+}
+
+const defaultDependencyField = "ready"
+
+func (d *DependencyDef) String() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return "query on " + d.Source
+}
+
+// Satisfied runs the dependency's query and reports whether its field is true
+func (d *DependencyDef) Satisfied(ctx context.Context, cfg *PlotConfig) (bool, error) {
+	src, exists := cfg.Sources[d.Source]
+	if !exists {
+		return false, fmt.Errorf("unknown dependency source: %q", d.Source)
+	}
+
+	field := d.Field
+	if field == "" {
+		field = defaultDependencyField
+	}
+
+	ds, err := src.GetDataSet(ctx, d.Query)
+	if err != nil {
+		return false, fmt.Errorf("failed to get dataset from source %q: %w", d.Source, err)
+	}
+	ds.ResetIterator()
+	if !ds.Next() {
+		if ds.Err() != nil {
+			return false, fmt.Errorf("dataset iteration ended with an error: %w", ds.Err())
+		}
+		return false, nil
+	}
+
+	switch v := ds.Field(field).(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	case error:
+		return false, fmt.Errorf("did not get field value %q: %w", field, v)
+	default:
+		return false, fmt.Errorf("dependency field %q is %T, not a boolean", field, v)
+	}
+}
+
+// waitForDependencies checks the plot's dependencies, polling until they are
+// all satisfied or the timeout passes. It returns the first dependency that
+// is not satisfied, or nil if all are.
+func waitForDependencies(ctx context.Context, cfg *PlotConfig, pd *PlotDef, interval time.Duration, timeout time.Duration, logger *slog.Logger) (*DependencyDef, error) {
+	deadline := time.Now().Add(timeout)
+	for i := 0; i < len(pd.DependsOn); {
+		dep := &pd.DependsOn[i]
+		ok, err := dep.Satisfied(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %q: %w", dep, err)
+		}
+		if ok {
+			logger.Debug("dependency satisfied", "dependency", dep.String())
+			i++
+			continue
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return dep, nil
+		}
+		logger.Info("waiting for dependency", "dependency", dep.String(), "interval", interval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return nil, nil
+}
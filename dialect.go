@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Dialect identifies the SQL dialect used by the queries of a data source
+type Dialect string
+
+const (
+	DialectPostgres   Dialect = "postgres"
+	DialectClickHouse Dialect = "clickhouse"
+	DialectDuckDB     Dialect = "duckdb"
+)
+
+func (d Dialect) String() string { return string(d) }
+
+func (d Dialect) Validate() error {
+	switch d {
+	case DialectPostgres, DialectClickHouse, DialectDuckDB:
+		return nil
+	default:
+		return fmt.Errorf("unsupported sql dialect: %q", d)
+	}
+}
+
+// A DialectSource is a data source that accepts SQL queries in a specific
+// dialect
+type DialectSource interface {
+	SQLDialect() Dialect
+}
+
+// sourceDialect returns the SQL dialect of the named source
+func (c *PlotConfig) sourceDialect(source string) (Dialect, error) {
+	src, ok := c.Sources[source]
+	if !ok {
+		return "", fmt.Errorf("unknown source: %q", source)
+	}
+	ds, ok := src.(DialectSource)
+	if !ok {
+		return "", fmt.Errorf("source %q does not use sql", source)
+	}
+	return ds.SQLDialect(), nil
+}
+
+// sourceTemplateFuncs returns the template functions that format times in
+// the dialect of a source, for templating a query run against it
+func sourceTemplateFuncs(cfg *PlotConfig, source string) template.FuncMap {
+	return template.FuncMap{
+		"sqlTimestamp":   sqlTimestamp(cfg, source),
+		"sqlTimestampTZ": sqlTimestampTZ(cfg, source),
+	}
+}
+
+// sqlTimestamp returns a template function that formats a time as a timestamp
+// literal in the dialect of a source. The source may be named before the
+// time, otherwise defaultSource is used, which is the source of the dataset
+// whose query is templated.
+func sqlTimestamp(cfg *PlotConfig, defaultSource string) func(args ...any) (string, error) {
+	return dialectFunc(cfg, defaultSource, map[Dialect]func(time.Time) string{
+		DialectPostgres:   pgTimestamp,
+		DialectClickHouse: clickhouseDateTime,
+		DialectDuckDB:     duckdbTimestamp,
+	})
+}
+
+// sqlTimestampTZ returns a template function that formats a time as a
+// timestamp literal with a time zone in the dialect of a source, which is
+// chosen as for sqlTimestamp
+func sqlTimestampTZ(cfg *PlotConfig, defaultSource string) func(args ...any) (string, error) {
+	return dialectFunc(cfg, defaultSource, map[Dialect]func(time.Time) string{
+		DialectPostgres:   pgTimestampTZ,
+		DialectClickHouse: clickhouseDateTime,
+		DialectDuckDB:     duckdbTimestampTZ,
+	})
+}
+
+// dialectFunc returns a template function taking an optional source name
+// and a time, which formats the time using the function for the dialect of
+// the source
+func dialectFunc(cfg *PlotConfig, defaultSource string, fns map[Dialect]func(time.Time) string) func(args ...any) (string, error) {
+	return func(args ...any) (string, error) {
+		source := defaultSource
+		switch len(args) {
+		case 1:
+		case 2:
+			s, ok := args[0].(string)
+			if !ok {
+				return "", fmt.Errorf("source name must be a string, got %T", args[0])
+			}
+			source = s
+		default:
+			return "", fmt.Errorf("expected an optional source name and a time, got %d arguments", len(args))
+		}
+		t, ok := args[len(args)-1].(time.Time)
+		if !ok {
+			return "", fmt.Errorf("expected a time, got %T", args[len(args)-1])
+		}
+		if source == "" {
+			// a plot definition is first templated as a whole, outside any
+			// dataset, and its queries again with their own sources
+			return "", nil
+		}
+
+		dialect, err := cfg.sourceDialect(source)
+		if err != nil {
+			return "", err
+		}
+		fn, ok := fns[dialect]
+		if !ok {
+			return "", fmt.Errorf("unsupported sql dialect: %q", dialect)
+		}
+		return fn(t), nil
+	}
+}
+
+func clickhouseDateTime(t time.Time) string {
+	return "toDateTime('" + t.UTC().Format("2006-01-02 15:04:05") + "', 'UTC')"
+}
+
+func duckdbTimestamp(t time.Time) string {
+	return "TIMESTAMP '" + t.UTC().Format("2006-01-02 15:04:05") + "'"
+}
+
+func duckdbTimestampTZ(t time.Time) string {
+	return "TIMESTAMPTZ '" + t.UTC().Format("2006-01-02 15:04:05") + "+00'"
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+)
+
+var diffCommand = &cli.Command{
+	Name:      "diff",
+	Usage:     "Compare two generated figures, listing the traces, points and layout settings that changed",
+	ArgsUsage: "<old figure json> <new figure json>",
+	Action:    Diff,
+	Flags: append([]cli.Flag{
+		&cli.Float64Flag{
+			Name:        "threshold",
+			Required:    false,
+			Usage:       "Minimum relative difference between numeric values to report. Use 0 to report every change.",
+			Value:       0.01,
+			Destination: &diffOpts.threshold,
+		},
+		&cli.IntFlag{
+			Name:        "max-changes",
+			Required:    false,
+			Usage:       "Maximum number of changes to print, or 0 to print all of them. The json output always has every change.",
+			Value:       50,
+			Destination: &diffOpts.maxChanges,
+		},
+		&cli.BoolFlag{
+			Name:        "json",
+			Required:    false,
+			Usage:       "Print the changes as json.",
+			Destination: &diffOpts.json,
+		},
+		&cli.BoolFlag{
+			Name:        "exit-code",
+			Required:    false,
+			Usage:       "Fail if the figures differ, as git diff --exit-code does.",
+			Destination: &diffOpts.exitCode,
+		},
+	}, loggingFlags...),
+}
+
+var diffOpts struct {
+	threshold  float64
+	maxChanges int
+	json       bool
+	exitCode   bool
+}
+
+type FigureChangeKind string
+
+const (
+	FigureChangeAdded   FigureChangeKind = "added"
+	FigureChangeRemoved FigureChangeKind = "removed"
+	FigureChangeChanged FigureChangeKind = "changed"
+)
+
+func (k FigureChangeKind) String() string { return string(k) }
+
+// A FigureChange is a difference between two figures. Changes to a whole
+// trace have no path; changes to a point of a trace have the label of the
+// point.
+type FigureChange struct {
+	Kind  FigureChangeKind `json:"kind"`
+	Trace string           `json:"trace,omitempty"` // the trace that changed, empty for changes to the layout
+	Path  string           `json:"path,omitempty"`  // the attribute of the trace or layout that changed
+	Label any              `json:"label,omitempty"` // the label of the point that changed
+	Old   any              `json:"old,omitempty"`
+	New   any              `json:"new,omitempty"`
+}
+
+// diffPointAttrs are the trace attributes that hold a value for each point.
+// When the points of a trace are matched by their labels these are not
+// compared, since they change whenever points are added or removed.
+var diffPointAttrs = map[string]bool{"text": true, "hovertext": true, "ids": true, "customdata": true}
+
+func Diff(cc *cli.Context) error {
+	setupLogging()
+
+	if cc.NArg() != 2 {
+		return fmt.Errorf("the old and new figure json files must be supplied as arguments")
+	}
+	if diffOpts.threshold < 0 {
+		return fmt.Errorf("threshold must not be negative")
+	}
+	oldFname, newFname := cc.Args().Get(0), cc.Args().Get(1)
+	oldContent, err := os.ReadFile(oldFname)
+	if err != nil {
+		return fmt.Errorf("failed to read old figure: %w", err)
+	}
+	newContent, err := os.ReadFile(newFname)
+	if err != nil {
+		return fmt.Errorf("failed to read new figure: %w", err)
+	}
+
+	changes, err := DiffFigureData(oldContent, newContent, diffOpts.threshold)
+	if err != nil {
+		return err
+	}
+
+	if diffOpts.json {
+		if changes == nil {
+			changes = []FigureChange{}
+		}
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal changes: %w", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		for i, c := range changes {
+			if i == diffOpts.maxChanges && diffOpts.maxChanges > 0 {
+				fmt.Printf("... %d more changes\n", len(changes)-i)
+				break
+			}
+			fmt.Println(formatFigureChange(c))
+		}
+	}
+
+	if diffOpts.exitCode && len(changes) > 0 {
+		return fmt.Errorf("figures differ by %d changes", len(changes))
+	}
+	return nil
+}
+
+// DiffFigureData compares the traces and layout of two plotly outputs.
+// Traces are matched by name, or by position if their names are missing or
+// not unique, and their points are matched by label so that a plot whose
+// period moved on shows the points that were added and removed rather than
+// every value changing. Numbers are equal if their relative difference is
+// within the threshold. The metadata of the figures is not compared. It is
+// also how canary compares its plots with production outputs.
+func DiffFigureData(oldData, newData []byte, threshold float64) ([]FigureChange, error) {
+	type figure struct {
+		Data   []map[string]any `json:"data"`
+		Layout map[string]any   `json:"layout"`
+	}
+
+	var oldFig, newFig figure
+	if err := json.Unmarshal(oldData, &oldFig); err != nil {
+		return nil, fmt.Errorf("unmarshal old figure: %w", err)
+	}
+	if err := json.Unmarshal(newData, &newFig); err != nil {
+		return nil, fmt.Errorf("unmarshal new figure: %w", err)
+	}
+
+	var changes []FigureChange
+	oldKeys, newKeys := diffTraceKeys(oldFig.Data), diffTraceKeys(newFig.Data)
+	newIndex := make(map[string]int, len(newKeys))
+	for i, key := range newKeys {
+		newIndex[key] = i
+	}
+	matched := make(map[string]bool, len(oldKeys))
+	for i, key := range oldKeys {
+		j, ok := newIndex[key]
+		if !ok {
+			changes = append(changes, FigureChange{Kind: FigureChangeRemoved, Trace: key})
+			continue
+		}
+		matched[key] = true
+		changes = append(changes, diffTrace(key, oldFig.Data[i], newFig.Data[j], threshold)...)
+	}
+	for _, key := range newKeys {
+		if !matched[key] {
+			changes = append(changes, FigureChange{Kind: FigureChangeAdded, Trace: key})
+		}
+	}
+
+	diffValues("", oldFig.Layout, newFig.Layout, threshold, func(c FigureChange) {
+		changes = append(changes, c)
+	})
+	return changes, nil
+}
+
+// diffTraceKeys names each trace by its name if that is unique in the
+// figure, otherwise by its position
+func diffTraceKeys(traces []map[string]any) []string {
+	count := make(map[string]int)
+	for _, t := range traces {
+		if name, _ := t["name"].(string); name != "" {
+			count[name]++
+		}
+	}
+	keys := make([]string, len(traces))
+	for i, t := range traces {
+		if name, _ := t["name"].(string); count[name] == 1 {
+			keys[i] = name
+		} else {
+			keys[i] = fmt.Sprintf("data[%d]", i)
+		}
+	}
+	return keys
+}
+
+func diffTrace(key string, oldTrace, newTrace map[string]any, threshold float64) []FigureChange {
+	var changes []FigureChange
+	add := func(c FigureChange) {
+		c.Trace = key
+		changes = append(changes, c)
+	}
+
+	labelKey, valueKey := "x", "y"
+	if o, _ := newTrace["orientation"].(string); o == "h" {
+		labelKey, valueKey = "y", "x"
+	}
+	if _, ok := newTrace["labels"]; ok {
+		labelKey, valueKey = "labels", "values"
+	}
+	oldPoints, oldOK := diffTracePoints(oldTrace, labelKey, valueKey)
+	newPoints, newOK := diffTracePoints(newTrace, labelKey, valueKey)
+	byLabel := oldOK && newOK
+
+	keys := make(map[string]bool)
+	for k := range oldTrace {
+		keys[k] = true
+	}
+	for k := range newTrace {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		if byLabel && (k == labelKey || k == valueKey || diffPointAttrs[k]) {
+			continue
+		}
+		diffValues(k, oldTrace[k], newTrace[k], threshold, add)
+	}
+
+	if !byLabel {
+		return changes
+	}
+	newByLabel := make(map[string]int, len(newPoints))
+	for i, p := range newPoints {
+		newByLabel[p.key] = i
+	}
+	seen := make(map[string]bool, len(oldPoints))
+	for _, p := range oldPoints {
+		seen[p.key] = true
+		i, ok := newByLabel[p.key]
+		if !ok {
+			add(FigureChange{Kind: FigureChangeRemoved, Path: valueKey, Label: p.label, Old: p.value})
+			continue
+		}
+		if !diffValueEqual(p.value, newPoints[i].value, threshold) {
+			add(FigureChange{Kind: FigureChangeChanged, Path: valueKey, Label: p.label, Old: p.value, New: newPoints[i].value})
+		}
+	}
+	for _, p := range newPoints {
+		if !seen[p.key] {
+			add(FigureChange{Kind: FigureChangeAdded, Path: valueKey, Label: p.label, New: p.value})
+		}
+	}
+	return changes
+}
+
+type diffPoint struct {
+	key   string // the label as text, to match points
+	label any
+	value any
+}
+
+// diffTracePoints returns the points of a trace if they can be matched by
+// label: the labels and values are arrays of the same length and no label is
+// repeated
+func diffTracePoints(t map[string]any, labelKey, valueKey string) ([]diffPoint, bool) {
+	labels, lok := t[labelKey].([]any)
+	values, vok := t[valueKey].([]any)
+	if !lok || !vok || len(labels) != len(values) {
+		return nil, false
+	}
+	points := make([]diffPoint, len(labels))
+	seen := make(map[string]bool, len(labels))
+	for i, l := range labels {
+		key := fmt.Sprint(l)
+		if seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		points[i] = diffPoint{key: key, label: l, value: values[i]}
+	}
+	return points, true
+}
+
+// diffValues reports the differences between two json values, recursing into
+// objects and arrays
+func diffValues(path string, a, b any, threshold float64, add func(FigureChange)) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch {
+	case a == nil && b == nil:
+		return
+	case a == nil:
+		add(FigureChange{Kind: FigureChangeAdded, Path: path, New: b})
+		return
+	case b == nil:
+		add(FigureChange{Kind: FigureChangeRemoved, Path: path, Old: a})
+		return
+	}
+
+	switch ta := a.(type) {
+	case map[string]any:
+		tb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range ta {
+			keys[k] = true
+		}
+		for k := range tb {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValues(join(k), ta[k], tb[k], threshold, add)
+		}
+		return
+	case []any:
+		tb, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(ta), len(tb)); i++ {
+			elem := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(ta):
+				add(FigureChange{Kind: FigureChangeAdded, Path: elem, New: tb[i]})
+			case i >= len(tb):
+				add(FigureChange{Kind: FigureChangeRemoved, Path: elem, Old: ta[i]})
+			default:
+				diffValues(elem, ta[i], tb[i], threshold, add)
+			}
+		}
+		return
+	}
+
+	if !diffValueEqual(a, b, threshold) {
+		add(FigureChange{Kind: FigureChangeChanged, Path: path, Old: a, New: b})
+	}
+}
+
+// diffValueEqual compares two scalar json values. Numbers are equal if their
+// relative difference is within the threshold.
+func diffValueEqual(a, b any, threshold float64) bool {
+	fa, aok := a.(float64)
+	fb, bok := b.(float64)
+	if aok && bok {
+		scale := math.Max(math.Abs(fa), math.Abs(fb))
+		return scale == 0 || math.Abs(fa-fb)/scale <= threshold
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// formatFigureChange describes a change on one line, prefixed with +, - or ~
+// as in a diff
+func formatFigureChange(c FigureChange) string {
+	where := "layout"
+	if c.Trace != "" {
+		where = fmt.Sprintf("trace %q", c.Trace)
+	}
+	if c.Path != "" {
+		where += " " + c.Path
+	}
+	if c.Label != nil {
+		where += " at " + formatDiffValue(c.Label)
+	}
+
+	switch c.Kind {
+	case FigureChangeAdded:
+		if c.New == nil {
+			return "+ " + where
+		}
+		return fmt.Sprintf("+ %s: %s", where, formatDiffValue(c.New))
+	case FigureChangeRemoved:
+		if c.Old == nil {
+			return "- " + where
+		}
+		return fmt.Sprintf("- %s: %s", where, formatDiffValue(c.Old))
+	default:
+		s := fmt.Sprintf("~ %s: %s -> %s", where, formatDiffValue(c.Old), formatDiffValue(c.New))
+		if fa, ok := c.Old.(float64); ok && fa != 0 {
+			if fb, ok := c.New.(float64); ok {
+				s += fmt.Sprintf(" (%+.1f%%)", (fb-fa)/math.Abs(fa)*100)
+			}
+		}
+		return s
+	}
+}
+
+// maxDiffValueLength limits the length of values printed for changes, which
+// may be whole objects or arrays
+const maxDiffValueLength = 60
+
+func formatDiffValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if r := []rune(string(data)); len(r) > maxDiffValueLength {
+		return string(r[:maxDiffValueLength-3]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDiffFigureData(t *testing.T) {
+	old := `{"data": [{"name": "peers", "type": "bar", "x": ["a", "b"], "y": [100, 200]}], "layout": {"title": {"text": "Peers"}}}`
+
+	tests := []struct {
+		name      string
+		new       string
+		threshold float64
+		want      []string
+	}{
+		{
+			name:      "within threshold",
+			new:       `{"data": [{"name": "peers", "type": "bar", "x": ["a", "b"], "y": [100.5, 200]}], "layout": {"title": {"text": "Peers"}}}`,
+			threshold: 0.01,
+		},
+		{
+			name:      "beyond threshold",
+			new:       `{"data": [{"name": "peers", "type": "bar", "x": ["a", "b"], "y": [100.5, 200]}], "layout": {"title": {"text": "Peers"}}}`,
+			threshold: 0,
+			want:      []string{`~ trace "peers" y at "a": 100 -> 100.5 (+0.5%)`},
+		},
+		{
+			name:      "points matched by label",
+			new:       `{"data": [{"name": "peers", "type": "bar", "x": ["b", "c"], "y": [200, 300]}], "layout": {"title": {"text": "Peers"}}}`,
+			threshold: 0.01,
+			want:      []string{`- trace "peers" y at "a": 100`, `+ trace "peers" y at "c": 300`},
+		},
+		{
+			name:      "layout and traces",
+			new:       `{"data": [{"name": "nodes", "type": "bar", "x": ["a"], "y": [1]}], "layout": {"title": {"text": "Nodes"}}}`,
+			threshold: 0.01,
+			want:      []string{`- trace "peers"`, `+ trace "nodes"`, `~ layout title.text: "Peers" -> "Nodes"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffFigureData([]byte(old), []byte(tt.new), tt.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(changes))
+			for i, c := range changes {
+				got[i] = formatFigureChange(c)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got changes %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("change %d is %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
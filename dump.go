@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type DataSetDumpFormat string
+
+const (
+	DataSetDumpNone    DataSetDumpFormat = ""        // do not dump datasets
+	DataSetDumpCSV     DataSetDumpFormat = "csv"     // a csv file with a header row of field names
+	DataSetDumpParquet DataSetDumpFormat = "parquet" // a parquet file with a column for each field
+)
+
+func (f DataSetDumpFormat) String() string { return string(f) }
+
+func (f DataSetDumpFormat) Validate() error {
+	switch f {
+	case DataSetDumpNone, DataSetDumpCSV, DataSetDumpParquet:
+		return nil
+	default:
+		return fmt.Errorf("unsupported dataset dump format: %q", f)
+	}
+}
+
+// dumpDataSets marshals each dataset fetched from a source by the plot in
+// the format and passes it to write with the suffix that should be added to
+// the plot's output filename, such as .peers.csv for a dataset named peers.
+// Datasets are dumped as fetched, before non-finite values were sanitized.
+func dumpDataSets(format DataSetDumpFormat, pd *PlotDef, fig *Figure, write func(data []byte, suffix string) error) error {
+	for _, ds := range pd.Datasets {
+		// all sources produce static datasets
+		sds, ok := fig.SourceDataSets[ds.Name].(*StaticDataSet)
+		if !ok {
+			continue
+		}
+
+		var data []byte
+		var err error
+		switch format {
+		case DataSetDumpCSV:
+			data, err = marshalDataSetCSV(sds)
+		case DataSetDumpParquet:
+			data, err = marshalDataSetParquet(sds)
+		default:
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("marshal dataset %q: %w", ds.Name, err)
+		}
+		if err := write(data, "."+ds.Name+"."+format.String()); err != nil {
+			return fmt.Errorf("write dataset %q: %w", ds.Name, err)
+		}
+	}
+	return nil
+}
+
+// sortedFields returns the field names of a dataset in alphabetical order
+func sortedFields(sds *StaticDataSet) []string {
+	fields := make([]string, 0, len(sds.Data))
+	for field := range sds.Data {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// dumpValue formats a dataset value as text. Times are formatted as RFC3339
+// so they can be parsed again.
+func dumpValue(v any) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return csvValue(v)
+}
+
+func marshalDataSetCSV(sds *StaticDataSet) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	fields := sortedFields(sds)
+	if err := w.Write(fields); err != nil {
+		return nil, err
+	}
+
+	row := make([]string, len(fields))
+	for i := 0; i < rowCount(sds); i++ {
+		for j, field := range fields {
+			row[j] = dumpValue(sds.Data[field][i])
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parquetColumn is the parquet type of a dataset field and the conversion
+// of the field's values to it
+type parquetColumn struct {
+	metadata string
+	convert  func(v any) any
+}
+
+var parquetNameRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// newParquetColumn chooses the narrowest parquet type that can hold all the
+// values of a field. Fields with values of mixed types are written as text.
+func newParquetColumn(field string, values []any) parquetColumn {
+	name := parquetNameRe.ReplaceAllString(field, "_")
+	allBool, allInt, allUint, allFloat, allTime := true, true, true, true, true
+	for _, v := range values {
+		switch tv := v.(type) {
+		case nil:
+		case bool:
+			allInt, allUint, allFloat, allTime = false, false, false, false
+		case int, int8, int16, int32, int64:
+			allBool, allUint, allTime = false, false, false
+		case uint8, uint16, uint32:
+			allBool, allTime = false, false
+		case uint:
+			allBool, allTime = false, false
+			allInt = allInt && uint64(tv) <= math.MaxInt64
+		case uint64:
+			allBool, allTime = false, false
+			allInt = allInt && tv <= math.MaxInt64
+		case float32, float64:
+			allBool, allInt, allUint, allTime = false, false, false, false
+		case time.Time:
+			allBool, allInt, allUint, allFloat = false, false, false, false
+		default:
+			allBool, allInt, allUint, allFloat, allTime = false, false, false, false, false
+		}
+	}
+
+	col := parquetColumn{
+		metadata: "name=" + name + ", type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL",
+		convert:  func(v any) any { return dumpValue(v) },
+	}
+	switch {
+	case allBool:
+		col.metadata = "name=" + name + ", type=BOOLEAN, repetitiontype=OPTIONAL"
+		col.convert = func(v any) any { return v }
+	case allUint:
+		// parquet stores unsigned integers in the bits of signed ones
+		col.metadata = "name=" + name + ", type=INT64, convertedtype=UINT_64, repetitiontype=OPTIONAL"
+		col.convert = func(v any) any {
+			switch tv := v.(type) {
+			case uint:
+				return int64(tv)
+			case uint8:
+				return int64(tv)
+			case uint16:
+				return int64(tv)
+			case uint32:
+				return int64(tv)
+			case uint64:
+				return int64(tv)
+			}
+			return v
+		}
+	case allInt:
+		col.metadata = "name=" + name + ", type=INT64, repetitiontype=OPTIONAL"
+		col.convert = func(v any) any {
+			switch tv := v.(type) {
+			case int:
+				return int64(tv)
+			case int8:
+				return int64(tv)
+			case int16:
+				return int64(tv)
+			case int32:
+				return int64(tv)
+			case uint:
+				return int64(tv)
+			case uint8:
+				return int64(tv)
+			case uint16:
+				return int64(tv)
+			case uint32:
+				return int64(tv)
+			case uint64:
+				return int64(tv)
+			}
+			return v
+		}
+	case allFloat:
+		col.metadata = "name=" + name + ", type=DOUBLE, repetitiontype=OPTIONAL"
+		col.convert = func(v any) any {
+			f, _ := toFloat64(v)
+			return f
+		}
+	case allTime:
+		col.metadata = "name=" + name + ", type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"
+		col.convert = func(v any) any { return v.(time.Time).UnixMilli() }
+	}
+	return col
+}
+
+func marshalDataSetParquet(sds *StaticDataSet) ([]byte, error) {
+	fields := sortedFields(sds)
+	cols := make([]parquetColumn, len(fields))
+	md := make([]string, len(fields))
+	for i, field := range fields {
+		cols[i] = newParquetColumn(field, sds.Data[field])
+		md[i] = cols[i].metadata
+	}
+
+	buf := new(bytes.Buffer)
+	pw, err := writer.NewCSVWriterFromWriter(md, buf, 1)
+	if err != nil {
+		return nil, fmt.Errorf("create parquet writer: %w", err)
+	}
+	for i := 0; i < rowCount(sds); i++ {
+		rec := make([]any, len(fields))
+		for j, field := range fields {
+			if v := sds.Data[field][i]; v != nil {
+				rec[j] = cols[j].convert(v)
+			}
+		}
+		if err := pw.Write(rec); err != nil {
+			return nil, fmt.Errorf("write parquet row: %w", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return nil, fmt.Errorf("finish parquet file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
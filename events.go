@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+// EventDef marks the times of events such as deployments and incidents on
+// the x axis of a plot
+type EventDef struct {
+	DataSet       string `yaml:"dataset"`
+	TimeField     string `yaml:"timeField"`     // the name of the field holding the time of each event, defaults to time
+	LabelField    string `yaml:"labelField"`    // the name of the field holding the label of each event, defaults to label
+	CategoryField string `yaml:"categoryField"` // the name of the field holding the category of each event, used to color it. Defaults to category, which may be absent.
+	Color         string `yaml:"color"`         // optional color of all the events, otherwise each category is colored like a series with its name
+	Dash          string `yaml:"dash"`          // the dash style of the event lines, defaults to dot
+}
+
+// eventMarkers returns a vertical line spanning all subplots and a labelled
+// annotation for each event in the datasets of the event definitions
+func eventMarkers(dataSets map[string]DataSet, eventDefs []EventDef, cfg *PlotConfig) ([]any, []Annotation, error) {
+	var shapes []any
+	var annotations []Annotation
+	for _, ed := range eventDefs {
+		ds, ok := dataSets[ed.DataSet]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown dataset in events: %q", ed.DataSet)
+		}
+		timeField := ed.TimeField
+		if timeField == "" {
+			timeField = "time"
+		}
+		labelField := ed.LabelField
+		if labelField == "" {
+			labelField = "label"
+		}
+		categoryField, categoryOptional := ed.CategoryField, false
+		if categoryField == "" {
+			categoryField, categoryOptional = "category", true
+		}
+		dash := ed.Dash
+		if dash == "" {
+			dash = "dot"
+		}
+
+		ds.ResetIterator()
+		for ds.Next() {
+			tv := ds.Field(timeField)
+			if err, ok := tv.(error); ok {
+				return nil, nil, fmt.Errorf("did not get time field value %q from dataset %q: %w", timeField, ed.DataSet, err)
+			}
+			t, ok := toTime(tv)
+			if !ok {
+				return nil, nil, fmt.Errorf("event time field value %v of type %T in dataset %q is not a time", tv, tv, ed.DataSet)
+			}
+			label := ds.Field(labelField)
+			if err, ok := label.(error); ok {
+				return nil, nil, fmt.Errorf("did not get label field value %q from dataset %q: %w", labelField, ed.DataSet, err)
+			}
+			category := ds.Field(categoryField)
+			if err, ok := category.(error); ok {
+				if !categoryOptional {
+					return nil, nil, fmt.Errorf("did not get category field value %q from dataset %q: %w", categoryField, ed.DataSet, err)
+				}
+				category = nil
+			}
+
+			var categoryName string
+			if category != nil {
+				categoryName = stringify(category)
+			}
+			color := cfg.MaybeLookupColor(ed.Color, categoryName)
+			if color == "" {
+				color = "grey"
+			}
+			x := t.UTC().Format(time.RFC3339)
+			shapes = append(shapes, map[string]any{
+				"type":  "line",
+				"xref":  "x",
+				"yref":  "paper",
+				"x0":    x,
+				"x1":    x,
+				"y0":    0,
+				"y1":    1,
+				"line":  map[string]any{"color": color, "width": 1, "dash": dash},
+				"layer": "below",
+			})
+
+			hover := fmt.Sprintf("%v<br>%s", label, x)
+			if categoryName != "" {
+				hover = categoryName + ": " + hover
+			}
+			annotations = append(annotations, Annotation{
+				RefX:      "x",
+				RefY:      "paper",
+				X:         x,
+				Y:         1,
+				Text:      stringify(label),
+				HoverText: hover,
+				Font:      &grob.IndicatorTitleFont{Color: color, Size: 10},
+				ShowArrow: false,
+			})
+		}
+		if ds.Err() != nil {
+			return nil, nil, fmt.Errorf("dataset iteration ended with an error: %w", ds.Err())
+		}
+	}
+	return shapes, annotations, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var examplesCommand = &cli.Command{
+	Name:   "examples",
+	Usage:  "Write example plot definitions for each series, scalar and table type, with the figures generated from them using the demo source",
+	Action: Examples,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Directory to write the example plot definitions and figures to.",
+			Value:       "examples",
+			Destination: &examplesOpts.outDir,
+		},
+	}, loggingFlags...),
+}
+
+var examplesOpts struct {
+	outDir string
+}
+
+// An example is a plot definition that shows how to use one type of series,
+// scalar or table. Its datasets are read from the demo source.
+type example struct {
+	Name string
+	Def  string
+}
+
+var examples = []example{
+	{Name: "series-bar", Def: `name: series-bar
+datasets:
+  - name: main
+    source: demo
+    query: populations
+series:
+  - type: bar
+    name: month 1
+    dataset: main
+    labels: creature
+    values: month1
+  - type: bar
+    name: month 2
+    dataset: main
+    labels: creature
+    values: month2
+layout:
+  title:
+    text: "Example: bar"
+`},
+	{Name: "series-hbar", Def: `name: series-hbar
+datasets:
+  - name: main
+    source: demo
+    query: populations
+series:
+  - type: hbar
+    name: month 1
+    dataset: main
+    labels: creature
+    values: month1
+layout:
+  title:
+    text: "Example: hbar"
+`},
+	{Name: "series-line", Def: `name: series-line
+datasets:
+  - name: main
+    source: demo
+    query: traffic
+series:
+  - type: line
+    name: requests
+    dataset: main
+    labels: day
+    values: requests
+    unit: count
+  - type: line
+    name: errors
+    dataset: main
+    labels: day
+    values: errors
+    yaxis: y2
+layout:
+  title:
+    text: "Example: line"
+  yaxis2:
+    overlaying: y
+    side: right
+`},
+	{Name: "series-scatter", Def: `name: series-scatter
+datasets:
+  - name: main
+    source: demo
+    query: traffic
+series:
+  - type: scatter
+    name: errors
+    dataset: main
+    labels: requests
+    values: errors
+    marker: diamond
+layout:
+  title:
+    text: "Example: scatter"
+`},
+	{Name: "series-box", Def: `name: series-box
+datasets:
+  - name: main
+    source: demo
+    query: latency
+series:
+  - type: box
+    dataset: main
+    values: ms
+    groupfield: region
+    groupvalue: "*"
+layout:
+  title:
+    text: "Example: box"
+`},
+	{Name: "series-hbox", Def: `name: series-hbox
+datasets:
+  - name: main
+    source: demo
+    query: latency
+series:
+  - type: hbox
+    dataset: main
+    values: ms
+    groupfield: region
+    groupvalue: "*"
+layout:
+  title:
+    text: "Example: hbox"
+`},
+	{Name: "series-choropleth", Def: `name: series-choropleth
+datasets:
+  - name: main
+    source: demo
+    query: countries
+series:
+  - type: choropleth
+    name: peers
+    dataset: main
+    labels: country
+    values: peers
+    locationMode: ISO-3
+    colorscale: Blues
+layout:
+  title:
+    text: "Example: choropleth"
+`},
+	{Name: "scalar-number", Def: `name: scalar-number
+datasets:
+  - name: main
+    source: demo
+    query: populations
+scalars:
+  - type: number
+    name: giraffes
+    dataset: main
+    value: month2
+    deltaDataset: main
+    deltaValue: month1
+    deltaType: relative
+layout:
+  title:
+    text: "Example: number"
+`},
+	{Name: "scalar-gauge", Def: `name: scalar-gauge
+datasets:
+  - name: main
+    source: demo
+    query: populations
+scalars:
+  - type: gauge
+    name: giraffes
+    dataset: main
+    value: month1
+    gauge:
+      axis:
+        range: [0, 50]
+layout:
+  title:
+    text: "Example: gauge"
+`},
+	{Name: "scalar-number-trend", Def: `name: scalar-number-trend
+datasets:
+  - name: main
+    source: demo
+    query: traffic
+scalars:
+  - type: number+trend
+    name: requests
+    dataset: main
+    value: requests
+    unit: count
+    trendDataset: main
+    trendX: day
+    trendY: requests
+layout:
+  title:
+    text: "Example: number+trend"
+`},
+	{Name: "table-heatmap", Def: `name: table-heatmap
+datasets:
+  - name: main
+    source: demo
+    query: latency
+tables:
+  - type: heatmap
+    name: latency
+    dataset: main
+    xLabels: percentile
+    yLabels: region
+    values: ms
+layout:
+  title:
+    text: "Example: heatmap"
+`},
+	{Name: "table-category-bar", Def: `name: table-category-bar
+datasets:
+  - name: main
+    source: demo
+    query: latency
+tables:
+  - type: category+bar
+    name: latency
+    dataset: main
+    xLabels: region
+    yLabels: percentile
+    values: ms
+layout:
+  title:
+    text: "Example: category+bar"
+`},
+	{Name: "table-markers", Def: `name: table-markers
+datasets:
+  - name: main
+    source: demo
+    query: latency
+tables:
+  - type: markers
+    name: latency
+    dataset: main
+    xLabels: region
+    yLabels: percentile
+    values: ms
+layout:
+  title:
+    text: "Example: markers"
+`},
+}
+
+func Examples(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	cfg, err := newPlotConfig(ctx, "", nil, false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, ex := range examples {
+		defFilename := filepath.Join(examplesOpts.outDir, ex.Name+".yaml")
+		if err := writeOutput(defFilename, []byte(ex.Def)); err != nil {
+			return fmt.Errorf("failed to write example %q: %w", ex.Name, err)
+		}
+
+		// examples are read back from disk so they are processed exactly
+		// as the plot command would
+		if err := generateExample(ctx, cfg, defFilename); err != nil {
+			fmt.Printf("%s: failed: %v\n", defFilename, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: ok\n", defFilename)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d examples failed", failed, len(examples))
+	}
+	return nil
+}
+
+// generateExample generates the figure of an example plot definition and
+// writes it as a plotly figure alongside the definition
+func generateExample(ctx context.Context, cfg *PlotConfig, defFilename string) error {
+	pds, err := loadPlotDefFiles(ctx, cfg, []string{defFilename})
+	if err != nil {
+		return err
+	}
+	pd := pds[0]
+
+	fig, err := generateFig(ctx, pd, cfg, nil)
+	if err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+	for _, ds := range pd.Datasets {
+		if rowCount(fig.DataSets[ds.Name]) == 0 {
+			return fmt.Errorf("dataset %q is empty", ds.Name)
+		}
+	}
+	if err := checkFigure(fig, FigureCheckFail, slog.With("name", pd.Name)); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(FigureData{Figure: fig, Metadata: newFigureMetadata(pd, cfg, time.Time{}), Params: pd.Parameters, DynLayout: pd.DynLayout, Config: pd.Config}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return writeOutput(withSuffix(defFilename, ".json"), append(data, '\n'))
+}
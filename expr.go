@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Expr is a parsed arithmetic expression over the fields of a dataset row
+type Expr struct {
+	source string
+	root   exprNode
+}
+
+// ParseExpr parses an arithmetic expression such as ok/(ok+failed). Supported
+// are numbers, field names, the operators + - * / and parentheses. A single
+// comparison using < <= > >= == or != may be used to compare two arithmetic
+// expressions, giving 1 when true and 0 when false.
+func ParseExpr(source string) (*Expr, error) {
+	p := &exprParser{src: []rune(source)}
+	root, err := p.parseComparison()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at position %d", source, string(p.src[p.pos]), p.pos)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+// Eval evaluates the expression using the field values supplied by lookup. It
+// reports false if a field is not numeric or the expression divides by zero.
+func (e *Expr) Eval(lookup func(field string) (float64, bool)) (float64, bool) {
+	return e.root.eval(lookup)
+}
+
+func (e *Expr) String() string { return e.source }
+
+type exprNode interface {
+	eval(lookup func(field string) (float64, bool)) (float64, bool)
+}
+
+type exprNumber float64
+
+func (n exprNumber) eval(func(string) (float64, bool)) (float64, bool) {
+	return float64(n), true
+}
+
+type exprField string
+
+func (f exprField) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	return lookup(string(f))
+}
+
+type exprNegate struct {
+	operand exprNode
+}
+
+func (n exprNegate) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	v, ok := n.operand.eval(lookup)
+	return -v, ok
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (b exprBinary) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	l, ok := b.left.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.right.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case "<":
+		return boolValue(l < r), true
+	case "<=":
+		return boolValue(l <= r), true
+	case ">":
+		return boolValue(l > r), true
+	case ">=":
+		return boolValue(l >= r), true
+	case "==":
+		return boolValue(l == r), true
+	case "!=":
+		return boolValue(l != r), true
+	default:
+		return 0, false
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type exprParser struct {
+	src []rune
+	pos int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space rune, or zero at the end of the input
+func (p *exprParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+var comparisonOps = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	for _, op := range comparisonOps {
+		if !strings.HasPrefix(string(p.src[p.pos:]), op) {
+			continue
+		}
+		p.pos += len(op)
+		right, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return exprBinary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: string(op), left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: string(op), left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNegate{operand: operand}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	r := p.peek()
+	switch {
+	case r == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case r == '(':
+		p.pos++
+		inner, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis at position %d", p.pos)
+		}
+		p.pos++
+		return inner, nil
+	case unicode.IsDigit(r) || r == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", string(p.src[start:p.pos]))
+		}
+		return exprNumber(v), nil
+	case unicode.IsLetter(r) || r == '_':
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsLetter(p.src[p.pos]) || unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		return exprField(string(p.src[start:p.pos])), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", string(r), p.pos)
+	}
+}
+
+// ComputeExpression evaluates the expression for each row of the input. The
+// computed dataset has the join field and result of each row. Results are
+// null for rows where the expression could not be evaluated, such as when
+// dividing by zero.
+func ComputeExpression(ctx context.Context, expr *Expr, in ComputeInput) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	data := make(map[string][]any)
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+
+		var fieldErr error
+		v, ok := expr.Eval(func(field string) (float64, bool) {
+			value := in.DataSet.Field(field)
+			if err, ok := value.(error); ok {
+				fieldErr = fmt.Errorf("did not get field value %q from dataset %q: %w", field, in.Def.DataSet, err)
+				return 0, false
+			}
+			return toFloat64(value)
+		})
+		if fieldErr != nil {
+			return nil, fieldErr
+		}
+
+		var res any
+		if ok {
+			res = v
+		}
+		data["field"] = append(data["field"], join)
+		data["value"] = append(data["value"], res)
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	return NewStaticDataSet(data), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	fields := map[string]float64{"a": 2, "b": 3, "c": 4, "zero": 0}
+	lookup := func(field string) (float64, bool) {
+		v, ok := fields[field]
+		return v, ok
+	}
+
+	tests := []struct {
+		source string
+		want   float64
+		ok     bool   // whether the expression has a result
+		err    string // the error expected when parsing, if any
+	}{
+		{source: "a+b*c", want: 14, ok: true},
+		{source: "-a*b", want: -6, ok: true},
+		{source: "(a+b)/c", want: 1.25, ok: true},
+		{source: "a - b - c", want: -5, ok: true},
+		{source: "a / b / c", want: 2.0 / 3 / 4, ok: true},
+		{source: "--a", want: 2, ok: true},
+		{source: "0.5 * c", want: 2, ok: true},
+		{source: "a < b", want: 1, ok: true},
+		{source: "a >= b", want: 0, ok: true},
+		{source: "a+b == 5", want: 1, ok: true},
+		{source: "c != c", want: 0, ok: true},
+		{source: "(a < b) + (b < c)", want: 2, ok: true},
+		{source: "a / zero"},
+		{source: "(a + b) / (c - c)"},
+		{source: "missing + a"},
+		{source: "1e6", err: `unexpected "e" at position 1`},
+		{source: "a b", err: `unexpected "b" at position 2`},
+		{source: "(a", err: "missing closing parenthesis at position 2"},
+		{source: "a +", err: "unexpected end of expression"},
+		{source: "1..2", err: `invalid number "1..2"`},
+		{source: "a < b < c", err: `unexpected "<" at position 6`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			expr, err := ParseExpr(tc.source)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, wanted one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, ok := expr.Eval(lookup)
+			if ok != tc.ok {
+				t.Fatalf("got ok %v, wanted %v", ok, tc.ok)
+			}
+			if ok && got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestComputeExpression(t *testing.T) {
+	expr, err := ParseExpr("ok/(ok+failed)")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	in := ComputeInput{
+		Def: ComputeDataSetDef{DataSet: "d", JoinField: "day"},
+		DataSet: NewStaticDataSet(map[string][]any{
+			"day":    {"mon", "tue", "wed", "thu"},
+			"ok":     {3, 0.0, 1.0, nil},
+			"failed": {1, 0.0, 1.0, 2.0},
+		}),
+	}
+	ds, err := ComputeExpression(context.Background(), expr, in)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	sds := ds.(*StaticDataSet)
+	if len(sds.Data) != 2 {
+		t.Errorf("got fields %v, wanted field and value", sortedFields(sds))
+	}
+	wantFields := []any{"mon", "tue", "wed", "thu"}
+	wantValues := []any{0.75, nil, 0.5, nil}
+	for i := range wantFields {
+		if sds.Data["field"][i] != wantFields[i] {
+			t.Errorf("row %d: got field %v, wanted %v", i, sds.Data["field"][i], wantFields[i])
+		}
+		if sds.Data["value"][i] != wantValues[i] {
+			t.Errorf("row %d: got value %v, wanted %v", i, sds.Data["value"][i], wantValues[i])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+type FigureCheckMode string
+
+const (
+	FigureCheckOff  FigureCheckMode = "off"  // do not check figures
+	FigureCheckWarn FigureCheckMode = "warn" // log problems with figures but still write them
+	FigureCheckFail FigureCheckMode = "fail" // fail plots whose figures have problems
+)
+
+func (m FigureCheckMode) String() string { return string(m) }
+
+func (m FigureCheckMode) Validate() error {
+	switch m {
+	case FigureCheckOff, FigureCheckWarn, FigureCheckFail:
+		return nil
+	default:
+		return fmt.Errorf("unsupported figure check mode: %q", m)
+	}
+}
+
+// plotlyTraceTypes are the trace types known to plotly.js
+var plotlyTraceTypes = map[string]bool{
+	"area": true, "bar": true, "barpolar": true, "box": true, "candlestick": true, "carpet": true,
+	"choropleth": true, "choroplethmapbox": true, "cone": true, "contour": true, "contourcarpet": true,
+	"densitymapbox": true, "funnel": true, "funnelarea": true, "heatmap": true, "heatmapgl": true,
+	"histogram": true, "histogram2d": true, "histogram2dcontour": true, "image": true, "indicator": true,
+	"isosurface": true, "mesh3d": true, "ohlc": true, "parcats": true, "parcoords": true, "pie": true,
+	"pointcloud": true, "sankey": true, "scatter": true, "scatter3d": true, "scattercarpet": true,
+	"scattergeo": true, "scattergl": true, "scattermapbox": true, "scatterpolar": true, "scatterpolargl": true,
+	"scatterternary": true, "splom": true, "streamtube": true, "sunburst": true, "surface": true, "table": true,
+	"treemap": true, "violin": true, "volume": true, "waterfall": true,
+}
+
+// traceArrayPairs are attributes of a trace that must have the same number
+// of elements when both are arrays
+var traceArrayPairs = [][2]string{
+	{"x", "y"},
+	{"labels", "values"},
+	{"locations", "z"},
+	{"x", "ids"},
+	{"x", "customdata"},
+	{"x", "text"},
+}
+
+// maxNonFiniteReports limits the number of non-finite numbers reported for a
+// trace
+const maxNonFiniteReports = 3
+
+// CheckFigure checks the traces of a figure for problems that stop plotly
+// from drawing them: unknown trace types, data arrays of different lengths
+// and numbers that are not finite, which cannot be represented in json. It
+// returns a description of each problem found.
+func CheckFigure(fig *Figure) []string {
+	var problems []string
+	for i, trace := range fig.Data {
+		path := fmt.Sprintf("data[%d]", i)
+
+		var nonFinite []string
+		findNonFinite(reflect.ValueOf(trace), path, &nonFinite)
+		if len(nonFinite) > 0 {
+			for j, p := range nonFinite {
+				if j == maxNonFiniteReports {
+					problems = append(problems, fmt.Sprintf("%s: %d more numbers that are not finite", path, len(nonFinite)-j))
+					break
+				}
+				problems = append(problems, p)
+			}
+			// the trace cannot be marshalled to check it further
+			continue
+		}
+
+		raw, err := json.Marshal(trace)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: cannot be marshalled: %v", path, err))
+			continue
+		}
+		var t map[string]any
+		if err := json.Unmarshal(raw, &t); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: cannot be unmarshalled: %v", path, err))
+			continue
+		}
+
+		if typ, _ := t["type"].(string); !plotlyTraceTypes[typ] {
+			problems = append(problems, fmt.Sprintf("%s: unknown trace type %q", path, t["type"]))
+		}
+		_, isGrid := t["z"]
+		for _, pair := range traceArrayPairs {
+			if isGrid && pair[0] == "x" {
+				// the x and y of heatmaps label the columns and rows of z
+				continue
+			}
+			a, aok := traceArrayLen(t, pair[0])
+			b, bok := traceArrayLen(t, pair[1])
+			if aok && bok && a != b {
+				problems = append(problems, fmt.Sprintf("%s: %s has %d values but %s has %d", path, pair[0], a, pair[1], b))
+			}
+		}
+		if z, ok := t["z"].([]any); ok && isGrid && t["locations"] == nil {
+			if y, ok := t["y"].([]any); ok && len(y) != len(z) {
+				problems = append(problems, fmt.Sprintf("%s: z has %d rows but y has %d values", path, len(z), len(y)))
+			}
+			x, xok := t["x"].([]any)
+			for j, row := range z {
+				if r, ok := row.([]any); ok && xok && len(r) != len(x) {
+					problems = append(problems, fmt.Sprintf("%s: z row %d has %d values but x has %d", path, j, len(r), len(x)))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkFigure checks the figure according to the mode, logging any problems
+// found and returning an error if the mode is fail
+func checkFigure(fig *Figure, mode FigureCheckMode, logger *slog.Logger) error {
+	if mode == FigureCheckOff {
+		return nil
+	}
+	problems := CheckFigure(fig)
+	for _, p := range problems {
+		logger.Warn("problem with generated figure", "problem", p)
+	}
+	if mode == FigureCheckFail && len(problems) > 0 {
+		return fmt.Errorf("generated figure has %d problems, first: %s", len(problems), problems[0])
+	}
+	return nil
+}
+
+// findNonFinite appends the path of each NaN or infinite number reachable
+// from v
+func findNonFinite(v reflect.Value, path string, found *[]string) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if !v.IsNil() {
+			findNonFinite(v.Elem(), path, found)
+		}
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			*found = append(*found, fmt.Sprintf("%s: %v is not a finite number", path, f))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			findNonFinite(v.Index(i), fmt.Sprintf("%s[%d]", path, i), found)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			findNonFinite(iter.Value(), fmt.Sprintf("%s.%v", path, iter.Key()), found)
+		}
+	case reflect.Struct:
+		typ := v.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" {
+				name = field.Name
+			}
+			findNonFinite(v.Field(i), path+"."+name, found)
+		}
+	}
+}
+
+// traceArrayLen returns the number of points in the named data array of a
+// trace. A multicategory x or y is given as an array of label arrays, one for
+// each level, so its points are counted in the innermost level.
+func traceArrayLen(t map[string]any, name string) (int, bool) {
+	a, ok := t[name].([]any)
+	if !ok {
+		return 0, false
+	}
+	if (name == "x" || name == "y") && len(a) > 0 {
+		if inner, ok := a[len(a)-1].([]any); ok {
+			return len(inner), true
+		}
+	}
+	return len(a), true
+}
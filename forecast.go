@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ComputeForecast fits a least squares line to the values of the input
+// against the times in its join field and projects it the given number of
+// periods of the interval beyond the latest time. Null values are ignored.
+// The computed dataset has the time and projected value of each future
+// period. If confidence is between 0 and 1 it also has lower and upper fields
+// holding the bounds of the prediction interval at that confidence level.
+func ComputeForecast(ctx context.Context, in ComputeInput, interval time.Duration, periods int, confidence float64) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	var ts []time.Time
+	var ys []float64
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		t, ok := toTime(join)
+		if !ok {
+			return nil, fmt.Errorf("cannot forecast join field value %v of type %T, expected a time", join, join)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		if value == nil {
+			continue
+		}
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot forecast value of type %T", value)
+		}
+		ts = append(ts, t)
+		ys = append(ys, f)
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	data := make(map[string][]any)
+	if len(ts) < 2 {
+		return NewStaticDataSet(data), nil
+	}
+
+	// measure time in periods from the latest time to keep the regression
+	// well conditioned
+	latest := ts[0]
+	for _, t := range ts {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	xs := make([]float64, len(ts))
+	for i, t := range ts {
+		xs[i] = t.Sub(latest).Seconds() / interval.Seconds()
+	}
+
+	n := float64(len(xs))
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+
+	var sxx, sxy float64
+	for i := range xs {
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+		sxy += (xs[i] - mx) * (ys[i] - my)
+	}
+	if sxx == 0 {
+		return nil, fmt.Errorf("cannot forecast from values that all have the same time")
+	}
+	slope := sxy / sxx
+	intercept := my - slope*mx
+
+	// the standard error of the residuals, used for the prediction interval
+	var se, z float64
+	withBand := confidence > 0 && confidence < 1 && len(xs) > 2
+	if withBand {
+		var sse float64
+		for i := range xs {
+			r := ys[i] - (intercept + slope*xs[i])
+			sse += r * r
+		}
+		se = math.Sqrt(sse / (n - 2))
+		z = math.Sqrt2 * math.Erfinv(confidence)
+	}
+
+	for k := 1; k <= periods; k++ {
+		t := latest.Add(time.Duration(k) * interval)
+		x := float64(k)
+		y := intercept + slope*x
+		data["field"] = append(data["field"], t)
+		data["value"] = append(data["value"], y)
+		if withBand {
+			margin := z * se * math.Sqrt(1+1/n+(x-mx)*(x-mx)/sxx)
+			data["lower"] = append(data["lower"], y-margin)
+			data["upper"] = append(data["upper"], y+margin)
+		}
+	}
+
+	return NewStaticDataSet(data), nil
+}
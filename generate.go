@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"math/big"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,93 +15,448 @@ import (
 	"golang.org/x/exp/slog"
 )
 
-func generateFig(ctx context.Context, pd *PlotDef, cfg *PlotConfig) (*grob.Fig, error) {
-	fig := &grob.Fig{
-		Layout: &pd.Layout,
+// PlotStats records information gathered while generating a plot
+type PlotStats struct {
+	DataSets []DataSetStats
+
+	// Anomalies lists the anomaly computed datasets that flagged any rows
+	Anomalies []AnomalyStats
+
+	// time spent in each stage of producing the plot. Template, Marshal and
+	// Write are recorded by the caller of generateFig.
+	Template time.Duration
+	Compute  time.Duration
+	Traces   time.Duration
+	Marshal  time.Duration
+	Write    time.Duration
+}
+
+type AnomalyStats struct {
+	DataSet string
+	Count   int // the number of anomalous rows
+	Last    any // the join field value of the last anomalous row
+}
+
+type DataSetStats struct {
+	Name     string
+	Source   string
+	Query    string
+	RowCount int
+	Duration time.Duration
+}
+
+// generateFig generates the figure for a plot definition. If stats is non-nil
+// it is populated with information about the datasets used and the time
+// spent computing datasets and building traces.
+func generateFig(ctx context.Context, pd *PlotDef, cfg *PlotConfig, stats *PlotStats) (*Figure, error) {
+	fig := &Figure{
+		Fig: &grob.Fig{
+			Layout: &pd.Layout,
+		},
 	}
 
 	logger := slog.With("name", pd.Name)
 
+	computed, err := orderComputed(pd.Computed)
+	if err != nil {
+		return nil, err
+	}
+	sourceNames := make(map[string]bool, len(pd.Datasets))
+	for _, ds := range pd.Datasets {
+		sourceNames[ds.Name] = true
+	}
+	for _, cds := range computed {
+		if sourceNames[cds.Name] {
+			return nil, fmt.Errorf("computed dataset name conflicts with existing dataset: %q", cds.Name)
+		}
+	}
+
 	dataSets := make(map[string]DataSet)
+	fig.SourceDataSets = make(map[string]DataSet)
+	if pd.Precondition != nil {
+		// the precondition is checked before the queries of the other
+		// datasets are run, so they are not run for plots that are skipped
+		needed := preconditionDataSets(pd.Precondition, computed)
+		include := func(name string) bool { return needed[name] }
+		if err := fetchDataSets(ctx, pd, cfg, include, dataSets, fig.SourceDataSets, stats, logger); err != nil {
+			return nil, err
+		}
+		if err := computeDataSets(ctx, pd, cfg, computed, include, dataSets, stats, logger); err != nil {
+			return nil, err
+		}
+		if err := checkPrecondition(pd.Precondition, dataSets); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining := func(name string) bool {
+		_, exists := dataSets[name]
+		return !exists
+	}
+	if err := fetchDataSets(ctx, pd, cfg, remaining, dataSets, fig.SourceDataSets, stats, logger); err != nil {
+		return nil, err
+	}
+	if err := computeDataSets(ctx, pd, cfg, computed, remaining, dataSets, stats, logger); err != nil {
+		return nil, err
+	}
+
+	tracesStart := time.Now()
+	fig.Data = grob.Traces{}
+
+	traces, series, err := seriesTraces(dataSets, pd.Series, cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("series traces: %w", err)
+	}
+	fig.Data = append(fig.Data, traces...)
+	fig.Series = series
+	fig.DataSets = dataSets
+	if pd.Quality != nil {
+		fig.Quality, err = seriesQuality(pd.Quality, series, cfg.BasisTime)
+		if err != nil {
+			return nil, fmt.Errorf("series quality: %w", err)
+		}
+	}
+	inferAxisTitles(fig.Layout, series)
+	markPartialPeriods(fig.Layout, pd, series, cfg.BasisTime, cfg.WeekStart)
+	shadeNonWorkingDays(fig.Layout, pd, series, cfg.Holidays)
+	setUnitAxisFormat(fig.Layout, series)
+	if err := setRangeControls(fig.Layout, pd); err != nil {
+		return nil, fmt.Errorf("range controls: %w", err)
+	}
+
+	if len(pd.Toggles) > 0 {
+		menus, err := toggleMenus(pd.Toggles, series)
+		if err != nil {
+			return nil, fmt.Errorf("toggles: %w", err)
+		}
+		if existing, ok := fig.Layout.Updatemenus.([]any); ok {
+			menus = append(existing, menus...)
+		}
+		fig.Layout.Updatemenus = menus
+	}
+
+	traces, axes, err := scalarTraces(dataSets, pd.Scalars, cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("scalar traces: %w", err)
+	}
+	fig.LayoutAxes = axes
+	setLocaleSeparators(fig.Layout, pd.Scalars)
+	fig.Data = append(fig.Data, traces...)
+
+	traces, annotations, err := tableTraces(dataSets, pd.Tables, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("table traces: %w", err)
+	}
+	fig.Data = append(fig.Data, traces...)
+
+	if fig.Layout.Annotations == nil {
+		fig.Layout.Annotations = annotations
+	} else if existingAnnotations, ok := fig.Layout.Annotations.([]interface{}); ok {
+		fig.Layout.Annotations = append(existingAnnotations, annotations)
+	}
+
+	if len(pd.Events) > 0 {
+		shapes, annotations, err := eventMarkers(dataSets, pd.Events, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+		addShapes(fig.Layout, shapes)
+		addAnnotations(fig.Layout, annotations)
+	}
+
+	if pd.Quality != nil && pd.Quality.Badges {
+		if badge := qualityBadge(fig.Quality); badge != nil {
+			addAnnotations(fig.Layout, []Annotation{*badge})
+		}
+	}
+
+	if stats != nil {
+		stats.Traces = time.Since(tracesStart)
+	}
+
+	return fig, nil
+}
+
+// fetchDataSets gets the datasets of the plot for which include returns
+// true from their sources. They are added to sourceDataSets as fetched and
+// to dataSets once non-finite values are sanitized.
+func fetchDataSets(ctx context.Context, pd *PlotDef, cfg *PlotConfig, include func(name string) bool, dataSets, sourceDataSets map[string]DataSet, stats *PlotStats, logger *slog.Logger) error {
+	names := make([]string, 0, len(pd.Datasets))
 	for _, ds := range pd.Datasets {
+		if !include(ds.Name) {
+			continue
+		}
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		default:
 		}
 		src, exists := cfg.Sources[ds.Source]
 		if !exists {
-			return nil, fmt.Errorf("unknown dataset source: %q", ds.Source)
+			return fmt.Errorf("unknown dataset source: %q", ds.Source)
 		}
 		var err error
 		logger.Debug("getting dataset", "dataset", ds.Name, "source", ds.Source, "query", stripNewlines(ds.Query))
+		start := time.Now()
 		dataSets[ds.Name], err = src.GetDataSet(ctx, ds.Query)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get dataset from source %q: %w", ds.Source, err)
+			return fmt.Errorf("failed to get dataset from source %q: %w", ds.Source, err)
+		}
+		if stats != nil {
+			stats.DataSets = append(stats.DataSets, DataSetStats{
+				Name:     ds.Name,
+				Source:   ds.Source,
+				Query:    ds.Query,
+				RowCount: rowCount(dataSets[ds.Name]),
+				Duration: time.Since(start),
+			})
 		}
+		sourceDataSets[ds.Name] = dataSets[ds.Name]
+		names = append(names, ds.Name)
 	}
 
-	for _, cds := range pd.Computed {
+	// sanitize the source datasets before they are used as compute inputs,
+	// which replaces rather than modifies them
+	return sanitizeNonFinite(dataSets, names, cfg.NonFinitePolicy(pd), logger)
+}
+
+// computeDataSets computes the datasets, in dependency order, for which
+// include returns true, adding them to dataSets. The time spent is added to
+// the compute time of stats.
+func computeDataSets(ctx context.Context, pd *PlotDef, cfg *PlotConfig, computed []ComputedDef, include func(name string) bool, dataSets map[string]DataSet, stats *PlotStats, logger *slog.Logger) error {
+	computeStart := time.Now()
+	names := make([]string, 0, len(computed))
+	for _, cds := range computed {
+		if !include(cds.Name) {
+			continue
+		}
+		names = append(names, cds.Name)
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		default:
 		}
-		if _, exists := dataSets[cds.Name]; exists {
-			return nil, fmt.Errorf("computed dataset name conflicts with existing dataset: %q", cds.Name)
-		}
 
-		for _, ds := range cds.DataSets {
+		inputs := make([]ComputeInput, len(cds.DataSets))
+		for i, ds := range cds.DataSets {
 			_, exists := dataSets[ds.DataSet]
 			if !exists {
-				return nil, fmt.Errorf("unknown dataset in computed dataset %q: %q", cds.Name, ds.DataSet)
+				return fmt.Errorf("unknown dataset in computed dataset %q: %q", cds.Name, ds.DataSet)
 			}
+			inputs[i] = ComputeInput{Def: ds, DataSet: dataSets[ds.DataSet]}
 		}
 
 		switch cds.Function {
 		case ComputeTypeDiff:
+			if len(cds.DataSets) != 2 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
 			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "dataset1", cds.DataSets[0].DataSet, "dataset2", cds.DataSets[1].DataSet)
+			var err error
+			dataSets[cds.Name], err = ComputeBinaryPredicate(ctx, diff2, cds.JoinType, inputs[0], inputs[1])
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeRatio:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "datasets", len(cds.DataSets))
 			if len(cds.DataSets) != 2 {
-				return nil, fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeBinaryPredicate(ctx, ratio2(cds.DivideByZero), cds.JoinType, inputs[0], inputs[1])
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypePercent:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "datasets", len(cds.DataSets))
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
 			}
 			var err error
-			dataSets[cds.Name], err = ComputeBinaryPredicate(ctx, diff2, ComputeInput{Def: cds.DataSets[0], DataSet: dataSets[cds.DataSets[0].DataSet]}, ComputeInput{Def: cds.DataSets[1], DataSet: dataSets[cds.DataSets[1].DataSet]})
+			dataSets[cds.Name], err = ComputePercentOfTotal(ctx, inputs[0])
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeCumSum:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "datasets", len(cds.DataSets))
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeCumulativeSum(ctx, inputs[0])
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeExpr:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "expression", cds.Expression)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			expr, err := ParseExpr(cds.Expression)
+			if err != nil {
+				return fmt.Errorf("computed dataset %q: %w", cds.Name, err)
+			}
+			dataSets[cds.Name], err = ComputeExpression(ctx, expr, inputs[0])
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeSort:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "order", cds.Order, "limit", cds.Limit, "offset", cds.Offset)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			sorted, err := ComputeSort(ctx, inputs[0], cds.Order)
 			if err != nil {
-				return nil, fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+			if cds.Limit > 0 || cds.Offset > 0 {
+				sorted, err = ComputeLimit(ctx, ComputeInput{Def: ComputeDataSetDef{DataSet: cds.Name, JoinField: "field", ValueField: "value"}, DataSet: sorted}, cds.Offset, cds.Limit)
+				if err != nil {
+					return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+				}
+			}
+			dataSets[cds.Name] = sorted
+		case ComputeTypeLimit:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "limit", cds.Limit, "offset", cds.Offset)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeLimit(ctx, inputs[0], cds.Offset, cds.Limit)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeResample:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "interval", cds.Interval, "aggregate", cds.Aggregate, "gapfill", cds.GapFill)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			interval, err := parseInterval(cds.Interval)
+			if err != nil {
+				return fmt.Errorf("computed dataset %q: %w", cds.Name, err)
+			}
+			aggregate := cds.Aggregate
+			if aggregate == "" {
+				aggregate = ComputeTypeSum
+			}
+			dataSets[cds.Name], err = ComputeResample(ctx, inputs[0], interval, aggregateFuncs[aggregate], cds.GapFill, cfg.BasisTime.Location(), cfg.WeekStart)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeShift:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "shift", cds.Shift)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			shift, err := parseShift(cds.Shift)
+			if err != nil {
+				return fmt.Errorf("computed dataset %q: %w", cds.Name, err)
+			}
+			dataSets[cds.Name], err = ComputeShift(ctx, inputs[0], shift, cfg.BasisTime.Location())
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeHistogram:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "width", cds.BinWidth, "start", cds.BinStart)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			if cds.BinWidth <= 0 {
+				return fmt.Errorf("computed dataset %q must have a positive bin width", cds.Name)
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeHistogram(ctx, inputs[0], cds.BinWidth, cds.BinStart)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeCorrelation:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "fields", cds.Fields)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			if len(cds.Fields) < 2 {
+				return fmt.Errorf("computed dataset %q must have at least two fields to correlate", cds.Name)
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeCorrelation(ctx, inputs[0], cds.Fields)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeForecast:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "interval", cds.Interval, "periods", cds.Periods, "confidence", cds.Confidence)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			interval, err := parseInterval(cds.Interval)
+			if err != nil {
+				return fmt.Errorf("computed dataset %q: %w", cds.Name, err)
+			}
+			dataSets[cds.Name], err = ComputeForecast(ctx, inputs[0], interval, cds.Periods, cds.Confidence)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeAnomaly:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "window", cds.Window, "threshold", cds.Threshold)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			if cds.Window < 2 {
+				return fmt.Errorf("computed dataset %q must have a window of at least two rows", cds.Name)
+			}
+			threshold := cds.Threshold
+			if threshold == 0 {
+				threshold = 3
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeAnomalies(ctx, inputs[0], cds.Window, threshold)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+			if stats != nil {
+				as := AnomalyStats{DataSet: cds.Name}
+				anomalies := dataSets[cds.Name]
+				anomalies.ResetIterator()
+				for anomalies.Next() {
+					as.Count++
+					as.Last = anomalies.Field("field")
+				}
+				if as.Count > 0 {
+					stats.Anomalies = append(stats.Anomalies, as)
+				}
+			}
+		case ComputeTypeSelect:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "fields", cds.Fields, "rename", cds.Rename)
+			if len(cds.DataSets) != 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeSelect(ctx, inputs[0], cds.Fields, cds.Rename)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
+			}
+		case ComputeTypeSum, ComputeTypeMean, ComputeTypeMin, ComputeTypeMax:
+			logger.Debug("computing dataset", "computed", cds.Name, "function", cds.Function, "datasets", len(cds.DataSets))
+			if len(cds.DataSets) < 1 {
+				return fmt.Errorf("unexpected number of datasets in computed dataset %q: %d", cds.Name, len(cds.DataSets))
+			}
+			var err error
+			dataSets[cds.Name], err = ComputeAggregate(ctx, aggregateFuncs[cds.Function], cds.JoinType, inputs...)
+			if err != nil {
+				return fmt.Errorf("failed to compute dataset %q: %w", cds.Name, err)
 			}
 		default:
-			return nil, fmt.Errorf("unknown function in computed dataset %q: %q", cds.Name, cds.Function)
+			return fmt.Errorf("unknown function in computed dataset %q: %q", cds.Name, cds.Function)
 		}
-
 	}
 
-	fig.Data = grob.Traces{}
-
-	traces, err := seriesTraces(dataSets, pd.Series, cfg, logger)
-	if err != nil {
-		return nil, fmt.Errorf("series traces: %w", err)
+	// computes such as ratios can produce non-finite values of their own
+	if err := sanitizeNonFinite(dataSets, names, cfg.NonFinitePolicy(pd), logger); err != nil {
+		return err
 	}
-	fig.Data = append(fig.Data, traces...)
-
-	traces, err = scalarTraces(dataSets, pd.Scalars, cfg, logger)
-	if err != nil {
-		return nil, fmt.Errorf("scalar traces: %w", err)
+	if stats != nil {
+		stats.Compute += time.Since(computeStart)
 	}
-	fig.Data = append(fig.Data, traces...)
-
-	traces, annotations, err := tableTraces(dataSets, pd.Tables, cfg)
-	if err != nil {
-		return nil, fmt.Errorf("table traces: %w", err)
-	}
-	fig.Data = append(fig.Data, traces...)
-
-	if fig.Layout.Annotations == nil {
-		fig.Layout.Annotations = annotations
-	} else if existingAnnotations, ok := fig.Layout.Annotations.([]interface{}); ok {
-		fig.Layout.Annotations = append(existingAnnotations, annotations)
-	}
-
-	return fig, nil
+	return nil
 }
 
 type Annotation struct {
@@ -110,24 +467,74 @@ type Annotation struct {
 	Text      string                   `json:"text"`
 	Font      *grob.IndicatorTitleFont `json:"font"`
 	ShowArrow bool                     `json:"showarrow"`
+	HoverText string                   `json:"hovertext,omitempty"`
+}
+
+// addAnnotations appends annotations to those of the layout, unless the
+// layout has annotations in a form that cannot be appended to
+func addAnnotations(layout *grob.Layout, annotations []Annotation) {
+	switch existing := layout.Annotations.(type) {
+	case nil:
+		layout.Annotations = annotations
+	case []Annotation:
+		layout.Annotations = append(existing, annotations...)
+	case []interface{}:
+		for _, a := range annotations {
+			existing = append(existing, a)
+		}
+		layout.Annotations = existing
+	}
 }
 
 type LabeledSeries struct {
-	Name      string
-	SeriesDef *SeriesDef
-	Labels    []any
-	Values    []any
+	Name       string
+	SeriesDef  *SeriesDef
+	Labels     []any
+	Values     []any
+	Text       []any
+	IDs        []any
+	CustomData [][]any
+
+	boolLabels bool // whether the labels were read from a boolean field
+	rank       int  // the position of the series' group value in the category order
+}
+
+// TraceMetadata returns the values that should be used for the ids, customdata
+// and meta attributes of the series' trace. Values are nil when unused so they
+// are omitted from the trace.
+func (ls *LabeledSeries) TraceMetadata() (ids any, customData any, meta any) {
+	if ls.IDs != nil {
+		ids = ls.IDs
+	}
+	if ls.CustomData != nil {
+		customData = ls.CustomData
+	}
+	if len(ls.SeriesDef.Meta) > 0 {
+		meta = ls.SeriesDef.Meta
+	}
+	return ids, customData, meta
 }
 
-func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *PlotConfig, logger *slog.Logger) ([]grob.Trace, error) {
+// HasText reports whether text should be printed on the points of the series
+func (ls *LabeledSeries) HasText() bool {
+	return ls.SeriesDef.TextField != "" || ls.SeriesDef.TextTemplate != ""
+}
+
+func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *PlotConfig, logger *slog.Logger) ([]grob.Trace, []*LabeledSeries, error) {
 	var traces []grob.Trace
 
+	// datasets are read in the order they are first used so the output does
+	// not depend on map iteration
+	var dsnames []string
 	seriesByDataSet := make(map[string][]SeriesDef)
 	for i, s := range seriesDefs {
 		if _, ok := dataSets[s.DataSet]; !ok {
 			logger.Error(fmt.Sprintf("unknown dataset name %q in series %d", s.DataSet, i))
 			continue
 		}
+		if _, ok := seriesByDataSet[s.DataSet]; !ok {
+			dsnames = append(dsnames, s.DataSet)
+		}
 		seriesByDataSet[s.DataSet] = append(seriesByDataSet[s.DataSet], s)
 	}
 
@@ -136,7 +543,8 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 	dataIndex := make(map[string]*LabeledSeries)
 
 	// if series are generated from a groupfield then it uses that ordering
-	for dsname, series := range seriesByDataSet {
+	for _, dsname := range dsnames {
+		series := seriesByDataSet[dsname]
 		ds := dataSets[dsname]
 
 		logger.Info("reading dataset", "dataset", dsname)
@@ -147,14 +555,16 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 			for _, s := range series {
 				s := s
 				name := s.Name
+				var group any
 				if s.GroupField != "" {
+					group = ds.Field(s.GroupField)
 					if s.GroupValue == "*" {
 						if name != "" {
-							name = fmt.Sprintf("%s-%s", name, ds.Field(s.GroupField))
+							name = fmt.Sprintf("%s-%v", name, s.displayValue(group))
 						} else {
-							name = fmt.Sprintf("%s", ds.Field(s.GroupField))
+							name = fmt.Sprint(s.displayValue(group))
 						}
-					} else if ds.Field(s.GroupField) != s.GroupValue {
+					} else if stringify(group) != s.GroupValue {
 						continue
 					}
 				}
@@ -166,34 +576,70 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 						Name:      name,
 						SeriesDef: &s,
 					}
+					if s.GroupValue == "*" {
+						// group fields may mix booleans with nulls or other values
+						// so the boolean order is always used for groups
+						ls.rank = categoryRank(s.categoryOrder(true), s.displayValue(group))
+					}
 					data = append(data, ls)
 					dataIndex[ls.Name] = ls
 				}
 				if s.Labels != "" {
-					ls.Labels = append(ls.Labels, normalizeValue(ds.Field(s.Labels)))
+					label := ds.Field(s.Labels)
+					if _, isBool := label.(bool); isBool {
+						ls.boolLabels = true
+					}
+					ls.Labels = append(ls.Labels, normalizeLabel(s.displayValue(label)))
 				}
 				ls.Values = append(ls.Values, normalizeValue(ds.Field(s.Values)))
+				if s.TextField != "" {
+					ls.Text = append(ls.Text, normalizeLabel(ds.Field(s.TextField)))
+				}
+				if s.IDField != "" {
+					ls.IDs = append(ls.IDs, normalizeLabel(ds.Field(s.IDField)))
+				}
+				if len(s.CustomData) > 0 {
+					row := make([]any, len(s.CustomData))
+					for i, f := range s.CustomData {
+						row[i] = normalizeLabel(ds.Field(f))
+					}
+					ls.CustomData = append(ls.CustomData, row)
+				}
 			}
 		}
 		if ds.Err() != nil {
-			return nil, fmt.Errorf("dataset iteration ended with an error: %w", ds.Err())
+			return nil, nil, fmt.Errorf("dataset iteration ended with an error: %w", ds.Err())
 		}
 		logger.Info("finished reading dataset", "dataset", dsname, "rowcount", rowcount)
 	}
 
+	for _, ls := range data {
+		ls.sortByCategory(ls.SeriesDef.categoryOrder(ls.boolLabels))
+	}
+
 	sort.Slice(data, func(i, j int) bool {
 		if data[i].SeriesDef.order != data[j].SeriesDef.order {
 			return data[i].SeriesDef.order < data[j].SeriesDef.order
 		}
+		if data[i].rank != data[j].rank {
+			return data[i].rank < data[j].rank
+		}
 		return data[i].Name < data[j].Name
 	})
 
+	applyPercent(data)
+
 	for _, ls := range data {
 		ls := ls
 		visible := true
 		if ls.SeriesDef.Visible != nil {
 			visible = *ls.SeriesDef.Visible
 		}
+		ids, customData, meta := ls.TraceMetadata()
+		hoverTemplate := ls.SeriesDef.HoverTemplate
+		if hoverTemplate == "" {
+			hoverTemplate = ls.SeriesDef.EffectiveUnit().HoverTemplate(ls.SeriesDef.ValueAxis())
+		}
 
 		switch ls.SeriesDef.Type {
 		case SeriesTypeBar:
@@ -203,8 +649,13 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 				Orientation:   grob.BarOrientationV,
 				X:             ls.Labels,
 				Y:             ls.Values,
-				Hovertemplate: ls.SeriesDef.HoverTemplate,
+				Hovertemplate: hoverTemplate,
 				Visible:       visible,
+				Ids:           ids,
+				Customdata:    customData,
+				Meta:          meta,
+				Opacity:       ls.SeriesDef.Opacity,
+				Width:         ls.SeriesDef.BarWidth,
 				Yaxis:         ls.SeriesDef.Yaxis,
 			}
 
@@ -214,16 +665,30 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 				}
 			}
 
+			if ls.HasText() {
+				if ls.Text != nil {
+					trace.Text = ls.Text
+				}
+				trace.Texttemplate = ls.SeriesDef.TextTemplate
+				trace.Textposition = grob.BarTextposition(ls.SeriesDef.TextPosition)
+			}
+
 			traces = append(traces, trace)
 		case SeriesTypeHBar:
 			trace := &grob.Bar{
-				Type:        grob.TraceTypeBar,
-				Name:        ls.Name,
-				Orientation: grob.BarOrientationH,
-				X:           ls.Values,
-				Y:           ls.Labels,
-				Visible:     visible,
-				Yaxis:       ls.SeriesDef.Yaxis,
+				Type:          grob.TraceTypeBar,
+				Name:          ls.Name,
+				Hovertemplate: hoverTemplate,
+				Orientation:   grob.BarOrientationH,
+				X:             ls.Values,
+				Y:             ls.Labels,
+				Visible:       visible,
+				Ids:           ids,
+				Customdata:    customData,
+				Meta:          meta,
+				Opacity:       ls.SeriesDef.Opacity,
+				Width:         ls.SeriesDef.BarWidth,
+				Yaxis:         ls.SeriesDef.Yaxis,
 			}
 			if c := cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name); c != "" {
 				trace.Marker = &grob.BarMarker{
@@ -231,17 +696,37 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 				}
 			}
 
+			if ls.HasText() {
+				if ls.Text != nil {
+					trace.Text = ls.Text
+				}
+				trace.Texttemplate = ls.SeriesDef.TextTemplate
+				trace.Textposition = grob.BarTextposition(ls.SeriesDef.TextPosition)
+			}
+
 			traces = append(traces, trace)
 		case SeriesTypeLine:
 			trace := &grob.Scatter{
-				Type:    grob.TraceTypeScatter,
-				Name:    ls.Name,
-				X:       ls.Labels,
-				Y:       ls.Values,
-				Mode:    "lines",
-				Marker:  &grob.ScatterMarker{},
-				Visible: visible,
-				Yaxis:   ls.SeriesDef.Yaxis,
+				Type:          grob.TraceTypeScatter,
+				Name:          ls.Name,
+				Hovertemplate: hoverTemplate,
+				X:             ls.Labels,
+				Y:             ls.Values,
+				Mode:          "lines",
+				Marker:        &grob.ScatterMarker{},
+				Visible:       visible,
+				Ids:           ids,
+				Customdata:    customData,
+				Meta:          meta,
+				Opacity:       ls.SeriesDef.Opacity,
+				Yaxis:         ls.SeriesDef.Yaxis,
+			}
+
+			if ls.SeriesDef.LineWidth != 0 || ls.SeriesDef.LineDash != "" {
+				trace.Line = &grob.ScatterLine{
+					Width: ls.SeriesDef.LineWidth,
+					Dash:  ls.SeriesDef.LineDash,
+				}
 			}
 
 			if ls.SeriesDef.Fill == FillTypeToZero {
@@ -253,28 +738,58 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 				trace.Marker.Symbol = ls.SeriesDef.Marker
 			}
 
+			if ls.HasText() {
+				trace.Mode += "+text"
+				if ls.Text != nil {
+					trace.Text = ls.Text
+				}
+				trace.Texttemplate = ls.SeriesDef.TextTemplate
+				trace.Textposition = grob.ScatterTextposition(ls.SeriesDef.TextPosition)
+			}
+
 			if c := cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name); c != "" {
 				trace.Marker.Color = c
 			}
 			traces = append(traces, trace)
 		case SeriesTypeScatter:
 			trace := &grob.Scatter{
-				Type: grob.TraceTypeScatter,
-				Name: ls.Name,
-				X:    ls.Labels,
-				Y:    ls.Values,
-				Mode: "markers",
+				Type:          grob.TraceTypeScatter,
+				Name:          ls.Name,
+				Hovertemplate: hoverTemplate,
+				X:             ls.Labels,
+				Y:             ls.Values,
+				Mode:          "markers",
 				Marker: &grob.ScatterMarker{
 					Symbol: MarkerTypeCircle,
 				},
-				Visible: visible,
-				Yaxis:   ls.SeriesDef.Yaxis,
+				Visible:    visible,
+				Ids:        ids,
+				Customdata: customData,
+				Meta:       meta,
+				Opacity:    ls.SeriesDef.Opacity,
+				Yaxis:      ls.SeriesDef.Yaxis,
+			}
+
+			if ls.SeriesDef.LineWidth != 0 || ls.SeriesDef.LineDash != "" {
+				trace.Line = &grob.ScatterLine{
+					Width: ls.SeriesDef.LineWidth,
+					Dash:  ls.SeriesDef.LineDash,
+				}
 			}
 
 			if ls.SeriesDef.Fill == FillTypeToZero {
 				trace.Fill = "tozeroy"
 			}
 
+			if ls.HasText() {
+				trace.Mode += "+text"
+				if ls.Text != nil {
+					trace.Text = ls.Text
+				}
+				trace.Texttemplate = ls.SeriesDef.TextTemplate
+				trace.Textposition = grob.ScatterTextposition(ls.SeriesDef.TextPosition)
+			}
+
 			if c := cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name); c != "" {
 				trace.Marker.Color = c
 			}
@@ -282,11 +797,21 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 			traces = append(traces, trace)
 		case SeriesTypeBox:
 			trace := &grob.Box{
-				Type:    grob.TraceTypeBox,
-				Name:    ls.Name,
-				Y:       ls.Values,
-				Visible: visible,
-				Yaxis:   ls.SeriesDef.Yaxis,
+				Type:       grob.TraceTypeBox,
+				Name:       ls.Name,
+				Y:          ls.Values,
+				Visible:    visible,
+				Ids:        ids,
+				Customdata: customData,
+				Meta:       meta,
+				Opacity:    ls.SeriesDef.Opacity,
+				Yaxis:      ls.SeriesDef.Yaxis,
+			}
+
+			if ls.SeriesDef.LineWidth != 0 {
+				trace.Line = &grob.BoxLine{
+					Width: ls.SeriesDef.LineWidth,
+				}
 			}
 
 			if c := cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name); c != "" {
@@ -297,11 +822,21 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 			traces = append(traces, trace)
 		case SeriesTypeHBox:
 			trace := &grob.Box{
-				Type:    grob.TraceTypeBox,
-				Name:    ls.Name,
-				X:       ls.Values,
-				Visible: visible,
-				Yaxis:   ls.SeriesDef.Yaxis,
+				Type:       grob.TraceTypeBox,
+				Name:       ls.Name,
+				X:          ls.Values,
+				Visible:    visible,
+				Ids:        ids,
+				Customdata: customData,
+				Meta:       meta,
+				Opacity:    ls.SeriesDef.Opacity,
+				Yaxis:      ls.SeriesDef.Yaxis,
+			}
+
+			if ls.SeriesDef.LineWidth != 0 {
+				trace.Line = &grob.BoxLine{
+					Width: ls.SeriesDef.LineWidth,
+				}
 			}
 
 			if c := cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name); c != "" {
@@ -310,65 +845,122 @@ func seriesTraces(dataSets map[string]DataSet, seriesDefs []SeriesDef, cfg *Plot
 				}
 			}
 			traces = append(traces, trace)
+		case SeriesTypeChoropleth:
+			locationMode := grob.ChoroplethLocationmodeIso3
+			if ls.SeriesDef.LocationMode != "" {
+				locationMode = grob.ChoroplethLocationmode(ls.SeriesDef.LocationMode)
+			}
+			colorScale := "Viridis"
+			if ls.SeriesDef.ColorScale != "" {
+				colorScale = ls.SeriesDef.ColorScale
+			}
+			reverseScale := ls.SeriesDef.ReverseScale
+
+			trace := &grob.Choropleth{
+				Type:          grob.TraceTypeChoropleth,
+				Name:          ls.Name,
+				Locations:     ls.Labels,
+				Z:             ls.Values,
+				Locationmode:  locationMode,
+				Colorscale:    colorScale,
+				Reversescale:  grob.Bool(&reverseScale),
+				Hovertemplate: hoverTemplate,
+				Visible:       visible,
+				Ids:           ids,
+				Customdata:    customData,
+				Meta:          meta,
+			}
+			traces = append(traces, trace)
 		default:
-			return nil, fmt.Errorf("unsupported series type: %s", ls.SeriesDef.Type)
+			return nil, nil, fmt.Errorf("unsupported series type: %s", ls.SeriesDef.Type)
 		}
 	}
 
-	return traces, nil
+	return traces, data, nil
 }
 
-func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *PlotConfig, logger *slog.Logger) ([]grob.Trace, error) {
-	// work out which dataset fields need to be read
-	datasetFieldsUsed := make(map[string][]string)
-	for _, s := range scalarDefs {
-		if _, ok := dataSets[s.DataSet]; !ok {
-			logger.Error(fmt.Sprintf("unknown dataset name %q for scalar %s", s.DataSet, s.Name))
+// applyPercent converts the values of series using the percent option to a
+// fraction of the total of the series, or of the total of all percent series
+// sharing the same label.
+func applyPercent(data []*LabeledSeries) {
+	labelTotals := make(map[string]float64)
+	for _, ls := range data {
+		if !ls.SeriesDef.Percent || ls.SeriesDef.PercentOf != PercentTypeLabel {
 			continue
 		}
-		datasetFieldsUsed[s.DataSet] = append(datasetFieldsUsed[s.DataSet], s.Value)
-
-		if s.DeltaDataSet != "" {
-			if _, ok := dataSets[s.DeltaDataSet]; !ok {
-				logger.Error(fmt.Sprintf("unknown delta dataset name %q for scalar %s", s.DeltaDataSet, s.Name))
-				continue
+		for i, v := range ls.Values {
+			if f, ok := toFloat64(v); ok && i < len(ls.Labels) {
+				labelTotals[stringify(ls.Labels[i])] += f
 			}
-			datasetFieldsUsed[s.DeltaDataSet] = append(datasetFieldsUsed[s.DeltaDataSet], s.DeltaValue)
 		}
 	}
 
-	// read one row from each referenced dataset and record the relevant fields
-	dsValues := make(map[string]map[string]float64)
-	for dsname, fields := range datasetFieldsUsed {
-		ds := dataSets[dsname]
+	for _, ls := range data {
+		if !ls.SeriesDef.Percent {
+			continue
+		}
 
-		logger.Info("reading first row of dataset", "dataset", dsname)
-		if !ds.Next() {
-			if ds.Err() != nil {
-				logger.Error(fmt.Sprintf("error reading dataset %q: %v", dsname, ds.Err()))
-				continue
+		switch ls.SeriesDef.PercentOf {
+		case PercentTypeLabel:
+			for i, v := range ls.Values {
+				f, ok := toFloat64(v)
+				if !ok || i >= len(ls.Labels) {
+					ls.Values[i] = nil
+					continue
+				}
+				ls.Values[i] = fraction(f, labelTotals[stringify(ls.Labels[i])])
+			}
+		default:
+			total := 0.0
+			for _, v := range ls.Values {
+				if f, ok := toFloat64(v); ok {
+					total += f
+				}
+			}
+			for i, v := range ls.Values {
+				f, ok := toFloat64(v)
+				if !ok {
+					ls.Values[i] = nil
+					continue
+				}
+				ls.Values[i] = fraction(f, total)
 			}
-			logger.Error(fmt.Sprintf("no rows found for dataset %q", dsname))
-			continue
 		}
+	}
+}
+
+func fraction(v, total float64) any {
+	if total == 0 {
+		return nil
+	}
+	return v / total
+}
 
-		dsValues[dsname] = make(map[string]float64)
+// prefixedValueFormat is the d3 format used for scalar values that have a
+// prefix but no unit
+const prefixedValueFormat = ",.2~f"
 
-		for _, f := range fields {
-			v := ds.Field(f)
-			switch tv := v.(type) {
-			case float64:
-				dsValues[dsname][f] = tv
-			case int64:
-				dsValues[dsname][f] = float64(tv)
-			default:
-				logger.Error(fmt.Sprintf("field %q not read from dataset %q: (type %T)", f, dsname, v))
-				dsValues[dsname][f] = 0
+func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *PlotConfig, logger *slog.Logger) ([]grob.Trace, map[string]any, error) {
+	for _, s := range scalarDefs {
+		if _, ok := dataSets[s.DataSet]; !ok {
+			logger.Error(fmt.Sprintf("unknown dataset name %q for scalar %s", s.DataSet, s.Name))
+		}
+		if s.HasDelta() {
+			if _, ok := dataSets[s.DeltaDataSetName()]; !ok {
+				logger.Error(fmt.Sprintf("unknown delta dataset name %q for scalar %s", s.DeltaDataSetName(), s.Name))
+			}
+		}
+		if s.Type == ScalarTypeNumberTrend {
+			if _, ok := dataSets[s.TrendDataSet]; !ok {
+				logger.Error(fmt.Sprintf("unknown trend dataset name %q for scalar %s", s.TrendDataSet, s.Name))
 			}
 		}
 	}
 
+	scalarDefs = expandScalarGroups(dataSets, scalarDefs, logger)
+
 	var traces []grob.Trace
+	axes := make(map[string]any)
 
 	domainX := 1.0 / float64(len(scalarDefs))
 	for idx, s := range scalarDefs {
@@ -377,8 +969,35 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 		if s.Visible != nil {
 			visible = *s.Visible
 		}
+		numberFormat := &grob.IndicatorNumber{
+			Prefix: s.ValuePrefix,
+			Suffix: s.ValueSuffix,
+		}
+		if s.Unit != UnitTypeNone {
+			numberFormat.Valueformat = s.Unit.Format()
+			if s.ValueSuffix == "" {
+				numberFormat.Suffix = s.Unit.Suffix()
+			}
+		} else if s.Currency != "" {
+			prefix, suffix, format := currencyFormat(s.Currency, s.Locale)
+			numberFormat.Valueformat = format
+			if s.ValuePrefix == "" {
+				numberFormat.Prefix = prefix
+			}
+			if s.ValueSuffix == "" {
+				numberFormat.Suffix = suffix
+			}
+		} else if s.ValuePrefix != "" {
+			// prefixed values are usually amounts such as currency so group
+			// thousands and limit decimal places
+			numberFormat.Valueformat = prefixedValueFormat
+		}
+		if s.Format != "" {
+			numberFormat.Valueformat = namedValueFormat(s.Format)
+		}
+
 		switch s.Type {
-		case ScalarTypeNumber:
+		case ScalarTypeNumber, ScalarTypeNumberTrend:
 			domain := &grob.IndicatorDomain{
 				Column: int64(idx),
 				X:      []float64{domainX * float64(idx), domainX * float64(idx+1)},
@@ -387,12 +1006,10 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 				domain = s.Domain
 			}
 			trace = &grob.Indicator{
-				Type: grob.TraceTypeIndicator,
-				Name: s.Name,
-				Mode: "number",
-				Number: &grob.IndicatorNumber{
-					Suffix: s.ValueSuffix,
-				},
+				Type:   grob.TraceTypeIndicator,
+				Name:   s.Name,
+				Mode:   "number",
+				Number: numberFormat,
 				Domain: domain,
 				Title: &grob.IndicatorTitle{
 					Text: s.Name,
@@ -400,17 +1017,29 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 				Visible: visible,
 			}
 
-			if s.DeltaDataSet != "" {
+			if s.HasDelta() {
 				trace.Mode = "number+delta"
 			}
+
+			if s.Type == ScalarTypeNumberTrend {
+				if ds, ok := dataSets[s.TrendDataSet]; ok {
+					trend, trendAxes, err := trendTrace(ds, &s, trace, len(axes)/2, cfg)
+					if err != nil {
+						logger.Error(fmt.Sprintf("trend not read for scalar %s: %v", s.Name, err))
+					} else {
+						traces = append(traces, trend)
+						for name, axis := range trendAxes {
+							axes[name] = axis
+						}
+					}
+				}
+			}
 		case ScalarTypeGauge:
 			trace = &grob.Indicator{
-				Type: grob.TraceTypeIndicator,
-				Name: s.Name,
-				Mode: "gauge+number",
-				Number: &grob.IndicatorNumber{
-					Suffix: s.ValueSuffix,
-				},
+				Type:   grob.TraceTypeIndicator,
+				Name:   s.Name,
+				Mode:   "gauge+number",
+				Number: numberFormat,
 				Title: &grob.IndicatorTitle{
 					Text: s.Name,
 				},
@@ -419,27 +1048,58 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 				Domain:  s.Domain,
 			}
 
-			if s.DeltaDataSet != "" {
+			if s.HasDelta() {
 				trace.Mode = "gauge+number+delta"
 			}
 
 		default:
-			return nil, fmt.Errorf("unsupported scalar type: %s", s.Type)
+			return nil, nil, fmt.Errorf("unsupported scalar type: %s", s.Type)
 		}
 
-		v, ok := dsValues[s.DataSet][s.Value]
+		v, ok := readScalarValue(dataSets, s.DataSet, s.Value, 0, s.groupWhere, logger)
 		if !ok {
 			logger.Error(fmt.Sprintf("missing value field for scalar %s", s.Name))
 			continue
 		}
 		trace.Value = v
+		if isEmpty(trace.Number.Valueformat) && isLargeNumber(v) {
+			trace.Number.Valueformat = UnitTypeCount.Format()
+		}
 
-		if s.DeltaDataSet != "" {
-			dv, ok := dsValues[s.DeltaDataSet][s.DeltaValue]
+		if s.HasDelta() {
+			deltaRow := 0
+			if s.DeltaRow != nil {
+				deltaRow = *s.DeltaRow
+			}
+			dv, ok := readScalarValue(dataSets, s.DeltaDataSetName(), s.DeltaValueField(), deltaRow, s.deltaWhere(), logger)
 			if !ok {
 				logger.Error(fmt.Sprintf("missing delta value field for scalar %s", s.Name))
 				continue
 			}
+			if s.DeltaFormat != DeltaFormatDefault {
+				// plotly cannot scale delta values so format the delta as
+				// part of the title instead
+				text, increased, ok := formatRatioDelta(v, dv, s.DeltaType, s.DeltaFormat)
+				if ok {
+					color := defaultIncreaseColor
+					if c := cfg.MaybeLookupColor(s.IncreaseColor, ""); c != "" {
+						color = c
+					}
+					if !increased {
+						color = defaultDecreaseColor
+						if c := cfg.MaybeLookupColor(s.DecreaseColor, ""); c != "" {
+							color = c
+						}
+					}
+					trace.Title.Text = fmt.Sprintf("%s<br><span style=\"font-size:0.8em;color:%s\">%s</span>", s.Name, color, text)
+				} else {
+					logger.Error(fmt.Sprintf("cannot format delta of scalar %s in %s", s.Name, s.DeltaFormat), "delta_type", s.DeltaType, "value", v, "reference", dv)
+				}
+				trace.Mode = grob.IndicatorMode(strings.TrimSuffix(string(trace.Mode), "+delta"))
+				traces = append(traces, trace)
+				continue
+			}
+
 			switch s.DeltaType {
 			case DeltaTypeRelative:
 				trace.Delta = &grob.IndicatorDelta{
@@ -449,11 +1109,12 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 				}
 			case DeltaTypeAbsolute:
 				trace.Delta = &grob.IndicatorDelta{
-					Reference: dv,
-					Relative:  grob.False,
+					Reference:   dv,
+					Relative:    grob.False,
+					Valueformat: trace.Number.Valueformat,
 				}
 			default:
-				return nil, fmt.Errorf("unsupported delta type: %s", s.DeltaType)
+				return nil, nil, fmt.Errorf("unsupported delta type: %s", s.DeltaType)
 			}
 			if c := cfg.MaybeLookupColor(s.IncreaseColor, ""); c != "" {
 				trace.Delta.Increasing = &grob.IndicatorDeltaIncreasing{
@@ -469,7 +1130,258 @@ func scalarTraces(dataSets map[string]DataSet, scalarDefs []ScalarDef, cfg *Plot
 
 		traces = append(traces, trace)
 	}
-	return traces, nil
+	if len(axes) == 0 {
+		axes = nil
+	}
+	return traces, axes, nil
+}
+
+const (
+	// trendAxisBase is the number of the first pair of axes used for scalar
+	// sparklines, high enough to avoid clashing with axes used by series
+	trendAxisBase = 100
+
+	// trendHeight is the fraction of a scalar's domain used by its sparkline
+	trendHeight = 0.35
+)
+
+// trendTrace creates a sparkline of the scalar's trend dataset to display
+// beneath the number of the indicator. The indicator's domain is reduced to
+// make room for the sparkline which is drawn on the n-th pair of hidden trend
+// axes. It returns the sparkline trace and the layout of its axes.
+func trendTrace(ds DataSet, s *ScalarDef, indicator *grob.Indicator, n int, cfg *PlotConfig) (*grob.Scatter, map[string]any, error) {
+	var xs, ys []any
+	ds.ResetIterator()
+	for ds.Next() {
+		if !rowMatches(ds, s.groupWhere) {
+			continue
+		}
+		xs = append(xs, normalizeLabel(ds.Field(s.TrendX)))
+		ys = append(ys, normalizeValue(ds.Field(s.TrendY)))
+	}
+	ds.ResetIterator()
+	if ds.Err() != nil {
+		return nil, nil, ds.Err()
+	}
+
+	var domain grob.IndicatorDomain
+	if indicator.Domain != nil {
+		domain = *indicator.Domain
+	}
+	x0, x1 := domainRange(domain.X)
+	y0, y1 := domainRange(domain.Y)
+	split := y0 + (y1-y0)*trendHeight
+	domain.X = []float64{x0, x1}
+	domain.Y = []float64{split, y1}
+	indicator.Domain = &domain
+
+	axisNum := trendAxisBase + n
+	trace := &grob.Scatter{
+		Type:       grob.TraceTypeScatter,
+		Name:       s.Name,
+		X:          xs,
+		Y:          ys,
+		Mode:       "lines",
+		Xaxis:      fmt.Sprintf("x%d", axisNum),
+		Yaxis:      fmt.Sprintf("y%d", axisNum),
+		Fill:       "tozeroy",
+		Showlegend: grob.False,
+	}
+	if c := cfg.MaybeLookupColor(s.Color, s.Name); c != "" {
+		trace.Line = &grob.ScatterLine{Color: c}
+	}
+
+	axes := map[string]any{
+		fmt.Sprintf("xaxis%d", axisNum): map[string]any{
+			"domain":     []float64{x0, x1},
+			"anchor":     fmt.Sprintf("y%d", axisNum),
+			"visible":    false,
+			"fixedrange": true,
+		},
+		fmt.Sprintf("yaxis%d", axisNum): map[string]any{
+			"domain":     []float64{y0, split},
+			"anchor":     fmt.Sprintf("x%d", axisNum),
+			"visible":    false,
+			"fixedrange": true,
+		},
+	}
+	return trace, axes, nil
+}
+
+// domainRange returns the extent of an indicator domain along one direction,
+// which defaults to the whole figure
+func domainRange(v any) (float64, float64) {
+	switch r := v.(type) {
+	case []float64:
+		if len(r) == 2 {
+			return r[0], r[1]
+		}
+	case []any:
+		if len(r) == 2 {
+			start, ok1 := toFloat64(r[0])
+			end, ok2 := toFloat64(r[1])
+			if ok1 && ok2 {
+				return start, end
+			}
+		}
+	}
+	return 0, 1
+}
+
+// HasDelta reports whether the scalar should display a delta
+func (s *ScalarDef) HasDelta() bool {
+	return s.DeltaDataSet != "" || s.DeltaRow != nil || len(s.DeltaWhere) > 0
+}
+
+// DeltaDataSetName returns the name of the dataset holding the delta value
+func (s *ScalarDef) DeltaDataSetName() string {
+	if s.DeltaDataSet == "" {
+		return s.DataSet
+	}
+	return s.DeltaDataSet
+}
+
+// DeltaValueField returns the name of the field holding the delta value
+func (s *ScalarDef) DeltaValueField() string {
+	if s.DeltaValue == "" {
+		return s.Value
+	}
+	return s.DeltaValue
+}
+
+// deltaWhere returns the field values selecting the rows of the delta
+// dataset. Scalars expanded from a group field select rows with the same
+// group value.
+func (s *ScalarDef) deltaWhere() map[string]any {
+	if len(s.groupWhere) == 0 {
+		return s.DeltaWhere
+	}
+	where := make(map[string]any, len(s.DeltaWhere)+len(s.groupWhere))
+	for k, v := range s.DeltaWhere {
+		where[k] = v
+	}
+	for k, v := range s.groupWhere {
+		where[k] = v
+	}
+	return where
+}
+
+// expandScalarGroups replaces each scalar that has a group field with one
+// scalar per distinct value of the field, in the order they are first found
+// in the dataset. If any scalars are expanded then all scalars without a
+// domain are laid out in a grid.
+func expandScalarGroups(dataSets map[string]DataSet, scalarDefs []ScalarDef, logger *slog.Logger) []ScalarDef {
+	expanded := make([]ScalarDef, 0, len(scalarDefs))
+	grouped := false
+	for _, s := range scalarDefs {
+		if s.GroupField == "" {
+			expanded = append(expanded, s)
+			continue
+		}
+		grouped = true
+
+		ds, ok := dataSets[s.DataSet]
+		if !ok {
+			continue
+		}
+
+		logger.Info("reading groups of dataset", "dataset", s.DataSet, "field", s.GroupField)
+		seen := make(map[string]bool)
+		ds.ResetIterator()
+		for ds.Next() {
+			group := normalizeValue(ds.Field(s.GroupField))
+			if seen[stringify(group)] {
+				continue
+			}
+			seen[stringify(group)] = true
+
+			gs := s
+			if s.Name != "" {
+				gs.Name = fmt.Sprintf("%s-%v", s.Name, group)
+			} else {
+				gs.Name = fmt.Sprintf("%v", group)
+			}
+			gs.groupWhere = map[string]any{s.GroupField: group}
+			expanded = append(expanded, gs)
+		}
+		ds.ResetIterator()
+		if ds.Err() != nil {
+			logger.Error(fmt.Sprintf("error reading groups of dataset %q: %v", s.DataSet, ds.Err()))
+		}
+	}
+
+	if !grouped {
+		return expanded
+	}
+
+	// lay out the scalars in a grid that is roughly square
+	cols := int(math.Ceil(math.Sqrt(float64(len(expanded)))))
+	rows := int(math.Ceil(float64(len(expanded)) / float64(max(cols, 1))))
+	for i := range expanded {
+		if expanded[i].Domain != nil {
+			continue
+		}
+		row, col := i/cols, i%cols
+		expanded[i].Domain = &grob.IndicatorDomain{
+			Row:    int64(row),
+			Column: int64(col),
+			X:      []float64{float64(col) / float64(cols), float64(col+1) / float64(cols)},
+			Y:      []float64{1 - float64(row+1)/float64(rows), 1 - float64(row)/float64(rows)},
+		}
+	}
+	return expanded
+}
+
+// readScalarValue reads a numeric field from a row of the named dataset. The
+// row is selected by its zero-based index among the rows matching the where
+// field values. Fields with non-numeric values are read as zero. It reports
+// false if no row could be read.
+func readScalarValue(dataSets map[string]DataSet, dsname string, field string, row int, where map[string]any, logger *slog.Logger) (float64, bool) {
+	ds, ok := dataSets[dsname]
+	if !ok {
+		return 0, false
+	}
+
+	logger.Info("reading row of dataset", "dataset", dsname, "row", row)
+	ds.ResetIterator()
+	defer ds.ResetIterator()
+
+	n := 0
+	for ds.Next() {
+		if !rowMatches(ds, where) {
+			continue
+		}
+		if n < row {
+			n++
+			continue
+		}
+
+		v := ds.Field(field)
+		f, ok := toFloat64(v)
+		if !ok {
+			logger.Error(fmt.Sprintf("field %q not read from dataset %q: (type %T)", field, dsname, v))
+			return 0, true
+		}
+		return f, true
+	}
+
+	if ds.Err() != nil {
+		logger.Error(fmt.Sprintf("error reading dataset %q: %v", dsname, ds.Err()))
+		return 0, false
+	}
+	logger.Error(fmt.Sprintf("no matching rows found for dataset %q", dsname))
+	return 0, false
+}
+
+// rowMatches reports whether the current row of the dataset has the given
+// field values
+func rowMatches(ds DataSet, where map[string]any) bool {
+	for field, want := range where {
+		if stringify(normalizeValue(ds.Field(field))) != stringify(want) {
+			return false
+		}
+	}
+	return true
 }
 
 type LabeledTable struct {
@@ -498,7 +1410,7 @@ func (lt LabeledTable) Annotations() []Annotation {
 	maxVal := -math.MaxFloat64
 	for _, yLabel := range lt.LabelsY {
 		for _, xLabel := range lt.LabelsX {
-			val, ok := lt.Values[xLabel][yLabel].(float64)
+			val, ok := toFloat64(lt.Values[xLabel][yLabel])
 			if !ok {
 				continue
 			}
@@ -520,7 +1432,7 @@ func (lt LabeledTable) Annotations() []Annotation {
 		for _, xLabel := range lt.LabelsX {
 
 			color := ""
-			val, ok := lt.Values[xLabel][yLabel].(float64)
+			val, ok := toFloat64(lt.Values[xLabel][yLabel])
 			if ok && val >= brightThreshold {
 				color = "#EEEEEE" // TODO: parametrize
 			}
@@ -545,16 +1457,23 @@ func tableTraces(dataSets map[string]DataSet, tablesDefs []TableDef, cfg *PlotCo
 	var traces []grob.Trace
 	var annotations []Annotation
 
+	// traces are added for each dataset in turn so datasets are read in the
+	// order they are first used, keeping the order of traces stable
+	var dsnames []string
 	tablesByDataSet := make(map[string][]TableDef)
 	for i, t := range tablesDefs {
 		if _, ok := dataSets[t.DataSet]; !ok {
 			slog.Error(fmt.Sprintf("unknown dataset name %q in table %d", t.DataSet, i))
 			continue
 		}
+		if _, ok := tablesByDataSet[t.DataSet]; !ok {
+			dsnames = append(dsnames, t.DataSet)
+		}
 		tablesByDataSet[t.DataSet] = append(tablesByDataSet[t.DataSet], t)
 	}
 
-	for dsname, tables := range tablesByDataSet {
+	for _, dsname := range dsnames {
+		tables := tablesByDataSet[dsname]
 		ds := dataSets[dsname]
 
 		data := make([]*LabeledTable, 0)
@@ -582,8 +1501,8 @@ func tableTraces(dataSets map[string]DataSet, tablesDefs []TableDef, cfg *PlotCo
 					dataIndex[lt.Name] = lt
 				}
 
-				labelX := normalizeValue(ds.Field(table.LabelsX))
-				labelY := normalizeValue(ds.Field(table.LabelsY))
+				labelX := normalizeLabel(ds.Field(table.LabelsX))
+				labelY := normalizeLabel(ds.Field(table.LabelsY))
 				valueZ := normalizeValue(ds.Field(table.Values))
 
 				if _, found := lt.Values[labelX]; !found {
@@ -617,22 +1536,36 @@ func tableTraces(dataSets map[string]DataSet, tablesDefs []TableDef, cfg *PlotCo
 		for _, lt := range data {
 			lt := lt
 
-			reverseScale := true
 			switch lt.TableDef.Type {
 			case TableTypeHeatmap:
-				trace := &grob.Heatmap{
-					Type:         grob.TraceTypeHeatmap,
-					Name:         lt.Name,
-					X:            lt.LabelsX,
-					Y:            lt.LabelsY,
-					Z:            lt.ValueZ(),
-					Colorscale:   "Viridis",
-					Colorbar:     lt.TableDef.Colorbar,
-					Reversescale: grob.Bool(&reverseScale),
-					Yaxis:        lt.TableDef.Yaxis,
+				colorScale := "Viridis"
+				if lt.TableDef.ColorScale != "" {
+					colorScale = lt.TableDef.ColorScale
+				}
+				reverseScale := true
+				if lt.TableDef.ReverseScale != nil {
+					reverseScale = *lt.TableDef.ReverseScale
+				}
+
+				trace := &heatmapTrace{
+					Heatmap: &grob.Heatmap{
+						Type:         grob.TraceTypeHeatmap,
+						Name:         lt.Name,
+						X:            lt.LabelsX,
+						Y:            lt.LabelsY,
+						Z:            lt.ValueZ(),
+						Colorscale:   colorScale,
+						Colorbar:     lt.TableDef.Colorbar,
+						Reversescale: grob.Bool(&reverseScale),
+						Yaxis:        lt.TableDef.Yaxis,
+					},
+					Zmin: lt.TableDef.ZMin,
+					Zmax: lt.TableDef.ZMax,
 				}
 				traces = append(traces, trace)
-				annotations = append(annotations, lt.Annotations()...)
+				if !lt.TableDef.NoAnnotations {
+					annotations = append(annotations, lt.Annotations()...)
+				}
 			case TableTypeCategoryBar:
 				xLabels := [][]any{}
 				xLabels = append(xLabels, []any{}, []any{})
@@ -697,6 +1630,43 @@ func stripNewlines(s string) string {
 	return strings.ReplaceAll(s, "\n", " ")
 }
 
+// maxExactInteger is the largest magnitude of integer that survives being
+// parsed as a double by the browser
+const maxExactInteger = 1 << 53
+
+// normalizeLabel normalizes a value used as a label, id or text. Integers too
+// large to be represented exactly as a double, such as block heights or
+// hashes, are converted to decimal strings so they are not rounded when the
+// figure is parsed.
+func normalizeLabel(v any) any {
+	switch tv := v.(type) {
+	case int64:
+		if tv > maxExactInteger || tv < -maxExactInteger {
+			return strconv.FormatInt(tv, 10)
+		}
+	case uint64:
+		if tv > maxExactInteger {
+			return strconv.FormatUint(tv, 10)
+		}
+	case uint:
+		if uint64(tv) > maxExactInteger {
+			return strconv.FormatUint(uint64(tv), 10)
+		}
+	case int:
+		return normalizeLabel(int64(tv))
+	case pgtype.Numeric:
+		if !tv.Valid || tv.NaN || tv.InfinityModifier != pgtype.Finite || tv.Exp < 0 {
+			break
+		}
+		n := new(big.Int).Mul(tv.Int, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tv.Exp)), nil))
+		if n.IsInt64() {
+			return normalizeLabel(n.Int64())
+		}
+		return n.String()
+	}
+	return normalizeValue(v)
+}
+
 func normalizeValue(v any) any {
 	switch tv := v.(type) {
 	case pgtype.Interval:
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxGeneratedRows limits the size of generated datasets so a mistyped step
+// cannot exhaust memory
+const maxGeneratedRows = 100000
+
+// GeneratorQueryJSON describes a generated dataset. If from and to are given
+// the dataset has a row with a time field for each step between them,
+// inclusive. If value is given each row also has it in a value field, or the
+// dataset is a single row holding just the value when there is no time range.
+type GeneratorQueryJSON struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Step  string `json:"step"`
+	Value any    `json:"value"`
+}
+
+// GeneratorDataSource generates datasets that would otherwise need backend
+// specific sql, such as a spine of dates for joins to fill gaps or a
+// constant for reference lines
+type GeneratorDataSource struct{}
+
+func (s *GeneratorDataSource) GetDataSet(_ context.Context, query string, params ...any) (DataSet, error) {
+	var gq GeneratorQueryJSON
+	if err := json.NewDecoder(strings.NewReader(query)).Decode(&gq); err != nil {
+		return nil, fmt.Errorf("failed to decode generator query: %w", err)
+	}
+
+	if gq.From == "" && gq.To == "" {
+		if gq.Value == nil {
+			return nil, fmt.Errorf("generator query must have a time range or a value")
+		}
+		return NewStaticDataSet(map[string][]any{"value": {gq.Value}}), nil
+	}
+
+	from, ok := toTime(gq.From)
+	if !ok {
+		return nil, fmt.Errorf("invalid generator from time: %q", gq.From)
+	}
+	to, ok := toTime(gq.To)
+	if !ok {
+		return nil, fmt.Errorf("invalid generator to time: %q", gq.To)
+	}
+	if gq.Step == "" {
+		return nil, fmt.Errorf("generator query with a time range must have a step")
+	}
+	step, err := parseInterval(gq.Step)
+	if err != nil {
+		return nil, fmt.Errorf("generator step: %w", err)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("generator to time %s is before from time %s", gq.To, gq.From)
+	}
+	if n := to.Sub(from) / step; n >= maxGeneratedRows {
+		return nil, fmt.Errorf("generator query would produce more than %d rows", maxGeneratedRows)
+	}
+
+	data := map[string][]any{}
+	for t := from.UTC(); !t.After(to); t = t.Add(step) {
+		data["time"] = append(data["time"], t)
+		if gq.Value != nil {
+			data["value"] = append(data["value"], gq.Value)
+		}
+	}
+	return NewStaticDataSet(data), nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var graphCommand = &cli.Command{
+	Name:      "graph",
+	Usage:     "Output the graph of plots, datasets, computed datasets, tables and sources used by plot definitions",
+	ArgsUsage: "<plot definition>...",
+	Action:    Graph,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "params",
+			Aliases:     []string{"p"},
+			Required:    false,
+			Usage:       "Specify templating parameters, in the format key=value. May be repeated to specify multiple parameters.",
+			Destination: &graphOpts.params,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &graphOpts.confDir,
+		},
+		&cli.StringFlag{
+			Name:        "format",
+			Required:    false,
+			Usage:       "Output format, either dot or json.",
+			Value:       "dot",
+			Destination: &graphOpts.format,
+		},
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Write the graph to a file instead of stdout.",
+			Destination: &graphOpts.output,
+		},
+	}, loggingFlags...),
+}
+
+var graphOpts struct {
+	params  cli.StringSlice
+	confDir string
+	format  string
+	output  string
+}
+
+func Graph(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if graphOpts.format != "dot" && graphOpts.format != "json" {
+		return fmt.Errorf("unsupported graph format: %q", graphOpts.format)
+	}
+
+	if cc.NArg() == 0 {
+		return fmt.Errorf("at least one plot definition must be supplied as an argument")
+	}
+
+	cfg, err := newPlotConfig(ctx, graphOpts.confDir, nil, false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+	cfg.TemplateParams, err = parseTemplateParams(graphOpts.params.Value())
+	if err != nil {
+		return err
+	}
+
+	pds, err := loadPlotDefFiles(ctx, cfg, cc.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	g := NewDependencyGraph()
+	for _, pd := range pds {
+		g.AddPlot(pd)
+	}
+
+	var out io.Writer = os.Stdout
+	if graphOpts.output != "" {
+		f, err := os.Create(graphOpts.output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if graphOpts.format == "json" {
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal to json: %w", err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	return g.WriteDot(out)
+}
+
+// loadPlotDefFiles templates and parses plot definition files without
+// running any queries. The queries of datasets are resolved so they can be
+// inspected.
+func loadPlotDefFiles(ctx context.Context, cfg *PlotConfig, fnames []string) ([]*PlotDef, error) {
+	pds := make([]*PlotDef, 0, len(fnames))
+	for _, fname := range fnames {
+		fcontent, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plot definition: %w", err)
+		}
+
+		templated, err := ExecuteTemplate(ctx, string(fcontent), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute templates for plot definition %q: %w", fname, err)
+		}
+
+		pd, err := parsePlotDef(fname, fcontent, []byte(templated))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse plot definition %q: %w", fname, err)
+		}
+
+		pd, plotCfg, err := withPlotTimezone(ctx, fname, string(fcontent), pd, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply timezone of plot definition %q: %w", fname, err)
+		}
+
+		if err := resolveQueries(ctx, fname, fcontent, pd, os.DirFS(filepath.Dir(fname)), ".", plotCfg); err != nil {
+			return nil, err
+		}
+		pds = append(pds, pd)
+	}
+	return pds, nil
+}
+
+type GraphNodeKind string
+
+const (
+	GraphNodePlot     GraphNodeKind = "plot"
+	GraphNodeDataSet  GraphNodeKind = "dataset"
+	GraphNodeComputed GraphNodeKind = "computed"
+	GraphNodeTable    GraphNodeKind = "table"
+	GraphNodeSource   GraphNodeKind = "source"
+)
+
+// A DependencyGraph records how plots depend on datasets, computed datasets,
+// tables and sources. Edges point from a node to the nodes it uses.
+type DependencyGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+
+	seenNodes map[string]bool
+	seenEdges map[GraphEdge]bool
+}
+
+type GraphNode struct {
+	ID     string        `json:"id"`
+	Kind   GraphNodeKind `json:"kind"`
+	Name   string        `json:"name"`
+	Plot   string        `json:"plot,omitempty"`   // the plot that defines a dataset or computed dataset
+	Source string        `json:"source,omitempty"` // the source containing a table
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		seenNodes: make(map[string]bool),
+		seenEdges: make(map[GraphEdge]bool),
+	}
+}
+
+// AddPlot adds a plot and everything it uses to the graph. Tables are found
+// by looking for names following from or join in dataset queries, so may
+// not be complete for complex queries.
+func (g *DependencyGraph) AddPlot(pd *PlotDef) {
+	plotID := g.addNode(GraphNode{ID: "plot:" + pd.Name, Kind: GraphNodePlot, Name: pd.Name})
+
+	datasetIDs := make(map[string]string)
+	for _, ds := range pd.Datasets {
+		id := g.addNode(GraphNode{ID: "dataset:" + pd.Name + "/" + ds.Name, Kind: GraphNodeDataSet, Name: ds.Name, Plot: pd.Name})
+		datasetIDs[ds.Name] = id
+		g.addEdge(plotID, id)
+
+		sourceID := g.addNode(GraphNode{ID: "source:" + ds.Source, Kind: GraphNodeSource, Name: ds.Source})
+		tables := queryTables(ds.Query)
+		if len(tables) == 0 {
+			g.addEdge(id, sourceID)
+		}
+		for _, table := range tables {
+			tableID := g.addNode(GraphNode{ID: "table:" + ds.Source + "/" + table, Kind: GraphNodeTable, Name: table, Source: ds.Source})
+			g.addEdge(id, tableID)
+			g.addEdge(tableID, sourceID)
+		}
+	}
+
+	for _, cds := range pd.Computed {
+		datasetIDs[cds.Name] = "computed:" + pd.Name + "/" + cds.Name
+	}
+	for _, cds := range pd.Computed {
+		id := g.addNode(GraphNode{ID: datasetIDs[cds.Name], Kind: GraphNodeComputed, Name: cds.Name, Plot: pd.Name})
+		g.addEdge(plotID, id)
+		for _, in := range cds.DataSets {
+			if inID, ok := datasetIDs[in.DataSet]; ok {
+				g.addEdge(id, inID)
+			}
+		}
+	}
+}
+
+func (g *DependencyGraph) addNode(n GraphNode) string {
+	if !g.seenNodes[n.ID] {
+		g.seenNodes[n.ID] = true
+		g.Nodes = append(g.Nodes, n)
+	}
+	return n.ID
+}
+
+func (g *DependencyGraph) addEdge(from, to string) {
+	e := GraphEdge{From: from, To: to}
+	if !g.seenEdges[e] {
+		g.seenEdges[e] = true
+		g.Edges = append(g.Edges, e)
+	}
+}
+
+var graphNodeShapes = map[GraphNodeKind]string{
+	GraphNodePlot:     "box",
+	GraphNodeDataSet:  "ellipse",
+	GraphNodeComputed: "diamond",
+	GraphNodeTable:    "note",
+	GraphNodeSource:   "cylinder",
+}
+
+// WriteDot writes the graph in graphviz dot format
+func (g *DependencyGraph) WriteDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph ashby {\n")
+	b.WriteString("  rankdir=LR;\n")
+
+	nodes := append([]GraphNode(nil), g.Nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Kind < nodes[j].Kind })
+	for _, n := range nodes {
+		label := n.Name
+		if n.Kind == GraphNodeTable {
+			label = n.Source + "." + n.Name
+		}
+		fmt.Fprintf(&b, "  %q [label=%q shape=%s];\n", n.ID, label, graphNodeShapes[n.Kind])
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %q -> %q;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var (
+	queryTableRe = regexp.MustCompile(`(?i)\b(?:from|join)\s+("?[a-z_][\w$]*"?(?:\."?[a-z_][\w$]*"?)?)`)
+	queryCTERe   = regexp.MustCompile(`(?i)\b([a-z_][\w$]*)\s+as\s*(?:not\s+)?(?:materialized\s*)?\(`)
+	queryFuncRe  = regexp.MustCompile(`(?i)\b(extract|substring|trim|overlay|position)\s*\($`)
+)
+
+// queryTables returns the names of the tables that a sql query appears to
+// read from, in the order they first appear. Common table expressions,
+// comments, string literals and the from keyword used within functions such
+// as extract are ignored.
+func queryTables(query string) []string {
+	query = sqlCommentRe.ReplaceAllString(query, " ")
+	query = sqlStringRe.ReplaceAllString(query, " ")
+
+	ctes := make(map[string]bool)
+	for _, m := range queryCTERe.FindAllStringSubmatch(query, -1) {
+		ctes[strings.ToLower(m[1])] = true
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, loc := range queryTableRe.FindAllStringSubmatchIndex(query, -1) {
+		// skip table functions such as generate_series(...)
+		if strings.HasPrefix(strings.TrimSpace(query[loc[1]:]), "(") {
+			continue
+		}
+		// skip uses of from within function arguments, such as extract(epoch from ts)
+		before := query[:loc[0]]
+		if open := strings.LastIndex(before, "("); open >= 0 && !strings.Contains(before[open:], ")") && queryFuncRe.MatchString(before[:open+1]) {
+			continue
+		}
+
+		table := strings.ReplaceAll(query[loc[2]:loc[3]], `"`, "")
+		if ctes[strings.ToLower(table)] || seen[table] {
+			continue
+		}
+		seen[table] = true
+		tables = append(tables, table)
+	}
+	return tables
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+	"gopkg.in/yaml.v3"
+)
+
+// Holiday is a configured non-working day
+type Holiday struct {
+	Date string `yaml:"date"` // the day of the holiday in yyyy-mm-dd format
+	Name string `yaml:"name"`
+	day  time.Time
+}
+
+func readHolidaysConf(conffs fs.FS) ([]Holiday, error) {
+	content, err := fs.ReadFile(conffs, "holidays.yaml")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read holidays: %w", err)
+	}
+
+	var holidays []Holiday
+	if err := yaml.Unmarshal(content, &holidays); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal holidays.yaml: %w", err)
+	}
+	for i := range holidays {
+		holidays[i].day, err = time.Parse(time.DateOnly, holidays[i].Date)
+		if err != nil {
+			return nil, fmt.Errorf("holiday %d: invalid date %q, expected yyyy-mm-dd", i+1, holidays[i].Date)
+		}
+	}
+	return holidays, nil
+}
+
+// timeLabelRange returns the earliest and latest labels of the series whose
+// labels are all times and are plotted on the x axis
+func timeLabelRange(series []*LabeledSeries) (first, last time.Time, ok bool) {
+	for _, ls := range series {
+		s := ls.SeriesDef
+		if s.Type == SeriesTypeChoropleth || s.ValueAxis() == "x" || len(ls.Labels) == 0 {
+			continue
+		}
+		times := make([]time.Time, 0, len(ls.Labels))
+		for _, l := range ls.Labels {
+			t, isTime := toTime(l)
+			if !isTime {
+				break
+			}
+			times = append(times, t.UTC())
+		}
+		if len(times) != len(ls.Labels) {
+			continue
+		}
+		for _, t := range times {
+			if !ok || t.Before(first) {
+				first = t
+			}
+			if !ok || t.After(last) {
+				last = t
+			}
+			ok = true
+		}
+	}
+	return first, last, ok
+}
+
+// shadeNonWorkingDays shades the weekends and configured holidays that fall
+// within the range of the time series of the plot, as requested by the plot
+// definition. Consecutive non-working days are shaded as a single region.
+func shadeNonWorkingDays(layout *grob.Layout, pd *PlotDef, series []*LabeledSeries, holidays []Holiday) {
+	if !pd.ShadeWeekends && !pd.ShadeHolidays {
+		return
+	}
+	first, last, ok := timeLabelRange(series)
+	if !ok {
+		return
+	}
+
+	holidaySet := make(map[time.Time]bool)
+	if pd.ShadeHolidays {
+		for _, h := range holidays {
+			holidaySet[h.day] = true
+		}
+	}
+	nonWorking := func(day time.Time) bool {
+		if pd.ShadeWeekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+			return true
+		}
+		return holidaySet[day]
+	}
+
+	var shapes []any
+	var start time.Time
+	inRegion := false
+	end := last.Truncate(24*time.Hour).AddDate(0, 0, 1)
+	for day := first.Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+		switch {
+		case nonWorking(day) && !inRegion:
+			start, inRegion = day, true
+		case !nonWorking(day) && inRegion:
+			shapes = append(shapes, xRegion(start, day, "rgba(128,128,128,0.1)"))
+			inRegion = false
+		}
+	}
+	if inRegion {
+		shapes = append(shapes, xRegion(start, end, "rgba(128,128,128,0.1)"))
+	}
+	addShapes(layout, shapes)
+}
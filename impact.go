@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var impactCommand = &cli.Command{
+	Name:      "impact",
+	Usage:     "List the plots whose queries reference a table or column",
+	ArgsUsage: "<plot definition>...",
+	Action:    Impact,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "table",
+			Required:    true,
+			Usage:       "Name of a table to look for, optionally qualified by schema. May be repeated to look for multiple tables.",
+			Destination: &impactOpts.tables,
+		},
+		&cli.StringSliceFlag{
+			Name:        "column",
+			Required:    false,
+			Usage:       "Only list plots whose queries also reference this column. May be repeated to look for multiple columns.",
+			Destination: &impactOpts.columns,
+		},
+		&cli.StringFlag{
+			Name:        "source",
+			Required:    false,
+			Usage:       "Only consider datasets that query this source.",
+			Destination: &impactOpts.source,
+		},
+		&cli.StringSliceFlag{
+			Name:        "params",
+			Aliases:     []string{"p"},
+			Required:    false,
+			Usage:       "Specify templating parameters, in the format key=value. May be repeated to specify multiple parameters.",
+			Destination: &impactOpts.params,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &impactOpts.confDir,
+		},
+	}, loggingFlags...),
+}
+
+var impactOpts struct {
+	tables  cli.StringSlice
+	columns cli.StringSlice
+	source  string
+	params  cli.StringSlice
+	confDir string
+}
+
+func Impact(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if cc.NArg() == 0 {
+		return fmt.Errorf("at least one plot definition must be supplied as an argument")
+	}
+
+	fnames := cc.Args().Slice()
+	cfg, err := newPlotConfig(ctx, impactOpts.confDir, nil, false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+	cfg.TemplateParams, err = parseTemplateParams(impactOpts.params.Value())
+	if err != nil {
+		return err
+	}
+
+	pds, err := loadPlotDefFiles(ctx, cfg, fnames)
+	if err != nil {
+		return err
+	}
+
+	for i, pd := range pds {
+		for _, ref := range FindQueryReferences(pd, impactOpts.tables.Value(), impactOpts.columns.Value(), impactOpts.source) {
+			line := fmt.Sprintf("%s: plot %q dataset %q uses %s.%s", fnames[i], pd.Name, ref.DataSet, ref.Source, ref.Table)
+			if len(ref.Columns) > 0 {
+				line += " (columns: " + strings.Join(ref.Columns, ", ") + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+// A QueryReference is a dataset whose query references a table and,
+// optionally, some of its columns
+type QueryReference struct {
+	DataSet string
+	Source  string
+	Table   string
+	Columns []string
+}
+
+// FindQueryReferences returns the datasets of the plot whose queries read
+// from any of the tables. If columns are given only datasets whose queries
+// also mention one of the columns are returned. Queries are analysed on a
+// best effort basis without fully parsing the sql, so a column is considered
+// referenced if its name appears anywhere outside string literals and
+// comments.
+func FindQueryReferences(pd *PlotDef, tables []string, columns []string, source string) []QueryReference {
+	var refs []QueryReference
+	for _, ds := range pd.Datasets {
+		if source != "" && ds.Source != source {
+			continue
+		}
+
+		var idents map[string]bool
+		for _, table := range queryTables(ds.Query) {
+			if !matchesAnyTable(table, tables) {
+				continue
+			}
+			ref := QueryReference{DataSet: ds.Name, Source: ds.Source, Table: table}
+			if len(columns) > 0 {
+				if idents == nil {
+					idents = queryIdentifiers(ds.Query)
+				}
+				for _, col := range columns {
+					if idents[strings.ToLower(col)] {
+						ref.Columns = append(ref.Columns, col)
+					}
+				}
+				if len(ref.Columns) == 0 {
+					continue
+				}
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// matchesAnyTable reports whether a table name used in a query refers to
+// one of the given tables. Names are compared case insensitively and an
+// unqualified name matches a schema qualified one with the same table name.
+func matchesAnyTable(table string, tables []string) bool {
+	table = strings.ToLower(table)
+	_, unqualified, qualified := strings.Cut(table, ".")
+	for _, t := range tables {
+		t = strings.ToLower(t)
+		if t == table {
+			return true
+		}
+		if qualified && !strings.Contains(t, ".") && t == unqualified {
+			return true
+		}
+		if !qualified && strings.HasSuffix(t, "."+table) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	sqlStringRe  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlCommentRe = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	sqlIdentRe   = regexp.MustCompile(`"[^"]+"|[A-Za-z_][\w$]*`)
+)
+
+// queryIdentifiers returns the lower cased identifiers used in a sql query,
+// ignoring string literals and comments
+func queryIdentifiers(query string) map[string]bool {
+	query = sqlCommentRe.ReplaceAllString(query, " ")
+	query = sqlStringRe.ReplaceAllString(query, " ")
+
+	idents := make(map[string]bool)
+	for _, m := range sqlIdentRe.FindAllString(query, -1) {
+		idents[strings.ToLower(strings.Trim(m, `"`))] = true
+	}
+	return idents
+}
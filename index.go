@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// indexFilename is the name of the index written to each directory of the
+// output tree that holds plots, and to the base of the tree
+const indexFilename = "index.json"
+
+// An OutputIndex lists the plots written to a directory of the output tree,
+// so they can be found without listing the directory. The index at the base
+// of the tree lists the plots in every directory.
+type OutputIndex struct {
+	Updated time.Time           `json:"updated"`
+	Plots   []*OutputIndexEntry `json:"plots"`
+}
+
+// OutputIndexEntry describes a plot output as of the last batch run that
+// processed it
+type OutputIndexEntry struct {
+	Name      string        `json:"name"`
+	Path      string        `json:"path"` // the path of the output, relative to the base of the output tree, using forward slashes
+	Frequency PlotFrequency `json:"frequency,omitempty"`
+	BasisTime time.Time     `json:"basisTime"`
+	Generated *time.Time    `json:"generated,omitempty"` // when the output was last written, missing if it has never been written
+	Thumbnail string        `json:"thumbnail,omitempty"` // the path of a png preview of the output, relative to the base of the output tree
+	Status    BatchStatus   `json:"status"`
+	Checksum  string        `json:"checksum,omitempty"` // the checksum of the plot definition the output was generated from
+	Versions  string        `json:"versions,omitempty"` // a glob pattern matching the paths of the outputs of the plot for every period, relative to the base of the output tree
+}
+
+// WriteIndexes updates the index of each directory that the plots of the run
+// were written to, including the latest directory, and the index at the base
+// of the output tree. Entries for outputs not processed by the run are kept.
+// Skipped plots keep their existing entries since their outputs were not
+// changed.
+func (r *BatchResults) WriteIndexes(ctx context.Context, store OutputStore, base string) error {
+	r.mu.Lock()
+	var entries []*OutputIndexEntry
+	for _, res := range r.Plots {
+		for _, output := range []string{res.Output, res.Latest} {
+			if output == "" {
+				continue
+			}
+			entry, err := newOutputIndexEntry(ctx, store, base, output, res, r.BasisTime)
+			if err != nil {
+				r.mu.Unlock()
+				return err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	r.mu.Unlock()
+
+	byDir := map[string][]*OutputIndexEntry{}
+	for _, e := range entries {
+		if e.Generated == nil {
+			// outputs that were never written only appear in the base index
+			// so that no directory is created for them
+			continue
+		}
+		dir := joinLocation(base, path.Dir(e.Path))
+		byDir[dir] = append(byDir[dir], e)
+	}
+	byDir[base] = entries
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := updateOutputIndex(ctx, store, joinLocation(dir, indexFilename), byDir[dir]); err != nil {
+			return fmt.Errorf("update index of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func newOutputIndexEntry(ctx context.Context, store OutputStore, base, output string, res *BatchResult, basisTime time.Time) (*OutputIndexEntry, error) {
+	rel, err := relLocation(base, output)
+	if err != nil {
+		return nil, fmt.Errorf("relative path of output: %w", err)
+	}
+	entry := &OutputIndexEntry{
+		Name:      res.Name,
+		Path:      rel,
+		Frequency: res.Frequency,
+		BasisTime: basisTime,
+		Status:    res.Status,
+		Checksum:  res.Checksum,
+	}
+	if res.Versions != "" && output == res.Output {
+		if entry.Versions, err = relLocation(base, res.Versions); err != nil {
+			return nil, fmt.Errorf("relative path of output versions: %w", err)
+		}
+	}
+	if res.Thumbnail != "" {
+		// thumbnails are written next to the output and its latest copy
+		entry.Thumbnail = withSuffix(rel, thumbnailSuffix)
+	}
+
+	modTime, err := store.ModTime(ctx, output)
+	if err == nil {
+		modTime = modTime.UTC()
+		entry.Generated = &modTime
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("stat output: %w", err)
+	}
+	return entry, nil
+}
+
+// updateOutputIndex merges entries into the index file, replacing existing
+// entries with the same path
+func updateOutputIndex(ctx context.Context, store OutputStore, fname string, entries []*OutputIndexEntry) error {
+	return updateFile(ctx, store, fname, func(data []byte, _ map[string]string) ([]byte, map[string]string, error) {
+		var idx OutputIndex
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &idx); err != nil {
+				return nil, nil, fmt.Errorf("unmarshal index: %w", err)
+			}
+		}
+
+		byPath := make(map[string]int, len(idx.Plots))
+		for i, e := range idx.Plots {
+			byPath[e.Path] = i
+		}
+		for _, e := range entries {
+			i, ok := byPath[e.Path]
+			if !ok {
+				byPath[e.Path] = len(idx.Plots)
+				idx.Plots = append(idx.Plots, e)
+				continue
+			}
+			if e.Status == BatchStatusSkipped {
+				continue
+			}
+			idx.Plots[i] = e
+		}
+		sort.Slice(idx.Plots, func(i, j int) bool {
+			return idx.Plots[i].Path < idx.Plots[j].Path
+		})
+		idx.Updated = time.Now().UTC()
+
+		data, err := json.MarshalIndent(&idx, "", "  ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal index: %w", err)
+		}
+		return append(data, '\n'), nil, nil
+	})
+}
+
+// removeFromOutputIndex removes the entries with the given paths from the
+// index file and returns the number of entries that remain. A missing index
+// is left missing.
+func removeFromOutputIndex(ctx context.Context, store OutputStore, fname string, paths map[string]bool) (int, error) {
+	remaining := 0
+	err := updateFile(ctx, store, fname, func(data []byte, _ map[string]string) ([]byte, map[string]string, error) {
+		remaining = 0
+		if len(data) == 0 {
+			return nil, nil, nil
+		}
+		var idx OutputIndex
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal index: %w", err)
+		}
+
+		kept := idx.Plots[:0]
+		for _, e := range idx.Plots {
+			if !paths[e.Path] {
+				kept = append(kept, e)
+			}
+		}
+		remaining = len(kept)
+		if len(kept) == len(idx.Plots) {
+			return nil, nil, nil
+		}
+		idx.Plots = kept
+		idx.Updated = time.Now().UTC()
+
+		data, err := json.MarshalIndent(&idx, "", "  ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal index: %w", err)
+		}
+		return append(data, '\n'), nil, nil
+	})
+	return remaining, err
+}
+
+// readOutputIndex reads an index file, which is empty if it is missing
+func readOutputIndex(ctx context.Context, store OutputStore, fname string) (*OutputIndex, error) {
+	data, err := store.ReadFile(ctx, fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &OutputIndex{}, nil
+		}
+		return nil, err
+	}
+	var idx OutputIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("unmarshal index: %w", err)
+	}
+	return &idx, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var initCommand = &cli.Command{
+	Name:  "init",
+	Usage: "Commands for starting new work",
+	Subcommands: []*cli.Command{
+		initPlotCommand,
+	},
+}
+
+var initPlotCommand = &cli.Command{
+	Name:      "plot",
+	Usage:     "Write a commented skeleton plot definition to start a new plot from",
+	ArgsUsage: "<name>",
+	Action:    InitPlot,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Directory to write the plot definition to.",
+			Value:       ".",
+			Destination: &initPlotOpts.outDir,
+		},
+		&cli.StringFlag{
+			Name:        "frequency",
+			Required:    false,
+			Usage:       "How often the plot is generated by batch: hourly, daily, weekly, monthly, quarterly or yearly.",
+			Value:       string(PlotFrequencyDaily),
+			Destination: &initPlotOpts.frequency,
+		},
+		&cli.StringFlag{
+			Name:        "query",
+			Required:    false,
+			Usage:       "Query of the plot's dataset. It is run to check the field names used by the plot. Defaults to a query of the built in demo source.",
+			Destination: &initPlotOpts.query,
+		},
+		&cli.StringFlag{
+			Name:        "from",
+			Required:    false,
+			Usage:       "Name of the data source the query given with --query is run against.",
+			Destination: &initPlotOpts.from,
+		},
+		&cli.StringFlag{
+			Name:        "labels",
+			Required:    false,
+			Usage:       "Field of the query's result to use for the labels of the series, usually a time. Defaults to the first time field, or the first text field.",
+			Destination: &initPlotOpts.labels,
+		},
+		&cli.StringFlag{
+			Name:        "values",
+			Required:    false,
+			Usage:       "Field of the query's result to use for the values of the series. Defaults to the first numeric field.",
+			Destination: &initPlotOpts.values,
+		},
+		&cli.StringSliceFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    false,
+			Usage:       "Specify the url of a data source, in the format name=url. May be repeated to specify multiple sources.",
+			Destination: &initPlotOpts.sources,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &initPlotOpts.confDir,
+		},
+		&cli.BoolFlag{
+			Name:        "force",
+			Required:    false,
+			Usage:       "Overwrite an existing plot definition.",
+			Destination: &initPlotOpts.force,
+		},
+	}, loggingFlags...),
+}
+
+var initPlotOpts struct {
+	outDir    string
+	frequency string
+	query     string
+	from      string
+	labels    string
+	values    string
+	sources   cli.StringSlice
+	confDir   string
+	force     bool
+}
+
+// plotSkeleton is the template of the plot definitions written by init plot
+var plotSkeleton = template.Must(template.New("").Parse(`apiVersion: {{ .APIVersion }}
+
+# The name of the plot, used for its output filename. Defaults to the name of
+# this file.
+name: {{ printf "%q" .Name }}
+
+# How often batch generates the plot. Template variables such as .StartOfDay
+# are computed for the time batch runs at.
+frequency: {{ .Frequency }}
+
+# Datasets are read from a data source by a query, which may use templates,
+# such as {{ "{{ .StartOfDay | timestamptz }}" }}. Use the query command to
+# look at the fields a query returns.
+datasets:
+  - name: main
+    source: {{ .Source }}
+    query: |-
+{{ .Query }}
+
+# Series draw the fields of a dataset: labels along the x axis and values up
+# the y axis. Other types are bar, hbar, scatter, box and choropleth.
+series:
+  - type: line
+    name: {{ printf "%q" .Values }}
+    dataset: main
+    labels: {{ printf "%q" .Labels }}
+    values: {{ printf "%q" .Values }}
+
+# Scalars show a single value from a dataset as a number or gauge, instead of
+# or as well as series. Uncomment to show the value of the first row.
+#
+# scalars:
+#   - type: number
+#     name: {{ printf "%q" .Values }}
+#     dataset: main
+#     value: {{ printf "%q" .Values }}
+
+# The layout is passed to plotly, see https://plotly.com/javascript/reference/layout/
+layout:
+  title:
+    text: {{ printf "%q" .Title }}
+  xaxis:
+    title:
+      text: {{ printf "%q" .Labels }}
+  yaxis:
+    title:
+      text: {{ printf "%q" .Values }}
+`))
+
+func InitPlot(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if cc.NArg() != 1 {
+		return fmt.Errorf("the name of the plot must be supplied as an argument")
+	}
+	name := cc.Args().Get(0)
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("plot name %q cannot be used as a filename", name)
+	}
+	if err := PlotFrequency(initPlotOpts.frequency).Validate(); err != nil {
+		return err
+	}
+
+	query, from := initPlotOpts.query, initPlotOpts.from
+	switch {
+	case query == "" && from == "":
+		query, from = "traffic", "demo"
+	case query == "":
+		return fmt.Errorf("a query must be given with --query to use the source %q", from)
+	case from == "":
+		return fmt.Errorf("the source to run the query against must be given with --from")
+	}
+
+	fname := filepath.Join(initPlotOpts.outDir, name+".yaml")
+	if _, err := os.Stat(fname); err == nil && !initPlotOpts.force {
+		return fmt.Errorf("plot definition %s already exists, use --force to overwrite it", fname)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to check plot definition: %w", err)
+	}
+
+	cfg, err := newPlotConfig(ctx, initPlotOpts.confDir, initPlotOpts.sources.Value(), false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+
+	// the query is run to find the fields the series can use
+	src, exists := cfg.Sources[from]
+	if !exists {
+		return fmt.Errorf("unknown dataset source: %q", from)
+	}
+	templated, err := executeTemplateWithData(ctx, query, cfg, nil, sourceTemplateFuncs(cfg, from))
+	if err != nil {
+		return fmt.Errorf("execute query templates: %w", err)
+	}
+	ds, err := src.GetDataSet(ctx, templated)
+	if err != nil {
+		return fmt.Errorf("failed to get dataset from source %q: %w", from, err)
+	}
+	sds, ok := ds.(*StaticDataSet)
+	if !ok {
+		return fmt.Errorf("source %q did not return a static dataset", from)
+	}
+	labels, values, err := skeletonFields(sds, initPlotOpts.labels, initPlotOpts.values)
+	if err != nil {
+		return err
+	}
+
+	var indented strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(query, "\n"), "\n") {
+		indented.WriteString("      " + line + "\n")
+	}
+	buf := new(bytes.Buffer)
+	err = plotSkeleton.Execute(buf, map[string]any{
+		"APIVersion": plotDefAPIVersion,
+		"Name":       name,
+		"Frequency":  initPlotOpts.frequency,
+		"Source":     from,
+		"Query":      strings.TrimRight(indented.String(), "\n"),
+		"Labels":     labels,
+		"Values":     values,
+		"Title":      strings.ReplaceAll(name, "-", " "),
+	})
+	if err != nil {
+		return fmt.Errorf("execute skeleton template: %w", err)
+	}
+
+	// the skeleton's own templates are not executed, so check that it parses
+	// as a plot definition after they would be
+	skeleton, err := ExecuteTemplate(ctx, buf.String(), cfg)
+	if err == nil {
+		_, err = parsePlotDef(fname, buf.Bytes(), []byte(skeleton))
+	}
+	if err != nil {
+		return fmt.Errorf("generated plot definition is invalid: %w", err)
+	}
+
+	if err := writeOutput(fname, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write plot definition: %w", err)
+	}
+	fmt.Printf("wrote %s, using fields %q for labels and %q for values\n", fname, labels, values)
+	fmt.Printf("preview it with: ashby plot --preview %s\n", fname)
+	return nil
+}
+
+// skeletonFields chooses the fields of a dataset to use for the labels and
+// values of a series, checking that the fields asked for exist
+func skeletonFields(sds *StaticDataSet, labels, values string) (string, string, error) {
+	fields := sortedFields(sds)
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("the query returned no fields")
+	}
+	for _, f := range []string{labels, values} {
+		if _, ok := sds.Data[f]; f != "" && !ok {
+			return "", "", fmt.Errorf("the query has no field %q, its fields are: %s", f, strings.Join(fields, ", "))
+		}
+	}
+
+	// the type of a field is taken from its first value that is set
+	isKind := func(field string, match func(v any) bool) bool {
+		for _, v := range sds.Data[field] {
+			if v != nil {
+				return match(v)
+			}
+		}
+		return false
+	}
+	isTime := func(v any) bool { _, ok := v.(time.Time); return ok }
+	isText := func(v any) bool { _, ok := v.(string); return ok }
+	isNumber := func(v any) bool { _, ok := toFloat64(v); return ok }
+
+	if labels == "" {
+		for _, match := range []func(any) bool{isTime, isText} {
+			for _, f := range fields {
+				if isKind(f, match) {
+					labels = f
+					break
+				}
+			}
+			if labels != "" {
+				break
+			}
+		}
+		if labels == "" {
+			labels = fields[0]
+		}
+	}
+	if values == "" {
+		for _, f := range fields {
+			if f != labels && isKind(f, isNumber) {
+				values = f
+				break
+			}
+		}
+		if values == "" {
+			return "", "", fmt.Errorf("the query has no numeric field to use for values other than %q, its fields are: %s", labels, strings.Join(fields, ", "))
+		}
+	}
+	return labels, values, nil
+}
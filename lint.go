@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var lintCommand = &cli.Command{
+	Name:      "lint",
+	Usage:     "Check plot definitions for common layout problems without running queries",
+	ArgsUsage: "<plot definition>...",
+	Action:    Lint,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "params",
+			Aliases:     []string{"p"},
+			Required:    false,
+			Usage:       "Specify templating parameters, in the format key=value. May be repeated to specify multiple parameters.",
+			Destination: &lintOpts.params,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &lintOpts.confDir,
+		},
+		&cli.IntFlag{
+			Name:        "max-traces",
+			Required:    false,
+			Usage:       "Maximum number of traces a plot should have.",
+			Value:       12,
+			Destination: &lintOpts.maxTraces,
+		},
+		&cli.IntFlag{
+			Name:        "max-ticks",
+			Required:    false,
+			Usage:       "Maximum number of ticks an axis should have.",
+			Value:       20,
+			Destination: &lintOpts.maxTicks,
+		},
+		&cli.Float64Flag{
+			Name:        "min-contrast",
+			Required:    false,
+			Usage:       "Minimum contrast ratio between series colors and the plot background.",
+			Value:       2,
+			Destination: &lintOpts.minContrast,
+		},
+		&cli.BoolFlag{
+			Name:        "strict",
+			Required:    false,
+			Usage:       "Also check definitions against the strict schema, reporting unknown keys, references to unknown series, invalid colors and templates that use values that are not set. Definitions that cannot be templated or parsed are reported as issues instead of stopping the lint.",
+			Destination: &lintOpts.strict,
+		},
+	}, loggingFlags...),
+}
+
+var lintOpts struct {
+	params      cli.StringSlice
+	confDir     string
+	maxTraces   int
+	maxTicks    int
+	minContrast float64
+	strict      bool
+}
+
+func Lint(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	cfg, err := newPlotConfig(ctx, lintOpts.confDir, nil, false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+	cfg.TemplateParams, err = parseTemplateParams(lintOpts.params.Value())
+	if err != nil {
+		return err
+	}
+
+	if cc.NArg() == 0 {
+		return fmt.Errorf("at least one plot definition must be supplied as an argument")
+	}
+
+	rules := LintRules{
+		MaxTraces:   lintOpts.maxTraces,
+		MaxTicks:    lintOpts.maxTicks,
+		MinContrast: lintOpts.minContrast,
+		Strict:      lintOpts.strict,
+	}
+
+	count := 0
+	if rules.Strict && lintOpts.confDir != "" {
+		for _, issue := range checkColorConf(cfg) {
+			fmt.Printf("%s: %s: %s\n", path.Join(lintOpts.confDir, "colors.yaml"), issue.Rule, issue.Message)
+			count++
+		}
+	}
+	for _, fname := range cc.Args().Slice() {
+		fcontent, err := os.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("failed to read plot definition: %w", err)
+		}
+
+		issues, err := rules.CheckSource(ctx, fname, fcontent, cfg)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			fmt.Printf("%s: %s: %s\n", fname, issue.Rule, issue.Message)
+			count++
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("found %d lint issues", count)
+	}
+	return nil
+}
+
+// LintRules holds the thresholds used by the layout lint checks
+type LintRules struct {
+	MaxTraces   int     // the maximum number of traces a plot should have
+	MaxTicks    int     // the maximum number of ticks an axis should have
+	MinContrast float64 // the minimum contrast ratio between series colors and the plot background
+	Strict      bool    // also check the definition against the strict schema
+}
+
+// A LintIssue is a problem found in a plot definition
+type LintIssue struct {
+	Rule    string
+	Message string
+}
+
+// CheckSource templates and parses the source of a plot definition and runs
+// the lint checks against it. Definitions that cannot be templated or parsed
+// fail unless the rules are strict, when the failure is reported as an issue.
+func (r *LintRules) CheckSource(ctx context.Context, fname string, content []byte, cfg *PlotConfig) ([]LintIssue, error) {
+	templated, err := ExecuteTemplate(ctx, string(content), cfg)
+	if err != nil {
+		if r.Strict {
+			return []LintIssue{{Rule: "invalid-template", Message: err.Error()}}, nil
+		}
+		return nil, fmt.Errorf("failed to execute templates for plot definition %q: %w", fname, err)
+	}
+
+	var issues []LintIssue
+	if r.Strict {
+		issues = append(issues, checkSchema([]byte(templated))...)
+	}
+
+	pd, err := parsePlotDef(fname, content, []byte(templated))
+	if err != nil {
+		if r.Strict {
+			return append(issues, LintIssue{Rule: "invalid-definition", Message: err.Error()}), nil
+		}
+		return nil, fmt.Errorf("failed to parse plot definition %q: %w", fname, err)
+	}
+	return append(issues, r.Check(pd, cfg)...), nil
+}
+
+// Check runs the lint checks against a plot definition
+func (r *LintRules) Check(pd *PlotDef, cfg *PlotConfig) []LintIssue {
+	var issues []LintIssue
+	add := func(rule string, format string, args ...any) {
+		issues = append(issues, LintIssue{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if pd.Layout.Title == nil || isEmpty(pd.Layout.Title.Text) {
+		add("missing-title", "plot has no title")
+	}
+
+	// work out the axis titles the figure would have, including inferred ones,
+	// using a copy of the layout so the definition is not modified
+	var series []*LabeledSeries
+	hasAxes := false
+	for i := range pd.Series {
+		series = append(series, &LabeledSeries{SeriesDef: &pd.Series[i], Name: pd.Series[i].Name})
+		if pd.Series[i].Type != SeriesTypeChoropleth {
+			hasAxes = true
+		}
+	}
+	layout := pd.Layout
+	if layout.Xaxis != nil {
+		xaxis := *layout.Xaxis
+		layout.Xaxis = &xaxis
+	}
+	if layout.Yaxis != nil {
+		yaxis := *layout.Yaxis
+		layout.Yaxis = &yaxis
+	}
+	inferAxisTitles(&layout, series)
+	setUnitAxisFormat(&layout, series)
+	if hasAxes {
+		if layout.Xaxis == nil || layout.Xaxis.Title == nil || isEmpty(layout.Xaxis.Title.Text) {
+			add("missing-axis-title", "x axis has no title")
+		}
+		if layout.Yaxis == nil || layout.Yaxis.Title == nil || isEmpty(layout.Yaxis.Title.Text) {
+			add("missing-axis-title", "y axis has no title")
+		}
+	}
+
+	// series expanded from a group field are counted once since the number of
+	// groups depends on the data
+	traces := len(pd.Series) + len(pd.Scalars) + len(pd.Tables)
+	if traces > r.MaxTraces {
+		add("too-many-traces", "plot has %d traces, more than %d are hard to read", traces, r.MaxTraces)
+	}
+
+	if pd.Layout.Xaxis != nil {
+		if n := tickCount(pd.Layout.Xaxis.Nticks, pd.Layout.Xaxis.Tickvals); n > r.MaxTicks {
+			add("tick-density", "x axis has %d ticks, more than %d are unreadable", n, r.MaxTicks)
+		}
+	}
+	if pd.Layout.Yaxis != nil {
+		if n := tickCount(pd.Layout.Yaxis.Nticks, pd.Layout.Yaxis.Tickvals); n > r.MaxTicks {
+			add("tick-density", "y axis has %d ticks, more than %d are unreadable", n, r.MaxTicks)
+		}
+	}
+
+	background := "#ffffff"
+	if bg, ok := pd.Layout.PlotBgcolor.(string); ok && bg != "" {
+		background = bg
+	}
+	bgLum, ok := luminance(background)
+	if ok {
+		for _, s := range pd.Series {
+			c := cfg.MaybeLookupColor(s.Color, s.Name)
+			lum, ok := luminance(c)
+			if !ok {
+				continue
+			}
+			if ratio := contrastRatio(lum, bgLum); ratio < r.MinContrast {
+				add("color-contrast", "series %q color %s has a contrast ratio of %.2f against the background %s", s.Name, c, ratio, background)
+			}
+		}
+	}
+
+	if r.Strict {
+		issues = append(issues, checkSeriesRefs(pd)...)
+		issues = append(issues, checkColors(pd, cfg)...)
+	}
+
+	return issues
+}
+
+// checkColorConf checks that the colors configured in colors.yaml are valid
+func checkColorConf(cfg *PlotConfig) []LintIssue {
+	var issues []LintIssue
+	if cfg.DefaultColor != "" && !validColor(cfg.DefaultColor) {
+		issues = append(issues, LintIssue{Rule: "invalid-color", Message: fmt.Sprintf("default color %q is not a valid color", cfg.DefaultColor)})
+	}
+	names := make([]string, 0, len(cfg.Colors))
+	for name := range cfg.Colors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !validColor(cfg.Colors[name]) {
+			issues = append(issues, LintIssue{Rule: "invalid-color", Message: fmt.Sprintf("color %q has value %q which is not a valid color", name, cfg.Colors[name])})
+		}
+	}
+	for i, c := range cfg.Palette {
+		if !validColor(c) {
+			issues = append(issues, LintIssue{Rule: "invalid-color", Message: fmt.Sprintf("palette color %d %q is not a valid color", i, c)})
+		}
+	}
+	return issues
+}
+
+// checkSchema reports the keys of a templated plot definition that are not
+// part of the definition format, and the places where a template wrote a
+// value that was not set, such as a missing parameter
+func checkSchema(templated []byte) []LintIssue {
+	var issues []LintIssue
+	for i, line := range strings.Split(string(templated), "\n") {
+		if strings.Contains(line, "<no value>") {
+			issues = append(issues, LintIssue{Rule: "missing-value", Message: fmt.Sprintf("line %d: a template uses a value that is not set", i+1)})
+		}
+	}
+
+	// keys are checked in the migrated definition so that keys renamed by a
+	// migration are known. Migrating only edits the changed keys and values,
+	// apart from adding an apiVersion line before the first key, so the lines
+	// of the keys are shifted by the lines added.
+	migrated, _, err := migratePlotDefSource(templated)
+	if err != nil {
+		// the definition is reported as invalid when it is parsed
+		return issues
+	}
+	added := bytes.Count(migrated, []byte("\n")) - bytes.Count(templated, []byte("\n"))
+	dec := yaml.NewDecoder(bytes.NewReader(migrated))
+	dec.KnownFields(true)
+	var pd PlotDef
+	var te *yaml.TypeError
+	if err := dec.Decode(&pd); errors.As(err, &te) {
+		for _, msg := range te.Errors {
+			if !strings.Contains(msg, " not found in type ") {
+				continue
+			}
+			var line int
+			if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+				_, rest, _ := strings.Cut(msg, ":")
+				msg = fmt.Sprintf("line %d:%s", line-added, rest)
+			}
+			issues = append(issues, LintIssue{Rule: "unknown-key", Message: msg})
+		}
+	}
+	return issues
+}
+
+// checkSeriesRefs reports toggle options and drilldowns that refer to series
+// the plot does not define. Series grouped by a field are named after the
+// values in their dataset, so references cannot be checked in plots that have
+// them.
+func checkSeriesRefs(pd *PlotDef) []LintIssue {
+	names := make([]string, 0, len(pd.Series))
+	for _, s := range pd.Series {
+		if s.GroupField != "" {
+			return nil
+		}
+		names = append(names, s.Name)
+	}
+	matches := func(pattern string) bool {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok || pattern == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var issues []LintIssue
+	for i, t := range pd.Toggles {
+		for _, opt := range t.Options {
+			for _, pattern := range opt.Series {
+				if !matches(pattern) {
+					issues = append(issues, LintIssue{Rule: "unknown-series", Message: fmt.Sprintf("toggle %d option %q refers to unknown series %q", i, opt.Label, pattern)})
+				}
+			}
+		}
+	}
+	for i, d := range pd.Drilldown {
+		if d.Series != "" && !matches(d.Series) {
+			issues = append(issues, LintIssue{Rule: "unknown-series", Message: fmt.Sprintf("drilldown %d refers to unknown series %q", i, d.Series)})
+		}
+	}
+	return issues
+}
+
+// checkColors reports colors of a plot definition that are neither a named
+// color from colors.yaml nor a valid css color
+func checkColors(pd *PlotDef, cfg *PlotConfig) []LintIssue {
+	var issues []LintIssue
+	check := func(what string, color string) {
+		if color == "" {
+			return
+		}
+		if c := cfg.MaybeLookupColor(color, ""); !validColor(c) {
+			issues = append(issues, LintIssue{Rule: "invalid-color", Message: fmt.Sprintf("%s color %q is not a named or valid color", what, color)})
+		}
+	}
+	for _, s := range pd.Series {
+		check(fmt.Sprintf("series %q", s.Name), s.Color)
+	}
+	for _, s := range pd.Scalars {
+		what := fmt.Sprintf("scalar %q", s.Name)
+		check(what, s.Color)
+		check(what+" increase", s.IncreaseColor)
+		check(what+" decrease", s.DecreaseColor)
+	}
+	for i, e := range pd.Events {
+		check(fmt.Sprintf("events %d", i), e.Color)
+	}
+	if bg, ok := pd.Layout.PlotBgcolor.(string); ok {
+		check("plot background", bg)
+	}
+	if bg, ok := pd.Layout.PaperBgcolor.(string); ok {
+		check("paper background", bg)
+	}
+	return issues
+}
+
+// validColor reports whether a color is in a notation plotly accepts: hex,
+// rgb(), rgba(), hsl(), hsla() or a css color name
+func validColor(color string) bool {
+	if _, _, _, ok := parseColor(color); ok {
+		return true
+	}
+	c := strings.TrimSpace(strings.ToLower(color))
+	if (strings.HasPrefix(c, "hsl(") || strings.HasPrefix(c, "hsla(")) && strings.HasSuffix(c, ")") {
+		return true
+	}
+	return cssColorNames[c]
+}
+
+var cssColorNames = func() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Fields(`
+		aliceblue antiquewhite aqua aquamarine azure beige bisque black blanchedalmond blue blueviolet brown
+		burlywood cadetblue chartreuse chocolate coral cornflowerblue cornsilk crimson cyan darkblue darkcyan
+		darkgoldenrod darkgray darkgreen darkgrey darkkhaki darkmagenta darkolivegreen darkorange darkorchid
+		darkred darksalmon darkseagreen darkslateblue darkslategray darkslategrey darkturquoise darkviolet
+		deeppink deepskyblue dimgray dimgrey dodgerblue firebrick floralwhite forestgreen fuchsia gainsboro
+		ghostwhite gold goldenrod gray grey green greenyellow honeydew hotpink indianred indigo ivory khaki
+		lavender lavenderblush lawngreen lemonchiffon lightblue lightcoral lightcyan lightgoldenrodyellow
+		lightgray lightgreen lightgrey lightpink lightsalmon lightseagreen lightskyblue lightslategray
+		lightslategrey lightsteelblue lightyellow lime limegreen linen magenta maroon mediumaquamarine
+		mediumblue mediumorchid mediumpurple mediumseagreen mediumslateblue mediumspringgreen
+		mediumturquoise mediumvioletred midnightblue mintcream mistyrose moccasin navajowhite navy oldlace
+		olive olivedrab orange orangered orchid palegoldenrod palegreen paleturquoise palevioletred
+		papayawhip peachpuff peru pink plum powderblue purple rebeccapurple red rosybrown royalblue
+		saddlebrown salmon sandybrown seagreen seashell sienna silver skyblue slateblue slategray slategrey
+		snow springgreen steelblue tan teal thistle tomato transparent turquoise violet wheat white
+		whitesmoke yellow yellowgreen`) {
+		names[name] = true
+	}
+	return names
+}()
+
+// tickCount returns the number of ticks requested by an axis, from either
+// its nticks or tickvals attributes
+func tickCount(nticks int64, tickvals any) int {
+	if vals, ok := tickvals.([]any); ok && len(vals) > int(nticks) {
+		return len(vals)
+	}
+	return int(nticks)
+}
+
+// luminance returns the relative luminance of a color given in hex or rgb()
+// notation, as defined by WCAG. It reports false if the color could not be
+// parsed.
+func luminance(color string) (float64, bool) {
+	r, g, b, ok := parseColor(color)
+	if !ok {
+		return 0, false
+	}
+	channel := func(v uint8) float64 {
+		c := float64(v) / 255
+		if c <= 0.03928 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(r) + 0.7152*channel(g) + 0.0722*channel(b), true
+}
+
+// contrastRatio returns the WCAG contrast ratio of two relative luminances
+func contrastRatio(l1, l2 float64) float64 {
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// parseColor parses a color in #rgb, #rrggbb, rgb(r, g, b) or rgba(r, g, b, a)
+// notation
+func parseColor(color string) (r, g, b uint8, ok bool) {
+	color = strings.TrimSpace(strings.ToLower(color))
+
+	if strings.HasPrefix(color, "#") {
+		hex := color[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return 0, 0, 0, false
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		return uint8(v >> 16), uint8(v >> 8), uint8(v), true
+	}
+
+	for _, prefix := range []string{"rgba(", "rgb("} {
+		if !strings.HasPrefix(color, prefix) || !strings.HasSuffix(color, ")") {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(color, prefix), ")"), ",")
+		if len(parts) < 3 {
+			return 0, 0, 0, false
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.ParseUint(strings.TrimSpace(parts[i]), 10, 8)
+			if err != nil {
+				return 0, 0, 0, false
+			}
+			rgb[i] = uint8(v)
+		}
+		return rgb[0], rgb[1], rgb[2], true
+	}
+
+	return 0, 0, 0, false
+}
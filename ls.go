@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var lsCommand = &cli.Command{
+	Name:   "ls",
+	Usage:  "List the plot definitions of the processing profiles with their latest outputs",
+	Action: Ls,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    true,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &lsOpts.confDir,
+			EnvVars:     []string{envPrefix + "CONF"},
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Required:    false,
+			Usage:       "Path of the output tree written by batch, or an s3 or gcs url of the form s3://bucket/prefix or gs://bucket/prefix, to find the latest version of each plot and whether it is stale.",
+			Destination: &lsOpts.outDir,
+			EnvVars:     []string{envPrefix + "OUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "s3-path-style",
+			Required:    false,
+			Usage:       "Address S3 buckets in the path of request urls rather than the hostname, as needed by some S3 compatible stores.",
+			Destination: &lsOpts.s3PathStyle,
+			EnvVars:     []string{envPrefix + "S3_PATH_STYLE"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    false,
+			Usage:       "Specify the url of a data source, in the format name=url. May be repeated to specify multiple sources.",
+			Destination: &lsOpts.sources,
+		},
+		&cli.StringFlag{
+			Name:        "match",
+			Required:    false,
+			Usage:       "Only list plot definitions that match this glob (use standard go glob syntax).",
+			Destination: &lsOpts.matchGlob,
+		},
+		&cli.StringFlag{
+			Name:        "week-start",
+			Required:    false,
+			Usage:       "Day of the week that weekly periods and the week template variables start on, such as monday or sunday.",
+			Value:       string(WeekStartMonday),
+			Destination: &lsOpts.weekStart,
+			EnvVars:     []string{envPrefix + "WEEK_START"},
+		},
+		&cli.StringFlag{
+			Name:        "tz",
+			Required:    false,
+			Usage:       "IANA timezone, such as Europe/Berlin, that periods and the template variables are computed in, unless the plot sets its own timezone.",
+			Value:       "UTC",
+			Destination: &lsOpts.tz,
+			EnvVars:     []string{envPrefix + "TZ"},
+		},
+		&cli.BoolFlag{
+			Name:        "json",
+			Required:    false,
+			Usage:       "Print the listing as json.",
+			Destination: &lsOpts.json,
+		},
+	}, loggingFlags...),
+}
+
+var lsOpts struct {
+	confDir     string
+	outDir      string
+	s3PathStyle bool
+	sources     cli.StringSlice
+	matchGlob   string
+	weekStart   string
+	tz          string
+	json        bool
+}
+
+// A PlotListing describes a plot of a processing profile, as templated for
+// the current time
+type PlotListing struct {
+	Definition string         `json:"definition"`       // the path of the plot definition
+	Output     string         `json:"output,omitempty"` // the output filename of the plot
+	Name       string         `json:"name,omitempty"`
+	Frequency  PlotFrequency  `json:"frequency,omitempty"`
+	Params     map[string]any `json:"params,omitempty"` // the parameters of the profile variant
+	Sources    []string       `json:"sources,omitempty"`
+	Latest     string         `json:"latest,omitempty"`    // the path of the latest dated output, relative to the base of the output tree
+	Generated  *time.Time     `json:"generated,omitempty"` // when the latest dated output was written
+	Stale      *bool          `json:"stale,omitempty"`     // whether the output for the current period is missing or older than the definition, unknown without an output tree
+	Error      string         `json:"error,omitempty"`     // why the definition could not be templated or parsed
+}
+
+func Ls(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	tz, err := loadTimezone(lsOpts.tz)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := newPlotConfig(ctx, lsOpts.confDir, lsOpts.sources.Value(), false, tz, lsOpts.weekStart)
+	if err != nil {
+		return err
+	}
+	profiles, err := readProfiles(os.DirFS(lsOpts.confDir), lsOpts.confDir)
+	if err != nil {
+		return err
+	}
+
+	var store OutputStore
+	var outBase string
+	if lsOpts.outDir != "" {
+		store, outBase, err = newOutputStore(ctx, lsOpts.outDir, lsOpts.s3PathStyle)
+		if err != nil {
+			return err
+		}
+	}
+
+	var listings []*PlotListing
+	for _, p := range profiles {
+		infs, fnames, err := p.plotDefFiles(lsOpts.matchGlob)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", p.Source, err)
+		}
+		for _, variant := range p.Variants {
+			org := &Organizer{
+				Base:         outBase,
+				Template:     p.OutTpl,
+				Params:       variant,
+				Store:        store,
+				PathTemplate: p.OutPath,
+				WeekStart:    cfg.WeekStart,
+			}
+			variantCfg := *cfg
+			variantCfg.TemplateParams = variant
+			for _, fname := range fnames {
+				l, err := listPlot(ctx, infs, fname, &variantCfg, org)
+				if err != nil {
+					return err
+				}
+				l.Definition = path.Join(p.Source, fname)
+				if !p.SourceIsDir() {
+					l.Definition = p.Source
+				}
+				if len(variant) > 0 {
+					l.Params = variant
+				}
+				listings = append(listings, l)
+			}
+		}
+	}
+
+	if lsOpts.json {
+		data, err := json.MarshalIndent(listings, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal listing: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return printPlotListings(os.Stdout, listings)
+}
+
+// listPlot describes a plot definition as templated with the config. Problems
+// with the definition are reported in the listing; the error is for failures
+// to read the output tree.
+func listPlot(ctx context.Context, infs fs.FS, fname string, cfg *PlotConfig, org *Organizer) (*PlotListing, error) {
+	l := &PlotListing{}
+	content, err := fs.ReadFile(infs, fname)
+	if err != nil {
+		l.Error = err.Error()
+		return l, nil
+	}
+	pd, cfg, err := loadPlotDef(ctx, fname, content, infs, path.Dir(fname), cfg)
+	if err != nil {
+		l.Error = err.Error()
+		return l, nil
+	}
+
+	l.Name = pd.Name
+	l.Frequency = pd.Frequency
+	if l.Output, err = org.Filename(pd.Name); err != nil {
+		l.Error = fmt.Sprintf("format output filename: %v", err)
+		return l, nil
+	}
+	seen := map[string]bool{}
+	for _, ds := range pd.Datasets {
+		if !seen[ds.Source] {
+			seen[ds.Source] = true
+			l.Sources = append(l.Sources, ds.Source)
+		}
+	}
+	sort.Strings(l.Sources)
+
+	if org.Store == nil {
+		return l, nil
+	}
+	existing, err := org.Glob(ctx, pd, cfg.BasisTime)
+	if err != nil {
+		return nil, fmt.Errorf("list outputs of %s: %w", fname, err)
+	}
+	if len(existing) > 0 {
+		sort.Strings(existing)
+		latest := existing[len(existing)-1]
+		if l.Latest, err = relLocation(org.Base, latest); err != nil {
+			return nil, err
+		}
+		modTime, err := org.Store.ModTime(ctx, latest)
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", latest, err)
+		}
+		modTime = modTime.UTC()
+		l.Generated = &modTime
+	}
+
+	info, err := stat(infs, fname)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", fname, err)
+	}
+	stale, err := org.IsStaleOrMissing(ctx, pd, cfg.BasisTime, info.ModTime(), plotChecksum(string(content), pd, cfg))
+	if err != nil {
+		return nil, fmt.Errorf("check output of %s: %w", fname, err)
+	}
+	l.Stale = &stale
+	return l, nil
+}
+
+func printPlotListings(w io.Writer, listings []*PlotListing) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEFINITION\tOUTPUT\tFREQUENCY\tSOURCES\tLATEST\tGENERATED\tSTALE")
+	for _, l := range listings {
+		if l.Error != "" {
+			fmt.Fprintf(tw, "%s\terror: %s\t\t\t\t\t\n", l.Definition, l.Error)
+			continue
+		}
+		generated := "-"
+		if l.Generated != nil {
+			generated = l.Generated.Format(time.RFC3339)
+		}
+		stale := "-"
+		if l.Stale != nil {
+			stale = "no"
+			if *l.Stale {
+				stale = "yes"
+			}
+		}
+		latest := l.Latest
+		if latest == "" {
+			latest = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", l.Definition, l.Output, l.Frequency, strings.Join(l.Sources, ","), latest, generated, stale)
+	}
+	return tw.Flush()
+}
@@ -20,6 +20,26 @@ func main() {
 		Commands: []*cli.Command{
 			plotCommand,
 			batchCommand,
+			backfillCommand,
+			runsCommand,
+			lintCommand,
+			graphCommand,
+			impactCommand,
+			canaryCommand,
+			reportCommand,
+			notebookCommand,
+			examplesCommand,
+			selftestCommand,
+			migrateCommand,
+			pruneCommand,
+			serveCommand,
+			lsCommand,
+			queryCommand,
+			explainCommand,
+			doctorCommand,
+			initCommand,
+			renderCommand,
+			diffCommand,
 		},
 	}
 
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// localeParam is the name of the template parameter holding the locale
+	// used to resolve message keys
+	localeParam = "locale"
+
+	// defaultLocale is used to resolve message keys when no locale parameter
+	// is set
+	defaultLocale = "en"
+
+	// messagesDir is the directory in the conf dir containing message
+	// catalogs, one yaml file per locale such as de.yaml
+	messagesDir = "messages"
+)
+
+// loadMessages reads the message catalogs in the messages directory of the
+// conf dir. It returns nil if there is no messages directory.
+func loadMessages(conffs fs.FS) (map[string]map[string]string, error) {
+	fnames, err := fs.Glob(conffs, path.Join(messagesDir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message catalogs: %w", err)
+	}
+	if len(fnames) == 0 {
+		return nil, nil
+	}
+
+	messages := make(map[string]map[string]string, len(fnames))
+	for _, fname := range fnames {
+		content, err := fs.ReadFile(conffs, fname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message catalog: %w", err)
+		}
+		var catalog map[string]string
+		if err := yaml.Unmarshal(content, &catalog); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message catalog %s: %w", fname, err)
+		}
+		messages[strings.TrimSuffix(path.Base(fname), ".yaml")] = catalog
+	}
+	return messages, nil
+}
+
+// Locale returns the locale set by the template parameters, or the default
+// locale if none is set
+func (c *PlotConfig) Locale() string {
+	if locale, ok := c.TemplateParams[localeParam].(string); ok && locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// Message resolves a message key using the catalog of the configured locale.
+func (c *PlotConfig) Message(key string) (string, error) {
+	locale := c.Locale()
+	catalog, ok := c.Messages[locale]
+	if !ok {
+		return "", fmt.Errorf("no message catalog for locale %q", locale)
+	}
+	text, ok := catalog[key]
+	if !ok {
+		return "", fmt.Errorf("unknown message key %q for locale %q", key, locale)
+	}
+	return text, nil
+}
+
+// expandLocales replaces the profile's variants with one variant per
+// combination of variant and locale, with the locale parameter set.
+func (p *ProcessingProfile) expandLocales() error {
+	if len(p.Locales) == 0 {
+		return nil
+	}
+	if !strings.Contains(p.OutTpl, "Params."+localeParam) {
+		return fmt.Errorf("output template of profile with locales must include {{ .Params.%s }}", localeParam)
+	}
+
+	variants := make([]map[string]any, 0, len(p.Variants)*len(p.Locales))
+	for _, variant := range p.Variants {
+		for _, locale := range p.Locales {
+			v := make(map[string]any, len(variant)+1)
+			for k, val := range variant {
+				v[k] = val
+			}
+			v[localeParam] = locale
+			variants = append(variants, v)
+		}
+	}
+	p.Variants = variants
+	return nil
+}
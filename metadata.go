@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// figureSchemaVersion is the version of the json written for a figure. It is
+// increased when the layout of the figure data changes in a way that
+// consumers of the outputs need to handle.
+const figureSchemaVersion = 1
+
+// FigureMetadata records how a figure was generated
+type FigureMetadata struct {
+	Name         string            `json:"name"`
+	BasisTime    time.Time         `json:"basisTime"`
+	Frequency    PlotFrequency     `json:"frequency,omitempty"`
+	Timezone     string            `json:"timezone,omitempty"`     // the timezone the plot's periods were computed in, if not UTC
+	GeneratedAt  *time.Time        `json:"generatedAt,omitempty"`  // when the figure was generated, left out of canonical outputs so they only depend on their data
+	QueryHashes  map[string]string `json:"queryHashes,omitempty"`  // sha256 of the query of each dataset, keyed by dataset name
+	AshbyVersion string            `json:"ashbyVersion,omitempty"` // the version of ashby that generated the figure
+}
+
+// newFigureMetadata describes the generation of a plot. The generation time
+// is left out if generatedAt is zero.
+func newFigureMetadata(pd *PlotDef, cfg *PlotConfig, generatedAt time.Time) *FigureMetadata {
+	md := &FigureMetadata{
+		Name:         pd.Name,
+		BasisTime:    cfg.BasisTime.UTC(),
+		Frequency:    pd.Frequency,
+		AshbyVersion: ashbyVersion(),
+	}
+	if loc := cfg.BasisTime.Location(); loc != time.UTC {
+		md.Timezone = loc.String()
+	}
+	if !generatedAt.IsZero() {
+		t := generatedAt.UTC()
+		md.GeneratedAt = &t
+	}
+	if len(pd.Datasets) > 0 {
+		md.QueryHashes = make(map[string]string, len(pd.Datasets))
+		for _, ds := range pd.Datasets {
+			sum := sha256.Sum256([]byte(ds.Query))
+			md.QueryHashes[ds.Name] = hex.EncodeToString(sum[:])
+		}
+	}
+	return md
+}
+
+// ashbyVersion returns the module version of the running binary, or the vcs
+// revision it was built from when it was not built from a released module
+func ashbyVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var revision string
+	modified := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// provenanceSuffix replaces the extension of a plot's output to name the
+// file recording its provenance
+const provenanceSuffix = ".meta.json"
+
+// PlotProvenance records how a plot output was generated in more detail than
+// its figure metadata, including the full text of its queries, so that a
+// past output can be debugged or reproduced
+type PlotProvenance struct {
+	Name           string              `json:"name"`
+	Definition     string              `json:"definition"`     // the filename of the plot definition
+	DefinitionHash string              `json:"definitionHash"` // sha256 of the plot definition before templating
+	ConfigHash     string              `json:"configHash"`     // sha256 of the settings of the run that affect the output, see provenanceConfig
+	BasisTime      time.Time           `json:"basisTime"`
+	Frequency      PlotFrequency       `json:"frequency,omitempty"`
+	Timezone       string              `json:"timezone,omitempty"`
+	GeneratedAt    time.Time           `json:"generatedAt"`
+	AshbyVersion   string              `json:"ashbyVersion,omitempty"`
+	Params         map[string]any      `json:"params,omitempty"` // the template parameters of the profile variant
+	Durations      ProvenanceDurations `json:"durations"`
+	DataSets       []ProvenanceDataSet `json:"datasets"`
+}
+
+// ProvenanceDurations are the seconds taken by each stage of producing a plot
+type ProvenanceDurations struct {
+	Template float64 `json:"template"`
+	Compute  float64 `json:"compute"`
+	Traces   float64 `json:"traces"`
+	Marshal  float64 `json:"marshal"`
+	Write    float64 `json:"write"`
+}
+
+type ProvenanceDataSet struct {
+	Name     string  `json:"name"`
+	Source   string  `json:"source"`
+	Identity string  `json:"identity,omitempty"` // what the source reads from, such as a postgres url without its password
+	Query    string  `json:"query"`
+	RowCount int     `json:"rowCount"`
+	Duration float64 `json:"duration"` // seconds taken to read the dataset
+}
+
+// provenanceConfig holds the settings of a run, other than the plot
+// definition and basis time, that change the output of a plot
+type provenanceConfig struct {
+	Params       map[string]any    `json:"params"`
+	DefaultColor string            `json:"defaultColor"`
+	Colors       map[string]string `json:"colors"`
+	Palette      []string          `json:"palette"`
+	WeekStart    WeekStart         `json:"weekStart"`
+	NonFinite    NonFinitePolicy   `json:"nonFinite"`
+	Format       OutputFormat      `json:"format"`
+	Canonical    bool              `json:"canonical"`
+}
+
+// newPlotProvenance describes the generation of a plot from the definition
+// read from fname with the content given, using the stats recorded while it
+// was produced
+func newPlotProvenance(pd *PlotDef, cfg *PlotConfig, fname string, content []byte, stats *PlotStats, format OutputFormat, canonical bool) (*PlotProvenance, error) {
+	defSum := sha256.Sum256(content)
+	conf, err := json.Marshal(&provenanceConfig{
+		Params:       cfg.TemplateParams,
+		DefaultColor: cfg.DefaultColor,
+		Colors:       cfg.Colors,
+		Palette:      cfg.Palette,
+		WeekStart:    cfg.WeekStart,
+		NonFinite:    cfg.NonFinite,
+		Format:       format,
+		Canonical:    canonical,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	confSum := sha256.Sum256(conf)
+
+	pv := &PlotProvenance{
+		Name:           pd.Name,
+		Definition:     fname,
+		DefinitionHash: hex.EncodeToString(defSum[:]),
+		ConfigHash:     hex.EncodeToString(confSum[:]),
+		BasisTime:      cfg.BasisTime.UTC(),
+		Frequency:      pd.Frequency,
+		GeneratedAt:    time.Now().UTC(),
+		AshbyVersion:   ashbyVersion(),
+		Params:         cfg.TemplateParams,
+		Durations: ProvenanceDurations{
+			Template: stats.Template.Seconds(),
+			Compute:  stats.Compute.Seconds(),
+			Traces:   stats.Traces.Seconds(),
+			Marshal:  stats.Marshal.Seconds(),
+			Write:    stats.Write.Seconds(),
+		},
+		DataSets: make([]ProvenanceDataSet, 0, len(stats.DataSets)),
+	}
+	if loc := cfg.BasisTime.Location(); loc != time.UTC {
+		pv.Timezone = loc.String()
+	}
+	for _, ds := range stats.DataSets {
+		pv.DataSets = append(pv.DataSets, ProvenanceDataSet{
+			Name:     ds.Name,
+			Source:   ds.Source,
+			Identity: sourceIdentity(cfg.Sources[ds.Source]),
+			Query:    ds.Query,
+			RowCount: ds.RowCount,
+			Duration: ds.Duration.Seconds(),
+		})
+	}
+	return pv, nil
+}
+
+// plotChecksum returns the sha256 of the source of a plot definition, the
+// template parameters, the start of the plot's period and the rendered queries
+// of its datasets, which include those read from query files. The source is
+// hashed before templating so that template variables finer than the period,
+// such as .Now, do not change the checksum unless they change a query.
+// Outputs generated from definitions with the same checksum are the same,
+// given the same data. cfg is the config the plot is generated with, after
+// the plot's own timezone is applied.
+func plotChecksum(source string, pd *PlotDef, cfg *PlotConfig) string {
+	period := cfg.BasisTime
+	if pd.Frequency.Validate() == nil {
+		period = pd.Frequency.Truncate(cfg.BasisTime, cfg.WeekStart)
+	}
+	params, _ := json.Marshal(cfg.TemplateParams) // map keys are sorted
+
+	h := sha256.New()
+	h.Write([]byte(source))
+	fmt.Fprintf(h, "\x00%s\x00%s", params, period.UTC().Format(time.RFC3339))
+	for _, ds := range pd.Datasets {
+		fmt.Fprintf(h, "\x00%s\x00%s\x00%s", ds.Name, ds.Source, ds.Query)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
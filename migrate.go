@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// plotDefAPIVersion is the current version of the plot definition format.
+// Definitions that do not declare an apiVersion are of this version, the
+// first, which predates versioning.
+const plotDefAPIVersion = "ashby/v1"
+
+// A plotDefMigration upgrades plot definitions from one version of the format
+// to the next by changing their yaml node tree. Changes are made through the
+// plotDefMigrator so that they can also be applied to the text of the
+// definition, which is a template and cannot be re-encoded.
+type plotDefMigration struct {
+	From        string
+	To          string
+	Description string
+	Migrate     func(m *plotDefMigrator, root *yaml.Node) error
+}
+
+// plotDefMigrations lists the migrations in the order they are applied. A
+// change to the format that is not backwards compatible adds a migration
+// from the current version and increases plotDefAPIVersion.
+var plotDefMigrations []plotDefMigration
+
+var migrateCommand = &cli.Command{
+	Name:      "migrate",
+	Usage:     "Upgrade plot definitions to the current version of the definition format",
+	ArgsUsage: "<plot definition>...",
+	Action:    Migrate,
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:        "check",
+			Required:    false,
+			Usage:       "Report the plot definitions that need to be migrated without changing them, failing if there are any.",
+			Destination: &migrateOpts.check,
+		},
+	}, loggingFlags...),
+}
+
+var migrateOpts struct {
+	check bool
+}
+
+func Migrate(cc *cli.Context) error {
+	setupLogging()
+
+	if cc.NArg() == 0 {
+		return fmt.Errorf("at least one plot definition must be supplied as an argument")
+	}
+
+	outdated := 0
+	for _, fname := range cc.Args().Slice() {
+		content, err := os.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("failed to read plot definition: %w", err)
+		}
+		migrated, from, err := migratePlotDefSource(content)
+		if err != nil {
+			return fmt.Errorf("failed to migrate plot definition %q: %w", fname, err)
+		}
+		if from == plotDefAPIVersion {
+			fmt.Printf("%s: up to date\n", fname)
+			continue
+		}
+		outdated++
+
+		if migrateOpts.check {
+			fmt.Printf("%s: needs migrating from %s to %s\n", fname, from, plotDefAPIVersion)
+			continue
+		}
+		info, err := os.Stat(fname)
+		if err != nil {
+			return fmt.Errorf("failed to stat plot definition: %w", err)
+		}
+		if err := os.WriteFile(fname, migrated, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write plot definition: %w", err)
+		}
+		fmt.Printf("%s: migrated from %s to %s\n", fname, from, plotDefAPIVersion)
+		pending, _ := pendingMigrations(from)
+		for _, mig := range pending {
+			fmt.Printf("  %s: %s\n", mig.To, mig.Description)
+		}
+	}
+
+	if migrateOpts.check && outdated > 0 {
+		return fmt.Errorf("%d plot definitions need migrating", outdated)
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations that upgrade a plot definition from
+// a version to the current one, or false if the version cannot be upgraded
+func pendingMigrations(from string) ([]plotDefMigration, bool) {
+	var pending []plotDefMigration
+	version := from
+	for _, mig := range plotDefMigrations {
+		if mig.From == version {
+			pending = append(pending, mig)
+			version = mig.To
+		}
+	}
+	return pending, version == plotDefAPIVersion
+}
+
+// migratePlotDef upgrades a plot definition's node tree to the current
+// version and returns the version it was at. Its text is not changed.
+func migratePlotDef(doc *yaml.Node) (string, error) {
+	return (&plotDefMigrator{}).migrate(doc)
+}
+
+// migratePlotDefSource upgrades the text of a plot definition to the current
+// version and returns it with the version it was at. Only the keys and values
+// that change are edited, so comments, formatting and template actions are
+// kept, but the text must be valid yaml before its templates are executed.
+func migratePlotDefSource(content []byte) ([]byte, string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, "", fmt.Errorf("plot definition must be valid yaml before templating to be migrated: %w", err)
+	}
+	m := &plotDefMigrator{src: content}
+	from, err := m.migrate(&doc)
+	if err != nil {
+		return nil, "", err
+	}
+	if from == plotDefAPIVersion {
+		return content, from, nil
+	}
+	migrated, err := m.apply()
+	if err != nil {
+		return nil, "", err
+	}
+	return migrated, from, nil
+}
+
+// plotDefMigrator applies migrations to the node tree of a plot definition.
+// When it has the source text of the definition it also records each change
+// as an edit of the text.
+type plotDefMigrator struct {
+	src   []byte
+	edits []textEdit
+}
+
+// textEdit replaces the bytes of the source between two offsets
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+func (m *plotDefMigrator) migrate(doc *yaml.Node) (string, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return plotDefAPIVersion, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		// not a plot definition, decoding will report it
+		return plotDefAPIVersion, nil
+	}
+	loc := &plotDefLocator{doc: doc, source: doc}
+
+	from := plotDefAPIVersion
+	versionNode := yamlMappingValue(root, "apiVersion")
+	if versionNode != nil {
+		from = versionNode.Value
+	}
+	if from == plotDefAPIVersion {
+		return from, nil
+	}
+
+	pending, ok := pendingMigrations(from)
+	if !ok {
+		return "", loc.errorAt(fmt.Errorf("unsupported apiVersion %q, the current version is %q", from, plotDefAPIVersion), "apiVersion")
+	}
+	for _, mig := range pending {
+		if err := mig.Migrate(m, root); err != nil {
+			return "", fmt.Errorf("migrate from %s to %s: %w", mig.From, mig.To, err)
+		}
+	}
+
+	if versionNode != nil {
+		if err := m.setScalar(versionNode, plotDefAPIVersion); err != nil {
+			return "", err
+		}
+	} else if err := m.addVersion(root); err != nil {
+		return "", err
+	}
+	return from, nil
+}
+
+// setScalar changes the value of a plain or quoted scalar node
+func (m *plotDefMigrator) setScalar(n *yaml.Node, value string) error {
+	if m.src != nil {
+		start, err := m.offset(n.Line, n.Column)
+		if err != nil {
+			return err
+		}
+		switch {
+		case n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0:
+			start++ // skip the opening quote
+		case n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+			return &PlotDefError{Line: n.Line, Column: n.Column, Err: fmt.Errorf("cannot migrate %q written as a block scalar", n.Value)}
+		}
+		end := start + len(n.Value)
+		if end > len(m.src) || string(m.src[start:end]) != n.Value {
+			return &PlotDefError{Line: n.Line, Column: n.Column, Err: fmt.Errorf("cannot migrate %q written with escapes", n.Value)}
+		}
+		m.edits = append(m.edits, textEdit{start: start, end: end, text: value})
+	}
+	n.Value = value
+	return nil
+}
+
+// addVersion adds the apiVersion key to the start of the root mapping
+func (m *plotDefMigrator) addVersion(root *yaml.Node) error {
+	if m.src != nil {
+		if root.Style&yaml.FlowStyle != 0 {
+			// a json style definition, insert after the opening brace
+			start, err := m.offset(root.Line, root.Column)
+			if err != nil {
+				return err
+			}
+			text := fmt.Sprintf("%q: %q", "apiVersion", plotDefAPIVersion)
+			if len(root.Content) > 0 {
+				text += ", "
+			}
+			m.edits = append(m.edits, textEdit{start: start + 1, end: start + 1, text: text})
+		} else {
+			// insert a line before the first key, with the same indentation
+			first := root.Content[0]
+			lineStart, err := m.offset(first.Line, 1)
+			if err != nil {
+				return err
+			}
+			keyStart, err := m.offset(first.Line, first.Column)
+			if err != nil {
+				return err
+			}
+			indent := string(m.src[lineStart:keyStart])
+			m.edits = append(m.edits, textEdit{start: lineStart, end: lineStart, text: indent + "apiVersion: " + plotDefAPIVersion + "\n"})
+		}
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "apiVersion"}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: plotDefAPIVersion}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+	return nil
+}
+
+// offset returns the byte offset in the source of a line and column as
+// reported by the yaml parser, which counts columns in characters
+func (m *plotDefMigrator) offset(line, column int) (int, error) {
+	off := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(m.src[off:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is beyond the end of the plot definition", line)
+		}
+		off += i + 1
+	}
+	for c := 1; c < column; c++ {
+		if off >= len(m.src) || m.src[off] == '\n' {
+			return 0, fmt.Errorf("column %d is beyond the end of line %d", column, line)
+		}
+		_, size := utf8.DecodeRune(m.src[off:])
+		off += size
+	}
+	return off, nil
+}
+
+// apply returns the source with the recorded edits made
+func (m *plotDefMigrator) apply() ([]byte, error) {
+	edits := append([]textEdit(nil), m.edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			return nil, fmt.Errorf("overlapping edits at offset %d", e.start)
+		}
+		buf.Write(m.src[pos:e.start])
+		buf.WriteString(e.text)
+		pos = e.end
+	}
+	buf.Write(m.src[pos:])
+	return buf.Bytes(), nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"time"
 
@@ -24,55 +26,193 @@ type PlotConfig struct {
 	// are passed directly to the templating engine.
 	TemplateParams map[string]any
 
+	// DefaultColor is the color of series that have no other color
 	DefaultColor string
 
 	// Colors is a mapping of friendly names to hex values of colors
 	Colors map[string]string
 
+	// Palette is a list of colors assigned to series that have no color
+	Palette []string
+
 	// Profiles contains information about different variants of plot defs
 	Profiles []*ProcessingProfile
 
 	MatchGlob string
+
+	// MatchFrequency limits a batch run to the plots of a frequency, if set
+	MatchFrequency PlotFrequency
+
+	// Notifier routes notifications of plot failures to their owners. May be nil.
+	Notifier *Notifier
+
+	// Messages holds message catalogs, keyed by locale, mapping message
+	// keys to localized text
+	Messages map[string]map[string]string
+
+	// Rollups maps source names to the table suffixes of the rollups that
+	// should be queried for each plot frequency
+	Rollups map[string]map[PlotFrequency]string
+
+	// Blackouts lists the windows during which sources must not be queried
+	Blackouts []BlackoutWindow
+
+	// Holidays lists the non-working days that may be shaded on time series
+	Holidays []Holiday
+
+	// NonFinite is the default policy for NaN and infinite values in datasets
+	NonFinite NonFinitePolicy
+
+	// WeekStart is the day weekly periods start on, Monday if empty
+	WeekStart WeekStart
 }
 
+// MaybeLookupColor resolves a color using the fallback chain: the explicit
+// color given by name, or the named color it refers to, then the named color
+// matching the series name, then a palette color chosen by the series name
+// and finally the default color. Colors that do not belong to a series, such
+// as delta colors, are looked up with an empty series name and resolve to an
+// empty string when no color is given.
 func (c *PlotConfig) MaybeLookupColor(name string, seriesName string) string {
-	// if name == "" {
-	// 	return c.DefaultColor
-	// }
-	v, ok := c.Colors[name]
-	if ok {
+	if name != "" {
+		if v, ok := c.Colors[name]; ok {
+			return v
+		}
+		return name
+	}
+
+	if seriesName == "" {
+		return ""
+	}
+	if v, ok := c.Colors[seriesName]; ok {
 		return v
 	}
-	return name
+	if len(c.Palette) > 0 {
+		// hash the name so a series has the same color in every plot
+		h := fnv.New32a()
+		h.Write([]byte(seriesName))
+		return c.Palette[h.Sum32()%uint32(len(c.Palette))]
+	}
+	return c.DefaultColor
 }
 
 type PlotFrequency string
 
 const (
-	PlotFrequencyWeekly PlotFrequency = "weekly"
-	PlotFrequencyDaily  PlotFrequency = "daily"
-	PlotFrequencyHourly PlotFrequency = "hourly"
+	PlotFrequencyYearly    PlotFrequency = "yearly"
+	PlotFrequencyQuarterly PlotFrequency = "quarterly"
+	PlotFrequencyMonthly   PlotFrequency = "monthly"
+	PlotFrequencyWeekly    PlotFrequency = "weekly"
+	PlotFrequencyDaily     PlotFrequency = "daily"
+	PlotFrequencyHourly    PlotFrequency = "hourly"
 )
 
 func (f PlotFrequency) String() string { return string(f) }
 
-func (f PlotFrequency) Truncate(t time.Time) time.Time {
+func (f PlotFrequency) Validate() error {
+	switch f {
+	case PlotFrequencyYearly, PlotFrequencyQuarterly, PlotFrequencyMonthly, PlotFrequencyWeekly, PlotFrequencyDaily, PlotFrequencyHourly:
+		return nil
+	default:
+		return fmt.Errorf("unsupported plot frequency: %q", f)
+	}
+}
+
+// Truncate returns the start of the calendar period of the frequency that
+// contains t, in the location of t. Weeks start on weekStart and quarters in
+// January, April, July and October.
+func (f PlotFrequency) Truncate(t time.Time, weekStart WeekStart) time.Time {
+	y, m, d := t.Date()
 	switch f {
+	case PlotFrequencyYearly:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+	case PlotFrequencyQuarterly:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case PlotFrequencyMonthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
 	case PlotFrequencyWeekly:
-		return t.Truncate(7 * 24 * time.Hour)
+		return time.Date(y, m, d-(int(t.Weekday()-weekStart.Weekday())+7)%7, 0, 0, 0, 0, t.Location())
 	case PlotFrequencyDaily:
-		return t.Truncate(24 * time.Hour)
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
 	case PlotFrequencyHourly:
-		return t.Truncate(time.Hour)
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
 	default:
 		panic(fmt.Sprintf("unsupported plot frequency: %q", f))
 	}
 }
 
+// Next returns the start of the calendar period of the frequency that follows
+// the one containing t
+func (f PlotFrequency) Next(t time.Time, weekStart WeekStart) time.Time {
+	start := f.Truncate(t, weekStart)
+	switch f {
+	case PlotFrequencyYearly:
+		return start.AddDate(1, 0, 0)
+	case PlotFrequencyQuarterly:
+		return start.AddDate(0, 3, 0)
+	case PlotFrequencyMonthly:
+		return start.AddDate(0, 1, 0)
+	case PlotFrequencyWeekly:
+		return start.AddDate(0, 0, 7)
+	case PlotFrequencyDaily:
+		return start.AddDate(0, 0, 1)
+	default:
+		return start.Add(time.Hour)
+	}
+}
+
+// WeekStart is the day of the week that weekly periods start on
+type WeekStart string
+
+const (
+	WeekStartMonday    WeekStart = "monday" // ISO weeks
+	WeekStartTuesday   WeekStart = "tuesday"
+	WeekStartWednesday WeekStart = "wednesday"
+	WeekStartThursday  WeekStart = "thursday"
+	WeekStartFriday    WeekStart = "friday"
+	WeekStartSaturday  WeekStart = "saturday"
+	WeekStartSunday    WeekStart = "sunday"
+)
+
+func (w WeekStart) String() string { return string(w) }
+
+func (w WeekStart) Validate() error {
+	switch w {
+	case "", WeekStartMonday, WeekStartTuesday, WeekStartWednesday, WeekStartThursday, WeekStartFriday, WeekStartSaturday, WeekStartSunday:
+		return nil
+	default:
+		return fmt.Errorf("unsupported week start: %q", w)
+	}
+}
+
+// Weekday returns the day the week starts on, Monday if not set
+func (w WeekStart) Weekday() time.Weekday {
+	switch w {
+	case WeekStartTuesday:
+		return time.Tuesday
+	case WeekStartWednesday:
+		return time.Wednesday
+	case WeekStartThursday:
+		return time.Thursday
+	case WeekStartFriday:
+		return time.Friday
+	case WeekStartSaturday:
+		return time.Saturday
+	case WeekStartSunday:
+		return time.Sunday
+	default:
+		return time.Monday
+	}
+}
+
 type ProcessingProfile struct {
 	Source   string           `yaml:"source"`
 	OutTpl   string           `yaml:"output"`
+	OutPath  string           `yaml:"outputPath"` // optional template of the path of each plot's output, replacing the dated directory layout
 	Variants []map[string]any `yaml:"variants"`
+	Locales  []string         `yaml:"locales"` // optional locales, each variant is generated once per locale with the locale parameter set
+
+	Dashboards []DashboardDef `yaml:"dashboards"` // optional dashboards combining the outputs of the profile's plots into single files
 }
 
 func (p *ProcessingProfile) SourceIsDir() bool {
@@ -81,56 +221,116 @@ func (p *ProcessingProfile) SourceIsDir() bool {
 }
 
 type PlotDef struct {
-	Name       string         `yaml:"name"`
-	Frequency  PlotFrequency  `yaml:"frequency"`
-	Datasets   []DataSetDef   `yaml:"datasets"`
-	Computed   []ComputedDef  `yaml:"computed"`
-	Series     []SeriesDef    `yaml:"series"`
-	Scalars    []ScalarDef    `yaml:"scalars"`
-	Tables     []TableDef     `yaml:"tables"`
-	Layout     grob.Layout    `yaml:"layout"`
-	Config     map[string]any `yaml:"config"`
-	Parameters map[string]any `yaml:"params"`
-	DynLayout  map[string]any `yaml:"dynamicLayout"`
+	APIVersion string          `yaml:"apiVersion"` // the version of the definition format, definitions of older versions are migrated when they are parsed
+	Name       string          `yaml:"name"`
+	Owner      string          `yaml:"owner"` // the team or person responsible for the plot, used to route failure notifications
+	Tags       []string        `yaml:"tags"`  // free-form tags, also used to route failure notifications
+	Frequency  PlotFrequency   `yaml:"frequency"`
+	Timezone   string          `yaml:"timezone"` // optional IANA timezone the plot's periods and template variables are computed in, overriding the one given on the command line
+	Datasets   []DataSetDef    `yaml:"datasets"`
+	Computed   []ComputedDef   `yaml:"computed"`
+	Series     []SeriesDef     `yaml:"series"`
+	Scalars    []ScalarDef     `yaml:"scalars"`
+	Tables     []TableDef      `yaml:"tables"`
+	Layout     grob.Layout     `yaml:"layout"`
+	Config     map[string]any  `yaml:"config"`
+	Parameters map[string]any  `yaml:"params"`
+	DynLayout  map[string]any  `yaml:"dynamicLayout"`
+	Drilldown  []DrilldownDef  `yaml:"drilldown"`
+	Toggles    []ToggleDef     `yaml:"toggles"`
+	DependsOn  []DependencyDef `yaml:"dependsOn"` // conditions that must hold before the plot is generated by a batch
+	Events     []EventDef      `yaml:"events"`    // datasets of events, such as deployments, to mark on the x axis
+
+	Precondition *PreconditionDef `yaml:"precondition"` // optional condition on the plot's data that must hold for the plot to be generated
+	Quality      *QualityDef      `yaml:"quality"`      // optional data quality indicators for the plot's series
+
+	PartialPeriod PartialPeriodType `yaml:"partialPeriod"` // how the incomplete final period of time series is marked: shade or none. Defaults to shade.
+	ShadeWeekends bool              `yaml:"shadeWeekends"` // shade weekends on the x axis of time series
+	ShadeHolidays bool              `yaml:"shadeHolidays"` // shade the holidays listed in the conf dir on the x axis of time series
+
+	RangeSelector []string `yaml:"rangeSelector"` // x axis range selector buttons for time series, such as 7d, 30d, 90d, 1y, ytd or all
+	RangeSlider   bool     `yaml:"rangeSlider"`   // show a range slider beneath the x axis of time series
+
+	NonFinite NonFinitePolicy `yaml:"nonFinite"` // how NaN and infinite values in the plot's datasets are handled: null, drop or fail. Defaults to the policy given on the command line.
+}
+
+// DrilldownDef links the points of a plot to another, more detailed, plot so
+// the frontend can navigate between them
+type DrilldownDef struct {
+	Series string         `yaml:"series" json:"series,omitempty"` // the name of the series the link applies to, all series if empty
+	Label  string         `yaml:"label" json:"label,omitempty"`   // the label of the point the link applies to, all labels if empty
+	Plot   string         `yaml:"plot" json:"plot"`               // the name of the target plot
+	Params map[string]any `yaml:"params" json:"params,omitempty"` // parameters to pass to the target plot
 }
 
 type DataSetDef struct {
-	Name   string `yaml:"name"`
-	Source string `yaml:"source"`
-	Query  string `yaml:"query"`
+	Name      string `yaml:"name"`
+	Source    string `yaml:"source"`
+	Query     string `yaml:"query"`
+	QueryFile string `yaml:"queryFile"` // optional path of a file containing the query, relative to the plot definition
 }
 
 type SeriesDef struct {
-	Type          SeriesType `yaml:"type"`
-	Name          string     `yaml:"name"` // name of the series
-	Color         string     `yaml:"color"`
-	Marker        MarkerType `yaml:"marker"`
-	Fill          FillType   `yaml:"fill"`
-	DataSet       string     `yaml:"dataset"`
-	Labels        string     `yaml:"labels"`     // the name of the field the series should use for labels
-	Values        string     `yaml:"values"`     // the name of the field the series should use for values
-	GroupField    string     `yaml:"groupfield"` // optional name of a field the series should use for grouping into related series
-	GroupValue    string     `yaml:"groupvalue"` // optional value of a field the series should use for grouping into related series
-	Percent       bool       `yaml:"percent"`
-	order         int        // used for retaining ordering of series
-	HoverTemplate string     `yaml:"hovertemplate,omitempty"`
-	Visible       *bool      `yaml:"visible"`
-	Yaxis         string     `yaml:"yaxis"`
+	Type          SeriesType     `yaml:"type"`
+	Name          string         `yaml:"name"` // name of the series
+	Color         string         `yaml:"color"`
+	Marker        MarkerType     `yaml:"marker"`
+	Fill          FillType       `yaml:"fill"`
+	DataSet       string         `yaml:"dataset"`
+	Labels        string         `yaml:"labels"`     // the name of the field the series should use for labels
+	Values        string         `yaml:"values"`     // the name of the field the series should use for values
+	GroupField    string         `yaml:"groupfield"` // optional name of a field the series should use for grouping into related series
+	GroupValue    string         `yaml:"groupvalue"` // optional value of a field the series should use for grouping into related series
+	Percent       bool           `yaml:"percent"`
+	order         int            // used for retaining ordering of series
+	HoverTemplate string         `yaml:"hovertemplate,omitempty"`
+	Visible       *bool          `yaml:"visible"`
+	Yaxis         string         `yaml:"yaxis"`
+	LocationMode  string         `yaml:"locationMode"` // for choropleth series, how labels are matched to locations (ISO-3, country names, USA-states)
+	ColorScale    string         `yaml:"colorscale"`   // for choropleth series, the name of the plotly colorscale to use
+	ReverseScale  bool           `yaml:"reverseScale"` // for choropleth series, whether the colorscale should be reversed
+	TextField     string         `yaml:"textField"`    // optional name of a field the series should use for text printed on each point
+	TextTemplate  string         `yaml:"textTemplate"` // optional plotly texttemplate used to format the text printed on each point
+	TextPosition  string         `yaml:"textPosition"` // optional position of the text printed on each point
+	IDField       string         `yaml:"idField"`      // optional name of a field used to give each point a stable id
+	CustomData    []string       `yaml:"customdata"`   // optional names of fields whose values are attached to each point for use by the frontend
+	Meta          map[string]any `yaml:"meta"`         // optional metadata attached to the trace for use by the frontend
+	Opacity       float64        `yaml:"opacity"`      // optional opacity of the series, between 0 and 1
+	LineWidth     float64        `yaml:"lineWidth"`    // optional width of the line in pixels, for line, scatter and box series
+	LineDash      string         `yaml:"lineDash"`     // optional dash style of the line (solid, dot, dash, longdash, dashdot, longdashdot), for line and scatter series
+	BarWidth      float64        `yaml:"barWidth"`     // optional width of the bars in position axis units, for bar series
+	PercentOf     PercentType    `yaml:"percentOf"`    // when percent is set, whether values are a percentage of the series total ("series", the default) or of the total for each label across percent series ("label")
+	Unit          UnitType       `yaml:"unit"`         // optional unit of the values (bytes, seconds, count, percent), used to format axes and hover text
+
+	ExpectedInterval string `yaml:"expectedInterval"` // optional interval, such as 1h or 1d, at which a time series is expected to have data, used to measure its completeness
+
+	ValueLabels map[string]string `yaml:"valueLabels"` // optional display strings for values of the labels and group fields, such as true: online
+	Categories  []string          `yaml:"categories"`  // optional order of the displayed labels and group values. Booleans default to false then true, other values keep the order of the data
 }
 
 type SeriesType string
 
 const (
-	SeriesTypeBar     SeriesType = "bar"     // vertical bars
-	SeriesTypeHBar    SeriesType = "hbar"    // horizontal bars
-	SeriesTypeLine    SeriesType = "line"    // lines
-	SeriesTypeScatter SeriesType = "scatter" // scatter
-	SeriesTypeBox     SeriesType = "box"     // vertical box plot
-	SeriesTypeHBox    SeriesType = "hbox"    // horizontal box plot
+	SeriesTypeBar        SeriesType = "bar"        // vertical bars
+	SeriesTypeHBar       SeriesType = "hbar"       // horizontal bars
+	SeriesTypeLine       SeriesType = "line"       // lines
+	SeriesTypeScatter    SeriesType = "scatter"    // scatter
+	SeriesTypeBox        SeriesType = "box"        // vertical box plot
+	SeriesTypeHBox       SeriesType = "hbox"       // horizontal box plot
+	SeriesTypeChoropleth SeriesType = "choropleth" // map of locations shaded by value
 )
 
 func (t SeriesType) String() string { return string(t) }
 
+type PercentType string
+
+const (
+	PercentTypeSeries PercentType = "series" // values are a percentage of the total of the series
+	PercentTypeLabel  PercentType = "label"  // values are a percentage of the total of all percent series with the same label
+)
+
+func (t PercentType) String() string { return string(t) }
+
 type FillType string
 
 const (
@@ -163,21 +363,35 @@ type ScalarDef struct {
 	Value         string                `yaml:"value"`         // the name of the field in the dataset that should be used for the scalar value
 	ValueSuffix   string                `yaml:"valueSuffix"`   // a string to append after the value
 	ValuePrefix   string                `yaml:"valuePrefix"`   // a string to prepend to the value
-	DeltaDataSet  string                `yaml:"deltaDataset"`  // the name of a dataset to use for a delta value
-	DeltaValue    string                `yaml:"deltaValue"`    // the name of the field in the delta dataset that should be used for the scalar value
+	DeltaDataSet  string                `yaml:"deltaDataset"`  // the name of a dataset to use for a delta value, defaults to the scalar's dataset when deltaRow or deltaWhere are used
+	DeltaValue    string                `yaml:"deltaValue"`    // the name of the field in the delta dataset that should be used for the scalar value, defaults to the scalar's value field when deltaRow or deltaWhere are used
 	DeltaType     DeltaType             `yaml:"deltaType"`     // the type of delta contained in the value field
 	IncreaseColor string                `yaml:"increaseColor"` // the color to use for delta that show an increase
 	DecreaseColor string                `yaml:"decreaseColor"` // the color to use for delta that show an decrease
 	Visible       *bool                 `yaml:"visible"`       // if this trace should be shown
 	Gauge         *grob.IndicatorGauge  `yaml:"gauge"`         // gauge configuration
 	Domain        *grob.IndicatorDomain `yaml:"domain"`
+	Unit          UnitType              `yaml:"unit"`         // optional unit of the value (bytes, seconds, count, percent), used to format the number
+	Currency      string                `yaml:"currency"`     // optional ISO 4217 code of the currency of the value, such as USD or EUR
+	Locale        string                `yaml:"locale"`       // optional locale used to format currency values, such as en or de
+	DeltaFormat   DeltaFormatType       `yaml:"deltaFormat"`  // optional format of the delta, pp for percentage points or bp for basis points
+	Format        string                `yaml:"format"`       // optional d3 format of the value, or one of the presets si, thousands or percent
+	DeltaRow      *int                  `yaml:"deltaRow"`     // optional zero-based index of the row of the delta dataset to use for the delta value
+	DeltaWhere    map[string]any        `yaml:"deltaWhere"`   // optional field values used to select the row of the delta dataset to use for the delta value
+	TrendDataSet  string                `yaml:"trendDataset"` // for number+trend scalars, the name of the dataset containing the time series shown beneath the number
+	TrendX        string                `yaml:"trendX"`       // for number+trend scalars, the name of the field in the trend dataset containing the times
+	TrendY        string                `yaml:"trendY"`       // for number+trend scalars, the name of the field in the trend dataset containing the values
+	GroupField    string                `yaml:"groupField"`   // optional field used to expand the scalar into one indicator per distinct value, laid out in a grid. Delta and trend datasets are filtered by the same field
+
+	groupWhere map[string]any // the group field value selecting the rows of an expanded scalar
 }
 
 type ScalarType string
 
 const (
-	ScalarTypeNumber ScalarType = "number" // display the scalar value as a number
-	ScalarTypeGauge  ScalarType = "gauge"  // display the scalar value as a gauge
+	ScalarTypeNumber      ScalarType = "number"       // display the scalar value as a number
+	ScalarTypeGauge       ScalarType = "gauge"        // display the scalar value as a gauge
+	ScalarTypeNumberTrend ScalarType = "number+trend" // display the scalar value as a number with a sparkline of a trend dataset beneath
 )
 
 func (t ScalarType) String() string { return string(t) }
@@ -192,6 +406,16 @@ const (
 
 func (t DeltaType) String() string { return string(t) }
 
+type DeltaFormatType string
+
+const (
+	DeltaFormatDefault          DeltaFormatType = ""   // the delta is formatted by plotly
+	DeltaFormatPercentagePoints DeltaFormatType = "pp" // the delta is displayed in percentage points, for values that are ratios
+	DeltaFormatBasisPoints      DeltaFormatType = "bp" // the delta is displayed in basis points, for values that are ratios
+)
+
+func (t DeltaFormatType) String() string { return string(t) }
+
 type DataSource interface {
 	GetDataSet(ctx context.Context, query string, params ...any) (DataSet, error)
 }
@@ -212,6 +436,7 @@ type DataSet interface {
 type ColorDoc struct {
 	Default string       `yaml:"default"`
 	Colors  []NamedColor `yaml:"colors"`
+	Palette []string     `yaml:"palette"` // colors assigned to series without a color, chosen by series name
 }
 
 type NamedColor struct {
@@ -221,30 +446,188 @@ type NamedColor struct {
 
 // ComputedDef defines a computed dataset from a combination of others
 type ComputedDef struct {
-	Name     string              `yaml:"name"`
-	Function ComputeType         `yaml:"function"`
-	DataSets []ComputeDataSetDef `yaml:"datasets"`
+	Name         string              `yaml:"name"`
+	Function     ComputeType         `yaml:"function"`
+	DataSets     []ComputeDataSetDef `yaml:"datasets"`
+	DivideByZero DivideByZeroType    `yaml:"divideByZero"` // for the ratio function, how to handle a zero denominator: null, zero or skip. Defaults to null.
+	Expression   string              `yaml:"expression"`   // for the expr function, an arithmetic expression over the fields of the dataset, such as ok/(ok+failed)
+	Order        SortOrder           `yaml:"order"`        // for the sort function, the direction to sort in: asc or desc. Defaults to asc.
+	Limit        int                 `yaml:"limit"`        // for the sort and limit functions, the maximum number of rows to keep. Zero keeps all rows.
+	Offset       int                 `yaml:"offset"`       // for the sort and limit functions, the number of leading rows to drop
+	JoinType     JoinType            `yaml:"joinType"`     // for the diff, ratio, sum, mean, min and max functions, which rows of the datasets are joined: inner, left or full. Defaults to inner.
+	Interval     string              `yaml:"interval"`     // for the resample and forecast functions, the size of the time buckets or forecast periods, such as 1h, 1d or 1w
+	Aggregate    ComputeType         `yaml:"aggregate"`    // for the resample function, how values in a bucket are combined: sum, mean, min or max. Defaults to sum.
+	GapFill      GapFillType         `yaml:"gapFill"`      // for the resample function, the value of buckets with no rows: null, zero or previous. Defaults to null.
+	Shift        string              `yaml:"shift"`        // for the shift function, how far to move the time of each row, such as 7d to overlay the previous week on the current one or -1d
+	BinWidth     float64             `yaml:"binWidth"`     // for the histogram function, the width of each bin
+	BinStart     float64             `yaml:"binStart"`     // for the histogram function, the value that bins are aligned to. Defaults to 0.
+	Fields       []string            `yaml:"fields"`       // for the correlation function, the fields of the dataset to correlate with each other. For the select function, the fields to keep, defaulting to all.
+	Rename       map[string]string   `yaml:"rename"`       // for the select function, new names for fields, keyed by their original name
+	Periods      int                 `yaml:"periods"`      // for the forecast function, the number of periods to project beyond the latest time
+	Confidence   float64             `yaml:"confidence"`   // for the forecast function, an optional confidence level such as 0.95 for the lower and upper fields of the prediction interval
+	Window       int                 `yaml:"window"`       // for the anomaly function, the number of preceding rows used as the baseline of each row
+	Threshold    float64             `yaml:"threshold"`    // for the anomaly function, the number of standard deviations from the baseline mean beyond which a row is anomalous. Defaults to 3.
 }
 
 type ComputeDataSetDef struct {
 	DataSet    string `yaml:"dataset"`    // the name of the dataset
 	JoinField  string `yaml:"joinField"`  // the field name that will be used to join the datasets
 	ValueField string `yaml:"valueField"` // the field containing the value that will be used in the computation
+	GroupField string `yaml:"groupField"` // for the percent function, an optional field whose values divide the dataset into groups with separate totals
+	OrderField string `yaml:"orderField"` // for the cumsum and sort functions, the field used to order the rows, defaults to the join field for cumsum and the value field for sort
+	Fill       any    `yaml:"fill"`       // for left and full joins, the value used when the dataset has no row for a join field value. Without one the computed value is null.
 }
 
 type ComputeType string
 
 const (
-	ComputeTypeDiff ComputeType = "diff" // compute the difference between the first series and the second (first-second)
+	ComputeTypeDiff      ComputeType = "diff"      // compute the difference between the first series and the second (first-second)
+	ComputeTypeSum       ComputeType = "sum"       // compute the sum of the series of one or more datasets
+	ComputeTypeMean      ComputeType = "mean"      // compute the mean of the series of one or more datasets, ignoring nulls
+	ComputeTypeMin       ComputeType = "min"       // compute the minimum of the series of one or more datasets, ignoring nulls
+	ComputeTypeMax       ComputeType = "max"       // compute the maximum of the series of one or more datasets, ignoring nulls
+	ComputeTypeRatio     ComputeType = "ratio"     // compute the ratio of the first series to the second (first/second)
+	ComputeTypePercent   ComputeType = "percent"   // compute each value as a fraction of the total of the dataset, or of its group
+	ComputeTypeCumSum    ComputeType = "cumsum"    // compute the running total of the values of a dataset
+	ComputeTypeExpr      ComputeType = "expr"      // compute an arithmetic expression over the fields of each row of a dataset, giving a dataset of two fields: field, the join field, and value, the result
+	ComputeTypeSort      ComputeType = "sort"      // sort the rows of a dataset by a field, optionally keeping only some of them
+	ComputeTypeLimit     ComputeType = "limit"     // keep only some of the rows of a dataset, in their existing order
+	ComputeTypeResample  ComputeType = "resample"  // aggregate the values of a dataset into time buckets of a fixed interval, filling gaps
+	ComputeTypeShift     ComputeType = "shift"     // move the times of a dataset by a fixed duration for period over period comparisons
+	ComputeTypeHistogram ComputeType = "histogram" // count the values of a dataset in bins of a fixed width
+
+	ComputeTypeCorrelation ComputeType = "correlation" // compute the pairwise correlation matrix of the fields of a wide dataset
+	ComputeTypeForecast    ComputeType = "forecast"    // project a time series into the future using a linear regression
+	ComputeTypeAnomaly     ComputeType = "anomaly"     // find the rows of a dataset that deviate from a rolling baseline
+	ComputeTypeSelect      ComputeType = "select"      // keep and rename some of the fields of a dataset
 )
 
 func (t ComputeType) String() string { return string(t) }
 
-type FigureData struct {
+type DivideByZeroType string
+
+const (
+	DivideByZeroNull DivideByZeroType = "null" // the computed value is null
+	DivideByZeroZero DivideByZeroType = "zero" // the computed value is zero
+	DivideByZeroSkip DivideByZeroType = "skip" // the row is omitted from the computed dataset
+)
+
+func (t DivideByZeroType) String() string { return string(t) }
+
+type JoinType string
+
+const (
+	JoinTypeInner JoinType = "inner" // only rows present in every dataset are included
+	JoinTypeLeft  JoinType = "left"  // every row of the first dataset is included
+	JoinTypeFull  JoinType = "full"  // every row of any dataset is included
+)
+
+func (t JoinType) String() string { return string(t) }
+
+type SortOrder string
+
+const (
+	SortOrderAsc  SortOrder = "asc"  // smallest values first
+	SortOrderDesc SortOrder = "desc" // largest values first
+)
+
+func (o SortOrder) String() string { return string(o) }
+
+// Figure is a generated plotly figure
+type Figure struct {
 	*grob.Fig
-	Params    map[string]any `json:"params"`
-	DynLayout map[string]any `json:"dynamicLayout"`
-	Config    map[string]any `json:"config"`
+
+	// LayoutAxes holds additional layout axes, such as xaxis2, keyed by their
+	// layout name. grob.Layout only supports the primary axes.
+	LayoutAxes map[string]any `json:"-"`
+
+	// Quality holds data quality indicators for each series, if requested
+	// by the plot definition
+	Quality []SeriesQuality `json:"quality,omitempty"`
+
+	// Series and DataSets hold the series and datasets the figure was
+	// generated from, for output formats other than plotly
+	Series   []*LabeledSeries   `json:"-"`
+	DataSets map[string]DataSet `json:"-"`
+
+	// SourceDataSets holds the datasets as they were fetched from their
+	// sources, before non-finite values were sanitized
+	SourceDataSets map[string]DataSet `json:"-"`
+}
+
+type FigureData struct {
+	*Figure
+	SchemaVersion int             `json:"schemaVersion"` // always written as the current figureSchemaVersion
+	Metadata      *FigureMetadata `json:"metadata,omitempty"`
+	Params        map[string]any  `json:"params"`
+	DynLayout     map[string]any  `json:"dynamicLayout"`
+	Config        map[string]any  `json:"config"`
+	Drilldown     []DrilldownDef  `json:"drilldown,omitempty"`
+}
+
+func (f FigureData) MarshalJSON() ([]byte, error) {
+	type figureData FigureData // avoids recursing into this method
+	f.SchemaVersion = figureSchemaVersion
+	data, err := json.Marshal(figureData(f))
+	if err != nil || f.Figure == nil || len(f.LayoutAxes) == 0 {
+		return data, err
+	}
+
+	// merge the additional axes into the marshalled layout
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	layout := make(map[string]json.RawMessage)
+	if raw, ok := doc["layout"]; ok {
+		if err := json.Unmarshal(raw, &layout); err != nil {
+			return nil, err
+		}
+	}
+	for name, axis := range f.LayoutAxes {
+		raw, err := json.Marshal(axis)
+		if err != nil {
+			return nil, fmt.Errorf("marshal layout axis %q: %w", name, err)
+		}
+		layout[name] = raw
+	}
+	doc["layout"], err = json.Marshal(layout)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// heatmapTrace is a heatmap whose color bounds are written whenever they are
+// set. grob.Heatmap omits bounds of zero, the usual minimum of counts.
+type heatmapTrace struct {
+	*grob.Heatmap
+	Zmin *float64
+	Zmax *float64
+}
+
+func (t *heatmapTrace) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(t.Heatmap)
+	if err != nil || (t.Zmin == nil && t.Zmax == nil) {
+		return data, err
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc["zauto"] = json.RawMessage("false")
+	if t.Zmin != nil {
+		if doc["zmin"], err = json.Marshal(*t.Zmin); err != nil {
+			return nil, err
+		}
+	}
+	if t.Zmax != nil {
+		if doc["zmax"], err = json.Marshal(*t.Zmax); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
 }
 
 type TableDef struct {
@@ -258,6 +641,12 @@ type TableDef struct {
 	Colorbar *grob.HeatmapColorbar `yaml:"colorbar"`
 	Yaxis    string                `yaml:"yaxis"`
 	order    int                   // used for retaining ordering of series
+
+	ColorScale    string   `yaml:"colorscale"`    // for heatmaps, the name of the plotly colorscale to use, defaults to Viridis
+	ReverseScale  *bool    `yaml:"reverseScale"`  // for heatmaps, whether the colorscale should be reversed, defaults to true
+	ZMin          *float64 `yaml:"zmin"`          // for heatmaps, the value mapped to the lowest color, defaults to the minimum value
+	ZMax          *float64 `yaml:"zmax"`          // for heatmaps, the value mapped to the highest color, defaults to the maximum value
+	NoAnnotations bool     `yaml:"noAnnotations"` // for heatmaps, do not print the value in each cell
 }
 
 type TableType string
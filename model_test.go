@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+func TestMaybeLookupColor(t *testing.T) {
+	palette := []string{"#111111", "#222222", "#333333"}
+	h := fnv.New32a()
+	h.Write([]byte("peers"))
+	hashed := palette[h.Sum32()%uint32(len(palette))]
+
+	tests := []struct {
+		name       string
+		cfg        PlotConfig
+		color      string
+		seriesName string
+		want       string
+	}{
+		{
+			name:       "explicit color",
+			cfg:        PlotConfig{Colors: map[string]string{"peers": "#abcdef"}, Palette: palette, DefaultColor: "#000000"},
+			color:      "#ff0000",
+			seriesName: "peers",
+			want:       "#ff0000",
+		},
+		{
+			name:       "explicit color named in colors",
+			cfg:        PlotConfig{Colors: map[string]string{"brand": "#123456", "peers": "#abcdef"}},
+			color:      "brand",
+			seriesName: "peers",
+			want:       "#123456",
+		},
+		{
+			name:       "series name in colors",
+			cfg:        PlotConfig{Colors: map[string]string{"peers": "#abcdef"}, Palette: palette, DefaultColor: "#000000"},
+			seriesName: "peers",
+			want:       "#abcdef",
+		},
+		{
+			name:       "palette hash of series name",
+			cfg:        PlotConfig{Colors: map[string]string{"other": "#abcdef"}, Palette: palette, DefaultColor: "#000000"},
+			seriesName: "peers",
+			want:       hashed,
+		},
+		{
+			name:       "default color",
+			cfg:        PlotConfig{Colors: map[string]string{"other": "#abcdef"}, DefaultColor: "#000000"},
+			seriesName: "peers",
+			want:       "#000000",
+		},
+		{
+			name: "no color or series name",
+			cfg:  PlotConfig{Colors: map[string]string{"": "#abcdef"}, Palette: palette, DefaultColor: "#000000"},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.MaybeLookupColor(tt.color, tt.seriesName); got != tt.want {
+				t.Errorf("MaybeLookupColor(%q, %q) = %q, want %q", tt.color, tt.seriesName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeatmapBoundsMarshal(t *testing.T) {
+	def := `name: h
+frequency: daily
+datasets:
+  - name: counts
+    source: static
+    query: counts
+tables:
+  - type: heatmap
+    name: counts
+    dataset: counts
+    xLabels: day
+    yLabels: hour
+    values: count
+    zmin: 0
+`
+	pd, err := parsePlotDef("h.yaml", nil, []byte(def))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &PlotConfig{
+		BasisTime: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		Sources: map[string]DataSource{"static": &StaticDataSource{DataSets: map[string]map[string][]any{
+			"counts": {"day": {"mon", "tue"}, "hour": {"0", "0"}, "count": {int64(3), int64(5)}},
+		}}},
+	}
+	fig, err := generateFig(context.Background(), pd, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(FigureData{Figure: fig})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Data) != 1 {
+		t.Fatalf("got %d traces, want 1", len(doc.Data))
+	}
+	trace := doc.Data[0]
+	if zmin, ok := trace["zmin"]; !ok || zmin != 0.0 {
+		t.Errorf("zmin = %v (present %v), want 0", zmin, ok)
+	}
+	if _, ok := trace["zmax"]; ok {
+		t.Errorf("zmax = %v, want it omitted", trace["zmax"])
+	}
+	if trace["zauto"] != false {
+		t.Errorf("zauto = %v, want false", trace["zauto"])
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"golang.org/x/exp/slog"
+)
+
+type NonFinitePolicy string
+
+const (
+	NonFiniteNull NonFinitePolicy = "null" // replace NaN and infinite values with null
+	NonFiniteDrop NonFinitePolicy = "drop" // remove rows containing NaN or infinite values
+	NonFiniteFail NonFinitePolicy = "fail" // fail to generate the plot
+)
+
+func (p NonFinitePolicy) String() string { return string(p) }
+
+func (p NonFinitePolicy) Validate() error {
+	switch p {
+	case NonFiniteNull, NonFiniteDrop, NonFiniteFail:
+		return nil
+	default:
+		return fmt.Errorf("unsupported non-finite value policy: %q", p)
+	}
+}
+
+// NonFinitePolicy returns the policy for NaN and infinite values in the
+// datasets of the plot: the plot's own policy if it has one, otherwise the
+// configured policy, which defaults to null.
+func (c *PlotConfig) NonFinitePolicy(pd *PlotDef) NonFinitePolicy {
+	if pd.NonFinite != "" {
+		return pd.NonFinite
+	}
+	if c.NonFinite != "" {
+		return c.NonFinite
+	}
+	return NonFiniteNull
+}
+
+func isNonFinite(v any) bool {
+	switch tv := v.(type) {
+	case float64:
+		return math.IsNaN(tv) || math.IsInf(tv, 0)
+	case float32:
+		return math.IsNaN(float64(tv)) || math.IsInf(float64(tv), 0)
+	}
+	return false
+}
+
+// sanitizeNonFinite applies the policy to the NaN and infinite values in the
+// named datasets, which would otherwise produce invalid json or broken
+// traces. Datasets are processed in the order given. Datasets that change
+// are replaced in the map by sanitized copies, so the originals are kept
+// intact for anyone holding them.
+func sanitizeNonFinite(dataSets map[string]DataSet, names []string, policy NonFinitePolicy, logger *slog.Logger) error {
+	for _, name := range names {
+		// all sources produce static datasets
+		sds, ok := dataSets[name].(*StaticDataSet)
+		if !ok {
+			continue
+		}
+
+		fields := make([]string, 0, len(sds.Data))
+		for field := range sds.Data {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		var bad []bool // rows containing a non-finite value
+		count := 0
+		for _, field := range fields {
+			for i, v := range sds.Data[field] {
+				if !isNonFinite(v) {
+					continue
+				}
+				if policy == NonFiniteFail {
+					return fmt.Errorf("dataset %q has non-finite value %v in field %q of row %d", name, v, field, i)
+				}
+				if bad == nil {
+					bad = make([]bool, sds.rowcount)
+				}
+				bad[i] = true
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		if policy == NonFiniteDrop {
+			kept := 0
+			for i := range bad {
+				if !bad[i] {
+					kept++
+				}
+			}
+			data := make(map[string][]any, len(sds.Data))
+			for field, values := range sds.Data {
+				filtered := make([]any, 0, kept)
+				for i, v := range values {
+					if !bad[i] {
+						filtered = append(filtered, v)
+					}
+				}
+				data[field] = filtered
+			}
+			logger.Warn("dropped rows with non-finite values", "dataset", name, "rows", sds.rowcount-kept)
+			dataSets[name] = NewStaticDataSet(data)
+			continue
+		}
+
+		data := make(map[string][]any, len(sds.Data))
+		for field, values := range sds.Data {
+			replaced := make([]any, len(values))
+			for i, v := range values {
+				if !isNonFinite(v) {
+					replaced[i] = v
+				}
+			}
+			data[field] = replaced
+		}
+		logger.Warn("replaced non-finite values with null", "dataset", name, "values", count)
+		dataSets[name] = NewStaticDataSet(data)
+	}
+	return nil
+}
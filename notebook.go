@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+)
+
+var notebookCommand = &cli.Command{
+	Name:      "notebook",
+	Usage:     "Emit a notebook cell that loads a generated plot, or its datasets, for further analysis",
+	ArgsUsage: "<path or url of generated output>",
+	Action:    Notebook,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "language",
+			Aliases:     []string{"l"},
+			Required:    false,
+			Usage:       "Notebook language, either python for Jupyter or observable.",
+			Value:       "python",
+			Destination: &notebookOpts.language,
+		},
+		&cli.BoolFlag{
+			Name:        "datasets",
+			Required:    false,
+			Usage:       "Load the datasets of an output generated with --format data instead of the plotly figure.",
+			Destination: &notebookOpts.datasets,
+		},
+		&cli.BoolFlag{
+			Name:        "ipynb",
+			Required:    false,
+			Usage:       "Emit a complete Jupyter notebook containing the cell instead of just its source. Only supported for python.",
+			Destination: &notebookOpts.ipynb,
+		},
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Write the cell to a file instead of stdout.",
+			Destination: &notebookOpts.output,
+		},
+	}, loggingFlags...),
+}
+
+var notebookOpts struct {
+	language string
+	datasets bool
+	ipynb    bool
+	output   string
+}
+
+func Notebook(cc *cli.Context) error {
+	setupLogging()
+
+	if cc.NArg() != 1 {
+		return fmt.Errorf("the path or url of a generated output must be supplied as an argument")
+	}
+	if notebookOpts.ipynb && notebookOpts.language != "python" {
+		return fmt.Errorf("ipynb notebooks are only supported for python")
+	}
+
+	cell, err := NotebookCell(notebookOpts.language, cc.Args().Get(0), notebookOpts.datasets)
+	if err != nil {
+		return err
+	}
+
+	data := []byte(cell)
+	if notebookOpts.ipynb {
+		data, err = ipynb(cell)
+		if err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if notebookOpts.output != "" {
+		f, err := os.Create(notebookOpts.output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+var notebookTemplates = map[string]map[bool]string{
+	"python": {
+		false: `import json
+{{ if .Remote }}from urllib.request import urlopen
+{{ end }}import plotly.graph_objects as go
+
+{{ if .Remote }}with urlopen({{ .Location }}) as f:{{ else }}with open({{ .Location }}) as f:{{ end }}
+    doc = json.load(f)
+
+fig = go.Figure(data=doc["data"], layout=doc["layout"])
+fig.show(config=doc.get("config"))
+`,
+		true: `import json
+{{ if .Remote }}from urllib.request import urlopen
+{{ end }}import pandas as pd
+
+{{ if .Remote }}with urlopen({{ .Location }}) as f:{{ else }}with open({{ .Location }}) as f:{{ end }}
+    doc = json.load(f)
+
+# one dataframe per dataset, after computation
+datasets = {name: pd.DataFrame(columns) for name, columns in doc["datasets"].items()}
+# one dataframe per series, with the label and value of each point
+series = {s["name"]: pd.DataFrame({"label": s["labels"], "value": s["values"]}) for s in doc["series"]}
+`,
+	},
+	"observable": {
+		false: `plot = {
+  const Plotly = await require("https://cdn.plot.ly/plotly-2.27.0.min.js");
+  const doc = await {{ if .Remote }}fetch({{ .Location }}).then((r) => r.json()){{ else }}FileAttachment({{ .Location }}).json(){{ end }};
+  const div = DOM.element("div");
+  await Plotly.newPlot(div, doc.data, doc.layout, doc.config);
+  return div;
+}
+`,
+		true: `datasets = {
+  const doc = await {{ if .Remote }}fetch({{ .Location }}).then((r) => r.json()){{ else }}FileAttachment({{ .Location }}).json(){{ end }};
+  // convert the columns of each dataset to an array of rows
+  return Object.fromEntries(Object.entries(doc.datasets).map(([name, columns]) => {
+    const fields = Object.keys(columns);
+    const n = fields.length ? columns[fields[0]].length : 0;
+    return [name, Array.from({length: n}, (_, i) => Object.fromEntries(fields.map((f) => [f, columns[f][i]])))];
+  }));
+}
+`,
+	},
+}
+
+// NotebookCell returns the source of a notebook cell in the language that
+// loads the generated output at location, which may be a path or a url
+func NotebookCell(language string, location string, datasets bool) (string, error) {
+	templates, ok := notebookTemplates[language]
+	if !ok {
+		return "", fmt.Errorf("unsupported notebook language: %q", language)
+	}
+	tmpl, err := template.New(language).Parse(templates[datasets])
+	if err != nil {
+		return "", fmt.Errorf("parse notebook template: %w", err)
+	}
+
+	// a json string is also a valid python and javascript string literal
+	quoted, err := json.Marshal(location)
+	if err != nil {
+		return "", err
+	}
+	data := map[string]any{
+		"Location": string(quoted),
+		"Remote":   strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("execute notebook template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// ipynb wraps the source of a python cell in a Jupyter notebook
+func ipynb(cell string) ([]byte, error) {
+	lines := strings.SplitAfter(strings.TrimSuffix(cell, "\n"), "\n")
+	nb := map[string]any{
+		"nbformat":       4,
+		"nbformat_minor": 5,
+		"metadata": map[string]any{
+			"kernelspec": map[string]any{"name": "python3", "display_name": "Python 3", "language": "python"},
+		},
+		"cells": []any{
+			map[string]any{
+				"cell_type":       "code",
+				"id":              "ashby",
+				"metadata":        map[string]any{},
+				"execution_count": nil,
+				"outputs":         []any{},
+				"source":          lines,
+			},
+		},
+	}
+	data, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal notebook: %w", err)
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// NotifyDoc represents a document that defines where notifications of plot
+// failures should be sent
+type NotifyDoc struct {
+	Default      string        `yaml:"default"`      // webhook url used when no route matches a plot
+	DefaultEmail string        `yaml:"defaultEmail"` // email address used when no route matches a plot
+	Routes       []NotifyRoute `yaml:"routes"`
+	Digest       bool          `yaml:"digest"` // collect the notifications of a batch run and send each recipient a single message when the run ends
+	SMTP         *SMTPConf     `yaml:"smtp"`   // the mail server used to send notifications to email addresses
+}
+
+// NotifyRoute sends notifications for plots matching an owner or tag to a
+// webhook, an email address or both. Webhooks are sent a Slack compatible
+// json payload.
+type NotifyRoute struct {
+	Owner   string `yaml:"owner"`
+	Tag     string `yaml:"tag"`
+	Webhook string `yaml:"webhook"`
+	Email   string `yaml:"email"`
+}
+
+// SMTPConf is the mail server that email notifications are sent through
+type SMTPConf struct {
+	Addr        string `yaml:"addr"` // host and port of the server
+	From        string `yaml:"from"`
+	Username    string `yaml:"username"`    // optional, authenticates with PLAIN auth when set
+	PasswordEnv string `yaml:"passwordEnv"` // the environment variable holding the password
+}
+
+// validate checks that every email recipient can be sent to
+func (d *NotifyDoc) validate() error {
+	hasEmail := d.DefaultEmail != ""
+	for _, r := range d.Routes {
+		if r.Webhook == "" && r.Email == "" {
+			return fmt.Errorf("route for owner %q tag %q has no webhook or email", r.Owner, r.Tag)
+		}
+		hasEmail = hasEmail || r.Email != ""
+	}
+	if !hasEmail {
+		return nil
+	}
+	if d.SMTP == nil || d.SMTP.Addr == "" || d.SMTP.From == "" {
+		return fmt.Errorf("email notifications need an smtp server with an addr and from address")
+	}
+	if _, _, err := net.SplitHostPort(d.SMTP.Addr); err != nil {
+		return fmt.Errorf("smtp addr: %w", err)
+	}
+	return nil
+}
+
+// A Notifier sends notifications of plot failures to the webhooks and email
+// addresses of the plot's owners. A nil Notifier discards all notifications.
+//
+// In digest mode notifications are held until Flush is called, when each
+// recipient is sent a single message listing them. Plots that were not
+// generated because of blackouts, dependencies or preconditions, and the
+// anomalies found in generated plots, are only included in digests.
+type Notifier struct {
+	Default      string
+	DefaultEmail string
+	Routes       []NotifyRoute
+	Client       *http.Client
+	Digest       bool
+	SMTP         *SMTPConf
+
+	mu      sync.Mutex
+	pending map[string][]notification // pending notifications for digests, keyed by recipient
+}
+
+// emailRecipient is the prefix of recipients that are email addresses rather
+// than webhook urls
+const emailRecipient = "mailto:"
+
+type notification struct {
+	kind notificationKind
+	text string
+}
+
+type notificationKind int
+
+// notifications are listed in digests in the order of their kinds
+const (
+	notificationFailed notificationKind = iota
+	notificationAnomaly
+	notificationNotGenerated
+)
+
+func NewNotifier(doc *NotifyDoc) *Notifier {
+	return &Notifier{
+		Default:      doc.Default,
+		DefaultEmail: doc.DefaultEmail,
+		Routes:       doc.Routes,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		Digest:       doc.Digest,
+		SMTP:         doc.SMTP,
+	}
+}
+
+// Recipients returns the webhooks and email addresses that should be
+// notified about the plot. Email addresses are prefixed with mailto:. The
+// plot may be nil if the failure happened before it could be parsed.
+func (n *Notifier) Recipients(pd *PlotDef) []string {
+	var recipients []string
+	seen := make(map[string]bool)
+	add := func(r string) {
+		if r == "" || r == emailRecipient || seen[r] {
+			return
+		}
+		seen[r] = true
+		recipients = append(recipients, r)
+	}
+
+	if pd != nil {
+		for _, r := range n.Routes {
+			matched := r.Owner != "" && r.Owner == pd.Owner
+			if !matched && r.Tag != "" {
+				matched = slices.Contains(pd.Tags, r.Tag)
+			}
+			if matched {
+				add(r.Webhook)
+				add(emailRecipient + r.Email)
+			}
+		}
+	}
+
+	if len(recipients) == 0 {
+		add(n.Default)
+		add(emailRecipient + n.DefaultEmail)
+	}
+	return recipients
+}
+
+// PlotFailed notifies the owners of a plot that it failed. The plot may be
+// nil if the failure happened before it could be parsed, in which case fname
+// is used to identify it.
+func (n *Notifier) PlotFailed(ctx context.Context, pd *PlotDef, fname string, msg string, err error) {
+	if n == nil {
+		return
+	}
+
+	name := fname
+	if pd != nil {
+		name = pd.Name
+	}
+
+	text := fmt.Sprintf("plot %q %s: %v", name, msg, err)
+	if pd != nil && pd.Owner != "" {
+		text += fmt.Sprintf(" (owner: %s)", pd.Owner)
+	}
+
+	if n.Digest {
+		n.queue(pd, notification{kind: notificationFailed, text: text})
+		return
+	}
+
+	for _, r := range n.Recipients(pd) {
+		if err := n.send(ctx, r, "ashby: "+text); err != nil {
+			slog.Error("failed to send failure notification", "name", name, "error", err)
+		}
+	}
+}
+
+// PlotNotGenerated records that a plot was deliberately not generated, such
+// as when one of its sources was in a blackout window. It is only reported
+// in digest mode.
+func (n *Notifier) PlotNotGenerated(pd *PlotDef, status BatchStatus, reason string) {
+	if n == nil || !n.Digest {
+		return
+	}
+
+	text := fmt.Sprintf("plot %q not generated (%s): %s", pd.Name, status, reason)
+	if pd.Owner != "" {
+		text += fmt.Sprintf(" (owner: %s)", pd.Owner)
+	}
+	n.queue(pd, notification{kind: notificationNotGenerated, text: text})
+}
+
+// AnomaliesFound records the anomalies flagged by the anomaly computed
+// datasets of a generated plot. They are only reported in digest mode.
+func (n *Notifier) AnomaliesFound(pd *PlotDef, anomalies []AnomalyStats) {
+	if n == nil || !n.Digest {
+		return
+	}
+
+	for _, a := range anomalies {
+		text := fmt.Sprintf("plot %q has %d anomalies in %q, the last at %s", pd.Name, a.Count, a.DataSet, stringify(a.Last))
+		if pd.Owner != "" {
+			text += fmt.Sprintf(" (owner: %s)", pd.Owner)
+		}
+		n.queue(pd, notification{kind: notificationAnomaly, text: text})
+	}
+}
+
+func (n *Notifier) queue(pd *PlotDef, nt notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pending == nil {
+		n.pending = make(map[string][]notification)
+	}
+	for _, r := range n.Recipients(pd) {
+		n.pending[r] = append(n.pending[r], nt)
+	}
+}
+
+// Flush sends each recipient a digest of its pending notifications. It does
+// nothing unless the notifier is in digest mode.
+func (n *Notifier) Flush(ctx context.Context) {
+	if n == nil || !n.Digest {
+		return
+	}
+
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	recipients := make([]string, 0, len(pending))
+	for r := range pending {
+		recipients = append(recipients, r)
+	}
+	sort.Strings(recipients)
+
+	for _, r := range recipients {
+		if err := n.send(ctx, r, digestText(pending[r])); err != nil {
+			slog.Error("failed to send notification digest", "error", err)
+		}
+	}
+}
+
+// digestText formats notifications as a single message, listing failures
+// first, then anomalies, then plots that were not generated
+func digestText(nts []notification) string {
+	sort.SliceStable(nts, func(i, j int) bool {
+		return nts[i].kind < nts[j].kind
+	})
+
+	counts := make(map[notificationKind]int)
+	for _, nt := range nts {
+		counts[nt.kind]++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ashby: %d plots failed, %d with anomalies, %d plots not generated", counts[notificationFailed], counts[notificationAnomaly], counts[notificationNotGenerated])
+	for _, nt := range nts {
+		b.WriteString("\n• ")
+		b.WriteString(nt.text)
+	}
+	return b.String()
+}
+
+// send sends the text to a recipient, by email if it is an email address
+// and otherwise to its webhook
+func (n *Notifier) send(ctx context.Context, recipient string, text string) error {
+	if addr, ok := strings.CutPrefix(recipient, emailRecipient); ok {
+		return n.mail(addr, text)
+	}
+	return n.post(ctx, recipient, text)
+}
+
+// mail sends the text to an email address, using its first line as the
+// subject
+func (n *Notifier) mail(to string, text string) error {
+	if n.SMTP == nil {
+		return fmt.Errorf("no smtp server to send email to %s", to)
+	}
+	subject, _, _ := strings.Cut(text, "\n")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.SMTP.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if n.SMTP.Username != "" {
+		host, _, _ := net.SplitHostPort(n.SMTP.Addr)
+		auth = smtp.PlainAuth("", n.SMTP.Username, os.Getenv(n.SMTP.PasswordEnv), host)
+	}
+	if err := smtp.SendMail(n.SMTP.Addr, auth, n.SMTP.From, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+	return nil
+}
+
+func (n *Notifier) post(ctx context.Context, url string, text string) error {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,31 +2,60 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
-	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"text/template"
 	"time"
 
 	"golang.org/x/exp/slog"
 )
 
+// latestDir is the directory of the output tree holding a copy of the latest
+// version of each plot
+const latestDir = "latest"
+
 // An Organizer organizes plots into a dated directory hierarchy
 // Plots will be placed into a folder named as base/{year}/{month}/{day}
-// Hourly plots will be placed in a subfolder named {hour}
+// Hourly plots will be placed in a subfolder named {hour}. Monthly plots are placed in
+// base/{year}/{month}, quarterly plots in base/{year}/Q{quarter} and yearly
+// plots in base/{year}. Weekly plots are dated by the day the week starts on,
+// which is Monday unless WeekStart is set.
+//
+// A profile may instead give an output path template, which is executed
+// with the plot's name, filename, frequency, parameters and period fields to
+// form the path of the plot's output relative to the base.
 // If the plot is determined to be the latest version then it will be
-// copied to a directory called "latest"
+// copied to a directory called "latest", or linked from it with LinkLatest
 // So a plot called demo.json dated 2023-05-08 will be placed in:
 //
 //	base/2023/05/08/demo.json
 //	latest/demo.json
+//
+// The base may be a local directory or, with an S3 store, an s3 url.
 type Organizer struct {
 	Base     string
 	Template string
 	Params   map[string]any
+	Store    OutputStore // where the plots are stored, the local filesystem if nil
+
+	PathTemplate string       // optional template of the path of each plot's output, replacing the dated layout
+	Format       OutputFormat // the format of the outputs, which sets the extension of their filenames
+	WeekStart    WeekStart
+	LinkLatest   bool // make the latest files symbolic links to the dated files instead of copies, which needs a LinkStore
+}
+
+func (o *Organizer) store() OutputStore {
+	if o.Store == nil {
+		return &FileStore{}
+	}
+	return o.Store
 }
 
 func (o *Organizer) Filename(name string) (string, error) {
@@ -45,18 +74,33 @@ func (o *Organizer) Filename(name string) (string, error) {
 		return "", fmt.Errorf("execute filename template: %w", err)
 	}
 
+	// the template usually ends in .json whatever the format
+	if o.Format != "" {
+		return strings.TrimSuffix(buf.String(), path.Ext(buf.String())) + o.Format.Extension(), nil
+	}
 	return buf.String(), nil
 }
 
 func (o *Organizer) Filepath(pd *PlotDef, basisTime time.Time) (string, error) {
+	if o.PathTemplate != "" {
+		return o.templatedPath(pd, o.periodFields(pd, basisTime))
+	}
+
 	var dated string
 	switch pd.Frequency {
+	case PlotFrequencyYearly:
+		dated = pd.Frequency.Truncate(basisTime, o.WeekStart).Format("2006")
+	case PlotFrequencyQuarterly:
+		start := pd.Frequency.Truncate(basisTime, o.WeekStart)
+		dated = fmt.Sprintf("%s/Q%d", start.Format("2006"), (start.Month()-1)/3+1)
+	case PlotFrequencyMonthly:
+		dated = pd.Frequency.Truncate(basisTime, o.WeekStart).Format("2006/01")
 	case PlotFrequencyWeekly:
-		dated = pd.Frequency.Truncate(basisTime).Format("2006/01/02")
+		dated = pd.Frequency.Truncate(basisTime, o.WeekStart).Format("2006/01/02")
 	case PlotFrequencyDaily:
-		dated = pd.Frequency.Truncate(basisTime).Format("2006/01/02")
+		dated = pd.Frequency.Truncate(basisTime, o.WeekStart).Format("2006/01/02")
 	case PlotFrequencyHourly:
-		dated = pd.Frequency.Truncate(basisTime).Format("2006/01/02/15")
+		dated = pd.Frequency.Truncate(basisTime, o.WeekStart).Format("2006/01/02/15")
 	default:
 		slog.Warn(fmt.Sprintf("unsupported plot frequency: %q", pd.Frequency))
 	}
@@ -66,24 +110,184 @@ func (o *Organizer) Filepath(pd *PlotDef, basisTime time.Time) (string, error) {
 		return "", err
 	}
 
-	return filepath.Join(o.Base, dated, filename), nil
+	return joinLocation(o.Base, dated, filename), nil
 }
 
-func (o *Organizer) Glob(pd *PlotDef, basisTime time.Time) ([]string, error) {
-	var pattern string
-	switch pd.Frequency {
-	case PlotFrequencyWeekly:
-		pattern = "20[0-9][0-9]/[0-9][0-9]/[0-9][0-9]"
-	case PlotFrequencyDaily:
-		pattern = "20[0-9][0-9]/[0-9][0-9]/[0-9][0-9]"
+func (o *Organizer) Glob(ctx context.Context, pd *PlotDef, basisTime time.Time) ([]string, error) {
+	if o.PathTemplate != "" {
+		pattern, err := o.templatedPath(pd, outputPathGlobFields)
+		if err != nil {
+			return nil, err
+		}
+		return o.store().Glob(ctx, pattern)
+	}
+
+	pattern, _ := datedDirLayout(pd.Frequency)
+	if pattern == "" {
+		slog.Warn(fmt.Sprintf("unsupported plot frequency: %q", pd.Frequency))
+	}
+	pattern = joinLocation(o.Base, pattern, pd.Name+o.Format.Extension())
+
+	return o.store().Glob(ctx, pattern)
+}
+
+// VersionsPattern returns a glob pattern matching the output of the plot for
+// every period, which identifies the outputs that are versions of the same
+// plot however the output path is laid out
+func (o *Organizer) VersionsPattern(pd *PlotDef) (string, error) {
+	if o.PathTemplate != "" {
+		return o.templatedPath(pd, outputPathGlobFields)
+	}
+	pattern, _ := datedDirLayout(pd.Frequency)
+	if pattern == "" {
+		return "", fmt.Errorf("unsupported plot frequency: %q", pd.Frequency)
+	}
+	filename, err := o.Filename(pd.Name)
+	if err != nil {
+		return "", err
+	}
+	return joinLocation(o.Base, pattern, globEscape(filename)), nil
+}
+
+// datedDirLayout returns a glob pattern matching the dated directories of
+// plots of a frequency and the time layout of their names. Quarterly
+// directories have no time layout.
+func datedDirLayout(freq PlotFrequency) (string, string) {
+	switch freq {
+	case PlotFrequencyYearly:
+		return "20[0-9][0-9]", "2006"
+	case PlotFrequencyQuarterly:
+		return "20[0-9][0-9]/Q[1-4]", ""
+	case PlotFrequencyMonthly:
+		return "20[0-9][0-9]/[0-9][0-9]", "2006/01"
+	case PlotFrequencyWeekly, PlotFrequencyDaily:
+		return "20[0-9][0-9]/[0-9][0-9]/[0-9][0-9]", "2006/01/02"
 	case PlotFrequencyHourly:
-		pattern = "20[0-9][0-9]/[0-9][0-9]/[0-9][0-9]/[0-9][0-9]"
+		return "20[0-9][0-9]/[0-9][0-9]/[0-9][0-9]/[0-9][0-9]", "2006/01/02/15"
 	default:
-		slog.Warn(fmt.Sprintf("unsupported plot frequency: %q", pd.Frequency))
+		return "", ""
+	}
+}
+
+// A datedOutput is an output of a plot found in the dated layout of the
+// output tree
+type datedOutput struct {
+	Path  string    // relative to the base of the output tree
+	Start time.Time // the start of the period, in UTC
+}
+
+// DatedOutputs finds the outputs of the plot in the dated layout of the
+// output tree by their filename, without consulting any index. Outputs
+// placed by an output path template are not found.
+func (o *Organizer) DatedOutputs(ctx context.Context, pd *PlotDef) ([]datedOutput, error) {
+	if o.PathTemplate != "" {
+		return nil, nil
+	}
+	pattern, layout := datedDirLayout(pd.Frequency)
+	if pattern == "" {
+		return nil, nil
+	}
+	formats := []OutputFormat{o.Format}
+	if o.Format == "" {
+		// without a format the output may have the extension of any of them
+		formats = []OutputFormat{OutputFormatPlotly, OutputFormatCSV, OutputFormatTSV, OutputFormatXLSX, OutputFormatHTML}
+	}
+	var matches []string
+	for _, f := range formats {
+		fo := *o
+		fo.Format = f
+		filename, err := fo.Filename(pd.Name)
+		if err != nil {
+			return nil, err
+		}
+		m, err := o.store().Glob(ctx, joinLocation(o.Base, pattern, globEscape(filename)))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+	outputs := make([]datedOutput, 0, len(matches))
+	for _, m := range matches {
+		rel, err := relLocation(o.Base, m)
+		if err != nil {
+			return nil, err
+		}
+		dir := path.Dir(rel)
+		var start time.Time
+		if layout == "" {
+			var year, quarter int
+			if _, err := fmt.Sscanf(dir, "%4d/Q%1d", &year, &quarter); err != nil {
+				continue
+			}
+			start = time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+		} else if start, err = time.Parse(layout, dir); err != nil {
+			continue
+		}
+		outputs = append(outputs, datedOutput{Path: rel, Start: start})
+	}
+	return outputs, nil
+}
+
+// outputPathGlobFields are the period fields of an output path template as
+// glob patterns that match any period
+var outputPathGlobFields = map[string]string{
+	"Year":     "[0-9][0-9][0-9][0-9]",
+	"Quarter":  "Q[1-4]",
+	"Month":    "[0-9][0-9]",
+	"WeekYear": "[0-9][0-9][0-9][0-9]",
+	"Week":     "[0-9][0-9]",
+	"Day":      "[0-9][0-9]",
+	"Hour":     "[0-9][0-9]",
+}
+
+// periodFields returns the fields of an output path template that identify
+// the period of the plot's frequency containing the basis time. Week is the
+// ISO week number of the start of the period and WeekYear the year it
+// belongs to, which differs from Year around the new year.
+func (o *Organizer) periodFields(pd *PlotDef, basisTime time.Time) map[string]string {
+	t := basisTime
+	if pd.Frequency.Validate() == nil {
+		t = pd.Frequency.Truncate(basisTime, o.WeekStart)
+	}
+	weekYear, week := t.ISOWeek()
+	return map[string]string{
+		"Year":     t.Format("2006"),
+		"Quarter":  fmt.Sprintf("Q%d", (t.Month()-1)/3+1),
+		"Month":    t.Format("01"),
+		"WeekYear": fmt.Sprintf("%04d", weekYear),
+		"Week":     fmt.Sprintf("%02d", week),
+		"Day":      t.Format("02"),
+		"Hour":     t.Format("15"),
+	}
+}
+
+// templatedPath executes the output path template with the name, filename,
+// frequency and parameters of the plot and the given period fields
+func (o *Organizer) templatedPath(pd *PlotDef, fields map[string]string) (string, error) {
+	t, err := template.New("").Parse(o.PathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parsing output path template: %w", err)
 	}
-	pattern = filepath.Join(o.Base, pattern, pd.Name+".json")
 
-	return filepath.Glob(pattern)
+	filename, err := o.Filename(pd.Name)
+	if err != nil {
+		return "", err
+	}
+	data := map[string]any{
+		"Name":      pd.Name,
+		"Filename":  filename,
+		"Frequency": pd.Frequency,
+		"Params":    o.Params,
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("execute output path template: %w", err)
+	}
+	return joinLocation(o.Base, buf.String()), nil
 }
 
 func (o *Organizer) LatestFilepath(pd *PlotDef) (string, error) {
@@ -92,28 +296,69 @@ func (o *Organizer) LatestFilepath(pd *PlotDef) (string, error) {
 		return "", err
 	}
 
-	return filepath.Join(o.Base, "latest", filename), nil
+	return joinLocation(o.Base, latestDir, filename), nil
 }
 
-func (o *Organizer) IsStaleOrMissing(pd *PlotDef, basisTime time.Time, expectedTime time.Time) (bool, error) {
+// IsStaleOrMissing reports whether the plot's output for the basis time
+// needs to be written, because it is missing or older than expectedTime, the
+// time the plot definition was modified. An older output is not stale if the
+// index of its directory records that it was generated from a definition with
+// the same checksum, so touching a definition without changing it, or what
+// its templates render to, does not regenerate it.
+func (o *Organizer) IsStaleOrMissing(ctx context.Context, pd *PlotDef, basisTime time.Time, expectedTime time.Time, checksum string) (bool, error) {
 	fname, err := o.Filepath(pd, basisTime)
 	if err != nil {
 		return false, fmt.Errorf("filepath: %w", err)
 	}
 
-	info, err := os.Lstat(fname)
+	modTime, err := o.store().ModTime(ctx, fname)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return true, nil
 		}
 		return false, fmt.Errorf("stat file: %w", err)
 	}
+	if !modTime.Before(expectedTime) {
+		return false, nil
+	}
+	if checksum == "" {
+		return true, nil
+	}
 
-	return info.ModTime().Before(expectedTime), nil
+	recorded, err := o.recordedChecksum(ctx, fname)
+	if err != nil {
+		return false, fmt.Errorf("read checksum: %w", err)
+	}
+	if recorded == checksum {
+		slog.Debug("plot definition was modified but its checksum is unchanged", "name", pd.Name, "filename", fname)
+		return false, nil
+	}
+	return true, nil
 }
 
-func (o *Organizer) IsLatest(pd *PlotDef, basisTime time.Time) (bool, error) {
-	existing, err := o.Glob(pd, basisTime)
+// recordedChecksum returns the checksum of the plot definition that the
+// output was last generated from, as recorded in the index of its directory,
+// or an empty string if there is none or the last attempt to generate it
+// failed
+func (o *Organizer) recordedChecksum(ctx context.Context, fname string) (string, error) {
+	rel, err := relLocation(o.Base, fname)
+	if err != nil {
+		return "", err
+	}
+	idx, err := readOutputIndex(ctx, o.store(), joinLocation(o.Base, path.Dir(rel), indexFilename))
+	if err != nil {
+		return "", err
+	}
+	for _, e := range idx.Plots {
+		if e.Path == rel && e.Status == BatchStatusGenerated {
+			return e.Checksum, nil
+		}
+	}
+	return "", nil
+}
+
+func (o *Organizer) IsLatest(ctx context.Context, pd *PlotDef, basisTime time.Time) (bool, error) {
+	existing, err := o.Glob(ctx, pd, basisTime)
 	if err != nil {
 		return false, fmt.Errorf("glob: %w", err)
 	}
@@ -129,17 +374,45 @@ func (o *Organizer) IsLatest(pd *PlotDef, basisTime time.Time) (bool, error) {
 	return false, nil
 }
 
-func (o *Organizer) WritePlot(data []byte, pd *PlotDef, basisTime time.Time) error {
-	path, err := o.Filepath(pd, basisTime)
+func (o *Organizer) WritePlot(ctx context.Context, data []byte, pd *PlotDef, basisTime time.Time) error {
+	return o.writeWithSuffix(ctx, data, pd, basisTime, "")
+}
+
+// WriteAlongside writes a file next to the plot's output, and its latest
+// copy, named by replacing the extension of the plot's filename with the
+// suffix.
+func (o *Organizer) WriteAlongside(ctx context.Context, data []byte, pd *PlotDef, basisTime time.Time, suffix string) error {
+	return o.writeWithSuffix(ctx, data, pd, basisTime, suffix)
+}
+
+// WriteProvenance writes the provenance of a plot as json next to the plot's
+// output, and its latest copy
+func (o *Organizer) WriteProvenance(ctx context.Context, pv *PlotProvenance, pd *PlotDef, basisTime time.Time) error {
+	data, err := json.MarshalIndent(pv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal provenance: %w", err)
+	}
+	return o.writeWithSuffix(ctx, append(data, '\n'), pd, basisTime, provenanceSuffix)
+}
+
+func withSuffix(path string, suffix string) string {
+	if suffix == "" {
+		return path
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + suffix
+}
+
+func (o *Organizer) writeWithSuffix(ctx context.Context, data []byte, pd *PlotDef, basisTime time.Time, suffix string) error {
+	dated, err := o.Filepath(pd, basisTime)
 	if err != nil {
 		return err
 	}
 
-	if err := writeOutput(path, data); err != nil {
+	if err := o.store().WriteFile(ctx, withSuffix(dated, suffix), data); err != nil {
 		return fmt.Errorf("write plot: %w", err)
 	}
 
-	isLatest, err := o.IsLatest(pd, basisTime)
+	isLatest, err := o.IsLatest(ctx, pd, basisTime)
 	if err != nil {
 		return fmt.Errorf("is latest: %w", err)
 	}
@@ -147,12 +420,33 @@ func (o *Organizer) WritePlot(data []byte, pd *PlotDef, basisTime time.Time) err
 		return nil
 	}
 
-	path, err = o.LatestFilepath(pd)
+	path, err := o.LatestFilepath(pd)
 	if err != nil {
 		return err
 	}
 
-	if err := writeOutput(path, data); err != nil {
+	if o.LinkLatest {
+		ls, ok := o.store().(LinkStore)
+		if !ok {
+			return fmt.Errorf("link latest: store does not support links")
+		}
+		if err := ls.Symlink(ctx, withSuffix(dated, suffix), withSuffix(path, suffix)); err != nil {
+			return fmt.Errorf("link latest: %w", err)
+		}
+		return nil
+	}
+
+	// another batch run may be writing the same plot for a later basis time,
+	// so the latest file is only replaced if it is not already newer. This
+	// relies on metadata kept by versioned stores such as GCS.
+	err = updateFile(ctx, o.store(), withSuffix(path, suffix), func(_ []byte, meta map[string]string) ([]byte, map[string]string, error) {
+		if existing, err := time.Parse(time.RFC3339, meta[basisTimeMetadataKey]); err == nil && existing.After(basisTime) {
+			slog.Info("not replacing latest plot written for a later basis time", "name", pd.Name, "basis_time", basisTime, "latest_basis_time", existing)
+			return nil, nil, nil
+		}
+		return data, map[string]string{basisTimeMetadataKey: basisTime.Format(time.RFC3339)}, nil
+	})
+	if err != nil {
 		return fmt.Errorf("write latest: %w", err)
 	}
 	return nil
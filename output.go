@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type OutputFormat string
+
+const (
+	OutputFormatPlotly   OutputFormat = "plotly"   // a plotly figure
+	OutputFormatData     OutputFormat = "data"     // the processed datasets and series with chart hints, for rendering with other charting libraries
+	OutputFormatCSV      OutputFormat = "csv"      // a csv file with the series name, label and value of each point of the traces
+	OutputFormatTSV      OutputFormat = "tsv"      // as csv but tab separated
+	OutputFormatXLSX     OutputFormat = "xlsx"     // an excel workbook with a sheet for each dataset and a chart of the series where possible
+	OutputFormatHTML     OutputFormat = "html"     // a self-contained web page that draws the plot
+	OutputFormatVegaLite OutputFormat = "vegalite" // a vega-lite spec that draws the series of the plot
+)
+
+func (f OutputFormat) String() string { return string(f) }
+
+func (f OutputFormat) Validate() error {
+	switch f {
+	case OutputFormatPlotly, OutputFormatData, OutputFormatCSV, OutputFormatTSV, OutputFormatXLSX, OutputFormatHTML, OutputFormatVegaLite:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %q", f)
+	}
+}
+
+// DataOutput holds the data of a plot without any plotly specifics
+type DataOutput struct {
+	Name     string                      `json:"name"`
+	Title    string                      `json:"title,omitempty"`
+	Series   []DataOutputSeries          `json:"series"`
+	DataSets map[string]map[string][]any `json:"datasets"` // the datasets of the plot after computation, keyed by name, as columns keyed by field
+	Quality  []SeriesQuality             `json:"quality,omitempty"`
+	Params   map[string]any              `json:"params,omitempty"`
+}
+
+// DataOutputSeries holds the labels and values of a series after
+// normalization, with hints for how it should be charted
+type DataOutputSeries struct {
+	Name       string     `json:"name"`
+	Type       SeriesType `json:"type"`
+	DataSet    string     `json:"dataset"`
+	Labels     []any      `json:"labels"`
+	Values     []any      `json:"values"`
+	LabelTitle string     `json:"labelTitle,omitempty"`
+	ValueTitle string     `json:"valueTitle,omitempty"`
+	Unit       UnitType   `json:"unit,omitempty"`
+	Color      string     `json:"color,omitempty"`
+	Axis       string     `json:"axis,omitempty"` // the value axis for series not on the primary axis, such as y2
+}
+
+// NewDataOutput collects the data a figure was generated from
+func NewDataOutput(pd *PlotDef, fig *Figure, cfg *PlotConfig) *DataOutput {
+	out := &DataOutput{
+		Name:     pd.Name,
+		Series:   make([]DataOutputSeries, 0, len(fig.Series)),
+		DataSets: make(map[string]map[string][]any, len(fig.DataSets)),
+		Quality:  fig.Quality,
+		Params:   pd.Parameters,
+	}
+	if pd.Layout.Title != nil {
+		out.Title, _ = pd.Layout.Title.Text.(string)
+	}
+
+	for _, ls := range fig.Series {
+		s := ls.SeriesDef
+		out.Series = append(out.Series, DataOutputSeries{
+			Name:       ls.Name,
+			Type:       s.Type,
+			DataSet:    s.DataSet,
+			Labels:     ls.Labels,
+			Values:     ls.Values,
+			LabelTitle: prettyFieldName(s.Labels),
+			ValueTitle: prettyFieldName(s.Values),
+			Unit:       s.EffectiveUnit(),
+			Color:      cfg.MaybeLookupColor(s.Color, ls.Name),
+			Axis:       s.Yaxis,
+		})
+	}
+
+	names := make([]string, 0, len(fig.DataSets))
+	for name := range fig.DataSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// all sources produce static datasets
+		if sds, ok := fig.DataSets[name].(*StaticDataSet); ok {
+			out.DataSets[name] = sds.Data
+		}
+	}
+	return out
+}
+
+// marshalOutput marshals a generated plot in the output format, returning
+// the complete content of the output file. Json outputs are written in
+// canonical form if canonical is set.
+func marshalOutput(format OutputFormat, pd *PlotDef, figDat FigureData, cfg *PlotConfig, compact bool, canonical bool) ([]byte, error) {
+	var v any = figDat
+	switch format {
+	case OutputFormatData:
+		v = NewDataOutput(pd, figDat.Figure, cfg)
+	case OutputFormatCSV:
+		return marshalTraceRows(figDat.Figure, ',')
+	case OutputFormatTSV:
+		return marshalTraceRows(figDat.Figure, '\t')
+	case OutputFormatXLSX:
+		return marshalWorkbook(pd, figDat.Figure)
+	case OutputFormatHTML:
+		return renderHTML(figDat, false)
+	case OutputFormatVegaLite:
+		spec, err := NewVegaLiteSpec(pd, figDat.Figure, cfg)
+		if err != nil {
+			return nil, err
+		}
+		v = spec
+	}
+
+	return marshalJSONOutput(v, compact, canonical)
+}
+
+// marshalJSONOutput marshals a json output, pretty-printed unless compact,
+// and in canonical form if asked for
+func marshalJSONOutput(v any, compact bool, canonical bool) ([]byte, error) {
+	var data []byte
+	var err error
+	if compact || canonical {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if canonical {
+		data, err = canonicalJSON(data, compact)
+		if err != nil {
+			return nil, fmt.Errorf("canonicalize output: %w", err)
+		}
+	}
+	return append(data, '\n'), nil
+}
+
+// IsJSON reports whether outputs in the format are json documents
+func (f OutputFormat) IsJSON() bool {
+	return f == OutputFormatPlotly || f == OutputFormatData
+}
+
+// Extension returns the filename extension of outputs in the format
+func (f OutputFormat) Extension() string {
+	switch f {
+	case OutputFormatCSV:
+		return ".csv"
+	case OutputFormatTSV:
+		return ".tsv"
+	case OutputFormatXLSX:
+		return ".xlsx"
+	case OutputFormatHTML:
+		return ".html"
+	default:
+		return ".json"
+	}
+}
+
+// ContentType returns the media type of outputs in the format
+func (f OutputFormat) ContentType() string {
+	switch f {
+	case OutputFormatCSV:
+		return "text/csv; charset=utf-8"
+	case OutputFormatTSV:
+		return "text/tab-separated-values; charset=utf-8"
+	case OutputFormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case OutputFormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// marshalTraceRows writes a row with the series name, label and value of
+// each point of the traces of the figure, separated by comma. Heatmap points
+// are labelled by their x and y labels joined by a slash and the values of
+// traces without labels, such as box plots, have an empty label.
+func marshalTraceRows(fig *Figure, comma rune) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	w.Comma = comma
+	if err := w.Write([]string{"series", "label", "value"}); err != nil {
+		return nil, err
+	}
+
+	for i, trace := range fig.Data {
+		// traces are read generically from their json form since each trace
+		// type holds its data in different attributes
+		raw, err := json.Marshal(trace)
+		if err != nil {
+			return nil, fmt.Errorf("marshal trace %d: %w", i, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var t map[string]any
+		if err := dec.Decode(&t); err != nil {
+			return nil, fmt.Errorf("unmarshal trace %d: %w", i, err)
+		}
+
+		name := csvValue(t["name"])
+		write := func(label, value any) error {
+			return w.Write([]string{name, csvValue(label), csvValue(value)})
+		}
+
+		switch {
+		case t["z"] != nil && t["locations"] != nil:
+			locations, values := anySlice(t["locations"]), anySlice(t["z"])
+			for j := range locations {
+				if err := write(locations[j], index(values, j)); err != nil {
+					return nil, err
+				}
+			}
+		case t["z"] != nil:
+			xs, ys := anySlice(t["x"]), anySlice(t["y"])
+			for j, row := range anySlice(t["z"]) {
+				for k, value := range anySlice(row) {
+					if err := write(csvValue(index(xs, k))+"/"+csvValue(index(ys, j)), value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case t["labels"] != nil:
+			labels, values := anySlice(t["labels"]), anySlice(t["values"])
+			for j := range labels {
+				if err := write(labels[j], index(values, j)); err != nil {
+					return nil, err
+				}
+			}
+		case t["value"] != nil:
+			if err := write(nil, t["value"]); err != nil {
+				return nil, err
+			}
+		default:
+			labels, values := anySlice(t["x"]), anySlice(t["y"])
+			if t["orientation"] == "h" || t["y"] == nil {
+				labels, values = values, labels
+			}
+			if len(labels) == 0 {
+				// box traces hold only the values of the series
+				for _, value := range values {
+					if err := write(nil, value); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			for j := range labels {
+				if err := write(labels[j], index(values, j)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func anySlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func index(s []any, i int) any {
+	if i < len(s) {
+		return s[i]
+	}
+	return nil
+}
+
+func csvValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
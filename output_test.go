@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+func TestMarshalTraceRows(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace grob.Trace
+		want  string
+	}{
+		{
+			name:  "bar",
+			trace: &grob.Bar{Type: grob.TraceTypeBar, Name: "b", X: []any{"a", "b"}, Y: []any{1, 2}},
+			want:  "series,label,value\nb,a,1\nb,b,2\n",
+		},
+		{
+			name:  "horizontal bar",
+			trace: &grob.Bar{Type: grob.TraceTypeBar, Name: "b", X: []any{1, 2}, Y: []any{"a", "b"}, Orientation: grob.BarOrientationH},
+			want:  "series,label,value\nb,a,1\nb,b,2\n",
+		},
+		{
+			name:  "box",
+			trace: &grob.Box{Type: grob.TraceTypeBox, Name: "b", Y: []any{1, 2, 3}},
+			want:  "series,label,value\nb,,1\nb,,2\nb,,3\n",
+		},
+		{
+			name:  "hbox",
+			trace: &grob.Box{Type: grob.TraceTypeBox, Name: "b", X: []any{1, 2, 3}},
+			want:  "series,label,value\nb,,1\nb,,2\nb,,3\n",
+		},
+		{
+			name:  "hbox with orientation",
+			trace: &grob.Box{Type: grob.TraceTypeBox, Name: "b", X: []any{1, 2}, Orientation: grob.BoxOrientationH},
+			want:  "series,label,value\nb,,1\nb,,2\n",
+		},
+		{
+			name:  "heatmap",
+			trace: &grob.Heatmap{Type: grob.TraceTypeHeatmap, Name: "h", X: []any{"a", "b"}, Y: []any{"c"}, Z: [][]any{{1, 2}}},
+			want:  "series,label,value\nh,a/c,1\nh,b/c,2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fig := &Figure{Fig: &grob.Fig{Data: grob.Traces{tt.trace}}}
+			got, err := marshalTraceRows(fig, ',')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"time"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+type PartialPeriodType string
+
+const (
+	PartialPeriodShade PartialPeriodType = "shade" // shade the incomplete final period of time series
+	PartialPeriodNone  PartialPeriodType = "none"  // do not mark the incomplete final period
+)
+
+// partialPeriodStarts returns the start of the final period of each time
+// series that has not ended by the basis time, without duplicates. The period
+// of a series is its expected interval, or the frequency of the plot if it
+// has none. Series whose labels are not all times are ignored.
+func partialPeriodStarts(pd *PlotDef, series []*LabeledSeries, basisTime time.Time, weekStart WeekStart) []time.Time {
+	seen := make(map[time.Time]bool)
+	var starts []time.Time
+	for _, ls := range series {
+		s := ls.SeriesDef
+		if s.Type == SeriesTypeChoropleth || s.ValueAxis() == "x" || len(ls.Labels) == 0 {
+			continue
+		}
+
+		// the period of the points is the expected interval of the series,
+		// or the calendar period of the plot's frequency
+		var period func(t time.Time) (start, end time.Time)
+		if s.ExpectedInterval != "" {
+			interval, err := parseInterval(s.ExpectedInterval)
+			if err != nil || interval == 0 {
+				continue
+			}
+			period = func(t time.Time) (time.Time, time.Time) {
+				return t.Truncate(interval), t.Truncate(interval).Add(interval)
+			}
+		} else if pd.Frequency.Validate() == nil {
+			period = func(t time.Time) (time.Time, time.Time) {
+				return pd.Frequency.Truncate(t, weekStart), pd.Frequency.Next(t, weekStart)
+			}
+		} else {
+			continue
+		}
+
+		var latest time.Time
+		timeLabels := true
+		for _, l := range ls.Labels {
+			t, ok := toTime(l)
+			if !ok {
+				timeLabels = false
+				break
+			}
+			if t.After(latest) {
+				latest = t
+			}
+		}
+		if !timeLabels {
+			continue
+		}
+
+		start, end := period(latest.In(basisTime.Location()))
+		if !end.After(basisTime) || seen[start] {
+			continue
+		}
+		seen[start] = true
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// markPartialPeriods shades the x axis from the start of each incomplete
+// final period to the basis time so that the lower values of a period that
+// is still being filled are not mistaken for a drop
+func markPartialPeriods(layout *grob.Layout, pd *PlotDef, series []*LabeledSeries, basisTime time.Time, weekStart WeekStart) {
+	if pd.PartialPeriod == PartialPeriodNone {
+		return
+	}
+	starts := partialPeriodStarts(pd, series, basisTime, weekStart)
+	if len(starts) == 0 {
+		return
+	}
+
+	shapes := make([]any, 0, len(starts))
+	for _, start := range starts {
+		shapes = append(shapes, xRegion(start, basisTime.UTC().Truncate(time.Second), "rgba(128,128,128,0.15)"))
+	}
+	addShapes(layout, shapes)
+}
+
+// xRegion returns a plotly shape that shades the full height of the plot
+// between two values of the x axis
+func xRegion(x0, x1 any, color string) map[string]any {
+	return map[string]any{
+		"type":      "rect",
+		"xref":      "x",
+		"yref":      "paper",
+		"x0":        x0,
+		"x1":        x1,
+		"y0":        0,
+		"y1":        1,
+		"fillcolor": color,
+		"line":      map[string]any{"width": 0},
+		"layer":     "below",
+	}
+}
+
+// addShapes appends shapes to those of the layout, unless the layout has
+// shapes in a form that cannot be appended to
+func addShapes(layout *grob.Layout, shapes []any) {
+	if layout.Shapes == nil {
+		layout.Shapes = shapes
+		return
+	}
+	if existing, ok := layout.Shapes.([]any); ok {
+		layout.Shapes = append(existing, shapes...)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A PlotDefError is a problem with a plot definition that can be traced to a
+// position in its yaml source. Positions are in the file as written when the
+// value can be found there, otherwise they are in the definition after
+// templating and Templated is set.
+type PlotDefError struct {
+	Filename  string
+	Line      int
+	Column    int
+	Templated bool
+	Err       error
+}
+
+func (e *PlotDefError) Error() string {
+	pos := fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	if e.Templated {
+		pos += " after templating"
+	}
+	if e.Filename != "" {
+		return fmt.Sprintf("%s: %s: %v", e.Filename, pos, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", pos, e.Err)
+}
+
+func (e *PlotDefError) Unwrap() error { return e.Err }
+
+// plotDefLocator finds the positions of the values of a plot definition in the
+// yaml document it was decoded from. Source is the same document if the
+// definition was not templated. Otherwise it is the document as written, and
+// positions are looked up there first, or nil if it is not valid yaml before
+// templating.
+type plotDefLocator struct {
+	filename string
+	doc      *yaml.Node
+	source   *yaml.Node
+}
+
+// find returns the node at a path of mapping keys and sequence indexes in a
+// document, and whether the full path is in the document. When it is not,
+// such as for a field that was left out, the deepest node along the path is
+// returned. Aliases and merge keys are followed, so a value that came from an
+// anchor is found where the anchor was defined.
+func (l *plotDefLocator) find(doc *yaml.Node, path ...any) (*yaml.Node, bool) {
+	n := resolveYAMLAlias(doc)
+	if n != nil && n.Kind == yaml.DocumentNode {
+		if len(n.Content) == 0 {
+			return nil, false
+		}
+		n = resolveYAMLAlias(n.Content[0])
+	}
+	for _, elem := range path {
+		var next *yaml.Node
+		switch elem := elem.(type) {
+		case string:
+			next = yamlMappingValue(n, elem)
+		case int:
+			if n.Kind == yaml.SequenceNode && elem >= 0 && elem < len(n.Content) {
+				next = resolveYAMLAlias(n.Content[elem])
+			}
+		}
+		if next == nil {
+			return n, false
+		}
+		n = next
+	}
+	return n, true
+}
+
+// errorAt attaches the position of the value at path to err
+func (l *plotDefLocator) errorAt(err error, path ...any) error {
+	n, complete := l.find(l.doc, path...)
+	if n == nil || n.Line == 0 {
+		return err
+	}
+	if l.source == l.doc {
+		return &PlotDefError{Filename: l.filename, Line: n.Line, Column: n.Column, Err: err}
+	}
+	if complete && l.source != nil {
+		// templates can add or remove items, so the value in the source
+		// is only used if it is the one that was templated
+		if sn, ok := l.find(l.source, path...); ok && sn.Line != 0 && sameTemplatedNode(sn, n) {
+			return &PlotDefError{Filename: l.filename, Line: sn.Line, Column: sn.Column, Err: err}
+		}
+	}
+	return &PlotDefError{Filename: l.filename, Line: n.Line, Column: n.Column, Templated: true, Err: err}
+}
+
+// sameTemplatedNode reports whether a node of the source of a definition is
+// likely to be the one that templating turned into a node of the templated
+// definition: the same kind of node and, for scalars, the same value or one
+// containing a template action
+func sameTemplatedNode(source, templated *yaml.Node) bool {
+	if source.Kind != templated.Kind {
+		return false
+	}
+	if source.Kind != yaml.ScalarNode {
+		return true
+	}
+	return source.Value == templated.Value || strings.Contains(source.Value, "{{")
+}
+
+// yamlMappingValue returns the value of a key in a mapping node. Keys written
+// in the mapping take precedence over those merged in with <<, and earlier
+// merged mappings over later ones, as when the document is decoded.
+func yamlMappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if isYAMLMergeKey(k) {
+			v = resolveYAMLAlias(v)
+			if v.Kind == yaml.SequenceNode {
+				merged = append(merged, v.Content...)
+			} else {
+				merged = append(merged, v)
+			}
+			continue
+		}
+		if k.Kind == yaml.ScalarNode && k.Value == key {
+			return resolveYAMLAlias(v)
+		}
+	}
+	for _, m := range merged {
+		if v := yamlMappingValue(resolveYAMLAlias(m), key); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func resolveYAMLAlias(n *yaml.Node) *yaml.Node {
+	if n != nil && n.Kind == yaml.AliasNode && n.Alias != nil {
+		return n.Alias
+	}
+	return n
+}
+
+func isYAMLMergeKey(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!merge"
+}
+
+// yamlFeatures counts the anchors, aliases and merge keys in a yaml document.
+// They are valid yaml but easy to misread in a large plot definition, and not
+// every tool that reads the definitions supports merge keys.
+func yamlFeatures(n *yaml.Node) (anchors, aliases, merges int) {
+	if n == nil {
+		return 0, 0, 0
+	}
+	if n.Anchor != "" {
+		anchors++
+	}
+	if n.Kind == yaml.AliasNode {
+		// the aliased node is counted where it is defined
+		return anchors, 1, 0
+	}
+	for i, c := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 && isYAMLMergeKey(c) {
+			merges++
+		}
+		an, al, m := yamlFeatures(c)
+		anchors += an
+		aliases += al
+		merges += m
+	}
+	return anchors, aliases, merges
+}
+
+// checkDataSetRefs checks that every dataset named by the series, scalars,
+// tables, computed datasets, events and precondition of a plot is one of its
+// datasets or computed datasets
+func checkDataSetRefs(pd *PlotDef, loc *plotDefLocator) error {
+	defined := make(map[string]bool, len(pd.Datasets)+len(pd.Computed))
+	for _, ds := range pd.Datasets {
+		defined[ds.Name] = true
+	}
+	for _, c := range pd.Computed {
+		defined[c.Name] = true
+	}
+
+	check := func(name string, what string, path ...any) error {
+		if defined[name] {
+			return nil
+		}
+		if name == "" {
+			return loc.errorAt(fmt.Errorf("%s has no dataset", what), path[:len(path)-1]...)
+		}
+		return loc.errorAt(fmt.Errorf("unknown dataset in %s: %q", what, name), path...)
+	}
+
+	for i, s := range pd.Series {
+		if err := check(s.DataSet, fmt.Sprintf("series %d", i), "series", i, "dataset"); err != nil {
+			return err
+		}
+	}
+	for i, s := range pd.Scalars {
+		what := fmt.Sprintf("scalar %d", i)
+		if err := check(s.DataSet, what, "scalars", i, "dataset"); err != nil {
+			return err
+		}
+		if s.DeltaDataSet != "" {
+			if err := check(s.DeltaDataSet, what, "scalars", i, "deltaDataset"); err != nil {
+				return err
+			}
+		}
+		if s.TrendDataSet != "" {
+			if err := check(s.TrendDataSet, what, "scalars", i, "trendDataset"); err != nil {
+				return err
+			}
+		}
+	}
+	for i, t := range pd.Tables {
+		if err := check(t.DataSet, fmt.Sprintf("table %d", i), "tables", i, "dataset"); err != nil {
+			return err
+		}
+	}
+	for i, c := range pd.Computed {
+		for j, ds := range c.DataSets {
+			if err := check(ds.DataSet, fmt.Sprintf("computed dataset %q", c.Name), "computed", i, "datasets", j, "dataset"); err != nil {
+				return err
+			}
+		}
+	}
+	for i, e := range pd.Events {
+		if err := check(e.DataSet, fmt.Sprintf("events %d", i), "events", i, "dataset"); err != nil {
+			return err
+		}
+	}
+	if pd.Precondition != nil {
+		if err := check(pd.Precondition.DataSet, "precondition", "precondition", "dataset"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestPlotDefErrorPosition(t *testing.T) {
+	// the range adds lines to the query, so the series are further down
+	// after templating than in the file
+	source := `name: p
+datasets:
+  - name: a
+    source: static
+    query: |
+      {{- range $i := list 1 2 3 }}
+      {{ $i }}
+      {{- end }}
+series:
+  - type: pie
+    dataset: a
+`
+	templated := strings.Replace(source, "      {{- range $i := list 1 2 3 }}\n      {{ $i }}\n      {{- end }}\n", "      1\n      2\n      3\n      4\n", 1)
+
+	tests := []struct {
+		name      string
+		source    string
+		content   string
+		want      string
+		templated bool
+	}{
+		{
+			name:    "untemplated",
+			content: templated,
+			want:    "p.yaml: line 11, column 11: unknown series type: \"pie\"",
+		},
+		{
+			name:    "position in source",
+			source:  source,
+			content: templated,
+			want:    "p.yaml: line 10, column 11: unknown series type: \"pie\"",
+		},
+		{
+			name:      "source is not yaml",
+			source:    "{{ if true }}\n" + templated + "{{ end }}\n",
+			content:   templated,
+			want:      "p.yaml: line 11, column 11 after templating: unknown series type: \"pie\"",
+			templated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var source []byte
+			if tt.source != "" {
+				source = []byte(tt.source)
+			}
+			_, err := parsePlotDef("p.yaml", source, []byte(tt.content))
+			var pde *PlotDefError
+			if !errors.As(err, &pde) {
+				t.Fatalf("got error %v, want a PlotDefError", err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got %q, want %q", err.Error(), tt.want)
+			}
+			if pde.Templated != tt.templated {
+				t.Errorf("got templated %v, want %v", pde.Templated, tt.templated)
+			}
+		})
+	}
+}
+
+func FuzzParsePlotDef(f *testing.F) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard)))
+
+	seeds := []string{
+		"name: p\ndatasets:\n  - name: a\n    source: static\n    query: '{\"x\":[1],\"y\":[2]}'\nseries:\n  - type: bar\n    dataset: a\n    labels: x\n    values: y\n",
+		"series:\n  - &s {type: line, dataset: a}\n  - <<: *s\n    type: pie\n",
+		"apiVersion: ashby/v0\nname: p\n",
+		"{\"name\": \"p\", \"scalars\": [{\"type\": \"gauge\", \"dataset\": \"b\"}]}",
+		"computed:\n  - name: c\n    function: sum\n    datasets: [{dataset: x}]\n",
+		"",
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s), []byte(s))
+		f.Add([]byte("{{ if true }}\n"+s+"{{ end }}\n"), []byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, source []byte, content []byte) {
+		_, err := parsePlotDef("fuzz.yaml", source, content)
+		var pde *PlotDefError
+		if errors.As(err, &pde) && (pde.Line <= 0 || pde.Column <= 0) {
+			t.Errorf("error has no position: %v", err)
+		}
+	})
+}
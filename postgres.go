@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -10,6 +13,7 @@ import (
 
 type PgDataSource struct {
 	connstr  string
+	dialect  Dialect // the dialect of the database, which may speak the postgres protocol without being postgres
 	poolOnce sync.Once
 	err      error
 	pool     *pgxpool.Pool
@@ -21,7 +25,47 @@ func NewPgDataSource(connstr string) *PgDataSource {
 	}
 }
 
+func (p *PgDataSource) SQLDialect() Dialect {
+	if p.dialect == "" {
+		return DialectPostgres
+	}
+	return p.dialect
+}
+
 func (p *PgDataSource) GetDataSet(ctx context.Context, query string, params ...any) (DataSet, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %w", err)
+	}
+
+	data := make(map[string][]any)
+	fds := rows.FieldDescriptions()
+	for rows.Next() {
+		vals, err := rows.Values()
+		if err != nil {
+			return nil, fmt.Errorf("read row values: %w", err)
+		}
+
+		for i, fd := range fds {
+			data[fd.Name] = append(data[fd.Name], vals[i])
+		}
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("collect rows: %w", rows.Err())
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// acquire connects to the database on first use and returns a connection from
+// the pool
+func (p *PgDataSource) acquire(ctx context.Context) (*pgxpool.Conn, error) {
 	p.poolOnce.Do(func() {
 		conf, err := pgxpool.ParseConfig(p.connstr)
 		if err != nil {
@@ -40,33 +84,107 @@ func (p *PgDataSource) GetDataSet(ctx context.Context, query string, params ...a
 		return nil, p.err
 	}
 
+	// failures to acquire a connection may be transient, such as while the
+	// database restarts, so they are not cached for later calls
 	conn, err := p.pool.Acquire(ctx)
 	if err != nil {
-		p.err = fmt.Errorf("unable to connect to database: %w", err)
-		return nil, fmt.Errorf("execute query: %w", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	return conn, nil
+}
+
+// Ping connects to the database and checks that it responds
+func (p *PgDataSource) Ping(ctx context.Context) error {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return err
 	}
 	defer conn.Release()
+	return conn.Ping(ctx)
+}
 
-	rows, err := conn.Query(ctx, query, params...)
+// ExplainQuery plans a query with EXPLAIN, without running it, and prepares
+// it to find the fields of its result
+func (p *PgDataSource) ExplainQuery(ctx context.Context, query string) (*QueryPlan, error) {
+	conn, err := p.acquire(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("execute query: %w", err)
+		return nil, err
 	}
+	defer conn.Release()
 
-	data := make(map[string][]any)
-	fds := rows.FieldDescriptions()
-	for rows.Next() {
-		vals, err := rows.Values()
-		if err != nil {
-			return nil, fmt.Errorf("read row values: %w", err)
-		}
+	sd, err := conn.Conn().Prepare(ctx, "", query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare query: %w", err)
+	}
 
-		for i, fd := range fds {
-			data[fd.Name] = append(data[fd.Name], vals[i])
-		}
+	var explained []byte
+	if err := conn.QueryRow(ctx, "EXPLAIN (VERBOSE, FORMAT JSON) "+query).Scan(&explained); err != nil {
+		return nil, fmt.Errorf("explain query: %w", err)
 	}
-	if rows.Err() != nil {
-		return nil, fmt.Errorf("collect rows: %w", rows.Err())
+	plan, err := parsePgPlan(explained)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range sd.Fields {
+		plan.Fields = append(plan.Fields, fd.Name)
 	}
+	return plan, nil
+}
 
-	return NewStaticDataSet(data), nil
+// pgPlanNode is a node of a plan output by EXPLAIN (VERBOSE, FORMAT JSON)
+type pgPlanNode struct {
+	Relation  string       `json:"Relation Name"`
+	Schema    string       `json:"Schema"`
+	Alias     string       `json:"Alias"`
+	TotalCost float64      `json:"Total Cost"`
+	PlanRows  float64      `json:"Plan Rows"`
+	Output    []string     `json:"Output"`
+	Plans     []pgPlanNode `json:"Plans"`
+}
+
+// parsePgPlan summarises a plan output by EXPLAIN (VERBOSE, FORMAT JSON). The
+// columns read by the query are the outputs of the nodes that scan tables.
+func parsePgPlan(explained []byte) (*QueryPlan, error) {
+	var doc []struct {
+		Plan pgPlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(explained, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal query plan: %w", err)
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("empty query plan")
+	}
+
+	plan := &QueryPlan{
+		Cost: doc[0].Plan.TotalCost,
+		Rows: doc[0].Plan.PlanRows,
+	}
+	seen := map[string]bool{}
+	var walk func(n *pgPlanNode)
+	walk = func(n *pgPlanNode) {
+		if n.Relation != "" {
+			table := n.Relation
+			if n.Schema != "" {
+				table = n.Schema + "." + table
+			}
+			for _, out := range n.Output {
+				// outputs of scans are qualified by the alias of the table
+				col := strings.TrimPrefix(out, n.Alias+".")
+				if (col == out && n.Alias != "") || strings.ContainsAny(col, " ()") {
+					// an expression rather than a column
+					continue
+				}
+				if name := table + "." + col; !seen[name] {
+					seen[name] = true
+					plan.Columns = append(plan.Columns, name)
+				}
+			}
+		}
+		for i := range n.Plans {
+			walk(&n.Plans[i])
+		}
+	}
+	walk(&doc[0].Plan)
+	sort.Strings(plan.Columns)
+	return plan, nil
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPreconditionNotMet is returned when generating a plot whose
+// precondition does not hold
+var ErrPreconditionNotMet = errors.New("precondition not met")
+
+// PreconditionDef is a condition that must hold for a plot to be generated,
+// such as a weekly event having occurred. Plots whose precondition does not
+// hold are skipped rather than showing a misleading figure.
+type PreconditionDef struct {
+	DataSet    string `yaml:"dataset"`    // the name of a dataset or computed dataset of the plot
+	Expression string `yaml:"expression"` // an expression over the fields of the first row of the dataset, such as events > 0. The precondition holds if it is non-zero.
+}
+
+// preconditionDataSets returns the names of the datasets needed to check the
+// precondition: its dataset and, if that is computed, the datasets it is
+// computed from. Computed must be in dependency order.
+func preconditionDataSets(pc *PreconditionDef, computed []ComputedDef) map[string]bool {
+	needed := map[string]bool{pc.DataSet: true}
+	for i := len(computed) - 1; i >= 0; i-- {
+		if !needed[computed[i].Name] {
+			continue
+		}
+		for _, ds := range computed[i].DataSets {
+			needed[ds.DataSet] = true
+		}
+	}
+	return needed
+}
+
+// checkPrecondition evaluates the precondition against the first row of its
+// dataset. An empty dataset or a null result does not meet the precondition.
+func checkPrecondition(pc *PreconditionDef, dataSets map[string]DataSet) error {
+	ds, ok := dataSets[pc.DataSet]
+	if !ok {
+		return fmt.Errorf("unknown precondition dataset: %q", pc.DataSet)
+	}
+	expr, err := ParseExpr(pc.Expression)
+	if err != nil {
+		return fmt.Errorf("precondition: %w", err)
+	}
+
+	ds.ResetIterator()
+	if !ds.Next() {
+		if ds.Err() != nil {
+			return fmt.Errorf("precondition dataset iteration ended with an error: %w", ds.Err())
+		}
+		return fmt.Errorf("%w: dataset %q has no rows", ErrPreconditionNotMet, pc.DataSet)
+	}
+
+	var fieldErr error
+	v, ok := expr.Eval(func(field string) (float64, bool) {
+		value := ds.Field(field)
+		if err, ok := value.(error); ok {
+			fieldErr = fmt.Errorf("did not get field value %q from dataset %q: %w", field, pc.DataSet, err)
+			return 0, false
+		}
+		if b, ok := value.(bool); ok {
+			return boolValue(b), true
+		}
+		return toFloat64(value)
+	})
+	if fieldErr != nil {
+		return fieldErr
+	}
+	if !ok || v == 0 {
+		return fmt.Errorf("%w: %s", ErrPreconditionNotMet, pc.Expression)
+	}
+	return nil
+}
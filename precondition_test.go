@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreconditionCheckedFirst(t *testing.T) {
+	// the peers dataset names a static dataset that does not exist, so it
+	// fails if it is fetched
+	def := `name: p
+frequency: weekly
+precondition:
+  dataset: held
+  expression: value > 0
+datasets:
+  - name: events
+    source: static
+    query: '{"x": [1], "y": [%s]}'
+  - name: peers
+    source: static
+    query: missing
+computed:
+  - name: held
+    function: expr
+    expression: y * 2
+    datasets:
+      - dataset: events
+        joinField: x
+series:
+  - type: bar
+    dataset: peers
+    labels: x
+    values: y
+`
+	cfg := &PlotConfig{
+		BasisTime: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		Sources:   map[string]DataSource{"static": &StaticDataSource{}},
+	}
+
+	tests := []struct {
+		name    string
+		events  string
+		notMet  bool
+		wantErr bool
+	}{
+		{name: "not met", events: "0", notMet: true},
+		{name: "met", events: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd, err := parsePlotDef("p.yaml", nil, []byte(strings.Replace(def, "%s", tt.events, 1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = generateFig(context.Background(), pd, cfg, nil)
+			if got := errors.Is(err, ErrPreconditionNotMet); got != tt.notMet {
+				t.Errorf("got error %v, want precondition not met %v", err, tt.notMet)
+			}
+			if tt.wantErr && err == nil {
+				t.Errorf("got no error, want the peers dataset to fail")
+			}
+		})
+	}
+}
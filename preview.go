@@ -10,22 +10,48 @@ import (
 )
 
 func preview(fig FigureData) error {
+	page, err := renderHTML(fig, true)
+	if err != nil {
+		return err
+	}
+	return browser.OpenReader(bytes.NewReader(page))
+}
+
+// renderHTML renders a page that draws the figure using plotly.js. If editor
+// is true the page also has an editor for the figure's json.
+func renderHTML(fig FigureData, editor bool) ([]byte, error) {
+	return renderPage(fig, editor, false)
+}
+
+// renderPage renders the page of renderHTML. With watch, the page polls the
+// server it was loaded from for new versions of the figure, see watchPlot.
+// The figure may also be json that was already marshalled, as read by render.
+func renderPage(fig any, editor bool, watch bool) ([]byte, error) {
 	figBytes, err := json.Marshal(fig)
 	if err != nil {
-		return fmt.Errorf("marshal fig: %w", err)
+		return nil, fmt.Errorf("marshal fig: %w", err)
 	}
 
 	tmpl, err := template.New("plotly").Parse(baseHtml)
 	if err != nil {
-		return fmt.Errorf("parse plotly html: %w", err)
+		return nil, fmt.Errorf("parse plotly html: %w", err)
 	}
 
-	buf := &bytes.Buffer{}
-	if err = tmpl.Execute(buf, string(figBytes)); err != nil {
-		return fmt.Errorf("html template: %w", err)
+	data := struct {
+		Figure string
+		Editor bool
+		Watch  bool
+	}{
+		Figure: string(figBytes),
+		Editor: editor,
+		Watch:  watch,
 	}
 
-	return browser.OpenReader(buf)
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("html template: %w", err)
+	}
+	return buf.Bytes(), nil
 }
 
 var baseHtml = `
@@ -35,12 +61,18 @@ var baseHtml = `
       <style id="plotly.js-style-global"></style>
       <style id="plotly.js-style-modebar-2e7662"></style>
 
+{{- if .Editor }}
       <link href="https://cdnjs.cloudflare.com/ajax/libs/jsoneditor/9.10.0/jsoneditor.css" rel="stylesheet" type="text/css">
       <script src="https://cdnjs.cloudflare.com/ajax/libs/jsoneditor/9.10.0/jsoneditor.min.js"></script>
       <script src="https://cdnjs.cloudflare.com/ajax/libs/js-yaml/4.1.0/js-yaml.min.js"></script>
+{{- end }}
    </head>
    <body>
+{{- if .Watch }}
+      <pre id="watch-error" style="color: #c00;"></pre>
+{{- end }}
       <div id="plot" class="js-plotly-plot"></div>
+{{- if .Editor }}
       <div>
          <p>
             <button onclick="updatePlot();">Update</button>
@@ -48,11 +80,13 @@ var baseHtml = `
          </p>
          <div id="jsoneditor" style="width: 100%; height: 800px;"></div>
       </div>
+{{- end }}
       
       <script>
-        const data = JSON.parse('{{ . }}')
+        const data = JSON.parse('{{ .Figure }}')
          
         Plotly.newPlot('plot', data);
+{{- if .Editor }}
 
         const container = document.getElementById("jsoneditor");
         const editor = new JSONEditor(container, { mode: 'code' }, data);
@@ -76,6 +110,31 @@ var baseHtml = `
                 container.innerText = "Copy layout yaml";
             }, 1000);
         }
+{{- end }}
+{{- if .Watch }}
+
+        let version = 0;
+        const watchError = document.getElementById("watch-error");
+        setInterval(async function() {
+            try {
+                const resp = await fetch("/figure");
+                const update = await resp.json();
+                if (update.version === version) {
+                    return;
+                }
+                version = update.version;
+                watchError.innerText = update.error || "";
+                if (update.figure) {
+                    Plotly.react('plot', update.figure);
+{{- if .Editor }}
+                    editor.set(update.figure);
+{{- end }}
+                }
+            } catch (e) {
+                watchError.innerText = "lost connection to ashby: " + e;
+            }
+        }, 1000);
+{{- end }}
       </script>
    </body>
 </html>
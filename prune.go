@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var pruneCommand = &cli.Command{
+	Name:   "prune",
+	Usage:  "Delete dated plot outputs that are older than the retention for their frequency",
+	Action: Prune,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "out",
+			Required:    true,
+			Usage:       "Path of the output tree written by batch, or an s3 or gcs url of the form s3://bucket/prefix or gs://bucket/prefix.",
+			Destination: &pruneOpts.outDir,
+			EnvVars:     []string{envPrefix + "OUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "s3-path-style",
+			Required:    false,
+			Usage:       "Address S3 buckets in the path of request urls rather than the hostname, as needed by some S3 compatible stores.",
+			Destination: &pruneOpts.s3PathStyle,
+			EnvVars:     []string{envPrefix + "S3_PATH_STYLE"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "retain",
+			Required:    true,
+			Usage:       "How long to keep the outputs of plots of a frequency, in the format frequency=interval, such as daily=90d. May be repeated for each frequency. Outputs of frequencies without a retention are kept.",
+			Destination: &pruneOpts.retain,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Directory containing the processing profiles written by batch. The dated outputs of their plot definitions are found in the tree and pruned even if they are missing from the index.",
+			Destination: &pruneOpts.confDir,
+			EnvVars:     []string{envPrefix + "CONF"},
+		},
+		&cli.BoolFlag{
+			Name:        "dry-run",
+			Required:    false,
+			Usage:       "Report the outputs that would be deleted without deleting them.",
+			Destination: &pruneOpts.dryRun,
+		},
+	}, loggingFlags...),
+}
+
+var pruneOpts struct {
+	outDir      string
+	s3PathStyle bool
+	retain      cli.StringSlice
+	confDir     string
+	dryRun      bool
+}
+
+func Prune(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	retention, err := parseRetentionOptions(pruneOpts.retain.Value())
+	if err != nil {
+		return err
+	}
+
+	store, base, err := newOutputStore(ctx, pruneOpts.outDir, pruneOpts.s3PathStyle)
+	if err != nil {
+		return err
+	}
+
+	idx, err := readOutputIndex(ctx, store, joinLocation(base, indexFilename))
+	if err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+	if pruneOpts.confDir != "" {
+		unindexed, err := unindexedOutputs(ctx, store, base, pruneOpts.confDir, idx)
+		if err != nil {
+			return err
+		}
+		idx.Plots = append(idx.Plots, unindexed...)
+	} else if len(idx.Plots) == 0 {
+		slog.Warn("the output tree has no index, use --conf to find outputs through the plot definitions")
+	}
+
+	expired := expiredOutputs(idx, retention, time.Now())
+	if len(expired) == 0 {
+		slog.Info("no outputs to prune")
+		return nil
+	}
+
+	files, err := prunedFiles(ctx, store, base, idx, expired)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range expired {
+		slog.Info("pruning output", "path", e.Path, "frequency", e.Frequency, "basis_time", e.BasisTime, "dry_run", pruneOpts.dryRun)
+	}
+	if pruneOpts.dryRun {
+		for _, f := range files {
+			fmt.Println(f)
+		}
+		return nil
+	}
+
+	for _, f := range files {
+		if err := store.DeleteFile(ctx, f); err != nil {
+			return fmt.Errorf("delete %s: %w", f, err)
+		}
+	}
+
+	if err := pruneIndexes(ctx, store, base, expired); err != nil {
+		return err
+	}
+	slog.Info("pruned outputs", "outputs", len(expired), "files", len(files))
+	return nil
+}
+
+// unindexedOutputs finds the dated outputs of the plot definitions of the
+// processing profiles that are missing from the index, such as those written
+// before indexes existed or with --index=false. The time an unindexed output
+// was written is not known so the start of its period is used.
+func unindexedOutputs(ctx context.Context, store OutputStore, base string, confDir string, idx *OutputIndex) ([]*OutputIndexEntry, error) {
+	cfg, err := newPlotConfig(ctx, confDir, nil, false, time.UTC, "")
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := readProfiles(os.DirFS(confDir), confDir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make(map[string]*OutputIndexEntry, len(idx.Plots))
+	for _, e := range idx.Plots {
+		indexed[e.Path] = e
+	}
+
+	var entries []*OutputIndexEntry
+	for _, p := range profiles {
+		infs, fnames, err := p.plotDefFiles("")
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", p.Source, err)
+		}
+		for _, variant := range p.Variants {
+			org := &Organizer{
+				Base:         base,
+				Template:     p.OutTpl,
+				Params:       variant,
+				Store:        store,
+				PathTemplate: p.OutPath,
+			}
+			variantCfg := *cfg
+			variantCfg.TemplateParams = variant
+			for _, fname := range fnames {
+				content, err := fs.ReadFile(infs, fname)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read plot definition %s: %w", fname, err)
+				}
+				pd, _, err := loadPlotDef(ctx, fname, content, infs, path.Dir(fname), &variantCfg)
+				if err != nil {
+					slog.Warn("skipping plot definition", "file", fname, "error", err)
+					continue
+				}
+				versions, err := org.VersionsPattern(pd)
+				if err != nil {
+					slog.Warn("skipping plot definition", "file", fname, "error", err)
+					continue
+				}
+				if versions, err = relLocation(base, versions); err != nil {
+					return nil, fmt.Errorf("find outputs of %s: %w", fname, err)
+				}
+				// entries written before the index recorded the versions of
+				// an output are given them
+				for _, e := range idx.Plots {
+					if e.Versions != "" || e.Name != pd.Name {
+						continue
+					}
+					if ok, _ := path.Match(versions, e.Path); ok {
+						e.Versions = versions
+					}
+				}
+
+				outputs, err := org.DatedOutputs(ctx, pd)
+				if err != nil {
+					return nil, fmt.Errorf("find outputs of %s: %w", fname, err)
+				}
+				for _, out := range outputs {
+					if indexed[out.Path] != nil {
+						continue
+					}
+					generated := out.Start
+					e := &OutputIndexEntry{
+						Name:      pd.Name,
+						Path:      out.Path,
+						Frequency: pd.Frequency,
+						BasisTime: out.Start,
+						Generated: &generated,
+						Versions:  versions,
+					}
+					indexed[out.Path] = e
+					entries = append(entries, e)
+				}
+			}
+		}
+	}
+	if len(entries) > 0 {
+		slog.Info("found outputs missing from the index", "outputs", len(entries))
+	}
+	return entries, nil
+}
+
+// parseRetentionOptions parses retentions given as frequency=interval
+func parseRetentionOptions(opts []string) (map[PlotFrequency]time.Duration, error) {
+	retention := make(map[PlotFrequency]time.Duration, len(opts))
+	for _, opt := range opts {
+		freq, interval, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("retain option not valid, use format 'frequency=interval'")
+		}
+		if err := PlotFrequency(freq).Validate(); err != nil {
+			return nil, fmt.Errorf("retain option %q: %w", opt, err)
+		}
+		d, err := parseInterval(interval)
+		if err != nil {
+			return nil, fmt.Errorf("retain option %q: %w", opt, err)
+		}
+		retention[PlotFrequency(freq)] = d
+	}
+	return retention, nil
+}
+
+// expiredOutputs returns the entries of the index at the base of an output
+// tree whose basis time is older than the retention for their frequency. The
+// latest directory is never pruned, and neither is the most recent dated
+// output of each plot, so every plot keeps at least one version.
+func expiredOutputs(idx *OutputIndex, retention map[PlotFrequency]time.Duration, now time.Time) []*OutputIndexEntry {
+	// outputs of the same plot match the same versions pattern. Entries
+	// without one were written in the dated layout, where the outputs of a
+	// plot have the same filename in different dated directories.
+	newest := map[string]time.Time{}
+	plotKey := func(e *OutputIndexEntry) string {
+		versions := e.Versions
+		if versions == "" {
+			dirs, _ := datedDirLayout(e.Frequency)
+			versions = path.Join(dirs, globEscape(path.Base(e.Path)))
+		}
+		return e.Name + "\x00" + versions
+	}
+	for _, e := range idx.Plots {
+		if isLatestPath(e.Path) || e.Generated == nil {
+			continue
+		}
+		if t, ok := newest[plotKey(e)]; !ok || e.BasisTime.After(t) {
+			newest[plotKey(e)] = e.BasisTime
+		}
+	}
+
+	var expired []*OutputIndexEntry
+	for _, e := range idx.Plots {
+		if isLatestPath(e.Path) {
+			continue
+		}
+		keep, ok := retention[e.Frequency]
+		if !ok || !e.BasisTime.Before(now.Add(-keep)) {
+			continue
+		}
+		if t, ok := newest[plotKey(e)]; ok && !e.BasisTime.Before(t) {
+			continue
+		}
+		expired = append(expired, e)
+	}
+	return expired
+}
+
+func isLatestPath(p string) bool {
+	return strings.HasPrefix(p, latestDir+"/")
+}
+
+// prunedFiles returns the locations of the files to delete for the expired
+// outputs: each output and the files written alongside it, such as its
+// thumbnail and dataset dumps, which share its name up to its extension.
+// Files that belong to other outputs in the index are left alone.
+func prunedFiles(ctx context.Context, store OutputStore, base string, idx *OutputIndex, expired []*OutputIndexEntry) ([]string, error) {
+	expiredPaths := make(map[string]bool, len(expired))
+	for _, e := range expired {
+		expiredPaths[e.Path] = true
+	}
+	others := map[string]bool{}
+	for _, e := range idx.Plots {
+		if expiredPaths[e.Path] {
+			continue
+		}
+		others[e.Path] = true
+		if e.Thumbnail != "" {
+			others[e.Thumbnail] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	var files []string
+	add := func(rel string) {
+		if rel == "" || seen[rel] || others[rel] {
+			return
+		}
+		seen[rel] = true
+		files = append(files, joinLocation(base, rel))
+	}
+	for _, e := range expired {
+		add(e.Path)
+		add(e.Thumbnail)
+
+		stem := strings.TrimSuffix(e.Path, path.Ext(e.Path))
+		matches, err := store.Glob(ctx, joinLocation(base, globEscape(stem)+".*"))
+		if err != nil {
+			return nil, fmt.Errorf("list files of %s: %w", e.Path, err)
+		}
+		for _, m := range matches {
+			rel, err := relLocation(base, m)
+			if err != nil {
+				return nil, err
+			}
+			add(rel)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// globEscape escapes the characters of a path that have a meaning in glob
+// patterns
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pruneIndexes removes the expired outputs from the index at the base of the
+// output tree and from the indexes of their directories. The index of a
+// directory that no longer lists any outputs is deleted, along with the
+// directory itself in a local tree if nothing else is left in it.
+func pruneIndexes(ctx context.Context, store OutputStore, base string, expired []*OutputIndexEntry) error {
+	byDir := map[string]map[string]bool{}
+	all := make(map[string]bool, len(expired))
+	for _, e := range expired {
+		all[e.Path] = true
+		dir := path.Dir(e.Path)
+		if byDir[dir] == nil {
+			byDir[dir] = map[string]bool{}
+		}
+		byDir[dir][e.Path] = true
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		fname := joinLocation(base, dir, indexFilename)
+		remaining, err := removeFromOutputIndex(ctx, store, fname, byDir[dir])
+		if err != nil {
+			return fmt.Errorf("update index of %s: %w", dir, err)
+		}
+		if remaining > 0 || dir == "." {
+			continue
+		}
+		if err := store.DeleteFile(ctx, fname); err != nil {
+			return fmt.Errorf("delete index of %s: %w", dir, err)
+		}
+		if _, ok := store.(*FileStore); ok {
+			removeEmptyDirs(base, filepath.FromSlash(dir))
+		}
+	}
+
+	if _, err := removeFromOutputIndex(ctx, store, joinLocation(base, indexFilename), all); err != nil {
+		return fmt.Errorf("update index of %s: %w", base, err)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes a directory relative to base and each of its
+// parents below base for as long as they are empty
+func removeEmptyDirs(base, dir string) {
+	for dir != "." && dir != string(filepath.Separator) {
+		if err := os.Remove(filepath.Join(base, dir)); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
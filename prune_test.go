@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExpiredOutputs(t *testing.T) {
+	org := &Organizer{
+		Base:         "/out",
+		Template:     "{{.PlotDefFilename}}.json",
+		PathTemplate: "{{.Name}}-{{.Year}}-{{.Month}}.json",
+	}
+	pd := &PlotDef{Name: "visits", Frequency: PlotFrequencyMonthly}
+	pattern, err := org.VersionsPattern(pd)
+	if err != nil {
+		t.Fatalf("VersionsPattern: %v", err)
+	}
+	versions, err := relLocation(org.Base, pattern)
+	if err != nil {
+		t.Fatalf("relLocation: %v", err)
+	}
+	if want := "visits-[0-9][0-9][0-9][0-9]-[0-9][0-9].json"; versions != want {
+		t.Fatalf("got versions %q, wanted %q", versions, want)
+	}
+
+	now := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	entry := func(p string, freq PlotFrequency, basisTime time.Time, versions string) *OutputIndexEntry {
+		return &OutputIndexEntry{
+			Name:      "visits",
+			Path:      p,
+			Frequency: freq,
+			BasisTime: basisTime,
+			Generated: &basisTime,
+			Versions:  versions,
+		}
+	}
+	month := func(m time.Month) time.Time {
+		return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		entries []*OutputIndexEntry
+		want    []string
+	}{
+		{
+			name: "templated path",
+			entries: []*OutputIndexEntry{
+				entry("visits-2024-01.json", PlotFrequencyMonthly, month(1), versions),
+				entry("visits-2024-02.json", PlotFrequencyMonthly, month(2), versions),
+				entry("visits-2024-05.json", PlotFrequencyMonthly, month(5), versions),
+				entry("latest/visits.json", PlotFrequencyMonthly, month(5), ""),
+			},
+			want: []string{"visits-2024-01.json", "visits-2024-02.json"},
+		},
+		{
+			name: "templated path keeps newest",
+			entries: []*OutputIndexEntry{
+				entry("visits-2024-01.json", PlotFrequencyMonthly, month(1), versions),
+				entry("visits-2024-02.json", PlotFrequencyMonthly, month(2), versions),
+			},
+			want: []string{"visits-2024-01.json"},
+		},
+		{
+			name: "dated layout with and without versions",
+			entries: []*OutputIndexEntry{
+				entry("2024/01/visits.json", PlotFrequencyMonthly, month(1), ""),
+				entry("2024/02/visits.json", PlotFrequencyMonthly, month(2), "20[0-9][0-9]/[0-9][0-9]/visits.json"),
+			},
+			want: []string{"2024/01/visits.json"},
+		},
+		{
+			name: "frequencies without retention are kept",
+			entries: []*OutputIndexEntry{
+				entry("2024/01/01/visits.json", PlotFrequencyDaily, day(1), ""),
+				entry("2024/01/02/visits.json", PlotFrequencyDaily, day(2), ""),
+			},
+		},
+	}
+
+	retention := map[PlotFrequency]time.Duration{PlotFrequencyMonthly: 60 * 24 * time.Hour}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expired := expiredOutputs(&OutputIndex{Plots: tc.entries}, retention, now)
+			var got []string
+			for _, e := range expired {
+				got = append(got, e.Path)
+			}
+			sort.Strings(got)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got expired %q, wanted %q", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got expired %q, wanted %q", got, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QualityDef enables data quality indicators for the series of a plot
+type QualityDef struct {
+	Badges          bool    `yaml:"badges"`          // add an annotation to the plot naming series with stale or incomplete data
+	MaxAge          string  `yaml:"maxAge"`          // optional age, such as 2d, beyond which the latest label of a series is stale, relative to the basis time
+	MinCompleteness float64 `yaml:"minCompleteness"` // the fraction of expected buckets below which a series is incomplete, defaults to 1
+}
+
+// SeriesQuality holds data quality indicators for a series
+type SeriesQuality struct {
+	Name         string     `json:"name"`
+	RowCount     int        `json:"rowCount"`
+	Latest       *time.Time `json:"latest,omitempty"`       // the latest label of a time series
+	Age          *float64   `json:"age,omitempty"`          // seconds between the latest label and the basis time
+	Completeness *float64   `json:"completeness,omitempty"` // the fraction of expected buckets that have data, for series with an expected interval
+	Stale        bool       `json:"stale,omitempty"`
+	Incomplete   bool       `json:"incomplete,omitempty"`
+}
+
+// seriesQuality computes quality indicators for each series. Freshness is
+// only available for series labelled by time and completeness only for
+// those with an expected interval. Expected buckets run from the first label
+// to the last complete bucket before the basis time.
+func seriesQuality(qd *QualityDef, series []*LabeledSeries, basisTime time.Time) ([]SeriesQuality, error) {
+	var maxAge time.Duration
+	if qd.MaxAge != "" {
+		var err error
+		maxAge, err = parseInterval(qd.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("max age: %w", err)
+		}
+	}
+	minCompleteness := qd.MinCompleteness
+	if minCompleteness == 0 {
+		minCompleteness = 1
+	}
+
+	qs := make([]SeriesQuality, 0, len(series))
+	for _, s := range series {
+		q := SeriesQuality{Name: s.Name, RowCount: len(s.Labels)}
+
+		var times []time.Time
+		for _, l := range s.Labels {
+			if t, ok := toTime(l); ok {
+				times = append(times, t.UTC())
+			}
+		}
+		if len(times) > 0 && len(times) == len(s.Labels) {
+			first, latest := times[0], times[0]
+			for _, t := range times {
+				if t.Before(first) {
+					first = t
+				}
+				if t.After(latest) {
+					latest = t
+				}
+			}
+			age := basisTime.Sub(latest).Seconds()
+			q.Latest = &latest
+			q.Age = &age
+			q.Stale = maxAge > 0 && basisTime.Sub(latest) > maxAge
+
+			if s.SeriesDef.ExpectedInterval != "" {
+				interval, err := parseInterval(s.SeriesDef.ExpectedInterval)
+				if err != nil {
+					return nil, fmt.Errorf("series %q expected interval: %w", s.Name, err)
+				}
+				buckets := make(map[time.Time]bool)
+				for _, t := range times {
+					buckets[t.Truncate(interval)] = true
+				}
+				last := latest.Truncate(interval)
+				if lastComplete := basisTime.UTC().Truncate(interval).Add(-interval); lastComplete.After(last) {
+					last = lastComplete
+				}
+				expected := int(last.Sub(first.Truncate(interval))/interval) + 1
+				completeness := float64(len(buckets)) / float64(expected)
+				q.Completeness = &completeness
+				q.Incomplete = completeness < minCompleteness
+			}
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// qualityBadge returns an annotation naming the series with stale or
+// incomplete data, or nil if there are none
+func qualityBadge(qs []SeriesQuality) *Annotation {
+	var problems []string
+	for _, q := range qs {
+		var issues []string
+		if q.Stale && q.Age != nil {
+			issues = append(issues, "stale, "+formatAge(*q.Age)+" old")
+		}
+		if q.Incomplete && q.Completeness != nil {
+			issues = append(issues, fmt.Sprintf("%.0f%% complete", *q.Completeness*100))
+		}
+		if len(issues) > 0 {
+			problems = append(problems, fmt.Sprintf("%s (%s)", q.Name, strings.Join(issues, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &Annotation{
+		RefX:      "paper",
+		RefY:      "paper",
+		X:         1,
+		Y:         1.05,
+		Text:      "⚠ partial data: " + strings.Join(problems, "; "),
+		ShowArrow: false,
+	}
+}
+
+// formatAge formats an age in seconds as whole hours or days
+func formatAge(secs float64) string {
+	hours := secs / 3600
+	if hours < 48 {
+		return fmt.Sprintf("%.0fh", hours)
+	}
+	return fmt.Sprintf("%.0fd", hours/24)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveQueries templates the query of each dataset with the standard data
+// variables as well as .DataSet, holding the dataset's Name and Source, and
+// .Plot, holding the plot's Name and Frequency. The rollup function selects
+// the rollup of a table for the dataset's source and the plot's frequency.
+//
+// Inline queries are part of the plot definition, whose content is executed
+// again with the context of each dataset and parsed to find its query. A
+// dataset may instead read its query from a query file, with a path relative
+// to dir in fsys, which is executed with the same context. The queries of
+// dependencies are templated again in the same way with the dialect of their
+// sources.
+func resolveQueries(ctx context.Context, fname string, content []byte, pd *PlotDef, fsys fs.FS, dir string, cfg *PlotConfig) error {
+	// the definition as templated without the context of any dataset, to
+	// find the datasets whose queries use it
+	plain, err := ExecuteTemplate(ctx, string(content), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to execute templates for plot definition: %w", err)
+	}
+
+	for i := range pd.Datasets {
+		ds := &pd.Datasets[i]
+		data, funcs := dataSetTemplateContext(pd, ds, cfg)
+		if ds.QueryFile == "" {
+			templated, err := executeTemplateWithData(ctx, string(content), cfg, data, funcs)
+			if err != nil {
+				return fmt.Errorf("failed to execute templates for query of dataset %q: %w", ds.Name, err)
+			}
+			if templated == plain {
+				continue
+			}
+			queries, err := parsePlotDefQueries([]byte(templated))
+			if err != nil {
+				return fmt.Errorf("failed to parse query of dataset %q: %w", ds.Name, err)
+			}
+			if i >= len(queries.Datasets) || queries.Datasets[i].Name != ds.Name {
+				return fmt.Errorf("the datasets of the plot definition change when templated for dataset %q", ds.Name)
+			}
+			ds.Query = queries.Datasets[i].Query
+			continue
+		}
+		if ds.Query != "" {
+			return fmt.Errorf("dataset %q must not specify both query and queryFile", ds.Name)
+		}
+
+		qcontent, err := fs.ReadFile(fsys, path.Join(dir, ds.QueryFile))
+		if err != nil {
+			return fmt.Errorf("failed to read query file for dataset %q: %w", ds.Name, err)
+		}
+
+		query, err := executeTemplateWithData(ctx, string(qcontent), cfg, data, funcs)
+		if err != nil {
+			return fmt.Errorf("failed to execute templates for query file of dataset %q: %w", ds.Name, err)
+		}
+		ds.Query = query
+	}
+
+	// the queries of dependencies are in the dialect of their own sources
+	for i := range pd.DependsOn {
+		dep := &pd.DependsOn[i]
+		templated, err := executeTemplateWithData(ctx, string(content), cfg, nil, sourceTemplateFuncs(cfg, dep.Source))
+		if err != nil {
+			return fmt.Errorf("failed to execute templates for query of dependency %q: %w", dep.String(), err)
+		}
+		if templated == plain {
+			continue
+		}
+		queries, err := parsePlotDefQueries([]byte(templated))
+		if err != nil {
+			return fmt.Errorf("failed to parse query of dependency %q: %w", dep.String(), err)
+		}
+		if i >= len(queries.DependsOn) {
+			return fmt.Errorf("the dependencies of the plot definition change when templated for dependency %q", dep.String())
+		}
+		dep.Query = queries.DependsOn[i].Query
+	}
+	return nil
+}
+
+// dataSetTemplateContext returns the additional data variables and functions
+// that the query of a dataset is templated with
+func dataSetTemplateContext(pd *PlotDef, ds *DataSetDef, cfg *PlotConfig) (map[string]any, template.FuncMap) {
+	data := map[string]any{
+		"DataSet": map[string]any{
+			"Name":   ds.Name,
+			"Source": ds.Source,
+		},
+		"Plot": map[string]any{
+			"Name":      pd.Name,
+			"Frequency": pd.Frequency,
+		},
+	}
+	// the dialect is that of the dataset's source unless another is named
+	funcs := sourceTemplateFuncs(cfg, ds.Source)
+	funcs["rollup"] = func(table string) string {
+		return cfg.RollupTable(ds.Source, string(pd.Frequency), table)
+	}
+	return data, funcs
+}
+
+// plotDefQueries holds the queries of a plot definition
+type plotDefQueries struct {
+	Datasets  []DataSetDef    `yaml:"datasets"`
+	DependsOn []DependencyDef `yaml:"dependsOn"`
+}
+
+// parsePlotDefQueries parses the queries of a templated plot definition
+func parsePlotDefQueries(templated []byte) (*plotDefQueries, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(templated, &doc); err != nil {
+		return nil, err
+	}
+	if _, err := migratePlotDef(&doc); err != nil {
+		return nil, err
+	}
+	var queries plotDefQueries
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&queries); err != nil {
+			return nil, err
+		}
+	}
+	return &queries, nil
+}
+
+// RollupTable returns the name of the rollup of a table that should be queried
+// from the source for plots of the given frequency. The table is returned
+// unchanged if the source has no rollup for the frequency.
+func (c *PlotConfig) RollupTable(source string, freq string, table string) string {
+	return table + c.Rollups[source][PlotFrequency(freq)]
+}
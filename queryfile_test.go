@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestResolveQueries(t *testing.T) {
+	def := `name: visits
+frequency: weekly
+datasets:
+  - name: a
+    source: pga
+    query: select '{{ .DataSet.Name }}' from {{ rollup "visits" }} -- {{ .Plot.Name }} {{ .Plot.Frequency }}
+  - name: b
+    source: pgb
+    query: select '{{ .DataSet.Name }}' from {{ rollup "visits" }}
+  - name: c
+    source: pga
+    queryFile: c.sql
+`
+	fsys := fstest.MapFS{
+		"visits.yaml": {Data: []byte(def)},
+		"c.sql":       {Data: []byte(`select '{{ .DataSet.Name }}' from {{ rollup "visits" }}`)},
+	}
+	cfg := &PlotConfig{
+		BasisTime: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		Rollups:   map[string]map[PlotFrequency]string{"pga": {PlotFrequencyWeekly: "_daily"}},
+	}
+
+	pd, _, err := loadPlotDef(context.Background(), "visits.yaml", []byte(def), fsys, ".", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"select 'a' from visits_daily -- visits weekly",
+		"select 'b' from visits",
+		"select 'c' from visits_daily",
+	}
+	for i, ds := range pd.Datasets {
+		if ds.Query != want[i] {
+			t.Errorf("dataset %q has query %q, want %q", ds.Name, ds.Query, want[i])
+		}
+	}
+}
+
+func TestResolveQueriesDialect(t *testing.T) {
+	def := `name: visits
+datasets:
+  - name: a
+    source: pg
+    query: select * from visits where t < {{ .Now | sqlTimestamp }}
+  - name: b
+    source: ch
+    query: select * from visits where t < {{ .Now | sqlTimestamp }}
+  - name: c
+    source: ch
+    query: select * from visits where t < {{ .Now | sqlTimestamp "pg" }}
+dependsOn:
+  - source: ch
+    query: select {{ .Now | sqlTimestamp }} > now() as ready
+`
+	basis := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	cfg := &PlotConfig{
+		BasisTime: basis,
+		Sources: map[string]DataSource{
+			"pg": &PgDataSource{dialect: DialectPostgres},
+			"ch": &PgDataSource{dialect: DialectClickHouse},
+		},
+	}
+
+	pd, _, err := loadPlotDef(context.Background(), "visits.yaml", []byte(def), fstest.MapFS{}, ".", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"select * from visits where t < " + pgTimestamp(basis),
+		"select * from visits where t < " + clickhouseDateTime(basis),
+		"select * from visits where t < " + pgTimestamp(basis),
+	}
+	for i, ds := range pd.Datasets {
+		if ds.Query != want[i] {
+			t.Errorf("dataset %q has query %q, want %q", ds.Name, ds.Query, want[i])
+		}
+	}
+	if want := "select " + clickhouseDateTime(basis) + " > now() as ready"; pd.DependsOn[0].Query != want {
+		t.Errorf("dependency has query %q, want %q", pd.DependsOn[0].Query, want)
+	}
+}
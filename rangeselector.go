@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+// rangeSteps maps the unit suffixes of range selector buttons to plotly steps
+var rangeSteps = map[byte]string{
+	'h': "hour",
+	'd': "day",
+	'm': "month",
+	'y': "year",
+}
+
+// parseRangeButton converts a range selector button such as 7d, 2w, 6m, 1y,
+// ytd or all into a plotly range selector button
+func parseRangeButton(s string) (map[string]any, error) {
+	switch s {
+	case "all":
+		return map[string]any{"step": "all", "label": "all"}, nil
+	case "ytd":
+		return map[string]any{"step": "year", "stepmode": "todate", "count": 1, "label": "YTD"}, nil
+	}
+
+	if len(s) < 2 {
+		return nil, fmt.Errorf("invalid range selector button: %q", s)
+	}
+	count, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid range selector button: %q", s)
+	}
+
+	unit := s[len(s)-1]
+	if unit == 'w' {
+		// plotly has no week step
+		unit = 'd'
+		count *= 7
+	}
+	step, ok := rangeSteps[unit]
+	if !ok {
+		return nil, fmt.Errorf("invalid range selector button unit: %q", s)
+	}
+
+	return map[string]any{
+		"step":     step,
+		"stepmode": "backward",
+		"count":    count,
+		"label":    s,
+	}, nil
+}
+
+// setRangeControls adds the range selector and range slider requested by the
+// plot definition to the x axis, unless the layout already specifies them.
+func setRangeControls(layout *grob.Layout, pd *PlotDef) error {
+	if len(pd.RangeSelector) == 0 && !pd.RangeSlider {
+		return nil
+	}
+	if layout.Xaxis == nil {
+		layout.Xaxis = &grob.LayoutXaxis{}
+	}
+
+	if len(pd.RangeSelector) > 0 && layout.Xaxis.Rangeselector == nil {
+		buttons := make([]any, 0, len(pd.RangeSelector))
+		for _, b := range pd.RangeSelector {
+			button, err := parseRangeButton(b)
+			if err != nil {
+				return err
+			}
+			buttons = append(buttons, button)
+		}
+		layout.Xaxis.Rangeselector = &grob.LayoutXaxisRangeselector{
+			Buttons: buttons,
+		}
+	}
+
+	if pd.RangeSlider && layout.Xaxis.Rangeslider == nil {
+		layout.Xaxis.Rangeslider = &grob.LayoutXaxisRangeslider{
+			Visible: grob.True,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var renderCommand = &cli.Command{
+	Name:      "render",
+	Usage:     "Render a previously generated figure json as html, png or pdf without running its queries",
+	ArgsUsage: "<figure json>",
+	Action:    Render,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Path of the file to write. Defaults to the figure's path with the extension of the format.",
+			Destination: &renderOpts.output,
+		},
+		&cli.StringFlag{
+			Name:        "format",
+			Required:    false,
+			Usage:       "Format to render: html, png or pdf. Defaults to the extension of --output, or html. Only html draws the figure as plotly does: png is a chart of line, scatter and bar traces on one value axis without a title, axis labels or legend, and rejects figures with other traces.",
+			Destination: &renderOpts.format,
+		},
+		&cli.IntFlag{
+			Name:        "width",
+			Required:    false,
+			Usage:       "Width of png output in pixels.",
+			Value:       960,
+			Destination: &renderOpts.width,
+		},
+		&cli.IntFlag{
+			Name:        "height",
+			Required:    false,
+			Usage:       "Height of png output in pixels.",
+			Value:       540,
+			Destination: &renderOpts.height,
+		},
+		&cli.BoolFlag{
+			Name:        "editor",
+			Required:    false,
+			Usage:       "Include an editor for the figure's json in html output, as in the preview of plot.",
+			Destination: &renderOpts.editor,
+		},
+	}, loggingFlags...),
+}
+
+var renderOpts struct {
+	output string
+	format string
+	width  int
+	height int
+	editor bool
+}
+
+type RenderFormat string
+
+const (
+	RenderFormatHTML RenderFormat = "html" // a page that draws the figure with plotly.js
+	RenderFormatPNG  RenderFormat = "png"  // a chart of line, scatter and bar traces on one value axis, without text
+	RenderFormatPDF  RenderFormat = "pdf"  // a page with the chart, its title and legend
+)
+
+func (f RenderFormat) String() string { return string(f) }
+
+func (f RenderFormat) Validate() error {
+	switch f {
+	case RenderFormatHTML, RenderFormatPNG, RenderFormatPDF:
+		return nil
+	default:
+		return fmt.Errorf("unsupported render format: %q", f)
+	}
+}
+
+// renderedFigure holds the parts of a figure json that are needed to render
+// it again. The traces are kept as plain json so that every trace type can be
+// read.
+type renderedFigure struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Metadata      *FigureMetadata  `json:"metadata"`
+	Data          []map[string]any `json:"data"`
+	Layout        map[string]any   `json:"layout"`
+}
+
+func Render(cc *cli.Context) error {
+	setupLogging()
+
+	if cc.NArg() != 1 {
+		return fmt.Errorf("a figure json file must be supplied as an argument")
+	}
+	fname := cc.Args().Get(0)
+
+	format := RenderFormat(renderOpts.format)
+	if format == "" {
+		format = RenderFormat(strings.TrimPrefix(strings.ToLower(filepath.Ext(renderOpts.output)), "."))
+		if format.Validate() != nil {
+			format = RenderFormatHTML
+		}
+	}
+	if err := format.Validate(); err != nil {
+		return err
+	}
+	if renderOpts.width <= 0 || renderOpts.height <= 0 {
+		return fmt.Errorf("width and height must be positive")
+	}
+
+	output := renderOpts.output
+	if output == "" {
+		output = withSuffix(fname, "."+format.String())
+	}
+	if filepath.Clean(output) == filepath.Clean(fname) {
+		return fmt.Errorf("output %s would overwrite the figure", output)
+	}
+
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("failed to read figure: %w", err)
+	}
+	var rf renderedFigure
+	if err := json.Unmarshal(content, &rf); err != nil {
+		return fmt.Errorf("failed to unmarshal figure: %w", err)
+	}
+	if rf.Data == nil && rf.Layout == nil {
+		return fmt.Errorf("%s is not a plotly figure, it has no data or layout", fname)
+	}
+	if rf.SchemaVersion > figureSchemaVersion {
+		return fmt.Errorf("figure has schema version %d, which is newer than this version of ashby supports (%d)", rf.SchemaVersion, figureSchemaVersion)
+	}
+
+	var data []byte
+	switch format {
+	case RenderFormatHTML:
+		data, err = renderPage(json.RawMessage(content), renderOpts.editor, false)
+		if err != nil {
+			return err
+		}
+	case RenderFormatPNG:
+		// the png is a rough chart, so refuse figures it would misrepresent
+		// rather than leave traces out
+		series, omitted := chartSeries(figureSeries(rf.Data))
+		for _, ls := range series {
+			if ls.SeriesDef.Yaxis != "" && ls.SeriesDef.Yaxis != "y" {
+				omitted = append(omitted, fmt.Sprintf("%s (on axis %s)", ls.Name, ls.SeriesDef.Yaxis))
+			}
+		}
+		if len(omitted) > 0 {
+			return fmt.Errorf("figure has traces that cannot be drawn as png: %s; render it as html instead", strings.Join(omitted, ", "))
+		}
+		data, err = renderChartPNG(series, &PlotConfig{}, renderOpts.width, renderOpts.height)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return fmt.Errorf("figure has no line, scatter or bar traces to draw as png")
+		}
+	case RenderFormatPDF:
+		data, err = renderFigurePDF(fname, &rf)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeOutput(output, data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	slog.Info("rendered figure", "figure", fname, "format", format, "output", output)
+	return nil
+}
+
+// renderFigurePDF draws the figure as the only plot of a report
+func renderFigurePDF(fname string, rf *renderedFigure) ([]byte, error) {
+	name := strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname))
+	basisTime := time.Now().UTC()
+	if rf.Metadata != nil {
+		if rf.Metadata.Name != "" {
+			name = rf.Metadata.Name
+		}
+		if !rf.Metadata.BasisTime.IsZero() {
+			basisTime = rf.Metadata.BasisTime
+		}
+	}
+
+	pd := &PlotDef{Name: name}
+	if title := figureTitle(rf.Layout); title != "" {
+		pd.Name = title
+	}
+	rp := newReportPDF(&ReportDef{Name: name}, basisTime)
+	rp.plot(pd, &Figure{Series: figureSeries(rf.Data)}, &PlotConfig{}, slog.With("figure", fname))
+
+	var buf bytes.Buffer
+	if err := rp.pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write pdf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// figureTitle returns the text of the title of a plotly layout, which may be
+// an object with text or a plain string
+func figureTitle(layout map[string]any) string {
+	switch t := layout["title"].(type) {
+	case string:
+		return t
+	case map[string]any:
+		s, _ := t["text"].(string)
+		return s
+	}
+	return ""
+}
+
+// figureSeries reconstructs the series of a figure from its plotly traces so
+// they can be drawn by the png and pdf renderers. Traces that are not line,
+// scatter or bar traces keep their plotly type, so they are left out of the
+// charts and listed as not shown. Hidden traces are skipped.
+func figureSeries(traces []map[string]any) []*LabeledSeries {
+	var series []*LabeledSeries
+	for i, t := range traces {
+		if visible, ok := t["visible"].(bool); ok && !visible {
+			continue
+		}
+		typ, _ := t["type"].(string)
+		if typ == "" {
+			typ = "scatter" // the plotly default
+		}
+		name, _ := t["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("trace %d", i)
+		}
+		x, _ := t["x"].([]any)
+		y, _ := t["y"].([]any)
+
+		yaxis, _ := t["yaxis"].(string)
+		ls := &LabeledSeries{
+			Name:      name,
+			SeriesDef: &SeriesDef{Color: traceColor(t), Yaxis: yaxis},
+			Labels:    x,
+			Values:    y,
+		}
+		switch typ {
+		case "scatter", "scattergl":
+			// plotly draws lines unless the mode leaves them out
+			if mode, _ := t["mode"].(string); mode == "" || strings.Contains(mode, "lines") {
+				ls.SeriesDef.Type = SeriesTypeLine
+			} else {
+				ls.SeriesDef.Type = SeriesTypeScatter
+			}
+		case "bar":
+			ls.SeriesDef.Type = SeriesTypeBar
+			if orientation, _ := t["orientation"].(string); orientation == "h" {
+				ls.SeriesDef.Type = SeriesTypeHBar
+				ls.Labels, ls.Values = y, x
+			}
+		default:
+			ls.SeriesDef.Type = SeriesType(typ)
+		}
+		series = append(series, ls)
+	}
+	return series
+}
+
+// traceColor returns the color of a trace's line or markers, if a single
+// color is set
+func traceColor(t map[string]any) string {
+	for _, attr := range []string{"line", "marker"} {
+		if m, ok := t[attr].(map[string]any); ok {
+			if c, ok := m["color"].(string); ok && c != "" {
+				return c
+			}
+		}
+	}
+	return ""
+}
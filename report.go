@@ -0,0 +1,714 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
+)
+
+var reportCommand = &cli.Command{
+	Name:      "report",
+	Usage:     "Render a report definition combining plots, headings and text into a PDF",
+	ArgsUsage: "<report definition>",
+	Action:    Report,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    false,
+			Usage:       "Specify the url of a data source, in the format name=url. May be repeated to specify multiple sources.",
+			Destination: &reportOpts.sources,
+		},
+		&cli.StringSliceFlag{
+			Name:        "params",
+			Aliases:     []string{"p"},
+			Required:    false,
+			Usage:       "Specify templating parameters, in the format key=value. May be repeated to specify multiple parameters.",
+			Destination: &reportOpts.params,
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &reportOpts.confDir,
+		},
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Path of the PDF to write. Defaults to the name of the report with a .pdf extension.",
+			Destination: &reportOpts.output,
+		},
+	}, loggingFlags...),
+}
+
+var reportOpts struct {
+	sources cli.StringSlice
+	params  cli.StringSlice
+	confDir string
+	output  string
+}
+
+// ReportDef is an ordered list of plots, headings and text blocks to be
+// rendered as a single document
+type ReportDef struct {
+	Name  string          `yaml:"name"`
+	Title string          `yaml:"title"`
+	Items []ReportItemDef `yaml:"items"`
+}
+
+// ReportItemDef is a single element of a report. Exactly one of its fields
+// must be set.
+type ReportItemDef struct {
+	Heading   string `yaml:"heading"`
+	Text      string `yaml:"text"`
+	Plot      string `yaml:"plot"` // path of a plot definition, relative to the report definition
+	PageBreak bool   `yaml:"pagebreak"`
+}
+
+func (r *ReportDef) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name must be specified")
+	}
+	if len(r.Items) == 0 {
+		return fmt.Errorf("at least one item must be specified")
+	}
+	for i, item := range r.Items {
+		set := 0
+		for _, v := range []bool{item.Heading != "", item.Text != "", item.Plot != "", item.PageBreak} {
+			if v {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("item %d: exactly one of heading, text, plot or pagebreak must be specified", i+1)
+		}
+	}
+	return nil
+}
+
+func Report(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if cc.NArg() != 1 {
+		return fmt.Errorf("a report definition must be supplied as an argument")
+	}
+	fname := cc.Args().Get(0)
+
+	cfg, err := newPlotConfig(ctx, reportOpts.confDir, reportOpts.sources.Value(), false, time.UTC, "")
+	if err != nil {
+		return err
+	}
+	cfg.TemplateParams, err = parseTemplateParams(reportOpts.params.Value())
+	if err != nil {
+		return err
+	}
+
+	fcontent, err := os.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("failed to read report definition: %w", err)
+	}
+	templated, err := ExecuteTemplate(ctx, string(fcontent), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to execute templates for report definition: %w", err)
+	}
+	var rd ReportDef
+	if err := yaml.Unmarshal([]byte(templated), &rd); err != nil {
+		return fmt.Errorf("failed to unmarshal report definition: %w", err)
+	}
+	if err := rd.validate(); err != nil {
+		return fmt.Errorf("invalid report definition: %w", err)
+	}
+
+	rp := newReportPDF(&rd, cfg.BasisTime)
+	failed := 0
+	for _, item := range rd.Items {
+		switch {
+		case item.Heading != "":
+			rp.heading(item.Heading)
+		case item.Text != "":
+			rp.text(item.Text)
+		case item.PageBreak:
+			rp.pdf.AddPage()
+		case item.Plot != "":
+			plotFname := item.Plot
+			if !filepath.IsAbs(plotFname) {
+				plotFname = filepath.Join(filepath.Dir(fname), plotFname)
+			}
+			logger := slog.With("plot", plotFname)
+
+			pd, fig, err := generateReportPlot(cc, cfg, plotFname)
+			if err != nil {
+				logger.Error("failed to generate plot", "error", err)
+				rp.failedPlot(item.Plot, err)
+				failed++
+				continue
+			}
+			rp.plot(pd, fig, cfg, logger)
+		}
+	}
+
+	output := reportOpts.output
+	if output == "" {
+		output = rd.Name + ".pdf"
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+	if err := rp.pdf.Output(f); err != nil {
+		return fmt.Errorf("failed to write pdf: %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d plots of the report could not be generated", failed)
+	}
+	return nil
+}
+
+func generateReportPlot(cc *cli.Context, cfg *PlotConfig, fname string) (*PlotDef, *Figure, error) {
+	pds, err := loadPlotDefFiles(cc.Context, cfg, []string{fname})
+	if err != nil {
+		return nil, nil, err
+	}
+	fig, err := generateFig(cc.Context, pds[0], cfg, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pds[0], fig, nil
+}
+
+// reportPDF lays out the items of a report on A4 pages
+type reportPDF struct {
+	pdf *fpdf.Fpdf
+	tr  func(string) string // translates utf-8 text to the encoding of the core fonts
+}
+
+const (
+	reportMargin      = 15.0 // mm
+	reportChartHeight = 95.0 // mm
+)
+
+func newReportPDF(rd *ReportDef, basisTime time.Time) *reportPDF {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(reportMargin, reportMargin, reportMargin)
+	pdf.SetAutoPageBreak(true, reportMargin)
+	rp := &reportPDF{
+		pdf: pdf,
+		tr:  pdf.UnicodeTranslatorFromDescriptor(""),
+	}
+
+	title := rd.Title
+	if title == "" {
+		title = rd.Name
+	}
+	pdf.SetTitle(title, true)
+	pdf.SetCreator(appName, true)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-reportMargin + 5)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, rp.tr(title+" - "+basisTime.Format(time.DateOnly)), "", 0, "L", false, 0, "")
+		pdf.SetX(reportMargin)
+		pdf.CellFormat(0, 5, strconv.Itoa(pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.MultiCell(0, 10, rp.tr(title), "", "L", false)
+	pdf.Ln(4)
+	return rp
+}
+
+// ensureSpace starts a new page unless there is at least h mm left on the
+// current one
+func (rp *reportPDF) ensureSpace(h float64) {
+	_, pageHeight := rp.pdf.GetPageSize()
+	if rp.pdf.GetY()+h > pageHeight-reportMargin {
+		rp.pdf.AddPage()
+	}
+}
+
+func (rp *reportPDF) heading(s string) {
+	// keep headings with at least the start of what follows them
+	rp.ensureSpace(30)
+	rp.pdf.Ln(2)
+	rp.pdf.SetFont("Helvetica", "B", 14)
+	rp.pdf.SetTextColor(0, 0, 0)
+	rp.pdf.MultiCell(0, 8, rp.tr(s), "", "L", false)
+	rp.pdf.Ln(1)
+}
+
+func (rp *reportPDF) text(s string) {
+	rp.pdf.SetFont("Helvetica", "", 10)
+	rp.pdf.SetTextColor(0, 0, 0)
+	for _, para := range strings.Split(strings.TrimSpace(s), "\n\n") {
+		rp.pdf.MultiCell(0, 5, rp.tr(strings.Join(strings.Fields(para), " ")), "", "L", false)
+		rp.pdf.Ln(2)
+	}
+}
+
+func (rp *reportPDF) failedPlot(name string, err error) {
+	rp.pdf.SetFont("Helvetica", "I", 10)
+	rp.pdf.SetTextColor(180, 0, 0)
+	rp.pdf.MultiCell(0, 5, rp.tr(fmt.Sprintf("Plot %s could not be generated: %v", name, err)), "", "L", false)
+	rp.pdf.Ln(2)
+}
+
+// reportDefaultColors are used for series without a configured color, in
+// the same order as plotly's default colorway
+var reportDefaultColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// plot draws the series of a figure as a simple chart. Only line, scatter
+// and bar series can be drawn, and horizontal bars only in charts of nothing
+// else. Other series are listed below the chart as omitted.
+func (rp *reportPDF) plot(pd *PlotDef, fig *Figure, cfg *PlotConfig, logger *slog.Logger) {
+	pdf := rp.pdf
+
+	title := pd.Name
+	if pd.Layout.Title != nil {
+		if s, ok := pd.Layout.Title.Text.(string); ok && s != "" {
+			title = s
+		}
+	}
+
+	series, omitted := chartSeries(fig.Series)
+	if len(omitted) > 0 {
+		logger.Warn("series cannot be drawn in a report", "series", omitted)
+	}
+
+	rp.ensureSpace(reportChartHeight + 10)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.MultiCell(0, 6, rp.tr(title), "", "L", false)
+
+	colors := make([][3]int, len(series))
+	for i, ls := range series {
+		colors[i] = reportColor(cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name), i)
+	}
+
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	x, y := left, pdf.GetY()
+	w := pageWidth - left - right
+
+	// legend
+	pdf.SetFont("Helvetica", "", 8)
+	lx := x
+	for i, ls := range series {
+		nw := pdf.GetStringWidth(rp.tr(ls.Name)) + 8
+		if lx+nw > x+w && lx > x {
+			lx = x
+			y += 5
+		}
+		pdf.SetFillColor(colors[i][0], colors[i][1], colors[i][2])
+		pdf.Rect(lx, y+1.5, 3, 2, "F")
+		pdf.SetXY(lx+4, y)
+		pdf.CellFormat(nw-4, 5, rp.tr(ls.Name), "", 0, "L", false, 0, "")
+		lx += nw
+	}
+	y += 7
+
+	h := reportChartHeight - (y - pdf.GetY())
+	if isHorizontalChart(series) {
+		rp.hchart(series, colors, x, y, w, h)
+	} else {
+		rp.chart(series, colors, x, y, w, h)
+	}
+	pdf.SetXY(x, y+h+2)
+
+	if len(omitted) > 0 {
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.MultiCell(0, 4, rp.tr("Not shown: "+strings.Join(omitted, ", ")), "", "L", false)
+	}
+	pdf.Ln(4)
+}
+
+// chart draws the axes and series within the rectangle. Labels are placed on
+// a time or numeric scale if all of them are times or numbers, otherwise
+// each distinct label is a category.
+func (rp *reportPDF) chart(series []*LabeledSeries, colors [][3]int, x, y, w, h float64) {
+	pdf := rp.pdf
+	const axisWidth, axisHeight = 16.0, 8.0
+	px, py, pw, ph := x+axisWidth, y, w-axisWidth, h-axisHeight
+
+	scale := newReportLabelScale(series)
+	bars := countBars(series)
+	ticks, unit := chartValueTicks(series, 5)
+	vmin, vmax := ticks[0], ticks[len(ticks)-1]
+	vy := func(v float64) float64 { return py + ph - (v-vmin)/(vmax-vmin)*ph }
+
+	// grid and value ticks
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.SetTextColor(80, 80, 80)
+	pdf.SetLineWidth(0.1)
+	pdf.SetDrawColor(220, 220, 220)
+	for _, t := range ticks {
+		ty := vy(t)
+		pdf.Line(px, ty, px+pw, ty)
+		pdf.SetXY(x, ty-2)
+		pdf.CellFormat(axisWidth-1, 4, formatReportValue(t, unit), "", 0, "R", false, 0, "")
+	}
+	pdf.SetDrawColor(120, 120, 120)
+	pdf.Line(px, py+ph, px+pw, py+ph)
+
+	// label ticks
+	for _, t := range scale.ticks(6) {
+		tx := px + t.pos*pw
+		pdf.Line(tx, py+ph, tx, py+ph+1)
+		lw := pw / 6
+		pdf.SetXY(tx-lw/2, py+ph+1)
+		pdf.CellFormat(lw, 4, rp.tr(t.label), "", 0, "C", false, 0, "")
+	}
+
+	slot := pw / float64(max(scale.slots(), 1))
+	bar := 0
+	pdf.SetLineWidth(0.4)
+	for i, ls := range series {
+		c := colors[i]
+		pdf.SetDrawColor(c[0], c[1], c[2])
+		pdf.SetFillColor(c[0], c[1], c[2])
+
+		var prevX, prevY float64
+		connected := false
+		for j, l := range ls.Labels {
+			pos, ok := scale.pos(l)
+			f, fok := toFloat64(index(ls.Values, j))
+			if !ok || !fok || math.IsNaN(f) || math.IsInf(f, 0) {
+				connected = false
+				continue
+			}
+			cx, cy := px+pos*pw, vy(f)
+
+			switch ls.SeriesDef.Type {
+			case SeriesTypeBar:
+				bw := slot * 0.8 / float64(bars)
+				bx := cx - slot*0.4 + float64(bar)*bw
+				top, bottom := math.Min(cy, vy(0)), math.Max(cy, vy(0))
+				pdf.Rect(bx, top, bw, bottom-top, "F")
+			case SeriesTypeScatter:
+				pdf.Circle(cx, cy, 0.6, "F")
+			default:
+				if connected {
+					pdf.Line(prevX, prevY, cx, cy)
+				}
+				prevX, prevY, connected = cx, cy, true
+			}
+		}
+		if ls.SeriesDef.Type == SeriesTypeBar {
+			bar++
+		}
+	}
+	pdf.SetLineWidth(0.2)
+}
+
+// hchart draws horizontal bars within the rectangle, with the labels along
+// the left edge from the bottom up, as plotly places them
+func (rp *reportPDF) hchart(series []*LabeledSeries, colors [][3]int, x, y, w, h float64) {
+	pdf := rp.pdf
+	const labelWidth, axisHeight = 28.0, 6.0
+	px, py, pw, ph := x+labelWidth, y, w-labelWidth, h-axisHeight
+
+	scale := newReportLabelScale(series)
+	bars := countBars(series)
+	ticks, unit := chartValueTicks(series, 5)
+	vmin, vmax := ticks[0], ticks[len(ticks)-1]
+	vx := func(v float64) float64 { return px + (v-vmin)/(vmax-vmin)*pw }
+
+	// grid and value ticks
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.SetTextColor(80, 80, 80)
+	pdf.SetLineWidth(0.1)
+	pdf.SetDrawColor(220, 220, 220)
+	tw := pw / float64(len(ticks))
+	for _, t := range ticks {
+		tx := vx(t)
+		pdf.Line(tx, py, tx, py+ph)
+		pdf.SetXY(tx-tw/2, py+ph+1)
+		pdf.CellFormat(tw, 4, formatReportValue(t, unit), "", 0, "C", false, 0, "")
+	}
+	pdf.SetDrawColor(120, 120, 120)
+	pdf.Line(px, py, px, py+ph)
+
+	// label ticks
+	for _, t := range scale.ticks(8) {
+		ty := py + ph - t.pos*ph
+		pdf.Line(px-1, ty, px, ty)
+		pdf.SetXY(x, ty-2)
+		pdf.CellFormat(labelWidth-2, 4, rp.tr(t.label), "", 0, "R", false, 0, "")
+	}
+
+	slot := ph / float64(max(scale.slots(), 1))
+	for i, ls := range series {
+		c := colors[i]
+		pdf.SetFillColor(c[0], c[1], c[2])
+		for j, l := range ls.Labels {
+			pos, ok := scale.pos(l)
+			f, fok := toFloat64(index(ls.Values, j))
+			if !ok || !fok || math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+			bh := slot * 0.8 / float64(bars)
+			by := py + ph - pos*ph + slot*0.4 - float64(i+1)*bh
+			left, right := math.Min(vx(f), vx(0)), math.Max(vx(f), vx(0))
+			pdf.Rect(left, by, right-left, bh, "F")
+		}
+	}
+	pdf.SetLineWidth(0.2)
+}
+
+// chartSeries returns the series that can be drawn in a chart and describes
+// the others. Horizontal bars can only be drawn in charts of nothing else,
+// since their labels are along the other axis.
+func chartSeries(all []*LabeledSeries) ([]*LabeledSeries, []string) {
+	var series []*LabeledSeries
+	var omitted []string
+	horizontal := true
+	for _, ls := range all {
+		switch ls.SeriesDef.Type {
+		case SeriesTypeLine, SeriesTypeScatter, SeriesTypeBar:
+			horizontal = false
+		}
+	}
+	for _, ls := range all {
+		switch ls.SeriesDef.Type {
+		case SeriesTypeLine, SeriesTypeScatter, SeriesTypeBar:
+			series = append(series, ls)
+		case SeriesTypeHBar:
+			if horizontal {
+				series = append(series, ls)
+				continue
+			}
+			omitted = append(omitted, fmt.Sprintf("%s (%s with other series)", ls.Name, ls.SeriesDef.Type))
+		default:
+			omitted = append(omitted, fmt.Sprintf("%s (%s)", ls.Name, ls.SeriesDef.Type))
+		}
+	}
+	return series, omitted
+}
+
+// isHorizontalChart reports whether the series, as chosen by chartSeries,
+// are horizontal bars
+func isHorizontalChart(series []*LabeledSeries) bool {
+	return len(series) > 0 && series[0].SeriesDef.Type == SeriesTypeHBar
+}
+
+func countBars(series []*LabeledSeries) int {
+	bars := 0
+	for _, ls := range series {
+		if ls.SeriesDef.Type == SeriesTypeBar || ls.SeriesDef.Type == SeriesTypeHBar {
+			bars++
+		}
+	}
+	return bars
+}
+
+// chartValueTicks returns about n ticks covering the values of the series,
+// and zero if there are bars, along with the unit of the values
+func chartValueTicks(series []*LabeledSeries, n int) ([]float64, UnitType) {
+	vmin, vmax := math.Inf(1), math.Inf(-1)
+	var unit UnitType
+	for _, ls := range series {
+		if ls.SeriesDef.Type == SeriesTypeBar || ls.SeriesDef.Type == SeriesTypeHBar {
+			vmin, vmax = math.Min(vmin, 0), math.Max(vmax, 0)
+		}
+		if u := ls.SeriesDef.EffectiveUnit(); u != UnitTypeNone {
+			unit = u
+		}
+		for _, v := range ls.Values {
+			if f, ok := toFloat64(v); ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				vmin, vmax = math.Min(vmin, f), math.Max(vmax, f)
+			}
+		}
+	}
+	if math.IsInf(vmin, 0) {
+		vmin, vmax = 0, 1
+	}
+	return niceTicks(vmin, vmax, n), unit
+}
+
+type reportTick struct {
+	pos   float64 // fraction of the width of the chart
+	label string
+}
+
+// reportLabelScale positions the labels of a chart along its width
+type reportLabelScale struct {
+	kind       string // time, number or category
+	lo, hi     float64
+	points     int // the most points in any series
+	categories []string
+	catIndex   map[string]int
+}
+
+func newReportLabelScale(series []*LabeledSeries) *reportLabelScale {
+	s := &reportLabelScale{lo: math.Inf(1), hi: math.Inf(-1)}
+	bars := false
+	for _, ls := range series {
+		s.points = max(s.points, len(ls.Labels))
+		bars = bars || ls.SeriesDef.Type == SeriesTypeBar || ls.SeriesDef.Type == SeriesTypeHBar
+	}
+
+	for _, kind := range []string{"time", "number"} {
+		s.kind = kind
+		ok := true
+		for _, ls := range series {
+			for _, l := range ls.Labels {
+				v, isValue := s.value(l)
+				if !isValue {
+					ok = false
+					break
+				}
+				s.lo, s.hi = math.Min(s.lo, v), math.Max(s.hi, v)
+			}
+		}
+		if ok && !math.IsInf(s.lo, 0) {
+			if s.lo == s.hi {
+				s.lo, s.hi = s.lo-1, s.hi+1
+			}
+			if bars {
+				// leave room for the bars at either end
+				pad := (s.hi - s.lo) / float64(2*max(s.points-1, 1))
+				s.lo, s.hi = s.lo-pad, s.hi+pad
+			}
+			return s
+		}
+		s.lo, s.hi = math.Inf(1), math.Inf(-1)
+	}
+
+	s.kind = "category"
+	s.catIndex = make(map[string]int)
+	for _, ls := range series {
+		for _, l := range ls.Labels {
+			label := csvValue(l)
+			if _, seen := s.catIndex[label]; !seen {
+				s.catIndex[label] = len(s.categories)
+				s.categories = append(s.categories, label)
+			}
+		}
+	}
+	return s
+}
+
+func (s *reportLabelScale) value(l any) (float64, bool) {
+	if s.kind == "time" {
+		t, ok := toTime(l)
+		return float64(t.Unix()), ok
+	}
+	return toFloat64(l)
+}
+
+// slots is the number of equal width slots bars are drawn within
+func (s *reportLabelScale) slots() int {
+	if s.kind == "category" {
+		return len(s.categories)
+	}
+	return s.points
+}
+
+func (s *reportLabelScale) pos(l any) (float64, bool) {
+	if s.kind == "category" {
+		i, ok := s.catIndex[csvValue(l)]
+		return (float64(i) + 0.5) / float64(len(s.categories)), ok
+	}
+	v, ok := s.value(l)
+	return (v - s.lo) / (s.hi - s.lo), ok
+}
+
+func (s *reportLabelScale) ticks(n int) []reportTick {
+	var ticks []reportTick
+	if s.kind == "category" {
+		step := (len(s.categories) + n - 1) / n
+		for i := 0; i < len(s.categories); i += max(step, 1) {
+			ticks = append(ticks, reportTick{pos: (float64(i) + 0.5) / float64(len(s.categories)), label: s.categories[i]})
+		}
+		return ticks
+	}
+
+	if s.kind == "number" {
+		for _, v := range niceTicks(s.lo, s.hi, n) {
+			if v >= s.lo && v <= s.hi {
+				ticks = append(ticks, reportTick{pos: (v - s.lo) / (s.hi - s.lo), label: strconv.FormatFloat(v, 'f', -1, 64)})
+			}
+		}
+		return ticks
+	}
+
+	layout := "Jan 2"
+	if s.hi-s.lo < 2*24*3600 {
+		layout = "Jan 2 15:04"
+	} else if s.hi-s.lo > 2*365*24*3600 {
+		layout = "Jan 2006"
+	}
+	for i := 0; i < n; i++ {
+		pos := (float64(i) + 0.5) / float64(n)
+		t := time.Unix(int64(s.lo+pos*(s.hi-s.lo)), 0).UTC()
+		ticks = append(ticks, reportTick{pos: pos, label: t.Format(layout)})
+	}
+	return ticks
+}
+
+// niceTicks returns evenly spaced round numbers covering lo to hi, using a
+// step of 1, 2 or 5 times a power of ten so there are about n intervals
+func niceTicks(lo, hi float64, n int) []float64 {
+	if hi <= lo {
+		hi = lo + 1
+	}
+	raw := (hi - lo) / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := mag * 10
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*mag {
+			step = m * mag
+			break
+		}
+	}
+
+	// ticks are rounded to the decimal places of the step so that their
+	// labels do not show floating point error, such as 0.6000000000000001
+	scale := math.Pow(10, math.Max(0, -math.Floor(math.Log10(step))))
+	tick := func(i float64) float64 { return math.Round(i*step*scale) / scale }
+
+	// the first tick is at or below lo and ticks are added until one is at or
+	// above hi, so that the ticks cover the data, which takes at most n+2 ticks
+	first := math.Floor(lo / step)
+	ticks := []float64{tick(first)}
+	for i := first + 1; (len(ticks) < 2 || ticks[len(ticks)-1] < hi) && len(ticks) < n+3; i++ {
+		ticks = append(ticks, tick(i))
+	}
+	return ticks
+}
+
+func formatReportValue(v float64, unit UnitType) string {
+	switch {
+	case unit == UnitTypePercent:
+		return strconv.FormatFloat(v*100, 'f', -1, 64) + "%"
+	case unit == UnitTypeBytes:
+		return FormatBytes(v)
+	case isLargeNumber(v) || unit == UnitTypeCount || unit == UnitTypeSeconds:
+		return FormatSI(v) + unit.Suffix()
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+func reportColor(color string, i int) [3]int {
+	r, g, b, ok := parseColor(color)
+	if !ok {
+		r, g, b, _ = parseColor(reportDefaultColors[i%len(reportDefaultColors)])
+	}
+	return [3]int{int(r), int(g), int(b)}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNiceTicks(t *testing.T) {
+	tests := []struct {
+		lo, hi float64
+		n      int
+		want   []float64
+	}{
+		{lo: 0, hi: 10, n: 5, want: []float64{0, 2, 4, 6, 8, 10}},
+		{lo: 2, hi: 17, n: 4, want: []float64{0, 5, 10, 15, 20}},
+		{lo: -3, hi: 7, n: 5, want: []float64{-4, -2, 0, 2, 4, 6, 8}},
+		{lo: 0.1, hi: 0.75, n: 4, want: []float64{0, 0.2, 0.4, 0.6, 0.8}},
+		{lo: 5, hi: 5, n: 4, want: []float64{5, 5.5, 6}},
+	}
+	for _, tt := range tests {
+		got := niceTicks(tt.lo, tt.hi, tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("niceTicks(%v, %v, %d) = %v, want %v", tt.lo, tt.hi, tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("niceTicks(%v, %v, %d) = %v, want %v", tt.lo, tt.hi, tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNiceTicksCoverRange(t *testing.T) {
+	for _, r := range [][2]float64{{2, 17}, {0, 1}, {-17, -2}, {3, 1003}, {0.001, 0.0173}, {1e9, 1.7e9}, {99, 101}} {
+		for n := 2; n <= 6; n++ {
+			ticks := niceTicks(r[0], r[1], n)
+			if ticks[0] > r[0] || ticks[len(ticks)-1] < r[1] {
+				t.Errorf("niceTicks(%v, %v, %d) = %v does not cover the range", r[0], r[1], n, ticks)
+			}
+		}
+	}
+}
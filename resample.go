@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type GapFillType string
+
+const (
+	GapFillNull     GapFillType = "null"     // missing buckets have a null value
+	GapFillZero     GapFillType = "zero"     // missing buckets have a zero value
+	GapFillPrevious GapFillType = "previous" // missing buckets have the value of the previous bucket
+)
+
+func (t GapFillType) String() string { return string(t) }
+
+// parseInterval parses a resampling interval given as a duration such as
+// 15m or 6h, or as a number of days or weeks such as 1d or 2w
+func parseInterval(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, perr := strconv.Atoi(s[:len(s)-1])
+		if perr != nil {
+			err = perr
+			break
+		}
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid interval: %q", s)
+	}
+	return d, nil
+}
+
+// parseShift parses a signed interval such as -7d or 1w
+func parseShift(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	unsigned := s
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		unsigned = s[1:]
+	case strings.HasPrefix(s, "+"):
+		unsigned = s[1:]
+	}
+	d, err := parseInterval(unsigned)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shift: %q", s)
+	}
+	return sign * d, nil
+}
+
+// toTime converts a time value, or a string in RFC3339 or yyyy-mm-dd format,
+// to a time
+func toTime(v any) (time.Time, bool) {
+	switch tv := v.(type) {
+	case time.Time:
+		return tv, true
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", time.DateOnly} {
+			if t, err := time.Parse(layout, tv); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// ComputeResample groups the rows of the input into buckets of the interval
+// by the time in their join field and combines the values in each bucket
+// using the aggregate function. Buckets of whole days start at midnight in
+// loc and buckets of whole weeks on weekStart, as the periods of plots do.
+// Buckets between the first and last that have no rows are filled according
+// to gapFill. The computed dataset has the start time and value of each
+// bucket.
+func ComputeResample(ctx context.Context, in ComputeInput, interval time.Duration, fn AggregateFunc, gapFill GapFillType, loc *time.Location, weekStart WeekStart) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	buckets := make(map[time.Time][]any)
+	var first, last time.Time
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		t, ok := toTime(join)
+		if !ok {
+			return nil, fmt.Errorf("cannot resample join field value %v of type %T, expected a time", join, join)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+
+		bucket := resampleBucket(t, interval, loc, weekStart)
+		if len(buckets) == 0 || bucket.Before(first) {
+			first = bucket
+		}
+		if len(buckets) == 0 || bucket.After(last) {
+			last = bucket
+		}
+		buckets[bucket] = append(buckets[bucket], value)
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	data := make(map[string][]any)
+	if len(buckets) == 0 {
+		return NewStaticDataSet(data), nil
+	}
+
+	var prev any
+	for bucket := first; !bucket.After(last); bucket = nextResampleBucket(bucket, interval) {
+		var value any
+		if values, ok := buckets[bucket]; ok {
+			var err error
+			value, err = fn(values)
+			if err != nil {
+				return nil, fmt.Errorf("bucket %s: %w", bucket.Format(time.RFC3339), err)
+			}
+		} else {
+			switch gapFill {
+			case GapFillZero:
+				value = 0.0
+			case GapFillPrevious:
+				value = prev
+			}
+		}
+		prev = value
+		data["field"] = append(data["field"], bucket)
+		data["value"] = append(data["value"], value)
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// resampleBucket returns the start of the bucket of the interval containing
+// t. Intervals shorter than a day, or that are not whole days, are counted
+// from the zero time. Intervals of whole days are counted in calendar days
+// in loc, from a day that starts a week, so that buckets of whole weeks start
+// on weekStart.
+func resampleBucket(t time.Time, interval time.Duration, loc *time.Location, weekStart WeekStart) time.Time {
+	const day = 24 * time.Hour
+	if interval%day != 0 {
+		return t.UTC().Truncate(interval)
+	}
+
+	start := PlotFrequencyDaily.Truncate(t.In(loc), weekStart)
+	days := int(interval / day)
+	if days == 1 {
+		return start
+	}
+
+	// count days on the calendar, which is the same in every location, so
+	// that days of 23 or 25 hours are whole days
+	epoch := PlotFrequencyWeekly.Truncate(time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC), weekStart)
+	y, m, d := start.Date()
+	n := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(epoch) / day)
+	offset := ((n % days) + days) % days
+	return start.AddDate(0, 0, -offset)
+}
+
+// nextResampleBucket returns the start of the bucket that follows the one
+// starting at bucket
+func nextResampleBucket(bucket time.Time, interval time.Duration) time.Time {
+	const day = 24 * time.Hour
+	if interval%day != 0 {
+		return bucket.Add(interval)
+	}
+	return bucket.AddDate(0, 0, int(interval/day))
+}
+
+// ComputeShift moves the time in the join field of each row of the input by
+// the shift, so that a previous period can be overlaid on the current one.
+// Shifts of whole days move times by calendar days in loc, so they keep
+// their time of day across changes to daylight saving time. The computed
+// dataset has the shifted time and value of each row.
+func ComputeShift(ctx context.Context, in ComputeInput, shift time.Duration, loc *time.Location) (DataSet, error) {
+	in.DataSet.ResetIterator()
+
+	data := make(map[string][]any)
+	for in.DataSet.Next() {
+		join := in.DataSet.Field(in.Def.JoinField)
+		if err, ok := join.(error); ok {
+			return nil, fmt.Errorf("did not get join field value %q from dataset %q: %w", in.Def.JoinField, in.Def.DataSet, err)
+		}
+		t, ok := toTime(join)
+		if !ok {
+			return nil, fmt.Errorf("cannot shift join field value %v of type %T, expected a time", join, join)
+		}
+		value := in.DataSet.Field(in.Def.ValueField)
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("did not get value field value %q from dataset %q: %w", in.Def.ValueField, in.Def.DataSet, err)
+		}
+		data["field"] = append(data["field"], shiftTime(t, shift, loc))
+		data["value"] = append(data["value"], value)
+	}
+	if in.DataSet.Err() != nil {
+		return nil, fmt.Errorf("dataset iteration ended with an error: %w", in.DataSet.Err())
+	}
+
+	return NewStaticDataSet(data), nil
+}
+
+// shiftTime moves t by the shift, by calendar days in loc if the shift is a
+// whole number of days
+func shiftTime(t time.Time, shift time.Duration, loc *time.Location) time.Time {
+	const day = 24 * time.Hour
+	if shift%day != 0 {
+		return t.Add(shift)
+	}
+	return t.In(loc).AddDate(0, 0, int(shift/day))
+}
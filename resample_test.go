@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestComputeResampleLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone database not available: %v", err)
+	}
+
+	// summer time starts in Berlin on 2024-03-31, a day of 23 hours
+	in := ComputeInput{
+		Def: ComputeDataSetDef{DataSet: "d", JoinField: "t", ValueField: "v"},
+		DataSet: NewStaticDataSet(map[string][]any{
+			"t": {
+				time.Date(2024, 3, 29, 22, 30, 0, 0, time.UTC), // 23:30 on the 29th in Berlin
+				time.Date(2024, 3, 29, 23, 30, 0, 0, time.UTC), // 00:30 on the 30th in Berlin
+				time.Date(2024, 3, 31, 21, 30, 0, 0, time.UTC), // 23:30 on the 31st in Berlin
+				time.Date(2024, 3, 31, 22, 30, 0, 0, time.UTC), // 00:30 on the 1st in Berlin
+			},
+			"v": {1.0, 2.0, 3.0, 4.0},
+		}),
+	}
+
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		weekStart WeekStart
+		want      []time.Time
+		values    []any
+	}{
+		{
+			name:     "daily",
+			interval: 24 * time.Hour,
+			want: []time.Time{
+				time.Date(2024, 3, 29, 0, 0, 0, 0, berlin),
+				time.Date(2024, 3, 30, 0, 0, 0, 0, berlin),
+				time.Date(2024, 3, 31, 0, 0, 0, 0, berlin),
+				time.Date(2024, 4, 1, 0, 0, 0, 0, berlin),
+			},
+			values: []any{1.0, 2.0, 3.0, 4.0},
+		},
+		{
+			name:      "weekly from sunday",
+			interval:  7 * 24 * time.Hour,
+			weekStart: WeekStartSunday,
+			want: []time.Time{
+				time.Date(2024, 3, 24, 0, 0, 0, 0, berlin),
+				time.Date(2024, 3, 31, 0, 0, 0, 0, berlin),
+			},
+			values: []any{3.0, 7.0},
+		},
+		{
+			name:     "weekly from monday",
+			interval: 7 * 24 * time.Hour,
+			want: []time.Time{
+				time.Date(2024, 3, 25, 0, 0, 0, 0, berlin),
+				time.Date(2024, 4, 1, 0, 0, 0, 0, berlin),
+			},
+			values: []any{6.0, 4.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, err := ComputeResample(context.Background(), in, tt.interval, aggregateFuncs[ComputeTypeSum], GapFillZero, berlin, tt.weekStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []time.Time
+			var values []any
+			for ds.Next() {
+				got = append(got, ds.Field("field").(time.Time))
+				values = append(values, ds.Field("value"))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got buckets %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) || values[i] != tt.values[i] {
+					t.Errorf("bucket %d is %v with %v, want %v with %v", i, got[i], values[i], tt.want[i], tt.values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestComputeShiftLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone database not available: %v", err)
+	}
+
+	// summer time starts in Berlin on 2024-03-31, so the week before the
+	// 2nd of April is an hour shorter than 7 days
+	in := ComputeInput{
+		Def: ComputeDataSetDef{DataSet: "d", JoinField: "t", ValueField: "v"},
+		DataSet: NewStaticDataSet(map[string][]any{
+			"t": {time.Date(2024, 3, 26, 0, 0, 0, 0, berlin)},
+			"v": {1.0},
+		}),
+	}
+
+	tests := []struct {
+		name  string
+		shift time.Duration
+		want  time.Time
+	}{
+		{name: "week", shift: 7 * 24 * time.Hour, want: time.Date(2024, 4, 2, 0, 0, 0, 0, berlin)},
+		{name: "hours", shift: 7 * 24 * time.Hour / 2, want: time.Date(2024, 3, 29, 12, 0, 0, 0, berlin)},
+		{name: "back a day", shift: -24 * time.Hour, want: time.Date(2024, 3, 25, 0, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, err := ComputeShift(context.Background(), in, tt.shift, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ds.Next() {
+				t.Fatal("computed dataset has no rows")
+			}
+			if got := ds.Field("field").(time.Time); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BatchResults is a machine readable record of the outcome of a batch run
+type BatchResults struct {
+	BasisTime time.Time      `json:"basisTime"`
+	Started   time.Time      `json:"started"`
+	Finished  time.Time      `json:"finished"`
+	Plots     []*BatchResult `json:"plots"`
+
+	mu sync.Mutex
+}
+
+// BatchResult records the outcome of a single plot in a batch run
+type BatchResult struct {
+	Plot       string         `json:"plot"`           // the output filename of the plot, unique within a run
+	Name       string         `json:"name,omitempty"` // the name of the plot
+	Definition string         `json:"definition"`     // the filename of the plot definition
+	Output     string         `json:"output,omitempty"`
+	Latest     string         `json:"latest,omitempty"`    // the path of the latest copy of the output, if it was updated
+	Thumbnail  string         `json:"thumbnail,omitempty"` // the path of the thumbnail of the output, if one was written
+	Versions   string         `json:"versions,omitempty"`  // a glob pattern matching the outputs of the plot for every period
+	Frequency  PlotFrequency  `json:"frequency,omitempty"`
+	Checksum   string         `json:"checksum,omitempty"` // the checksum of the templated plot definition and its queries
+	Status     BatchStatus    `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	Duration   float64        `json:"duration"` // seconds taken to process the plot
+	RowCounts  map[string]int `json:"rowCounts,omitempty"`
+	Deferred   float64        `json:"deferred,omitempty"` // seconds spent waiting for source blackout windows to end or dependencies to be satisfied
+
+	Scalars map[string]float64 `json:"scalars,omitempty"` // the values of the named scalars of a generated plot
+}
+
+type BatchStatus string
+
+const (
+	BatchStatusGenerated BatchStatus = "generated" // the plot was generated and written
+	BatchStatusSkipped   BatchStatus = "skipped"   // the plot did not need to be generated
+	BatchStatusFailed    BatchStatus = "failed"    // the plot could not be generated
+	BatchStatusBlackout  BatchStatus = "blackout"  // the plot was not generated because a source was in a blackout window
+	BatchStatusUnmet     BatchStatus = "unmet"     // the plot was not generated because a dependency was not satisfied in time
+
+	// the plot was not generated because its precondition did not hold
+	BatchStatusPreconditionNotMet BatchStatus = "preconditionNotMet"
+)
+
+func (s BatchStatus) String() string { return string(s) }
+
+// UnmarshalText accepts the statuses written by earlier versions, so their
+// results can still be compared
+func (s *BatchStatus) UnmarshalText(text []byte) error {
+	*s = BatchStatus(text)
+	if *s == "precondition-not-met" {
+		*s = BatchStatusPreconditionNotMet
+	}
+	return nil
+}
+
+func NewBatchResults(basisTime time.Time) *BatchResults {
+	return &BatchResults{
+		BasisTime: basisTime,
+		Started:   time.Now().UTC(),
+	}
+}
+
+// Add records the result of a plot. It is safe for concurrent use.
+func (r *BatchResults) Add(res *BatchResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Plots = append(r.Plots, res)
+}
+
+// Write writes the results as json to the named file.
+func (r *BatchResults) Write(fname string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now().UTC()
+	sort.Slice(r.Plots, func(i, j int) bool {
+		return r.Plots[i].Plot < r.Plots[j].Plot
+	})
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal results: %w", err)
+	}
+	if err := writeOutput(fname, append(data, '\n')); err != nil {
+		return fmt.Errorf("write results: %w", err)
+	}
+	return nil
+}
+
+func ReadBatchResults(fname string) (*BatchResults, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("read results: %w", err)
+	}
+
+	var r BatchResults
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal results: %w", err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+var runsCommand = &cli.Command{
+	Name:  "runs",
+	Usage: "Commands for working with the results of batch runs",
+	Subcommands: []*cli.Command{
+		runsCompareCommand,
+	},
+}
+
+var runsCompareCommand = &cli.Command{
+	Name:      "compare",
+	Usage:     "Compare the results of two batch runs, written using the batch --results option",
+	ArgsUsage: "<old results> <new results>",
+	Action:    RunsCompare,
+	Flags: []cli.Flag{
+		&cli.Float64Flag{
+			Name:        "duration-threshold",
+			Required:    false,
+			Usage:       "Report plots whose duration increased by more than this fraction.",
+			Value:       0.5,
+			Destination: &runsCompareOpts.durationThreshold,
+		},
+		&cli.Float64Flag{
+			Name:        "min-duration",
+			Required:    false,
+			Usage:       "Ignore duration regressions for plots that took less than this many seconds in the new run.",
+			Value:       5,
+			Destination: &runsCompareOpts.minDuration,
+		},
+		&cli.Float64Flag{
+			Name:        "rowcount-threshold",
+			Required:    false,
+			Usage:       "Report datasets whose row count changed by more than this fraction.",
+			Value:       0.2,
+			Destination: &runsCompareOpts.rowCountThreshold,
+		},
+	},
+}
+
+var runsCompareOpts struct {
+	durationThreshold float64
+	minDuration       float64
+	rowCountThreshold float64
+}
+
+func RunsCompare(cc *cli.Context) error {
+	if cc.NArg() != 2 {
+		return fmt.Errorf("two batch results files must be supplied as arguments")
+	}
+
+	oldRun, err := ReadBatchResults(cc.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("old run: %w", err)
+	}
+	newRun, err := ReadBatchResults(cc.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("new run: %w", err)
+	}
+
+	cmp := CompareBatchResults(oldRun, newRun, runsCompareOpts.durationThreshold, runsCompareOpts.minDuration, runsCompareOpts.rowCountThreshold)
+	cmp.Print(os.Stdout)
+	return nil
+}
+
+// A RunComparison lists the notable differences between two batch runs
+type RunComparison struct {
+	NewlyFailing []*BatchResult
+	Recovered    []*BatchResult
+	Slower       []DurationChange
+	RowCounts    []RowCountChange
+}
+
+type DurationChange struct {
+	Plot string
+	Old  float64
+	New  float64
+}
+
+type RowCountChange struct {
+	Plot    string
+	DataSet string
+	Old     int
+	New     int
+}
+
+func CompareBatchResults(oldRun, newRun *BatchResults, durationThreshold, minDuration, rowCountThreshold float64) *RunComparison {
+	oldPlots := make(map[string]*BatchResult, len(oldRun.Plots))
+	for _, res := range oldRun.Plots {
+		oldPlots[res.Plot] = res
+	}
+
+	cmp := &RunComparison{}
+	for _, res := range newRun.Plots {
+		prev, ok := oldPlots[res.Plot]
+		if !ok {
+			if res.Status == BatchStatusFailed {
+				cmp.NewlyFailing = append(cmp.NewlyFailing, res)
+			}
+			continue
+		}
+
+		if res.Status == BatchStatusFailed && prev.Status != BatchStatusFailed {
+			cmp.NewlyFailing = append(cmp.NewlyFailing, res)
+		} else if res.Status != BatchStatusFailed && prev.Status == BatchStatusFailed {
+			cmp.Recovered = append(cmp.Recovered, res)
+		}
+
+		// only generated plots have comparable durations and row counts
+		if res.Status != BatchStatusGenerated || prev.Status != BatchStatusGenerated {
+			continue
+		}
+
+		if res.Duration >= minDuration && res.Duration > prev.Duration*(1+durationThreshold) {
+			cmp.Slower = append(cmp.Slower, DurationChange{Plot: res.Plot, Old: prev.Duration, New: res.Duration})
+		}
+
+		for dsname, n := range res.RowCounts {
+			prevn, ok := prev.RowCounts[dsname]
+			if !ok {
+				continue
+			}
+			if relativeChange(float64(prevn), float64(n)) > rowCountThreshold {
+				cmp.RowCounts = append(cmp.RowCounts, RowCountChange{Plot: res.Plot, DataSet: dsname, Old: prevn, New: n})
+			}
+		}
+	}
+
+	sort.Slice(cmp.RowCounts, func(i, j int) bool {
+		if cmp.RowCounts[i].Plot != cmp.RowCounts[j].Plot {
+			return cmp.RowCounts[i].Plot < cmp.RowCounts[j].Plot
+		}
+		return cmp.RowCounts[i].DataSet < cmp.RowCounts[j].DataSet
+	})
+
+	return cmp
+}
+
+func (c *RunComparison) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "Newly failing plots: %d\n", len(c.NewlyFailing))
+	for _, res := range c.NewlyFailing {
+		fmt.Fprintf(tw, "  %s\t%s\n", res.Plot, res.Error)
+	}
+
+	fmt.Fprintf(tw, "Recovered plots: %d\n", len(c.Recovered))
+	for _, res := range c.Recovered {
+		fmt.Fprintf(tw, "  %s\n", res.Plot)
+	}
+
+	fmt.Fprintf(tw, "Duration regressions: %d\n", len(c.Slower))
+	for _, d := range c.Slower {
+		fmt.Fprintf(tw, "  %s\t%.1fs -> %.1fs\n", d.Plot, d.Old, d.New)
+	}
+
+	fmt.Fprintf(tw, "Row count changes: %d\n", len(c.RowCounts))
+	for _, r := range c.RowCounts {
+		fmt.Fprintf(tw, "  %s\t%s\t%d -> %d\n", r.Plot, r.DataSet, r.Old, r.New)
+	}
+}
+
+// relativeChange returns the absolute change from old to new as a fraction of
+// old. Any change from zero is treated as infinite.
+func relativeChange(old, new float64) float64 {
+	if old == 0 {
+		if new == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(new-old) / math.Abs(old)
+}
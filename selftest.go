@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var selftestCommand = &cli.Command{
+	Name:   "selftest",
+	Usage:  "Generate a bundled set of plots end to end against a disposable Postgres database and verify their outputs",
+	Action: Selftest,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    false,
+			Usage:       "Url of an existing Postgres database to use instead of starting one in docker. The ashby_selftest schema is dropped and recreated in it.",
+			Destination: &selftestOpts.source,
+		},
+		&cli.StringFlag{
+			Name:        "image",
+			Required:    false,
+			Usage:       "Docker image used to start the disposable Postgres database.",
+			Value:       "postgres:16-alpine",
+			Destination: &selftestOpts.image,
+		},
+		&cli.DurationFlag{
+			Name:        "startup-timeout",
+			Required:    false,
+			Usage:       "Maximum time to wait for the database to accept connections.",
+			Value:       time.Minute,
+			Destination: &selftestOpts.startupTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Required:    false,
+			Usage:       "Directory to write the generated plots to, for inspection.",
+			Destination: &selftestOpts.outDir,
+		},
+	}, loggingFlags...),
+}
+
+var selftestOpts struct {
+	source         string
+	image          string
+	startupTimeout time.Duration
+	outDir         string
+}
+
+// selftestSchema is loaded into the database before the selftest plots are
+// generated. It uses the column types that need conversion when read, such as
+// dates, numerics and booleans.
+const selftestSchema = `
+DROP SCHEMA IF EXISTS ashby_selftest CASCADE;
+CREATE SCHEMA ashby_selftest;
+
+CREATE TABLE ashby_selftest.visits (
+	day        date NOT NULL,
+	region     text NOT NULL,
+	visits     bigint NOT NULL,
+	latency_ms double precision
+);
+INSERT INTO ashby_selftest.visits VALUES
+	('2024-01-01', 'eu', 100, 40.5), ('2024-01-01', 'us', 200, 61.0),
+	('2024-01-02', 'eu', 110, 41.5), ('2024-01-02', 'us', 190, 62.0),
+	('2024-01-03', 'eu', 120, 42.5), ('2024-01-03', 'us', 180, NULL),
+	('2024-01-04', 'eu', 130, 43.5), ('2024-01-04', 'us', 170, 64.0),
+	('2024-01-05', 'eu', 140, 44.5), ('2024-01-05', 'us', 160, 65.0),
+	('2024-01-06', 'eu', 150, 45.5), ('2024-01-06', 'us', 150, 66.0),
+	('2024-01-07', 'eu', 160, 46.5), ('2024-01-07', 'us', 140, 67.0),
+	('2024-01-08', 'eu', 999, 99.0), ('2024-01-08', 'us', 999, 99.0);
+
+CREATE TABLE ashby_selftest.peers (
+	agent  text NOT NULL,
+	online boolean NOT NULL,
+	count  numeric(20, 0) NOT NULL
+);
+INSERT INTO ashby_selftest.peers VALUES
+	('kubo', true, 1200), ('kubo', true, 800), ('kubo', false, 300),
+	('lotus', true, 500), ('lotus', false, 50);
+`
+
+// selftestBasisTime is the basis time of the selftest plots. Rows on or after
+// it must be excluded by their queries.
+var selftestBasisTime = time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+
+// A selftestCase is a plot definition that is generated against the selftest
+// database, with the values its series and scalars are expected to have
+type selftestCase struct {
+	Name    string
+	Def     string
+	Series  map[string][]float64
+	Scalars map[string]float64
+}
+
+var selftestCases = []selftestCase{
+	{
+		Name: "daily-visits",
+		Def: `name: daily-visits
+frequency: daily
+datasets:
+  - name: visits
+    source: selftest
+    query: |
+      SELECT day, sum(visits) AS visits
+      FROM ashby_selftest.visits
+      WHERE day < {{ .Now | sqlTimestampTZ "selftest" }}
+      GROUP BY day ORDER BY day
+series:
+  - type: line
+    name: visits
+    dataset: visits
+    labels: day
+    values: visits
+`,
+		Series: map[string][]float64{"visits": {300, 300, 300, 300, 300, 300, 300}},
+	},
+	{
+		Name: "visits-by-region",
+		Def: `name: visits-by-region
+datasets:
+  - name: visits
+    source: selftest
+    query: |
+      SELECT day, region, visits
+      FROM ashby_selftest.visits
+      WHERE day < {{ .Now | timestamptz }}
+      ORDER BY day, region
+series:
+  - type: bar
+    dataset: visits
+    labels: day
+    values: visits
+    groupfield: region
+    groupvalue: "*"
+`,
+		Series: map[string][]float64{
+			"eu": {100, 110, 120, 130, 140, 150, 160},
+			"us": {200, 190, 180, 170, 160, 150, 140},
+		},
+	},
+	{
+		Name: "peers-online",
+		Def: `name: peers-online
+datasets:
+  - name: peers
+    source: selftest
+    query: |
+      SELECT agent, online, sum(count) AS peers
+      FROM ashby_selftest.peers
+      GROUP BY agent, online ORDER BY agent, online
+series:
+  - type: bar
+    dataset: peers
+    labels: agent
+    values: peers
+    groupfield: online
+    groupvalue: "*"
+    valueLabels:
+      "true": online
+      "false": offline
+`,
+		Series: map[string][]float64{
+			"offline": {300, 50},
+			"online":  {2000, 500},
+		},
+	},
+	{
+		Name: "total-visits",
+		Def: `name: total-visits
+datasets:
+  - name: total
+    source: selftest
+    query: |
+      SELECT sum(visits) AS visits
+      FROM ashby_selftest.visits
+      WHERE day < {{ .Now | timestamptz }}
+scalars:
+  - type: number
+    name: total visits
+    dataset: total
+    value: visits
+`,
+		Scalars: map[string]float64{"total visits": 2100},
+	},
+	{
+		Name: "latency-heatmap",
+		Def: `name: latency-heatmap
+datasets:
+  - name: latency
+    source: selftest
+    query: |
+      SELECT day, region, latency_ms
+      FROM ashby_selftest.visits
+      WHERE day < {{ .Now | timestamptz }}
+      ORDER BY day, region
+tables:
+  - type: heatmap
+    name: latency
+    dataset: latency
+    xLabels: day
+    yLabels: region
+    values: latency_ms
+`,
+	},
+}
+
+func Selftest(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	connstr := selftestOpts.source
+	if connstr == "" {
+		var stop func()
+		var err error
+		connstr, stop, err = startPostgresContainer(ctx, selftestOpts.image, selftestOpts.startupTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to start postgres: %w", err)
+		}
+		defer stop()
+	}
+
+	cfg, err := selftestConfig(ctx, connstr, selftestOpts.startupTimeout)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, tc := range selftestCases {
+		if err := runSelftestCase(ctx, cfg, tc, selftestOpts.outDir); err != nil {
+			fmt.Printf("%s: failed: %v\n", tc.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: ok\n", tc.Name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d selftest plots failed", failed, len(selftestCases))
+	}
+	return nil
+}
+
+// startPostgresContainer starts a disposable postgres container, returning
+// the url of its database and a function that removes the container
+func startPostgresContainer(ctx context.Context, image string, timeout time.Duration) (connstr string, stop func(), err error) {
+	// testcontainers panics when it cannot find a docker host
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("docker is not available: %v", r)
+		}
+	}()
+
+	slog.Info("starting postgres container", "image", image)
+	ctr, err := postgres.Run(ctx, image,
+		postgres.WithUsername("ashby"),
+		postgres.WithPassword("ashby"),
+		postgres.WithDatabase("ashby"),
+		testcontainers.WithWaitStrategy(
+			// postgres restarts once after initializing the database
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(timeout)),
+	)
+	stop = func() {
+		slog.Info("removing postgres container")
+		if err := testcontainers.TerminateContainer(ctr); err != nil {
+			slog.Error("failed to remove postgres container", "error", err)
+		}
+	}
+	if err != nil {
+		stop()
+		return "", nil, err
+	}
+
+	connstr, err = ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("connection string: %w", err)
+	}
+	return connstr, stop, nil
+}
+
+// selftestConfig loads the selftest schema into the database and returns
+// the configuration the selftest plots are generated with
+func selftestConfig(ctx context.Context, connstr string, timeout time.Duration) (*PlotConfig, error) {
+	if err := loadSelftestSchema(ctx, connstr, timeout); err != nil {
+		return nil, err
+	}
+
+	cfg, err := newPlotConfig(ctx, "", nil, false, time.UTC, "")
+	if err != nil {
+		return nil, err
+	}
+	cfg.BasisTime = selftestBasisTime
+	cfg.Sources["selftest"] = NewPgDataSource(connstr)
+	return cfg, nil
+}
+
+// loadSelftestSchema waits for the database to accept connections and loads
+// the selftest schema into it
+func loadSelftestSchema(ctx context.Context, connstr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var conn *pgx.Conn
+	for {
+		var err error
+		conn, err = pgx.Connect(ctx, connstr)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("database did not accept connections within %s: %w", timeout, err)
+		}
+		slog.Debug("waiting for database", "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	defer conn.Close(ctx)
+
+	slog.Info("loading selftest schema")
+	if _, err := conn.Exec(ctx, selftestSchema); err != nil {
+		return fmt.Errorf("failed to load selftest schema: %w", err)
+	}
+	return nil
+}
+
+// runSelftestCase generates the plot of a selftest case and compares its
+// series and scalars with the expected values. The figure json is written to
+// outDir if it is not empty.
+func runSelftestCase(ctx context.Context, cfg *PlotConfig, tc selftestCase, outDir string) error {
+	pd, cfg, err := loadPlotDef(ctx, tc.Name+".yaml", []byte(tc.Def), nil, ".", cfg)
+	if err != nil {
+		return err
+	}
+
+	fig, err := generateFig(ctx, pd, cfg, nil)
+	if err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+	if err := checkFigure(fig, FigureCheckFail, slog.With("name", pd.Name)); err != nil {
+		return err
+	}
+	if len(fig.Data) == 0 {
+		return fmt.Errorf("figure has no traces")
+	}
+
+	data, err := json.MarshalIndent(FigureData{Figure: fig}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if outDir != "" {
+		if err := writeOutput(filepath.Join(outDir, tc.Name+".json"), append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	for name, want := range tc.Series {
+		var got []any
+		found := false
+		for _, ls := range fig.Series {
+			if ls.Name == name {
+				got, found = ls.Values, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("series %q is missing", name)
+		}
+		if len(got) != len(want) {
+			return fmt.Errorf("series %q has %d values, expected %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if v, ok := toFloat64(got[i]); !ok || !selftestEqual(v, want[i]) {
+				return fmt.Errorf("series %q value %d is %v, expected %v", name, i, got[i], want[i])
+			}
+		}
+	}
+
+	scalars := figureScalars(fig)
+	for name, want := range tc.Scalars {
+		got, ok := scalars[name]
+		if !ok {
+			return fmt.Errorf("scalar %q is missing", name)
+		}
+		if !selftestEqual(got, want) {
+			return fmt.Errorf("scalar %q is %v, expected %v", name, got, want)
+		}
+	}
+	return nil
+}
+
+func selftestEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// TestSelftest generates the bundled selftest plots against a disposable
+// Postgres container. It is skipped when docker is not available.
+func TestSelftest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a postgres container")
+	}
+	skipWithoutDocker(t)
+
+	ctx := context.Background()
+	connstr, stop, err := startPostgresContainer(ctx, "postgres:16-alpine", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to start postgres: %v", err)
+	}
+	t.Cleanup(stop)
+
+	cfg, err := selftestConfig(ctx, connstr, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range selftestCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := runSelftestCase(ctx, cfg, tc, t.TempDir()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// skipWithoutDocker skips the test if there is no docker host to start
+// containers on. testcontainers panics when it cannot find one.
+func skipWithoutDocker(t *testing.T) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("docker is not available: %v", r)
+		}
+	}()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+}
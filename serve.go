@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slog"
+)
+
+var serveCommand = &cli.Command{
+	Name:   "serve",
+	Usage:  "Serve an HTTP API that lists plot definitions, generates plots on demand and serves the outputs of batch runs",
+	Action: Serve,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr",
+			Required:    false,
+			Usage:       "Address to listen on.",
+			Value:       "localhost:8080",
+			Destination: &serveOpts.addr,
+			EnvVars:     []string{envPrefix + "ADDR"},
+		},
+		&cli.StringFlag{
+			Name:        "defs",
+			Required:    true,
+			Usage:       "Path of directory containing the plot definitions to serve.",
+			Destination: &serveOpts.defsDir,
+			EnvVars:     []string{envPrefix + "DEFS"},
+		},
+		&cli.StringFlag{
+			Name:        "conf",
+			Required:    false,
+			Usage:       "Path of directory containing configuration.",
+			Destination: &serveOpts.confDir,
+			EnvVars:     []string{envPrefix + "CONF"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "source",
+			Aliases:     []string{"s"},
+			Required:    false,
+			Usage:       "Specify the url of a data source, in the format name=url. May be repeated to specify multiple sources.",
+			Destination: &serveOpts.sources,
+		},
+		&cli.BoolFlag{
+			Name:        "resolve-sources",
+			Required:    false,
+			Usage:       "Check that the hosts of all data sources can be resolved before serving.",
+			Destination: &serveOpts.resolveSources,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Required:    false,
+			Usage:       "Path of an output tree written by batch to serve under /outputs/, or an s3 or gcs url of the form s3://bucket/prefix or gs://bucket/prefix.",
+			Destination: &serveOpts.outDir,
+			EnvVars:     []string{envPrefix + "OUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "s3-path-style",
+			Required:    false,
+			Usage:       "Address S3 buckets in the path of request urls rather than the hostname, as needed by some S3 compatible stores.",
+			Destination: &serveOpts.s3PathStyle,
+			EnvVars:     []string{envPrefix + "S3_PATH_STYLE"},
+		},
+		&cli.StringFlag{
+			Name:        "figure-checks",
+			Required:    false,
+			Usage:       "How to handle problems found in generated figures, such as data arrays of different lengths: off, warn or fail.",
+			Value:       string(FigureCheckWarn),
+			Destination: &serveOpts.figureChecks,
+		},
+		&cli.StringFlag{
+			Name:        "non-finite",
+			Required:    false,
+			Usage:       "How to handle NaN and infinite values in datasets of plots that do not specify a policy: null, drop the rows containing them or fail.",
+			Value:       string(NonFiniteNull),
+			Destination: &serveOpts.nonFinite,
+		},
+		&cli.StringFlag{
+			Name:        "week-start",
+			Required:    false,
+			Usage:       "Day of the week that weekly periods and the week template variables start on, such as monday or sunday.",
+			Value:       string(WeekStartMonday),
+			Destination: &serveOpts.weekStart,
+		},
+		&cli.StringFlag{
+			Name:        "tz",
+			Required:    false,
+			Usage:       "IANA timezone, such as Europe/Berlin, that periods and the template variables are computed in, unless the plot sets its own timezone.",
+			Value:       "UTC",
+			Destination: &serveOpts.tz,
+		},
+	}, loggingFlags...),
+}
+
+var serveOpts struct {
+	addr           string
+	defsDir        string
+	confDir        string
+	sources        cli.StringSlice
+	resolveSources bool
+	outDir         string
+	s3PathStyle    bool
+	figureChecks   string
+	nonFinite      string
+	weekStart      string
+	tz             string
+}
+
+func Serve(cc *cli.Context) error {
+	ctx := cc.Context
+	setupLogging()
+
+	if err := FigureCheckMode(serveOpts.figureChecks).Validate(); err != nil {
+		return err
+	}
+	tz, err := loadTimezone(serveOpts.tz)
+	if err != nil {
+		return err
+	}
+	if err := NonFinitePolicy(serveOpts.nonFinite).Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := newPlotConfig(ctx, serveOpts.confDir, serveOpts.sources.Value(), serveOpts.resolveSources, tz, serveOpts.weekStart)
+	if err != nil {
+		return err
+	}
+	cfg.NonFinite = NonFinitePolicy(serveOpts.nonFinite)
+
+	srv := &plotServer{
+		cfg:          cfg,
+		tz:           tz,
+		defs:         os.DirFS(serveOpts.defsDir),
+		figureChecks: FigureCheckMode(serveOpts.figureChecks),
+	}
+	if serveOpts.outDir != "" {
+		srv.store, srv.outBase, err = newOutputStore(ctx, serveOpts.outDir, serveOpts.s3PathStyle)
+		if err != nil {
+			return err
+		}
+	}
+
+	hs := &http.Server{
+		Addr:              serveOpts.addr,
+		Handler:           srv.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		hs.Close()
+	}()
+
+	slog.Info("serving plots", "addr", serveOpts.addr, "definitions", serveOpts.defsDir)
+	if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
+
+// plotServer generates plots from the definitions in a directory on request
+// and serves the files of an output tree
+type plotServer struct {
+	cfg          *PlotConfig // copied for each request, which sets its basis time and parameters
+	tz           *time.Location
+	defs         fs.FS
+	figureChecks FigureCheckMode
+	store        OutputStore // the store of the output tree, nil if none is served
+	outBase      string
+}
+
+func (s *plotServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/plots", s.listPlots)
+	mux.HandleFunc("GET /api/plots/{file}", s.renderPlot)
+	mux.HandleFunc("GET /outputs/{path...}", s.serveOutput)
+	return mux
+}
+
+// ServedPlot describes a plot definition that can be generated by the server
+type ServedPlot struct {
+	File      string        `json:"file"` // the filename of the definition, used to generate it
+	Name      string        `json:"name,omitempty"`
+	Frequency PlotFrequency `json:"frequency,omitempty"`
+	Error     string        `json:"error,omitempty"` // why the definition could not be parsed
+}
+
+// listPlots lists the plot definitions, as parsed for the current time
+// without any parameters
+func (s *plotServer) listPlots(w http.ResponseWriter, r *http.Request) {
+	fnames, err := fs.Glob(s.defs, "*.yaml")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, fmt.Errorf("list plot definitions: %w", err))
+		return
+	}
+	sort.Strings(fnames)
+
+	cfg := s.requestConfig(time.Now(), nil)
+	plots := make([]ServedPlot, 0, len(fnames))
+	for _, fname := range fnames {
+		sp := ServedPlot{File: fname}
+		content, err := fs.ReadFile(s.defs, fname)
+		if err == nil {
+			var pd *PlotDef
+			if pd, _, err = loadPlotDef(r.Context(), fname, content, s.defs, path.Dir(fname), cfg); err == nil {
+				sp.Name = pd.Name
+				sp.Frequency = pd.Frequency
+			}
+		}
+		if err != nil {
+			sp.Error = err.Error()
+		}
+		plots = append(plots, sp)
+	}
+	writeJSON(w, plots)
+}
+
+// renderPlot generates a plot. The basis and format query parameters set the
+// basis time, in the formats accepted by batch, and the output format. Every
+// other query parameter is passed to the plot's templates as a parameter and
+// must be declared by the plot definition or the variants of a profile, since
+// templates are spliced into the text of queries.
+func (s *plotServer) renderPlot(w http.ResponseWriter, r *http.Request) {
+	fname := r.PathValue("file")
+	if !fs.ValidPath(fname) || strings.Contains(fname, "/") {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid plot definition name: %q", fname))
+		return
+	}
+
+	query := r.URL.Query()
+	basisTime := time.Now()
+	if basis := query.Get("basis"); basis != "" {
+		var err error
+		if basisTime, err = parseBasisTime(basis); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	format := OutputFormatPlotly
+	if f := query.Get("format"); f != "" {
+		format = OutputFormat(f)
+	}
+	if err := format.Validate(); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	params := map[string]any{}
+	for key, values := range query {
+		if key == "basis" || key == "format" {
+			continue
+		}
+		params[key] = values[len(values)-1]
+	}
+
+	content, err := fs.ReadFile(s.defs, fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			httpError(w, http.StatusNotFound, fmt.Errorf("unknown plot definition: %q", fname))
+			return
+		}
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx := r.Context()
+	pd, cfg, err := loadPlotDef(ctx, fname, content, s.defs, path.Dir(fname), s.requestConfig(basisTime, nil))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(params) > 0 {
+		declared := s.declaredParams(pd)
+		for key := range params {
+			if !declared[key] {
+				httpError(w, http.StatusBadRequest, fmt.Errorf("undeclared plot parameter: %q", key))
+				return
+			}
+		}
+		pd, cfg, err = loadPlotDef(ctx, fname, content, s.defs, path.Dir(fname), s.requestConfig(basisTime, params))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	logger := slog.With("name", pd.Name)
+	logger.Info("generating figure", "filename", fname, "basis_time", cfg.BasisTime)
+	fig, err := generateFig(ctx, pd, cfg, nil)
+	if err != nil {
+		logger.Error("failed to generate plot", "error", err)
+		httpError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate plot: %w", err))
+		return
+	}
+	if err := checkFigure(fig, s.figureChecks, logger); err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	figDat := FigureData{
+		Figure:    fig,
+		Metadata:  newFigureMetadata(pd, cfg, time.Now()),
+		Params:    pd.Parameters,
+		DynLayout: pd.DynLayout,
+		Drilldown: pd.Drilldown,
+		Config:    pd.Config,
+	}
+	data, err := marshalOutput(format, pd, figDat, cfg, true, false)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, fmt.Errorf("failed to marshal output: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Write(data)
+}
+
+// serveOutput serves a file of the output tree, such as latest/peers.json
+func (s *plotServer) serveOutput(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rel := r.PathValue("path")
+	if !fs.ValidPath(rel) {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid output path: %q", rel))
+		return
+	}
+	data, err := s.store.ReadFile(r.Context(), joinLocation(s.outBase, rel))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ct := mime.TypeByExtension(path.Ext(rel)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Write(data)
+}
+
+// requestConfig returns a copy of the server's config for a basis time and
+// template parameters
+func (s *plotServer) requestConfig(basisTime time.Time, params map[string]any) *PlotConfig {
+	cfg := *s.cfg
+	cfg.BasisTime = basisTime.In(s.tz)
+	if params == nil {
+		params = map[string]any{}
+	}
+	cfg.TemplateParams = params
+	return &cfg
+}
+
+// declaredParams returns the names of the template parameters a plot accepts
+// on request: those in its params and those set by the variants of a profile
+func (s *plotServer) declaredParams(pd *PlotDef) map[string]bool {
+	declared := map[string]bool{}
+	for key := range pd.Parameters {
+		declared[key] = true
+	}
+	for _, p := range s.cfg.Profiles {
+		for _, variant := range p.Variants {
+			for key := range variant {
+				declared[key] = true
+			}
+		}
+		if len(p.Locales) > 0 {
+			declared[localeParam] = true
+		}
+	}
+	return declared
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpError responds with an error as a json object with an error field
+func httpError(w http.ResponseWriter, code int, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRenderPlotParams(t *testing.T) {
+	def := `name: p
+frequency: daily
+params:
+  metric: peers
+datasets:
+  - name: a
+    source: static
+    query: |
+      {"x": [1, 2, 3], "y": [4, 5, {{ len (.Params.network | default "") }}]}
+series:
+  - type: bar
+    name: {{ .Params.metric | default "none" }}
+    dataset: a
+    labels: x
+    values: y
+`
+	srv := &plotServer{
+		cfg: &PlotConfig{
+			Sources:  map[string]DataSource{"static": &StaticDataSource{}},
+			Profiles: []*ProcessingProfile{{Variants: []map[string]any{{"network": "ipfs"}}}},
+		},
+		tz:           time.UTC,
+		defs:         fstest.MapFS{"p.yaml": {Data: []byte(def)}},
+		figureChecks: FigureCheckOff,
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "no params", query: "", want: http.StatusOK},
+		{name: "declared by variant", query: "?network=filecoin", want: http.StatusOK},
+		{name: "declared by plot", query: "?metric=bandwidth", want: http.StatusOK},
+		{name: "undeclared", query: "?network=ipfs&where=x'%20OR%201=1%20--", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/plots/p.yaml"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A SourceSpec names the url of a data source, given by a --source option or
+// an entry in sources.yaml
+type SourceSpec struct {
+	Name    string  `yaml:"name"`
+	URL     string  `yaml:"url"`
+	Dialect Dialect `yaml:"dialect"` // the sql dialect of the source's queries, defaults to postgres
+
+	origin string // where the source was specified, used in error messages
+}
+
+// supportedSourceSchemes lists the url schemes of the supported data sources
+var supportedSourceSchemes = map[string]bool{
+	"postgres":   true,
+	"postgresql": true,
+}
+
+// parseSourceOptions parses source options in the format name=url
+func parseSourceOptions(opts []string) ([]SourceSpec, error) {
+	specs := make([]SourceSpec, 0, len(opts))
+	for _, sopt := range opts {
+		name, url, ok := strings.Cut(sopt, "=")
+		if !ok {
+			return nil, fmt.Errorf("source option not valid, use format 'name=url'")
+		}
+		specs = append(specs, SourceSpec{Name: name, URL: url, origin: "--source option"})
+	}
+	return specs, nil
+}
+
+// readSourcesConf reads the optional sources.yaml in the conf dir, which
+// lists the names and urls of data sources
+func readSourcesConf(conffs fs.FS) ([]SourceSpec, error) {
+	content, err := fs.ReadFile(conffs, "sources.yaml")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read sources: %w", err)
+	}
+
+	var specs []SourceSpec
+	if err := yaml.Unmarshal(content, &specs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sources.yaml: %w", err)
+	}
+	for i := range specs {
+		specs[i].origin = "sources.yaml"
+	}
+	return specs, nil
+}
+
+// validateSources checks that every source has a unique name and a url with
+// a supported scheme and a host, optionally checking that the host can be
+// resolved. All problems found are reported together.
+func validateSources(ctx context.Context, cfg *PlotConfig, specs []SourceSpec, resolve bool) error {
+	var errs []error
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: source with url %q has no name", spec.origin, redactURL(spec.URL)))
+			continue
+		}
+		if _, exists := cfg.Sources[spec.Name]; exists || seen[spec.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate source %q specified", spec.origin, spec.Name))
+			continue
+		}
+		seen[spec.Name] = true
+
+		u, err := url.Parse(spec.URL)
+		if err != nil {
+			// the parse error includes the url which may contain a password
+			errs = append(errs, fmt.Errorf("%s: source %q has an invalid url", spec.origin, spec.Name))
+			continue
+		}
+		if spec.Dialect != "" {
+			if err := spec.Dialect.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: source %q: %w", spec.origin, spec.Name, err))
+				continue
+			}
+		}
+		if !supportedSourceSchemes[u.Scheme] {
+			errs = append(errs, fmt.Errorf("%s: source %q has unsupported url scheme %q", spec.origin, spec.Name, u.Scheme))
+			continue
+		}
+		if u.Hostname() == "" {
+			errs = append(errs, fmt.Errorf("%s: source %q url has no host", spec.origin, spec.Name))
+			continue
+		}
+		if resolve {
+			if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+				errs = append(errs, fmt.Errorf("%s: source %q host could not be resolved: %w", spec.origin, spec.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addSources validates the sources and adds them to the config
+func addSources(ctx context.Context, cfg *PlotConfig, specs []SourceSpec, resolve bool) error {
+	if err := validateSources(ctx, cfg, specs, resolve); err != nil {
+		return fmt.Errorf("invalid sources:\n%w", err)
+	}
+	for _, spec := range specs {
+		src := NewPgDataSource(spec.URL)
+		src.dialect = spec.Dialect
+		cfg.Sources[spec.Name] = src
+	}
+	return nil
+}
+
+// sourceIdentity describes what a data source reads from, without any
+// credentials
+func sourceIdentity(src DataSource) string {
+	switch src := src.(type) {
+	case nil:
+		return ""
+	case *PgDataSource:
+		return redactURL(src.connstr)
+	case *StaticDataSource:
+		return "static"
+	case *DemoDataSource:
+		return "demo"
+	case *GeneratorDataSource:
+		return "generate"
+	default:
+		return fmt.Sprintf("%T", src)
+	}
+}
+
+// redactURL removes any password from a url so it can be logged
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return u.Redacted()
+}
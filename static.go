@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 type StaticQueryJSON struct {
@@ -13,21 +20,60 @@ type StaticQueryJSON struct {
 	Y []any `json:"y"`
 }
 
-type StaticDataSource struct{}
+// StaticDataSource returns data given in the query as json with x and y
+// arrays, or the named dataset if the query is the name of one of its
+// datasets.
+type StaticDataSource struct {
+	// DataSets are the named datasets read from the static directory of the
+	// conf dir, as columns keyed by field
+	DataSets map[string]map[string][]any
+}
 
 func (s *StaticDataSource) GetDataSet(_ context.Context, query string, params ...any) (DataSet, error) {
+	if name := strings.TrimSpace(query); !strings.HasPrefix(name, "{") {
+		columns, ok := s.DataSets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown static dataset: %q", name)
+		}
+		// copy the columns since datasets may be modified once read
+		data := make(map[string][]any, len(columns))
+		for field, values := range columns {
+			data[field] = append([]any(nil), values...)
+		}
+		return NewStaticDataSet(data), nil
+	}
+
 	var jq StaticQueryJSON
-	err := json.NewDecoder(strings.NewReader(query)).Decode(&jq)
-	if err != nil {
+	dec := json.NewDecoder(strings.NewReader(query))
+	dec.UseNumber()
+	if err := dec.Decode(&jq); err != nil {
 		return nil, fmt.Errorf("failed to decode static data: %w", err)
 	}
 
 	return NewStaticDataSet(map[string][]any{
-		"x": jq.X,
-		"y": jq.Y,
+		"x": jsonNumbers(jq.X),
+		"y": jsonNumbers(jq.Y),
 	}), nil
 }
 
+// jsonNumbers converts the json numbers in the values to an int64 if they
+// are integers that fit, so large integers are kept exactly, or a float64
+// otherwise
+func jsonNumbers(values []any) []any {
+	for i, v := range values {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if iv, err := n.Int64(); err == nil {
+			values[i] = iv
+		} else if fv, err := n.Float64(); err == nil {
+			values[i] = fv
+		}
+	}
+	return values
+}
+
 var _ DataSet = (*StaticDataSet)(nil)
 
 type StaticDataSet struct {
@@ -90,3 +136,117 @@ func (s *StaticDataSet) Field(name string) any {
 	}
 	return col[s.nextrow-1]
 }
+
+// rowCount returns the number of rows in a dataset. The dataset's iterator
+// is reset.
+func rowCount(ds DataSet) int {
+	if sds, ok := ds.(*StaticDataSet); ok {
+		if sds.rowcount < 0 {
+			return 0
+		}
+		return sds.rowcount
+	}
+
+	ds.ResetIterator()
+	n := 0
+	for ds.Next() {
+		n++
+	}
+	ds.ResetIterator()
+	return n
+}
+
+// readStaticConf reads the datasets of the static source from the yaml and
+// csv files in the static directory of the conf dir, naming each after its
+// file. Yaml files hold a list of rows mapping fields to values. Csv files
+// have a header row of field names and their values are read as integers,
+// numbers or booleans where possible, with empty values as null.
+func readStaticConf(conffs fs.FS) (map[string]map[string][]any, error) {
+	fnames, err := fs.Glob(conffs, "static/*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list static datasets: %w", err)
+	}
+
+	dataSets := make(map[string]map[string][]any)
+	for _, fname := range fnames {
+		ext := path.Ext(fname)
+		if ext != ".yaml" && ext != ".csv" {
+			continue
+		}
+		name := strings.TrimSuffix(path.Base(fname), ext)
+		if _, exists := dataSets[name]; exists {
+			return nil, fmt.Errorf("duplicate static dataset %q", name)
+		}
+
+		content, err := fs.ReadFile(conffs, fname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read static dataset: %w", err)
+		}
+
+		var rows []map[string]any
+		if ext == ".yaml" {
+			if err := yaml.Unmarshal(content, &rows); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal %s: %w", fname, err)
+			}
+		} else {
+			rows, err = readStaticCSV(content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", fname, err)
+			}
+		}
+
+		// rows may omit fields, which are null
+		columns := make(map[string][]any)
+		for _, row := range rows {
+			for field := range row {
+				if _, ok := columns[field]; !ok {
+					columns[field] = make([]any, 0, len(rows))
+				}
+			}
+		}
+		for _, row := range rows {
+			for field := range columns {
+				columns[field] = append(columns[field], row[field])
+			}
+		}
+		dataSets[name] = columns
+	}
+	return dataSets, nil
+}
+
+func readStaticCSV(content []byte) ([]map[string]any, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]map[string]any, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]any, len(header))
+		for i, field := range header {
+			row[field] = parseStaticValue(rec[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseStaticValue(s string) any {
+	if s == "" {
+		return nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
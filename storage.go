@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/exp/slog"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// s3Scheme is the prefix of output locations that are stored in S3, in
+	// the form s3://bucket/prefix
+	s3Scheme = "s3://"
+
+	// gcsScheme is the prefix of output locations that are stored in Google
+	// Cloud Storage, in the form gs://bucket/prefix
+	gcsScheme = "gs://"
+)
+
+// maxUpdateAttempts is the number of times updateFile reads and writes a file
+// that is being changed by another writer before giving up
+const maxUpdateAttempts = 8
+
+// updateRetryDelay is the longest wait before the first retry of updateFile.
+// It doubles with each attempt and a random part of it is used so that
+// competing writers spread out.
+const updateRetryDelay = 50 * time.Millisecond
+
+// errVersionChanged is reported by a VersionedStore when a file was changed
+// by another writer after it was read
+var errVersionChanged = errors.New("file was changed by another writer")
+
+// An OutputStore reads and writes the files of the output tree. Files are
+// named by their location, a local path or an s3 url depending on the store.
+// Missing files are reported with errors wrapping fs.ErrNotExist.
+type OutputStore interface {
+	WriteFile(ctx context.Context, loc string, data []byte) error
+	ReadFile(ctx context.Context, loc string) ([]byte, error)
+	ModTime(ctx context.Context, loc string) (time.Time, error)
+	Glob(ctx context.Context, pattern string) ([]string, error)
+
+	// DeleteFile removes a file. Deleting a missing file is not an error.
+	DeleteFile(ctx context.Context, loc string) error
+}
+
+// A VersionedStore can replace a file only if it has not changed since it was
+// read, so that concurrent batch runs sharing an output tree do not overwrite
+// each other's changes. Files also carry metadata alongside their contents.
+type VersionedStore interface {
+	OutputStore
+
+	// ReadFileVersion reads a file with its version and metadata. A missing
+	// file is read as empty with version zero.
+	ReadFileVersion(ctx context.Context, loc string) ([]byte, int64, map[string]string, error)
+
+	// WriteFileIfVersion writes a file only if its version is unchanged,
+	// version zero meaning that it must not exist. Otherwise it fails with an
+	// error wrapping errVersionChanged.
+	WriteFileIfVersion(ctx context.Context, loc string, data []byte, meta map[string]string, version int64) error
+}
+
+// A LinkStore can make a file a symbolic link to another file of the store,
+// so that the same contents are not stored twice
+type LinkStore interface {
+	OutputStore
+
+	// Symlink replaces the file at loc with a link to target
+	Symlink(ctx context.Context, target, loc string) error
+}
+
+// updateFile replaces a file with the result of applying update to its
+// current contents and metadata, which are empty if the file is missing. When
+// update returns nil data the file is left unchanged. With a VersionedStore
+// the update is retried if another writer changes the file in between,
+// otherwise metadata is not available and the file is simply overwritten.
+func updateFile(ctx context.Context, store OutputStore, loc string, update func(data []byte, meta map[string]string) ([]byte, map[string]string, error)) error {
+	vs, ok := store.(VersionedStore)
+	if !ok {
+		old, err := store.ReadFile(ctx, loc)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		data, _, err := update(old, nil)
+		if err != nil || data == nil {
+			return err
+		}
+		return store.WriteFile(ctx, loc, data)
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(rand.Int63n(int64(updateRetryDelay << (attempt - 1))))):
+			}
+		}
+		old, version, meta, err := vs.ReadFileVersion(ctx, loc)
+		if errors.Is(err, errVersionChanged) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, newMeta, err := update(old, meta)
+		if err != nil || data == nil {
+			return err
+		}
+		err = vs.WriteFileIfVersion(ctx, loc, data, newMeta, version)
+		if errors.Is(err, errVersionChanged) {
+			slog.Debug("file changed while updating, retrying", "location", loc, "attempt", attempt+1)
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("update %s: gave up after %d attempts: %w", loc, maxUpdateAttempts, errVersionChanged)
+}
+
+// newOutputStore returns the store for an output location given on the
+// command line and the location of the base of the output tree within it.
+// Local directories are made absolute.
+func newOutputStore(ctx context.Context, out string, s3PathStyle bool) (OutputStore, string, error) {
+	if scheme := objectScheme(out); scheme != "" {
+		bucket, prefix := splitObjectLocation(out)
+		if bucket == "" {
+			return nil, "", fmt.Errorf("output location has no bucket: %q", out)
+		}
+		base := joinLocation(scheme+bucket, prefix)
+		if scheme == gcsScheme {
+			store, err := NewGCSStore(ctx)
+			if err != nil {
+				return nil, "", err
+			}
+			return store, base, nil
+		}
+		store, err := NewS3Store(ctx, s3PathStyle)
+		if err != nil {
+			return nil, "", err
+		}
+		return store, base, nil
+	}
+
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find output directory: %w", err)
+	}
+	return &FileStore{}, abs, nil
+}
+
+// objectScheme returns the scheme of a location in object storage, or an
+// empty string for a local path
+func objectScheme(loc string) string {
+	for _, scheme := range []string{s3Scheme, gcsScheme} {
+		if strings.HasPrefix(loc, scheme) {
+			return scheme
+		}
+	}
+	return ""
+}
+
+// splitObjectLocation splits a location in object storage into its bucket
+// and key
+func splitObjectLocation(loc string) (string, string) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(loc, objectScheme(loc)), "/")
+	return bucket, key
+}
+
+// joinLocation joins path elements to a location, using forward slashes for
+// object storage and the separator of the operating system for local paths
+func joinLocation(base string, elem ...string) string {
+	if scheme := objectScheme(base); scheme != "" {
+		return scheme + path.Join(append([]string{strings.TrimPrefix(base, scheme)}, elem...)...)
+	}
+	return filepath.Join(append([]string{base}, elem...)...)
+}
+
+// relLocation returns the path of a location relative to a base location,
+// using forward slashes
+func relLocation(base, loc string) (string, error) {
+	if objectScheme(base) != "" {
+		rel, ok := strings.CutPrefix(loc, strings.TrimSuffix(base, "/")+"/")
+		if !ok {
+			return "", fmt.Errorf("%s is not within %s", loc, base)
+		}
+		return rel, nil
+	}
+	rel, err := filepath.Rel(base, loc)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// FileStore stores the output tree in the local filesystem
+type FileStore struct{}
+
+func (s *FileStore) WriteFile(_ context.Context, loc string, data []byte) error {
+	// a link must be replaced rather than written through, which would
+	// change the file it links to
+	if info, err := os.Lstat(loc); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(loc); err != nil {
+			return fmt.Errorf("remove link: %w", err)
+		}
+	}
+	return writeOutput(loc, data)
+}
+
+func (s *FileStore) ReadFile(_ context.Context, loc string) ([]byte, error) {
+	return os.ReadFile(loc)
+}
+
+func (s *FileStore) ModTime(_ context.Context, loc string) (time.Time, error) {
+	info, err := os.Lstat(loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (s *FileStore) Glob(_ context.Context, pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// Symlink links loc to target with a relative link, so the tree can be moved
+// or served from another path. The link is created next to loc and renamed
+// over it so that readers never see it missing.
+func (s *FileStore) Symlink(_ context.Context, target, loc string) error {
+	dir := filepath.Dir(loc)
+	if err := os.MkdirAll(dir, 0o775); err != nil {
+		return fmt.Errorf("make directories: %w", err)
+	}
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return fmt.Errorf("relative path of link target: %w", err)
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", loc, rand.Int63())
+	if err := os.Symlink(rel, tmp); err != nil {
+		return fmt.Errorf("create link: %w", err)
+	}
+	if err := os.Rename(tmp, loc); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace with link: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) DeleteFile(_ context.Context, loc string) error {
+	if err := os.Remove(loc); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// S3Store stores the output tree in S3, with the same key layout as the
+// directories of a local tree. Credentials, region and endpoint are read from
+// the standard AWS environment variables and config files.
+type S3Store struct {
+	client *s3.Client
+}
+
+// NewS3Store creates a store using the default AWS config. With pathStyle,
+// buckets are addressed in the path of request urls rather than the
+// hostname, as some S3 compatible services require.
+func NewS3Store(ctx context.Context, pathStyle bool) (*S3Store, error) {
+	conf, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	client := s3.NewFromConfig(conf, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+	})
+	return &S3Store{client: client}, nil
+}
+
+func (s *S3Store) WriteFile(ctx context.Context, loc string, data []byte) error {
+	bucket, key := splitObjectLocation(loc)
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		in.ContentType = aws.String(ct)
+	}
+	if _, err := s.client.PutObject(ctx, in); err != nil {
+		return fmt.Errorf("put object %s: %w", loc, err)
+	}
+	return nil
+}
+
+func (s *S3Store) ReadFile(ctx context.Context, loc string) ([]byte, error) {
+	bucket, key := splitObjectLocation(loc)
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("get object %s: %w", loc, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("get object %s: %w", loc, err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3Store) ModTime(ctx context.Context, loc string) (time.Time, error) {
+	bucket, key := splitObjectLocation(loc)
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return time.Time{}, fmt.Errorf("head object %s: %w", loc, fs.ErrNotExist)
+		}
+		return time.Time{}, fmt.Errorf("head object %s: %w", loc, err)
+	}
+	return aws.ToTime(out.LastModified), nil
+}
+
+func (s *S3Store) DeleteFile(ctx context.Context, loc string) error {
+	bucket, key := splitObjectLocation(loc)
+	// deleting a missing key succeeds
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("delete object %s: %w", loc, err)
+	}
+	return nil
+}
+
+// globPrefix returns the part of a glob pattern before its first wildcard
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// Glob lists the objects under the part of the pattern before its first
+// wildcard and returns those whose keys match the pattern
+func (s *S3Store) Glob(ctx context.Context, pattern string) ([]string, error) {
+	bucket, keyPattern := splitObjectLocation(pattern)
+	prefix := globPrefix(keyPattern)
+
+	var matches []string
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list objects in s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			ok, err := path.Match(keyPattern, key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matches = append(matches, s3Scheme+bucket+"/"+key)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// basisTimeMetadataKey is the metadata key under which stores that support it
+// record the basis time of the plot a latest file was generated for
+const basisTimeMetadataKey = "ashby-basis-time"
+
+// GCSStore stores the output tree in Google Cloud Storage, with the same key
+// layout as the directories of a local tree. Credentials are found using
+// Application Default Credentials. Writes can be made conditional on the
+// generation of an object, so it implements VersionedStore.
+type GCSStore struct {
+	client *storage.Client
+}
+
+func NewGCSStore(ctx context.Context) (*GCSStore, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client: %w", err)
+	}
+	return &GCSStore{client: client}, nil
+}
+
+func (s *GCSStore) object(loc string) *storage.ObjectHandle {
+	bucket, key := splitObjectLocation(loc)
+	return s.client.Bucket(bucket).Object(key)
+}
+
+func (s *GCSStore) WriteFile(ctx context.Context, loc string, data []byte) error {
+	return s.write(ctx, s.object(loc), loc, data, nil)
+}
+
+func (s *GCSStore) write(ctx context.Context, obj *storage.ObjectHandle, loc string, data []byte, meta map[string]string) error {
+	w := obj.NewWriter(ctx)
+	w.ContentType = mime.TypeByExtension(path.Ext(loc))
+	w.Metadata = meta
+	_, err := w.Write(data)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return fmt.Errorf("write object %s: %w", loc, errVersionChanged)
+		}
+		return fmt.Errorf("write object %s: %w", loc, err)
+	}
+	return nil
+}
+
+func (s *GCSStore) ReadFile(ctx context.Context, loc string) ([]byte, error) {
+	return s.read(ctx, s.object(loc), loc)
+}
+
+func (s *GCSStore) read(ctx context.Context, obj *storage.ObjectHandle, loc string) ([]byte, error) {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, fmt.Errorf("read object %s: %w", loc, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("read object %s: %w", loc, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s *GCSStore) ModTime(ctx context.Context, loc string) (time.Time, error) {
+	attrs, err := s.object(loc).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return time.Time{}, fmt.Errorf("object attributes %s: %w", loc, fs.ErrNotExist)
+		}
+		return time.Time{}, fmt.Errorf("object attributes %s: %w", loc, err)
+	}
+	return attrs.Updated, nil
+}
+
+func (s *GCSStore) DeleteFile(ctx context.Context, loc string) error {
+	if err := s.object(loc).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("delete object %s: %w", loc, err)
+	}
+	return nil
+}
+
+// Glob lists the objects under the part of the pattern before its first
+// wildcard and returns those whose names match the pattern
+func (s *GCSStore) Glob(ctx context.Context, pattern string) ([]string, error) {
+	bucket, keyPattern := splitObjectLocation(pattern)
+	prefix := globPrefix(keyPattern)
+
+	var matches []string
+	it := s.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list objects in gs://%s/%s: %w", bucket, prefix, err)
+		}
+		ok, err := path.Match(keyPattern, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, gcsScheme+bucket+"/"+attrs.Name)
+		}
+	}
+	return matches, nil
+}
+
+// ReadFileVersion reads the generation of an object that its attributes
+// were read from, so the contents always match the version
+func (s *GCSStore) ReadFileVersion(ctx context.Context, loc string) ([]byte, int64, map[string]string, error) {
+	obj := s.object(loc)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, 0, nil, nil
+		}
+		return nil, 0, nil, fmt.Errorf("object attributes %s: %w", loc, err)
+	}
+
+	data, err := s.read(ctx, obj.Generation(attrs.Generation), loc)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// the generation was replaced or deleted after its attributes
+			// were read
+			return nil, 0, nil, fmt.Errorf("read object %s: %w", loc, errVersionChanged)
+		}
+		return nil, 0, nil, err
+	}
+	return data, attrs.Generation, attrs.Metadata, nil
+}
+
+func (s *GCSStore) WriteFileIfVersion(ctx context.Context, loc string, data []byte, meta map[string]string, version int64) error {
+	cond := storage.Conditions{GenerationMatch: version}
+	if version == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+	return s.write(ctx, s.object(loc).If(cond), loc, data, meta)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/template"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+// defaultSummaryTemplate is used to write the markdown summary of a batch run
+// when no template is supplied
+const defaultSummaryTemplate = `# Plots for {{ .Now.Format "2006-01-02 15:04 MST" }}
+
+{{ .Counts.generated }} generated, {{ .Counts.skipped }} skipped, {{ .Counts.failed }} failed.
+{{- range .Plots }}{{ if eq .Status "generated" }}
+
+## {{ .Name }}
+{{- if .ImageURL }}
+
+![{{ .Name }}]({{ .ImageURL }})
+{{- end }}
+{{- if .Scalars }}
+{{ range $name, $value := .Scalars }}
+- {{ $name }}: {{ number $value }}
+{{- end }}
+{{- end }}
+{{- end }}{{ end }}
+{{- if .Counts.failed }}
+
+## Failed
+{{ range .Plots }}{{ if eq .Status "failed" }}
+- {{ .Plot }}: {{ .Error }}
+{{- end }}{{ end }}
+{{- end }}
+`
+
+// SummaryPlot is the result of a plot in a batch run as seen by a summary
+// template
+type SummaryPlot struct {
+	*BatchResult
+	ImageURL string // the url of an image of the plot, empty if no image url template was given
+}
+
+// figureScalars returns the values of the named scalar indicators of a figure
+func figureScalars(fig *Figure) map[string]float64 {
+	var scalars map[string]float64
+	for _, trace := range fig.Data {
+		ind, ok := trace.(*grob.Indicator)
+		if !ok {
+			continue
+		}
+		name, _ := ind.Name.(string)
+		if name == "" {
+			continue
+		}
+		if scalars == nil {
+			scalars = make(map[string]float64)
+		}
+		scalars[name] = ind.Value
+	}
+	return scalars
+}
+
+// WriteSummary writes a markdown summary of the batch run to the named file
+// by executing the summary template, or the default one if it is empty. The
+// template has the standard template data and functions as well as the
+// results of each plot in .Plots and the number of plots with each status in
+// .Counts. Plots and their scalar values can be looked up by name or output
+// filename with the plot and scalar functions, and image embeds an image of
+// a plot. Image urls are formed by executing imageURL with the result of each
+// plot.
+func (r *BatchResults) WriteSummary(ctx context.Context, cfg *PlotConfig, fname string, tpl string, imageURL string) error {
+	r.mu.Lock()
+	plots := make([]*SummaryPlot, 0, len(r.Plots))
+	for _, res := range r.Plots {
+		plots = append(plots, &SummaryPlot{BatchResult: res})
+	}
+	r.mu.Unlock()
+	sort.Slice(plots, func(i, j int) bool {
+		return plots[i].Plot < plots[j].Plot
+	})
+
+	counts := map[string]int{}
+	for _, status := range []BatchStatus{BatchStatusGenerated, BatchStatusSkipped, BatchStatusFailed} {
+		counts[status.String()] = 0
+	}
+	var urlTpl *template.Template
+	if imageURL != "" {
+		var err error
+		urlTpl, err = template.New("").Parse(imageURL)
+		if err != nil {
+			return fmt.Errorf("parse image url template: %w", err)
+		}
+	}
+	for _, p := range plots {
+		counts[p.Status.String()]++
+		if urlTpl == nil || p.Status == BatchStatusFailed {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := urlTpl.Execute(buf, p.BatchResult); err != nil {
+			return fmt.Errorf("execute image url template for plot %q: %w", p.Plot, err)
+		}
+		p.ImageURL = buf.String()
+	}
+
+	lookup := func(name string) (*SummaryPlot, error) {
+		for _, p := range plots {
+			if p.Name == name || p.Plot == name {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("no plot named %q in batch run", name)
+	}
+	funcs := template.FuncMap{
+		"plot": lookup,
+		"scalar": func(plot, name string) (float64, error) {
+			p, err := lookup(plot)
+			if err != nil {
+				return 0, err
+			}
+			v, ok := p.Scalars[name]
+			if !ok {
+				return 0, fmt.Errorf("plot %q has no scalar named %q", plot, name)
+			}
+			return v, nil
+		},
+		"image": func(plot string) (string, error) {
+			p, err := lookup(plot)
+			if err != nil || p.ImageURL == "" {
+				return "", err
+			}
+			return fmt.Sprintf("![%s](%s)", p.Name, p.ImageURL), nil
+		},
+		"number": formatSummaryNumber,
+	}
+
+	if tpl == "" {
+		tpl = defaultSummaryTemplate
+	}
+	out, err := executeTemplateWithData(ctx, tpl, cfg, map[string]any{"Plots": plots, "Counts": counts}, funcs)
+	if err != nil {
+		return fmt.Errorf("summary template: %w", err)
+	}
+	if err := writeOutput(fname, []byte(out)); err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	return nil
+}
+
+// formatSummaryNumber formats a number for reading, using SI prefixes for
+// large numbers and at most three decimal places for others
+func formatSummaryNumber(v float64) string {
+	if isLargeNumber(v) {
+		return FormatSI(v)
+	}
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+// readSummaryTemplate reads the summary template file, returning an empty
+// template if no file was given
+func readSummaryTemplate(fname string) (string, error) {
+	if fname == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return "", fmt.Errorf("failed to read summary template: %w", err)
+	}
+	return string(content), nil
+}
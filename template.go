@@ -13,10 +13,21 @@ import (
 )
 
 func ExecuteTemplate(ctx context.Context, source string, cfg *PlotConfig) (string, error) {
+	return executeTemplateWithData(ctx, source, cfg, nil, nil)
+}
+
+// executeTemplateWithData executes a template with the standard data
+// variables and functions as well as the extra ones supplied
+func executeTemplateWithData(ctx context.Context, source string, cfg *PlotConfig, extra map[string]any, extraFuncs template.FuncMap) (string, error) {
 	// See http://masterminds.github.io/sprig/
 	fm := sprig.FuncMap()
 	fm["timestamptz"] = pgTimestampTZ
 	fm["timestamp"] = pgTimestamp
+	fm["clickhouseDateTime"] = clickhouseDateTime
+	fm["duckdbTimestamp"] = duckdbTimestamp
+	fm["duckdbTimestampTZ"] = duckdbTimestampTZ
+	fm["sqlTimestamp"] = sqlTimestamp(cfg, "")     // format a timestamp in the dialect of the named source, or of the query's source, see resolveQueries
+	fm["sqlTimestampTZ"] = sqlTimestampTZ(cfg, "") // format a timestamp with time zone in the dialect of the named source, or of the query's source
 	fm["simpledate"] = simpleDateFormat
 	fm["isodate"] = isoDateFormat
 	fm["dayModify"] = dayModify     // a version of sprig's dateModify that accepts a number of days
@@ -24,26 +35,43 @@ func ExecuteTemplate(ctx context.Context, source string, cfg *PlotConfig) (strin
 	fm["monthModify"] = monthModify // a version of sprig's dateModify that accepts a number of months
 	fm["toUpper"] = strings.ToUpper
 	fm["toTitle"] = strings.ToTitle
+	fm["humanCount"] = templateNumberFormat(1000, siPrefixes, "")             // format a number using SI prefixes, such as 3.4M
+	fm["humanBytes"] = templateNumberFormat(1000, siPrefixes, " B")           // format a number of bytes using SI prefixes, such as 1.2 GB
+	fm["humanBinaryBytes"] = templateNumberFormat(1024, binaryPrefixes, " B") // format a number of bytes using binary prefixes, such as 1.2 GiB
+	fm["rollupTable"] = cfg.RollupTable                                       // select the rollup of a table for a source and frequency
+	fm["rollup"] = func(table string) string { return table }                 // select the rollup of a table for the dataset whose query is templated, see resolveQueries
+	fm["msg"] = cfg.Message                                                   // resolve a message key using the catalog of the locale parameter
+
+	for k, f := range extraFuncs {
+		fm[k] = f
+	}
 
 	t, err := template.New("").Funcs(fm).Parse(source)
 	if err != nil {
 		return "", fmt.Errorf("parse query template: %w", err)
 	}
 
+	startOfHour := PlotFrequencyHourly.Truncate(cfg.BasisTime, cfg.WeekStart)
+	startOfDay := PlotFrequencyDaily.Truncate(cfg.BasisTime, cfg.WeekStart)
+	startOfWeek := PlotFrequencyWeekly.Truncate(cfg.BasisTime, cfg.WeekStart)
+
 	data := map[string]any{
 		"Now":         cfg.BasisTime,
-		"StartOfHour": cfg.BasisTime.Truncate(time.Hour),
-		"StartOfDay":  cfg.BasisTime.Truncate(24 * time.Hour),
-		"StartOfWeek": cfg.BasisTime.Truncate(7 * 24 * time.Hour),
+		"StartOfHour": startOfHour,
+		"StartOfDay":  startOfDay,
+		"StartOfWeek": startOfWeek,
 
 		// The following are useful when formatting dates that are immediately before the start of the period
 		// They are not really suitable for use as the end of a range in a query.
-		"EndOfPreviousHour":   cfg.BasisTime.Truncate(time.Hour).Add(-time.Nanosecond),
-		"EndOfPreviousDay":    cfg.BasisTime.Truncate(24 * time.Hour).Add(-time.Nanosecond),
-		"EndOfPreviousWeek":   cfg.BasisTime.Truncate(7 * 24 * time.Hour).Add(-time.Nanosecond),
-		"StartOfPreviousWeek": cfg.BasisTime.Truncate(7 * 24 * time.Hour).Add(-7 * 24 * time.Hour),
+		"EndOfPreviousHour":   startOfHour.Add(-time.Nanosecond),
+		"EndOfPreviousDay":    startOfDay.Add(-time.Nanosecond),
+		"EndOfPreviousWeek":   startOfWeek.Add(-time.Nanosecond),
+		"StartOfPreviousWeek": startOfWeek.AddDate(0, 0, -7),
 		"Params":              cfg.TemplateParams,
 	}
+	for k, v := range extra {
+		data[k] = v
+	}
 
 	buf := new(bytes.Buffer)
 	if err := t.Execute(buf, data); err != nil {
@@ -54,11 +82,11 @@ func ExecuteTemplate(ctx context.Context, source string, cfg *PlotConfig) (strin
 }
 
 func pgTimestampTZ(t time.Time) string {
-	return "'" + t.Format("2006-01-02 15:04:05 Z") + "'::timestamptz"
+	return "'" + t.UTC().Format("2006-01-02 15:04:05 Z") + "'::timestamptz"
 }
 
 func pgTimestamp(t time.Time) string {
-	return "'" + t.Format("2006-01-02 15:04:05") + "'::timestamp"
+	return "'" + t.UTC().Format("2006-01-02 15:04:05") + "'::timestamp"
 }
 
 func simpleDateFormat(t time.Time) string {
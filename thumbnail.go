@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// thumbnailSuffix replaces the extension of a plot's output to name its
+	// thumbnail
+	thumbnailSuffix = ".png"
+
+	thumbnailWidth  = 320
+	thumbnailHeight = 180
+
+	// thumbnails are drawn at a multiple of their size and scaled down so
+	// that lines are smoothed
+	thumbnailSupersample = 2
+)
+
+// renderThumbnail draws the series of a figure as a small chart without any
+// text, for previewing the plot in a gallery. It returns nil if the figure
+// has no series that can be drawn.
+func renderThumbnail(fig *Figure, cfg *PlotConfig, logger *slog.Logger) ([]byte, error) {
+	series, omitted := chartSeries(fig.Series)
+	if len(omitted) > 0 {
+		logger.Info("series cannot be drawn in a thumbnail", "series", omitted)
+	}
+	return renderChartPNG(series, cfg, thumbnailWidth, thumbnailHeight)
+}
+
+// renderChartPNG draws series, as chosen by chartSeries, as a png chart of
+// the given size without any text. It returns nil if there are none.
+func renderChartPNG(series []*LabeledSeries, cfg *PlotConfig, width, height int) ([]byte, error) {
+	if len(series) == 0 {
+		return nil, nil
+	}
+
+	const ss = thumbnailSupersample
+	img := image.NewRGBA(image.Rect(0, 0, width*ss, height*ss))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	pad := 6.0 * ss
+	px, py := pad, pad
+	pw, ph := float64(img.Bounds().Dx())-2*pad, float64(img.Bounds().Dy())-2*pad
+
+	scale := newReportLabelScale(series)
+	bars := countBars(series)
+	ticks, _ := chartValueTicks(series, 4)
+	vmin, vmax := ticks[0], ticks[len(ticks)-1]
+
+	// point returns the position in the image of a value at a position along
+	// the label axis, which is vertical for horizontal bars, with labels from
+	// the bottom up as plotly places them
+	horizontal := isHorizontalChart(series)
+	labelLength := pw
+	point := func(pos, v float64) (float64, float64) {
+		return px + pos*pw, py + ph - (v-vmin)/(vmax-vmin)*ph
+	}
+	if horizontal {
+		labelLength = ph
+		point = func(pos, v float64) (float64, float64) {
+			return px + (v-vmin)/(vmax-vmin)*pw, py + ph - pos*ph
+		}
+	}
+
+	grid := color.RGBA{R: 230, G: 230, B: 230, A: 255}
+	axis := color.RGBA{R: 160, G: 160, B: 160, A: 255}
+	for _, t := range ticks {
+		if horizontal {
+			tx, _ := point(0, t)
+			fillRect(img, tx, py, ss, ph, grid)
+		} else {
+			_, ty := point(0, t)
+			fillRect(img, px, ty, pw, ss, grid)
+		}
+	}
+	if horizontal {
+		fillRect(img, px, py, ss, ph, axis)
+	} else {
+		fillRect(img, px, py+ph, pw, ss, axis)
+	}
+
+	slot := labelLength / float64(max(scale.slots(), 1))
+	bar := 0
+	for i, ls := range series {
+		rgb := reportColor(cfg.MaybeLookupColor(ls.SeriesDef.Color, ls.Name), i)
+		c := color.RGBA{R: uint8(rgb[0]), G: uint8(rgb[1]), B: uint8(rgb[2]), A: 255}
+
+		var prevX, prevY float64
+		connected := false
+		for j, l := range ls.Labels {
+			pos, ok := scale.pos(l)
+			f, fok := toFloat64(index(ls.Values, j))
+			if !ok || !fok || math.IsNaN(f) || math.IsInf(f, 0) {
+				connected = false
+				continue
+			}
+			cx, cy := point(pos, f)
+
+			switch ls.SeriesDef.Type {
+			case SeriesTypeBar:
+				bw := slot * 0.8 / float64(bars)
+				bx := cx - slot*0.4 + float64(bar)*bw
+				_, zy := point(pos, 0)
+				top, bottom := math.Min(cy, zy), math.Max(cy, zy)
+				fillRect(img, bx, top, math.Max(bw, 1), math.Max(bottom-top, 1), c)
+			case SeriesTypeHBar:
+				bh := slot * 0.8 / float64(bars)
+				by := cy + slot*0.4 - float64(bar+1)*bh
+				zx, _ := point(pos, 0)
+				left, right := math.Min(cx, zx), math.Max(cx, zx)
+				fillRect(img, left, by, math.Max(right-left, 1), math.Max(bh, 1), c)
+			case SeriesTypeScatter:
+				fillCircle(img, cx, cy, 2*ss, c)
+			default:
+				if connected {
+					drawLine(img, prevX, prevY, cx, cy, 1.5*ss, c)
+				}
+				prevX, prevY, connected = cx, cy, true
+			}
+		}
+		if ls.SeriesDef.Type == SeriesTypeBar || ls.SeriesDef.Type == SeriesTypeHBar {
+			bar++
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, downsample(img, ss)); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func fillRect(img *image.RGBA, x, y, w, h float64, c color.Color) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	draw.Draw(img, r.Intersect(img.Bounds()), image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func fillCircle(img *image.RGBA, cx, cy, radius float64, c color.Color) {
+	for y := int(cy - radius); y <= int(cy+radius); y++ {
+		for x := int(cx - radius); x <= int(cx+radius); x++ {
+			if dx, dy := float64(x)-cx, float64(y)-cy; dx*dx+dy*dy <= radius*radius {
+				img.Set(x, y, c)
+			}
+		}
+	}
+}
+
+// drawLine draws a line of the given width by stamping a square at each step
+// along it
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width float64, c color.Color) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		x, y := x0+t*(x1-x0), y0+t*(y1-y0)
+		fillRect(img, x-width/2, y-width/2, width, width, c)
+	}
+}
+
+// downsample scales an image down by an integer factor, averaging each block
+// of pixels
+func downsample(img *image.RGBA, factor int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()/factor, b.Dy()/factor))
+	n := uint32(factor * factor)
+	for y := 0; y < out.Bounds().Dy(); y++ {
+		for x := 0; x < out.Bounds().Dx(); x++ {
+			var r, g, bl, a uint32
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					p := img.RGBAAt(x*factor+dx, y*factor+dy)
+					r, g, bl, a = r+uint32(p.R), g+uint32(p.G), bl+uint32(p.B), a+uint32(p.A)
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return out
+}
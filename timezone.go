@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	_ "time/tzdata" // timezones are resolved without relying on the zoneinfo of the host
+)
+
+// loadTimezone returns the location named by an IANA timezone name, such as
+// Europe/Berlin, or UTC if the name is empty
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone: %q", name)
+	}
+	return loc, nil
+}
+
+// withPlotTimezone returns the definition and config to generate a plot with.
+// The basis time of the config is in the timezone of the config, which
+// determines the template variables such as StartOfDay, the calendar periods
+// of the plot's frequency and the dated directory of its output. A plot that
+// sets a different timezone is templated again with the basis time in its
+// own timezone.
+func withPlotTimezone(ctx context.Context, fname string, content string, pd *PlotDef, cfg *PlotConfig) (*PlotDef, *PlotConfig, error) {
+	if pd.Timezone == "" {
+		return pd, cfg, nil
+	}
+	loc, err := loadTimezone(pd.Timezone)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.String() == cfg.BasisTime.Location().String() {
+		return pd, cfg, nil
+	}
+
+	plotCfg := *cfg
+	plotCfg.BasisTime = cfg.BasisTime.In(loc)
+	templated, err := ExecuteTemplate(ctx, content, &plotCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("execute templates in timezone %q: %w", pd.Timezone, err)
+	}
+	pd, err = parsePlotDef(fname, []byte(content), []byte(templated))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse in timezone %q: %w", loc, err)
+	}
+	return pd, &plotCfg, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteTimings writes a breakdown of the time spent producing a plot. The
+// breakdown is written with a single call so that output from plots
+// generated concurrently is not interleaved.
+func (s *PlotStats) WriteTimings(w io.Writer, name string) error {
+	var b strings.Builder
+	total := s.Template + s.Compute + s.Traces + s.Marshal + s.Write
+
+	fmt.Fprintf(&b, "timings for plot %q:\n", name)
+	line := func(stage string, d time.Duration, detail string) {
+		fmt.Fprintf(&b, "  %-24s %10s", stage, d.Round(time.Microsecond))
+		if detail != "" {
+			fmt.Fprintf(&b, "  %s", detail)
+		}
+		b.WriteString("\n")
+	}
+
+	line("template", s.Template, "")
+	for _, ds := range s.DataSets {
+		total += ds.Duration
+		line("dataset "+ds.Name, ds.Duration, fmt.Sprintf("source=%s rows=%d", ds.Source, ds.RowCount))
+	}
+	line("compute", s.Compute, "")
+	line("traces", s.Traces, "")
+	line("marshal", s.Marshal, "")
+	line("write", s.Write, "")
+	line("total", total, "")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
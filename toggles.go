@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+)
+
+// ToggleDef defines a group of layout buttons or a dropdown menu that toggles
+// the visibility of series or updates the layout of the plot
+type ToggleDef struct {
+	Type      ToggleType        `yaml:"type"`      // buttons or dropdown, defaults to buttons
+	Direction string            `yaml:"direction"` // the direction the buttons are laid out or the dropdown opens, such as left or down
+	X         *float64          `yaml:"x"`         // optional horizontal position of the menu in normalized coordinates
+	Y         *float64          `yaml:"y"`         // optional vertical position of the menu in normalized coordinates
+	Options   []ToggleOptionDef `yaml:"options"`
+}
+
+// ToggleOptionDef defines a single button of a toggle. The first option is
+// active when the plot is first displayed.
+type ToggleOptionDef struct {
+	Label  string         `yaml:"label"`
+	Series []string       `yaml:"series"` // names or glob patterns of the series shown when the option is selected, all others are hidden
+	Layout map[string]any `yaml:"layout"` // layout attributes set when the option is selected, such as yaxis.type: log
+}
+
+type ToggleType string
+
+const (
+	ToggleTypeButtons  ToggleType = "buttons"
+	ToggleTypeDropdown ToggleType = "dropdown"
+)
+
+func (t ToggleType) String() string { return string(t) }
+
+// toggleSpacing is the vertical distance between menus that have no position
+const toggleSpacing = 0.12
+
+// toggleMenus converts toggle definitions into plotly updatemenus. series
+// holds the series of the figure in the same order as their traces, which
+// must be the first traces of the figure.
+func toggleMenus(toggles []ToggleDef, series []*LabeledSeries) ([]any, error) {
+	indices := make([]int, len(series))
+	for i := range series {
+		indices[i] = i
+	}
+
+	menus := make([]any, 0, len(toggles))
+	for i, t := range toggles {
+		typ := t.Type
+		if typ == "" {
+			typ = ToggleTypeButtons
+		}
+		direction := t.Direction
+		if direction == "" && typ == ToggleTypeButtons {
+			direction = "left"
+		}
+
+		buttons := make([]any, 0, len(t.Options))
+		for _, opt := range t.Options {
+			var visible []bool
+			if len(opt.Series) > 0 {
+				visible = make([]bool, len(series))
+				for j, ls := range series {
+					show, err := matchesAny(ls.Name, opt.Series)
+					if err != nil {
+						return nil, fmt.Errorf("toggle option %q: %w", opt.Label, err)
+					}
+					visible[j] = show
+				}
+			}
+
+			button := map[string]any{"label": opt.Label}
+			switch {
+			case visible != nil && len(opt.Layout) > 0:
+				button["method"] = "update"
+				button["args"] = []any{map[string]any{"visible": visible}, opt.Layout, indices}
+			case visible != nil:
+				button["method"] = "restyle"
+				button["args"] = []any{map[string]any{"visible": visible}, indices}
+			default:
+				button["method"] = "relayout"
+				button["args"] = []any{opt.Layout}
+			}
+			buttons = append(buttons, button)
+		}
+
+		menu := map[string]any{
+			"type":    typ,
+			"buttons": buttons,
+			"xanchor": "left",
+			"yanchor": "bottom",
+			"x":       0.0,
+			"y":       math.Round((1.02+toggleSpacing*float64(i))*100) / 100,
+		}
+		if direction != "" {
+			menu["direction"] = direction
+		}
+		if t.X != nil {
+			menu["x"] = *t.X
+		}
+		if t.Y != nil {
+			menu["y"] = *t.Y
+		}
+		menus = append(menus, menu)
+	}
+
+	return menus, nil
+}
+
+// matchesAny reports whether the name matches any of the glob patterns
+func matchesAny(name string, patterns []string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid series pattern %q: %w", p, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	grob "github.com/MetalBlueberry/go-plotly/graph_objects"
+)
+
+type UnitType string
+
+const (
+	UnitTypeNone    UnitType = ""
+	UnitTypeBytes   UnitType = "bytes"   // values are a number of bytes
+	UnitTypeSeconds UnitType = "seconds" // values are a duration in seconds
+	UnitTypeCount   UnitType = "count"   // values are a count of things
+	UnitTypePercent UnitType = "percent" // values are a fraction where 1 is 100%
+)
+
+func (u UnitType) String() string { return string(u) }
+
+func (u UnitType) Validate() error {
+	switch u {
+	case UnitTypeNone, UnitTypeBytes, UnitTypeSeconds, UnitTypeCount, UnitTypePercent:
+		return nil
+	default:
+		return fmt.Errorf("unknown unit: %q", u)
+	}
+}
+
+// Format returns the d3 format that should be used to display values with
+// this unit.
+func (u UnitType) Format() string {
+	switch u {
+	case UnitTypeBytes, UnitTypeSeconds, UnitTypeCount:
+		return ".3~s"
+	case UnitTypePercent:
+		return ".1%"
+	default:
+		return ""
+	}
+}
+
+// TickFormat returns the d3 format that should be used for axis ticks for
+// values with this unit.
+func (u UnitType) TickFormat() string {
+	switch u {
+	case UnitTypeBytes, UnitTypeSeconds, UnitTypeCount:
+		return "~s"
+	case UnitTypePercent:
+		return ".0%"
+	default:
+		return ""
+	}
+}
+
+// Suffix returns the string that should follow a formatted value with this
+// unit.
+func (u UnitType) Suffix() string {
+	switch u {
+	case UnitTypeBytes:
+		return "B"
+	case UnitTypeSeconds:
+		return "s"
+	default:
+		return ""
+	}
+}
+
+// Title returns a human readable name of the unit, suitable for an axis title.
+func (u UnitType) Title() string {
+	switch u {
+	case UnitTypeBytes:
+		return "Bytes"
+	case UnitTypeSeconds:
+		return "Seconds"
+	case UnitTypeCount:
+		return "Count"
+	case UnitTypePercent:
+		return "Percent"
+	default:
+		return ""
+	}
+}
+
+// HoverTemplate returns a plotly hovertemplate that formats the value of
+// each point according to the unit. axis is the name of the axis holding the
+// values, x or y.
+func (u UnitType) HoverTemplate(axis string) string {
+	if u == UnitTypeNone {
+		return ""
+	}
+	return fmt.Sprintf("%%{%s:%s}%s", axis, u.Format(), u.Suffix())
+}
+
+// EffectiveUnit returns the unit of the series values, taking into account
+// the percent option.
+func (s *SeriesDef) EffectiveUnit() UnitType {
+	if s.Percent {
+		return UnitTypePercent
+	}
+	return s.Unit
+}
+
+// ValueAxis returns the name of the axis that the series values are plotted
+// against, x or y.
+func (s *SeriesDef) ValueAxis() string {
+	if s.Type == SeriesTypeHBar || s.Type == SeriesTypeHBox {
+		return "x"
+	}
+	return "y"
+}
+
+// setUnitAxisFormat formats the value axis of series that have a unit, unless
+// the layout already specifies a format. Series without a unit that contain
+// large numbers are formatted using SI prefixes. Only the primary axes are
+// supported.
+func setUnitAxisFormat(layout *grob.Layout, series []*LabeledSeries) {
+	for _, ls := range series {
+		s := ls.SeriesDef
+		if s.Yaxis != "" && s.Yaxis != "y" {
+			continue
+		}
+
+		unit := s.EffectiveUnit()
+		title := unit.Title()
+		if unit == UnitTypeNone {
+			if !ls.HasLargeValues() {
+				continue
+			}
+			unit = UnitTypeCount
+			title = ""
+		}
+
+		if s.ValueAxis() == "x" {
+			if layout.Xaxis == nil {
+				layout.Xaxis = &grob.LayoutXaxis{}
+			}
+			if isEmpty(layout.Xaxis.Tickformat) {
+				layout.Xaxis.Tickformat = unit.TickFormat()
+				if unit.Suffix() != "" {
+					layout.Xaxis.Ticksuffix = unit.Suffix()
+				}
+			}
+			if layout.Xaxis.Title == nil && title != "" {
+				layout.Xaxis.Title = &grob.LayoutXaxisTitle{Text: title}
+			}
+			continue
+		}
+
+		if layout.Yaxis == nil {
+			layout.Yaxis = &grob.LayoutYaxis{}
+		}
+		if isEmpty(layout.Yaxis.Tickformat) {
+			layout.Yaxis.Tickformat = unit.TickFormat()
+			if unit.Suffix() != "" {
+				layout.Yaxis.Ticksuffix = unit.Suffix()
+			}
+		}
+		if layout.Yaxis.Title == nil && title != "" {
+			layout.Yaxis.Title = &grob.LayoutYaxisTitle{Text: title}
+		}
+	}
+}
+
+// largeNumberThreshold is the magnitude above which numbers without a unit
+// are displayed using SI prefixes
+const largeNumberThreshold = 1e6
+
+func isLargeNumber(v float64) bool {
+	return math.Abs(v) >= largeNumberThreshold
+}
+
+// HasLargeValues reports whether any of the series values are large enough
+// to be displayed using SI prefixes
+func (ls *LabeledSeries) HasLargeValues() bool {
+	for _, v := range ls.Values {
+		if f, ok := toFloat64(v); ok && isLargeNumber(f) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	siPrefixes     = []string{"", "k", "M", "G", "T", "P", "E"}
+	binaryPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+)
+
+// Number is the set of numeric types that can be formatted with prefixes
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+// FormatSI formats a number using SI prefixes, such as 3.4M
+func FormatSI[N Number](v N) string {
+	return formatPrefixed(v, 1000, siPrefixes, "")
+}
+
+// FormatBytes formats a number of bytes using SI prefixes, such as 1.2 GB
+func FormatBytes[N Number](v N) string {
+	return formatPrefixed(v, 1000, siPrefixes, " B")
+}
+
+// FormatBinaryBytes formats a number of bytes using binary prefixes, such as
+// 1.2 GiB
+func FormatBinaryBytes[N Number](v N) string {
+	return formatPrefixed(v, 1024, binaryPrefixes, " B")
+}
+
+// templateNumberFormat returns a template function formatting numbers with
+// prefixes, as formatPrefixed. Template values may be of any numeric type,
+// including those read from query results.
+func templateNumberFormat(base uint64, prefixes []string, unit string) func(v any) (string, error) {
+	return func(v any) (string, error) {
+		switch tv := v.(type) {
+		case int:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		case int64:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		case int32:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		case uint:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		case uint64:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		case uint32:
+			return formatPrefixed(tv, base, prefixes, unit), nil
+		}
+		f, ok := toFloat64(v)
+		if !ok {
+			return "", fmt.Errorf("not a number: %v", v)
+		}
+		return formatPrefixed(f, base, prefixes, unit), nil
+	}
+}
+
+// formatPrefixed divides v by the base until it is smaller than the base and
+// formats it to one decimal place followed by the unit and the prefix for the
+// number of divisions. Integers are divided exactly rather than converted to
+// floats, which cannot represent every integer above 2^53.
+func formatPrefixed[N Number](v N, base uint64, prefixes []string, unit string) string {
+	i := 0
+	var s string
+	if half := 0.5; N(half) != 0 {
+		f := float64(v)
+		for math.Abs(f) >= float64(base) && i < len(prefixes)-1 {
+			f /= float64(base)
+			i++
+		}
+		s = strconv.FormatFloat(f, 'f', 1, 64)
+		s = strings.TrimSuffix(s, ".0")
+	} else {
+		neg := v < 0
+		mag := uint64(v)
+		if neg {
+			mag = uint64(-int64(v))
+		}
+		div := uint64(1)
+		for mag/div >= base && i < len(prefixes)-1 {
+			div *= base
+			i++
+		}
+		whole, tenths := mag/div, (mag%div*10+div/2)/div
+		if tenths == 10 {
+			whole, tenths = whole+1, 0
+		}
+		s = strconv.FormatUint(whole, 10)
+		if tenths != 0 {
+			s += "." + strconv.FormatUint(tenths, 10)
+		}
+		if neg && s != "0" {
+			s = "-" + s
+		}
+	}
+
+	if unit == "" {
+		return s + prefixes[i]
+	}
+	// unit begins with a space separating it from the number
+	return s + unit[:1] + prefixes[i] + unit[1:]
+}
+
+// isEmpty reports whether a plotly string value is unset
+func isEmpty(v grob.String) bool {
+	return v == nil || v == ""
+}
+
+// valueFormatPresets maps friendly names to d3 formats
+var valueFormatPresets = map[string]string{
+	"si":        ".3~s", // SI prefixes, such as 3.4M
+	"thousands": ",.0f", // grouped thousands, such as 3,400,000
+	"percent":   ".1%",  // a ratio as a percentage, such as 12.3%
+}
+
+// namedValueFormat returns the d3 format for a preset name, or the format
+// itself if it is not a preset
+func namedValueFormat(format string) string {
+	if f, ok := valueFormatPresets[format]; ok {
+		return f
+	}
+	return format
+}
+
+// currencySymbols maps ISO 4217 currency codes to their symbols
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"CNY": "¥",
+	"CHF": "CHF",
+}
+
+// currencyDecimals lists currencies that are not displayed with two decimal places
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+}
+
+// localeSeparators maps languages to the plotly separators string for the
+// locale, the decimal separator followed by the thousands separator
+var localeSeparators = map[string]string{
+	"en": ".,",
+	"de": ",.",
+	"es": ",.",
+	"fr": ", ",
+	"it": ",.",
+	"nl": ",.",
+	"pt": ",.",
+}
+
+// localeSymbolAfter lists languages that place the currency symbol after the amount
+var localeSymbolAfter = map[string]bool{
+	"de": true,
+	"es": true,
+	"fr": true,
+	"it": true,
+	"pt": true,
+}
+
+// localeLanguage returns the language part of a locale such as de-DE
+func localeLanguage(locale string) string {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	return strings.ToLower(lang)
+}
+
+// currencyFormat returns the prefix, suffix and d3 format used to display an
+// amount of the currency in the locale.
+func currencyFormat(code string, locale string) (prefix, suffix, format string) {
+	decimals, ok := currencyDecimals[code]
+	if !ok {
+		decimals = 2
+	}
+	format = fmt.Sprintf(",.%df", decimals)
+
+	symbol := currencySymbols[code]
+	if localeSymbolAfter[localeLanguage(locale)] {
+		return "", " " + symbol, format
+	}
+	if len(symbol) > 1 {
+		symbol += " "
+	}
+	return symbol, "", format
+}
+
+// setLocaleSeparators sets the layout's number separators from the locale of
+// the first scalar that has one, unless the layout already specifies them.
+// Plotly only supports separators for the whole figure.
+func setLocaleSeparators(layout *grob.Layout, scalarDefs []ScalarDef) {
+	if !isEmpty(layout.Separators) {
+		return
+	}
+	for _, s := range scalarDefs {
+		if s.Locale == "" {
+			continue
+		}
+		if sep, ok := localeSeparators[localeLanguage(s.Locale)]; ok {
+			layout.Separators = sep
+			return
+		}
+	}
+}
+
+const (
+	defaultIncreaseColor = "#3D9970" // plotly's default color for increasing deltas
+	defaultDecreaseColor = "#FF4136" // plotly's default color for decreasing deltas
+)
+
+// formatRatioDelta formats the change from the reference to the value in
+// percentage points or basis points. Values are expected to be ratios, where
+// 1 is 100%. It reports whether the value increased and false if the delta
+// could not be calculated.
+func formatRatioDelta(value, reference float64, typ DeltaType, format DeltaFormatType) (string, bool, bool) {
+	delta := value - reference
+	if typ == DeltaTypeRelative {
+		if reference == 0 {
+			return "", false, false
+		}
+		delta = delta / math.Abs(reference)
+	}
+
+	increased := delta >= 0
+	arrow := "▲"
+	if !increased {
+		arrow = "▼"
+	}
+
+	switch format {
+	case DeltaFormatPercentagePoints:
+		return fmt.Sprintf("%s%s pp", arrow, strconv.FormatFloat(math.Abs(delta*100), 'f', 2, 64)), increased, true
+	case DeltaFormatBasisPoints:
+		return fmt.Sprintf("%s%s bp", arrow, strconv.FormatFloat(math.Abs(delta*10000), 'f', 0, 64)), increased, true
+	default:
+		return "", false, false
+	}
+}
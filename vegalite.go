@@ -0,0 +1,249 @@
+package main
+
+import "fmt"
+
+// vegaLiteSchema is the version of the vega-lite schema that specs are
+// written for
+const vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+
+// VegaLiteSpec is a vega-lite specification of a plot. The points of all
+// series are held in a single table of series, label and value rows and each
+// layer draws the series of one type by filtering the table. The same type is
+// used for the top level spec and its layers.
+type VegaLiteSpec struct {
+	Schema    string                      `json:"$schema,omitempty"`
+	Title     string                      `json:"title,omitempty"`
+	Width     string                      `json:"width,omitempty"`
+	Data      *VegaLiteData               `json:"data,omitempty"`
+	Transform []VegaLiteTransform         `json:"transform,omitempty"`
+	Mark      *VegaLiteMark               `json:"mark,omitempty"`
+	Encoding  map[string]*VegaLiteChannel `json:"encoding,omitempty"`
+	Layer     []*VegaLiteSpec             `json:"layer,omitempty"`
+	Resolve   *VegaLiteResolve            `json:"resolve,omitempty"`
+}
+
+type VegaLiteData struct {
+	Values []map[string]any `json:"values"`
+}
+
+type VegaLiteTransform struct {
+	Filter VegaLiteFilter `json:"filter"`
+}
+
+type VegaLiteFilter struct {
+	Field string   `json:"field"`
+	OneOf []string `json:"oneOf"`
+}
+
+type VegaLiteMark struct {
+	Type    string  `json:"type"`
+	Tooltip bool    `json:"tooltip,omitempty"`
+	Opacity float64 `json:"opacity,omitempty"`
+}
+
+type VegaLiteChannel struct {
+	Field string         `json:"field"`
+	Type  string         `json:"type"`
+	Title any            `json:"title"` // null hides the title
+	Sort  []any          `json:"sort,omitempty"`
+	Stack *bool          `json:"stack,omitempty"`
+	Axis  *VegaLiteAxis  `json:"axis,omitempty"`
+	Scale *VegaLiteScale `json:"scale,omitempty"`
+}
+
+type VegaLiteAxis struct {
+	Format string `json:"format,omitempty"`
+	Orient string `json:"orient,omitempty"`
+}
+
+type VegaLiteScale struct {
+	Domain []string `json:"domain,omitempty"`
+	Range  []string `json:"range,omitempty"`
+}
+
+type VegaLiteResolve struct {
+	Scale map[string]string `json:"scale"`
+}
+
+// vegaLiteLayerKey identifies the series that are drawn by the same layer
+type vegaLiteLayerKey struct {
+	typ  SeriesType
+	fill FillType
+	axis string
+}
+
+// NewVegaLiteSpec builds a vega-lite spec that draws the series of a figure.
+// Scalars and tables have no vega-lite equivalent and are left out. Series on
+// the secondary axis are drawn against an independent value scale.
+func NewVegaLiteSpec(pd *PlotDef, fig *Figure, cfg *PlotConfig) (*VegaLiteSpec, error) {
+	if len(fig.Series) == 0 {
+		return nil, fmt.Errorf("plot has no series that can be drawn with vega-lite")
+	}
+
+	spec := &VegaLiteSpec{
+		Schema: vegaLiteSchema,
+		Width:  "container",
+		Data:   &VegaLiteData{},
+	}
+	if pd.Layout.Title != nil {
+		spec.Title, _ = pd.Layout.Title.Text.(string)
+	}
+
+	var names, colors []string
+	var labelOrder []any
+	seenLabels := map[any]bool{}
+	allTimes, allNumbers, anyLabels := true, true, false
+	var keys []vegaLiteLayerKey
+	layerSeries := map[vegaLiteLayerKey][]*LabeledSeries{}
+	for _, ls := range fig.Series {
+		s := ls.SeriesDef
+		if s.Type == SeriesTypeChoropleth {
+			return nil, fmt.Errorf("series %q: choropleth series cannot be drawn with vega-lite", ls.Name)
+		}
+		names = append(names, ls.Name)
+		colors = append(colors, cfg.MaybeLookupColor(s.Color, ls.Name))
+
+		key := vegaLiteLayerKey{typ: s.Type, fill: s.Fill, axis: s.Yaxis}
+		if _, ok := layerSeries[key]; !ok {
+			keys = append(keys, key)
+		}
+		layerSeries[key] = append(layerSeries[key], ls)
+
+		boxed := s.Type == SeriesTypeBox || s.Type == SeriesTypeHBox
+		for i, v := range ls.Values {
+			row := map[string]any{"series": ls.Name, "value": v}
+			if !boxed && i < len(ls.Labels) {
+				l := ls.Labels[i]
+				row["label"] = l
+				anyLabels = true
+				// date strings are read as times, as plotly does
+				if _, ok := toTime(l); ok {
+					allNumbers = false
+				} else if _, ok := toFloat64(l); ok {
+					allTimes = false
+				} else {
+					allTimes, allNumbers = false, false
+				}
+				if !seenLabels[l] {
+					seenLabels[l] = true
+					labelOrder = append(labelOrder, l)
+				}
+			}
+			spec.Data.Values = append(spec.Data.Values, row)
+		}
+	}
+
+	label := &VegaLiteChannel{Field: "label", Type: "nominal", Sort: labelOrder}
+	switch {
+	case anyLabels && allTimes:
+		label = &VegaLiteChannel{Field: "label", Type: "temporal"}
+	case anyLabels && allNumbers:
+		label = &VegaLiteChannel{Field: "label", Type: "quantitative"}
+	}
+	if title := prettyFieldName(fig.Series[0].SeriesDef.Labels); title != "" {
+		label.Title = title
+	}
+
+	color := &VegaLiteChannel{Field: "series", Type: "nominal", Scale: &VegaLiteScale{Domain: names}}
+	for _, c := range colors {
+		if c == "" {
+			colors = nil
+			break
+		}
+	}
+	color.Scale.Range = colors
+
+	stacked := pd.Layout.Barmode == "stack" || pd.Layout.Barmode == "relative"
+	var primary, secondary []*VegaLiteSpec
+	for _, key := range keys {
+		layer := newVegaLiteLayer(key, layerSeries[key], label, color, stacked)
+		if key.axis == "y2" {
+			secondary = append(secondary, layer)
+		} else {
+			primary = append(primary, layer)
+		}
+	}
+
+	if len(secondary) == 0 {
+		spec.Layer = primary
+		return spec, nil
+	}
+	spec.Layer = []*VegaLiteSpec{{Layer: primary}, {Layer: secondary}}
+	spec.Resolve = &VegaLiteResolve{Scale: map[string]string{"y": "independent"}}
+	return spec, nil
+}
+
+// newVegaLiteLayer builds the layer that draws the series of one type against
+// the label axis. Bars of several series are placed side by side unless the
+// plot stacks them.
+func newVegaLiteLayer(key vegaLiteLayerKey, series []*LabeledSeries, label *VegaLiteChannel, color *VegaLiteChannel, stacked bool) *VegaLiteSpec {
+	first := series[0].SeriesDef
+	names := make([]string, 0, len(series))
+	for _, ls := range series {
+		names = append(names, ls.Name)
+	}
+
+	unit := first.EffectiveUnit()
+	value := &VegaLiteChannel{Field: "value", Type: "quantitative"}
+	if title := prettyFieldName(first.Values); title != "" {
+		value.Title = title
+	} else if title := unit.Title(); title != "" {
+		value.Title = title
+	}
+	if format := unit.TickFormat(); format != "" || key.axis == "y2" {
+		value.Axis = &VegaLiteAxis{Format: format}
+		if key.axis == "y2" {
+			value.Axis.Orient = "right"
+		}
+	}
+
+	layer := &VegaLiteSpec{
+		Transform: []VegaLiteTransform{{Filter: VegaLiteFilter{Field: "series", OneOf: names}}},
+		Mark:      &VegaLiteMark{Tooltip: true, Opacity: first.Opacity},
+		Encoding:  map[string]*VegaLiteChannel{"color": color},
+	}
+
+	position, offset := "x", "xOffset"
+	valuePos := "y"
+	if key.typ == SeriesTypeHBar || key.typ == SeriesTypeHBox {
+		position, offset, valuePos = "y", "yOffset", "x"
+	}
+	layer.Encoding[valuePos] = value
+
+	switch key.typ {
+	case SeriesTypeBar, SeriesTypeHBar:
+		layer.Mark.Type = "bar"
+		layer.Encoding[position] = label
+		if !stacked {
+			layer.Encoding[offset] = vegaLiteSeriesChannel(names)
+			noStack := false
+			value.Stack = &noStack
+		}
+	case SeriesTypeLine:
+		layer.Mark.Type = "line"
+		if key.fill == FillTypeToZero {
+			layer.Mark.Type = "area"
+			noStack := false
+			value.Stack = &noStack
+		}
+		layer.Encoding[position] = label
+	case SeriesTypeScatter:
+		layer.Mark.Type = "point"
+		layer.Encoding[position] = label
+	case SeriesTypeBox, SeriesTypeHBox:
+		// box plots have no labels, each series is drawn at its own position
+		layer.Mark = &VegaLiteMark{Type: "boxplot", Opacity: first.Opacity}
+		layer.Encoding[position] = vegaLiteSeriesChannel(names)
+	}
+	return layer
+}
+
+// vegaLiteSeriesChannel positions marks by series name, in the order of the
+// series
+func vegaLiteSeriesChannel(names []string) *VegaLiteChannel {
+	order := make([]any, len(names))
+	for i, name := range names {
+		order[i] = name
+	}
+	return &VegaLiteChannel{Field: "series", Type: "nominal", Sort: order}
+}
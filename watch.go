@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/browser"
+	"golang.org/x/exp/slog"
+)
+
+// watchInterval is how often the files of a watched plot are checked for
+// changes
+const watchInterval = 500 * time.Millisecond
+
+// watchPlot regenerates a plot whenever its definition or one of its query
+// files changes, and serves a preview page on a local port that redraws the
+// figure each time it is regenerated. Datasets are cached by query, so only
+// queries that changed are run again. It returns when the context is done.
+func watchPlot(ctx context.Context, fname string, cfg *PlotConfig) error {
+	sources := make(map[string]DataSource, len(cfg.Sources))
+	for name, src := range cfg.Sources {
+		sources[name] = newCachingDataSource(src)
+	}
+	watchCfg := *cfg
+	watchCfg.Sources = sources
+
+	w := &plotWatcher{
+		fname: fname,
+		cfg:   &watchCfg,
+	}
+	w.render(ctx)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", w.servePage)
+	mux.HandleFunc("GET /figure", w.serveFigure)
+	hs := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go hs.Serve(ln)
+	defer hs.Close()
+
+	url := "http://" + ln.Addr().String() + "/"
+	slog.Info("watching plot definition", "filename", fname, "preview", url)
+	if err := browser.OpenURL(url); err != nil {
+		slog.Warn("failed to open preview in browser", "error", err)
+	}
+
+	t := time.NewTicker(watchInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if w.changed() {
+				w.render(ctx)
+			}
+		}
+	}
+}
+
+// A plotWatcher holds the latest version of a watched plot
+type plotWatcher struct {
+	fname string
+	cfg   *PlotConfig
+
+	mu        sync.Mutex
+	version   int
+	fig       FigureData
+	figJSON   json.RawMessage // nil if the last render failed
+	renderErr error
+	modTimes  map[string]time.Time // the modification times of the files the plot was last rendered from
+}
+
+// render generates the plot and records the files it was generated from
+func (w *plotWatcher) render(ctx context.Context) {
+	files := []string{w.fname}
+	fig, err := w.generate(ctx, &files)
+
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			modTimes[f] = info.ModTime()
+		}
+	}
+
+	var figJSON []byte
+	if err == nil {
+		figJSON, err = json.Marshal(fig)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.version++
+	w.modTimes = modTimes
+	w.renderErr = err
+	if err != nil {
+		slog.Error("failed to generate plot", "filename", w.fname, "error", err)
+		w.figJSON = nil
+		return
+	}
+	slog.Info("generated plot", "filename", w.fname, "version", w.version)
+	w.fig = fig
+	w.figJSON = figJSON
+}
+
+// generate generates the figure of the plot, adding the query files of its
+// datasets to files
+func (w *plotWatcher) generate(ctx context.Context, files *[]string) (FigureData, error) {
+	content, err := os.ReadFile(w.fname)
+	if err != nil {
+		return FigureData{}, fmt.Errorf("failed to read plot definition: %w", err)
+	}
+	dir := filepath.Dir(w.fname)
+	pd, cfg, err := loadPlotDef(ctx, w.fname, content, os.DirFS(dir), ".", w.cfg)
+	if err != nil {
+		// the query files of a definition that cannot be parsed are not
+		// known, but fixing the definition changes it
+		return FigureData{}, err
+	}
+	for _, ds := range pd.Datasets {
+		if ds.QueryFile != "" {
+			*files = append(*files, filepath.Join(dir, filepath.FromSlash(ds.QueryFile)))
+		}
+	}
+
+	fig, err := generateFig(ctx, pd, cfg, nil)
+	if err != nil {
+		return FigureData{}, fmt.Errorf("failed to generate plot: %w", err)
+	}
+	if err := checkFigure(fig, FigureCheckMode(plotOpts.figureChecks), slog.With("name", pd.Name)); err != nil {
+		return FigureData{}, err
+	}
+	return FigureData{
+		Figure:    fig,
+		Metadata:  newFigureMetadata(pd, cfg, time.Now()),
+		Params:    pd.Parameters,
+		DynLayout: pd.DynLayout,
+		Drilldown: pd.Drilldown,
+		Config:    pd.Config,
+	}, nil
+}
+
+// changed reports whether any of the files the plot was last rendered from
+// was modified or removed since
+func (w *plotWatcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.modTimes) == 0 {
+		// the definition could not be read
+		_, err := os.Stat(w.fname)
+		return err == nil
+	}
+	for f, modTime := range w.modTimes {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *plotWatcher) servePage(rw http.ResponseWriter, _ *http.Request) {
+	w.mu.Lock()
+	fig := w.fig
+	w.mu.Unlock()
+
+	page, err := renderPage(fig, true, true)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write(page)
+}
+
+func (w *plotWatcher) serveFigure(rw http.ResponseWriter, _ *http.Request) {
+	w.mu.Lock()
+	update := struct {
+		Version int             `json:"version"`
+		Figure  json.RawMessage `json:"figure,omitempty"`
+		Error   string          `json:"error,omitempty"`
+	}{
+		Version: w.version,
+		Figure:  w.figJSON,
+	}
+	if w.renderErr != nil {
+		update.Error = w.renderErr.Error()
+	}
+	w.mu.Unlock()
+	writeJSON(rw, update)
+}
+
+// cachingDataSource remembers the datasets read from a source by query, so
+// that a plot can be regenerated without running queries that did not change
+type cachingDataSource struct {
+	src DataSource
+
+	mu    sync.Mutex
+	cache map[string]*StaticDataSet
+}
+
+// newCachingDataSource wraps a source in a cache, keeping its SQL dialect
+func newCachingDataSource(src DataSource) DataSource {
+	c := &cachingDataSource{src: src, cache: map[string]*StaticDataSet{}}
+	if ds, ok := src.(DialectSource); ok {
+		return &cachingDialectSource{cachingDataSource: c, dialect: ds.SQLDialect()}
+	}
+	return c
+}
+
+func (c *cachingDataSource) GetDataSet(ctx context.Context, query string, params ...any) (DataSet, error) {
+	if len(params) > 0 {
+		return c.src.GetDataSet(ctx, query, params...)
+	}
+	c.mu.Lock()
+	cached, ok := c.cache[query]
+	c.mu.Unlock()
+	if ok {
+		return copyStaticDataSet(cached), nil
+	}
+
+	ds, err := c.src.GetDataSet(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	sds, ok := ds.(*StaticDataSet)
+	if !ok {
+		return ds, nil
+	}
+	c.mu.Lock()
+	c.cache[query] = copyStaticDataSet(sds)
+	c.mu.Unlock()
+	return sds, nil
+}
+
+type cachingDialectSource struct {
+	*cachingDataSource
+	dialect Dialect
+}
+
+func (c *cachingDialectSource) SQLDialect() Dialect { return c.dialect }
+
+// copyStaticDataSet copies the columns of a dataset so that generating a
+// plot from the copy does not change the original
+func copyStaticDataSet(sds *StaticDataSet) *StaticDataSet {
+	data := make(map[string][]any, len(sds.Data))
+	for field, values := range sds.Data {
+		data[field] = append([]any(nil), values...)
+	}
+	return NewStaticDataSet(data)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// excelChartTypes maps the series types that can be drawn as a native excel
+// chart to their chart type
+var excelChartTypes = map[SeriesType]excelize.ChartType{
+	SeriesTypeLine:    excelize.Line,
+	SeriesTypeBar:     excelize.Col,
+	SeriesTypeHBar:    excelize.Bar,
+	SeriesTypeScatter: excelize.Scatter,
+}
+
+// marshalWorkbook writes the datasets of a figure to an excel workbook, one
+// sheet per dataset. If all the series of the plot can be drawn with the
+// same type of native chart the workbook starts with a sheet holding the
+// labels and values of each series and a chart of them.
+func marshalWorkbook(pd *PlotDef, fig *Figure) ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	used := make(map[string]bool)
+	first := true
+	addSheet := func(name string) (string, error) {
+		name = excelSheetName(name, used)
+		if first {
+			first = false
+			return name, f.SetSheetName(f.GetSheetName(0), name)
+		}
+		_, err := f.NewSheet(name)
+		return name, err
+	}
+
+	if chartType, ok := excelChartType(fig.Series); ok {
+		sheet, err := addSheet(pd.Name)
+		if err != nil {
+			return nil, fmt.Errorf("add chart sheet: %w", err)
+		}
+		if err := writeSeriesChart(f, sheet, pd, fig.Series, chartType); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(fig.DataSets))
+	for name := range fig.DataSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sds, ok := fig.DataSets[name].(*StaticDataSet)
+		if !ok {
+			continue
+		}
+		sheet, err := addSheet(name)
+		if err != nil {
+			return nil, fmt.Errorf("add sheet for dataset %q: %w", name, err)
+		}
+
+		fields := make([]string, 0, len(sds.Data))
+		for field := range sds.Data {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		header := make([]any, len(fields))
+		for i, field := range fields {
+			header[i] = field
+		}
+		if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+			return nil, fmt.Errorf("write header of dataset %q: %w", name, err)
+		}
+		for i := 0; i < sds.rowcount; i++ {
+			row := make([]any, len(fields))
+			for j, field := range fields {
+				row[j] = sds.Data[field][i]
+			}
+			cell, err := excelize.CoordinatesToCellName(1, i+2)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+				return nil, fmt.Errorf("write row of dataset %q: %w", name, err)
+			}
+		}
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("write workbook: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalDashboardWorkbook combines the workbooks written for the panels of
+// a dashboard into one. The first sheet lists the panels with their position
+// in the grid and the sheets holding their data, which are copies of the
+// values of the sheets of each panel's workbook. Native charts are not
+// copied. Panels without an output have no sheets, and the contents note
+// panels whose output is stale or missing.
+func marshalDashboardWorkbook(d *DashboardDef, panels []DashboardPanelData, outputs [][]byte) ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	used := make(map[string]bool)
+	contents := excelSheetName(d.Name, used)
+	if err := f.SetSheetName(f.GetSheetName(0), contents); err != nil {
+		return nil, fmt.Errorf("add contents sheet: %w", err)
+	}
+	header := []any{"panel", "plot", "row", "column", "width", "height", "sheets", "status", "error"}
+	if err := f.SetSheetRow(contents, "A1", &header); err != nil {
+		return nil, fmt.Errorf("write contents header: %w", err)
+	}
+
+	for i, p := range panels {
+		title := p.Title
+		if title == "" {
+			title = strings.TrimSuffix(p.Plot, filepath.Ext(p.Plot))
+		}
+
+		status := "current"
+		switch {
+		case outputs[i] == nil:
+			status = "missing"
+		case p.Stale:
+			status = "stale, generated " + p.Generated.Format(time.RFC3339)
+		}
+
+		var sheets []string
+		if outputs[i] != nil {
+			var err error
+			if sheets, err = copyWorkbookSheets(f, outputs[i], title, used); err != nil {
+				return nil, fmt.Errorf("copy workbook of plot %q: %w", p.Plot, err)
+			}
+		}
+
+		row := []any{title, p.Plot, p.Row, p.Column, p.Width, p.Height, strings.Join(sheets, ", "), status, p.Error}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.SetSheetRow(contents, cell, &row); err != nil {
+			return nil, fmt.Errorf("write contents of panel %q: %w", title, err)
+		}
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("write workbook: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// copyWorkbookSheets copies the values of every sheet of a workbook into new
+// sheets of f, named after the title and the copied sheet
+func copyWorkbookSheets(f *excelize.File, workbook []byte, title string, used map[string]bool) ([]string, error) {
+	pf, err := excelize.OpenReader(bytes.NewReader(workbook))
+	if err != nil {
+		return nil, fmt.Errorf("read workbook: %w", err)
+	}
+	defer pf.Close()
+
+	var sheets []string
+	for _, src := range pf.GetSheetList() {
+		rows, err := pf.GetRows(src)
+		if err != nil {
+			return nil, fmt.Errorf("read sheet %q: %w", src, err)
+		}
+		sheet := excelSheetName(title+" "+src, used)
+		if _, err := f.NewSheet(sheet); err != nil {
+			return nil, fmt.Errorf("add sheet: %w", err)
+		}
+		for r, row := range rows {
+			values := make([]any, len(row))
+			for c, v := range row {
+				values[c] = workbookCellValue(v)
+			}
+			cell, err := excelize.CoordinatesToCellName(1, r+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+				return nil, fmt.Errorf("write row: %w", err)
+			}
+		}
+		sheets = append(sheets, sheet)
+	}
+	return sheets, nil
+}
+
+// workbookCellValue converts the text of a copied cell back to a number
+// where it is one, so it is not stored as text
+func workbookCellValue(v string) any {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// excelChartType returns the native chart type that can draw all the series
+func excelChartType(series []*LabeledSeries) (excelize.ChartType, bool) {
+	if len(series) == 0 {
+		return 0, false
+	}
+	chartType, ok := excelChartTypes[series[0].SeriesDef.Type]
+	if !ok {
+		return 0, false
+	}
+	for _, ls := range series[1:] {
+		if ct, ok := excelChartTypes[ls.SeriesDef.Type]; !ok || ct != chartType {
+			return 0, false
+		}
+	}
+	return chartType, true
+}
+
+// writeSeriesChart writes the labels and values of each series to a pair of
+// columns of the sheet and adds a chart of them to the right
+func writeSeriesChart(f *excelize.File, sheet string, pd *PlotDef, series []*LabeledSeries, chartType excelize.ChartType) error {
+	chart := &excelize.Chart{
+		Type:   chartType,
+		Format: excelize.GraphicOptions{ScaleX: 2, ScaleY: 2},
+		Legend: excelize.ChartLegend{Position: "bottom"},
+	}
+	if pd.Layout.Title != nil {
+		if title, ok := pd.Layout.Title.Text.(string); ok && title != "" {
+			chart.Title = []excelize.RichTextRun{{Text: title}}
+		}
+	}
+
+	quoted := "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+	for i, ls := range series {
+		labelCol, err := excelize.ColumnNumberToName(2*i + 1)
+		if err != nil {
+			return err
+		}
+		valueCol, err := excelize.ColumnNumberToName(2*i + 2)
+		if err != nil {
+			return err
+		}
+
+		header := []any{prettyFieldName(ls.SeriesDef.Labels), ls.Name}
+		if err := f.SetSheetRow(sheet, labelCol+"1", &header); err != nil {
+			return fmt.Errorf("write header of series %q: %w", ls.Name, err)
+		}
+		for j := range ls.Labels {
+			row := []any{ls.Labels[j], nil}
+			if j < len(ls.Values) {
+				row[1] = ls.Values[j]
+			}
+			if err := f.SetSheetRow(sheet, fmt.Sprintf("%s%d", labelCol, j+2), &row); err != nil {
+				return fmt.Errorf("write series %q: %w", ls.Name, err)
+			}
+		}
+
+		last := len(ls.Labels) + 1
+		chart.Series = append(chart.Series, excelize.ChartSeries{
+			Name:       fmt.Sprintf("%s!$%s$1", quoted, valueCol),
+			Categories: fmt.Sprintf("%s!$%s$2:$%s$%d", quoted, labelCol, labelCol, last),
+			Values:     fmt.Sprintf("%s!$%s$2:$%s$%d", quoted, valueCol, valueCol, last),
+		})
+	}
+
+	chartCol, err := excelize.ColumnNumberToName(2*len(series) + 2)
+	if err != nil {
+		return err
+	}
+	if err := f.AddChart(sheet, chartCol+"2", chart); err != nil {
+		return fmt.Errorf("add chart: %w", err)
+	}
+	return nil
+}
+
+// excelSheetName makes a name valid and unique for use as a sheet name
+func excelSheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "data"
+	}
+	base := []rune(name)
+	if len(base) > 31 {
+		base = base[:31]
+	}
+	name = string(base)
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		trimmed := base
+		if len(trimmed)+len(suffix) > 31 {
+			trimmed = trimmed[:31-len(suffix)]
+		}
+		name = string(trimmed) + suffix
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}